@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// minHashPrefix is the length of the shortest hash prefix accepted as an
+// abbreviation of a hash. Every CIDv0 starts with "Qm" and every base32
+// CIDv1 with "bafy", so fewer characters hardly narrow anything down.
+const minHashPrefix = 6
+
+// depHashes maps every form of the hashes of deps to the hash as given
+func depHashes(deps []*gx.Dependency) map[string]string {
+	out := make(map[string]string)
+	for _, d := range deps {
+		for _, f := range hashForms(d.Hash) {
+			out[f] = d.Hash
+		}
+	}
+	return out
+}
+
+// matchHash returns the hash in hashes that ref is, or is a unique prefix
+// of, as mapped to by hashes. It returns "" if there is none, and an error
+// if ref is a prefix of several.
+func matchHash(hashes map[string]string, ref string) (string, error) {
+	if h, ok := hashes[ref]; ok {
+		return h, nil
+	}
+	if len(ref) < minHashPrefix {
+		return "", nil
+	}
+
+	matches := make(map[string]bool)
+	for f, h := range hashes {
+		if strings.HasPrefix(f, ref) {
+			matches[h] = true
+		}
+	}
+
+	var out []string
+	for h := range matches {
+		out = append(out, h)
+	}
+	sort.Strings(out)
+	switch len(out) {
+	case 0:
+		return "", nil
+	case 1:
+		return out[0], nil
+	default:
+		return "", fmt.Errorf("%s is ambiguous, it abbreviates:\n  %s", ref, strings.Join(out, "\n  "))
+	}
+}
+
+// expandHash returns the hash ref abbreviates, among the dependencies of
+// pkg and the packages vendored in pkgdir. ref is returned as is if it does
+// not abbreviate any of them.
+func expandHash(pkg *Package, pkgdir, ref string) (string, error) {
+	hashes := make(map[string]string)
+	if vendored, err := vendoredHashes(pkgdir); err == nil {
+		for h := range vendored {
+			hashes[h] = h
+		}
+	}
+	if pkg != nil {
+		for f, h := range depHashes(pkg.Dependencies) {
+			hashes[f] = h
+		}
+	}
+
+	h, err := matchHash(hashes, ref)
+	if err != nil || h == "" {
+		return ref, err
+	}
+	if h != ref {
+		VLog("  - %s expands to %s", ref, h)
+	}
+	return h, nil
+}
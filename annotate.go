@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// annotationPrefix starts the comments 'annotate' puts next to gx imports
+const annotationPrefix = "// gx: "
+
+type annotateMode int
+
+const (
+	// annotateAll annotates every gx import
+	annotateAll annotateMode = iota
+	// annotateRefresh only updates imports that are already annotated
+	annotateRefresh
+	// annotateStrip removes all annotations
+	annotateStrip
+)
+
+var AnnotateCommand = cli.Command{
+	Name:  "annotate",
+	Usage: "add comments naming the package next to gx imports",
+	Description: `appends a '// gx: <name> <version>' comment to every gx/ipfs import of the
+package, so readers can tell which package a hash refers to. Imports that
+already have a comment of their own are left alone.
+
+'rewrite --annotate' does the same after rewriting, a plain rewrite keeps
+existing annotations up to date and 'rewrite --undo' removes them.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "strip",
+			Usage: "remove the annotations instead",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		return forEachPackage(func(dir string) error {
+			if c.Bool("strip") {
+				return annotatePackage(dir, nil, annotateStrip)
+			}
+
+			pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName))
+			if err != nil {
+				return err
+			}
+
+			labels, err := importLabels(pkg, filepath.Join(dir, vendorDir))
+			if err != nil {
+				return err
+			}
+			return annotatePackage(dir, labels, annotateAll)
+		})
+	},
+}
+
+// importLabels returns the annotation for every hash in the dependency
+// tree of pkg, in each of its forms
+func importLabels(pkg *Package, pkgdir string) (map[string]string, error) {
+	labels := make(map[string]string)
+	err := forEachDep(pkg, pkgdir, func(dep *gx.Dependency, dpkg *Package, _ string) error {
+		for _, h := range hashForms(dep.Hash) {
+			labels[h] = strings.TrimSpace(dpkg.Name + " " + dpkg.Version)
+		}
+		return nil
+	})
+	return labels, err
+}
+
+// annotatePackage annotates the gx imports of the go files of the package in
+// dir, leaving nested packages alone
+func annotatePackage(dir string, labels map[string]string, mode annotateMode) error {
+	nested := nestedPackages(dir)
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+		if fi.IsDir() {
+			if rel != "." && (skipDir(fi.Name()) || inNested(nested, rel)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(p, ".go") {
+			return nil
+		}
+		if err := annotateFile(p, labels, mode); err != nil {
+			return fmt.Errorf("annotating %s: %s", rel, err)
+		}
+		return nil
+	})
+}
+
+func annotateFile(path string, labels map[string]string, mode annotateMode) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		// not ours to fix, the rewrite reports these
+		return nil
+	}
+
+	lines := strings.Split(string(src), "\n")
+	var changed bool
+	for _, imp := range f.Imports {
+		ipath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		end := fset.Position(imp.End())
+		line := lines[end.Line-1]
+		code, rest := line[:end.Column-1], strings.TrimSpace(line[end.Column-1:])
+
+		annotated := strings.HasPrefix(rest, annotationPrefix)
+		if rest != "" && !annotated {
+			// the import has a comment of its own
+			continue
+		}
+
+		var label string
+		switch {
+		case mode == annotateStrip || !strings.HasPrefix(ipath, "gx/ipfs/"):
+		case mode == annotateAll || annotated:
+			label = labels[hashFromImport(ipath)]
+		}
+
+		nline := code
+		if label != "" {
+			nline = code + " " + annotationPrefix + label
+		}
+		if nline != line {
+			lines[end.Line-1] = nline
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	out := []byte(strings.Join(lines, "\n"))
+	if formatted, err := format.Source(out); err == nil {
+		out = formatted
+	}
+	if bytes.Equal(out, src) {
+		return nil
+	}
+
+	tmp := path + ".temp"
+	if err := ioutil.WriteFile(tmp, out, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
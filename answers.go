@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// answers are the responses to interactive prompts given with --answers,
+// by prompt id. A response for "id:subject" takes precedence over one for
+// "id", so prompts asked for several packages can be answered one by one.
+var answers map[string]string
+
+// answersFile is where answers were loaded from, for messages
+var answersFile string
+
+// loadAnswers loads the answers file at p, a json object of prompt ids to
+// responses. Yes/no prompts may be answered with a boolean.
+func loadAnswers(p string) error {
+	if p == "" {
+		return nil
+	}
+
+	var raw map[string]interface{}
+	if err := loadMap(&raw, p); err != nil {
+		return fmt.Errorf("loading answers: %s", err)
+	}
+
+	answers = make(map[string]string)
+	for id, v := range raw {
+		switch v := v.(type) {
+		case string:
+			answers[id] = v
+		case bool:
+			answers[id] = "n"
+			if v {
+				answers[id] = "y"
+			}
+		default:
+			out, _ := json.Marshal(v)
+			return fmt.Errorf("answer to %q in %s must be a string or boolean, not %s", id, p, out)
+		}
+	}
+	answersFile = p
+	return nil
+}
+
+// answerFor returns the scripted response to the prompt id about subject.
+// With an answers file, prompts it does not answer take their default.
+func answerFor(id, subject string) (string, bool) {
+	if answers == nil {
+		return "", false
+	}
+
+	if subject != "" {
+		if a, ok := answers[id+":"+subject]; ok {
+			return a, true
+		}
+	}
+	if a, ok := answers[id]; ok {
+		return a, true
+	}
+
+	key := id
+	if subject != "" {
+		key += ":" + subject
+	}
+	Warn("%s has no answer for prompt %q, using the default", answersFile, key)
+	return "", true
+}
+
+// parseYesNo parses the response to a yes/no prompt, "" being the default
+func parseYesNo(val string, def bool) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "":
+		return def, true
+	case "y", "yes":
+		return true, true
+	case "n", "no":
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// attestFileName is where 'deps attest' records the dependency tree by
+// default
+const attestFileName = "DEPENDENCIES.md"
+
+var depsAttestCommand = cli.Command{
+	Name:  "attest",
+	Usage: "record the dependency tree in a human readable file",
+	Description: `writes every package in the dependency tree, with its version, hash,
+origin and license, to DEPENDENCIES.md, keeping an auditable record of the
+dependencies in the repository itself. The file is a markdown table, or
+aligned plain text if --out does not end in .md, and only changes when the
+dependency tree does.
+
+With --verify, the file is not written but compared against the tree, and
+the command fails if it is stale, so CI can require it to be kept current.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "out",
+			Value: attestFileName,
+			Usage: "file to record the dependency tree in",
+		},
+		cli.BoolFlag{
+			Name:  "verify",
+			Usage: "fail if the file does not match the dependency tree",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		out := c.String("out")
+		want, err := renderAttestation(pkg, filepath.Join(cwd, vendorDir), strings.HasSuffix(out, ".md"))
+		if err != nil {
+			return err
+		}
+
+		if !c.Bool("verify") {
+			if err := ioutil.WriteFile(out, want, 0644); err != nil {
+				return err
+			}
+			Log("recorded the dependency tree of %s in %s", pkg.Name, out)
+			return nil
+		}
+
+		have, err := ioutil.ReadFile(out)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("no %s, create it with 'gx-go deps attest'", out)
+			}
+			return err
+		}
+		if bytes.Equal(have, want) {
+			VLog("%s is up to date", out)
+			return nil
+		}
+
+		for _, l := range lineChanges(string(have), string(want)) {
+			Log("%s", l)
+		}
+		return fmt.Errorf("%s is stale, update it with 'gx-go deps attest'", out)
+	},
+}
+
+// renderAttestation renders the dependency tree of pkg, vendored in pkgdir,
+// as a markdown table or aligned text, sorted so it only changes with the
+// tree
+func renderAttestation(pkg *Package, pkgdir string, markdown bool) ([]byte, error) {
+	var rows [][]string
+	err := forEachDep(pkg, pkgdir, func(dep *gx.Dependency, dpkg *Package, dir string) error {
+		origin := "-"
+		if dpkg.Gx.DvcsImport != "" {
+			origin = dpkg.Gx.DvcsImport
+			if dpkg.Gx.DvcsRevision != "" {
+				origin += "@" + dpkg.Gx.DvcsRevision
+			}
+		}
+		license := packageLicense(dpkg, dir)
+		if license == "" {
+			license = "unknown"
+		}
+		rows = append(rows, []string{dpkg.Name, dpkg.Version, dep.Hash, origin, license})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i][0] != rows[j][0] {
+			return rows[i][0] < rows[j][0]
+		}
+		return rows[i][2] < rows[j][2]
+	})
+
+	headers := []string{"NAME", "VERSION", "HASH", "ORIGIN", "LICENSE"}
+	var buf bytes.Buffer
+	if !markdown {
+		fmt.Fprintf(&buf, "Dependencies of %s, generated by 'gx-go deps attest'.\n\n", pkg.Name)
+		tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+		for _, r := range append([][]string{headers}, rows...) {
+			fmt.Fprintln(tw, strings.Join(r, "\t"))
+		}
+		if err := tw.Flush(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	fmt.Fprintf(&buf, "# Dependencies of %s\n\n", pkg.Name)
+	fmt.Fprintf(&buf, "Generated by `gx-go deps attest`, do not edit.\n\n")
+	fmt.Fprintf(&buf, "| Name | Version | Hash | Origin | License |\n")
+	fmt.Fprintf(&buf, "|------|---------|------|--------|---------|\n")
+	for _, r := range rows {
+		for i, v := range r {
+			r[i] = strings.Replace(v, "|", `\|`, -1)
+		}
+		fmt.Fprintf(&buf, "| %s |\n", strings.Join(r, " | "))
+	}
+	return buf.Bytes(), nil
+}
+
+// lineChanges lists the lines only in have prefixed with '-' and the lines
+// only in want prefixed with '+'
+func lineChanges(have, want string) []string {
+	count := make(map[string]int)
+	for _, l := range strings.Split(have, "\n") {
+		count[l]++
+	}
+	for _, l := range strings.Split(want, "\n") {
+		count[l]--
+	}
+
+	var out []string
+	for _, l := range strings.Split(have, "\n") {
+		if count[l] > 0 {
+			out = append(out, "- "+l)
+			count[l]--
+		}
+	}
+	for _, l := range strings.Split(want, "\n") {
+		if count[l] < 0 {
+			out = append(out, "+ "+l)
+			count[l]++
+		}
+	}
+	return out
+}
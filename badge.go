@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// badge is a badge in the format of the shields.io endpoint badge
+type badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+var BadgeCommand = cli.Command{
+	Name:  "badge",
+	Usage: "write shields.io badges about the health of the dependency tree",
+	Description: `writes badges for how many dependencies are outdated and how many
+packages are in the tree more than once, as 'status' counts them, to
+badges.json, keyed by badge name. Each badge is in the format of the
+shields.io endpoint badge, so a repository can publish the file from CI and
+show the badges with a dynamic json badge. With --dir, every badge is also
+written to a file of its own, <name>.json, usable as the endpoint itself.
+
+Outdated dependencies are looked up in the configured registry, without
+one the badge says "unknown". Frozen dependencies are not counted.
+
+There is no badge for vulnerabilities, gx-go has no advisory database to
+audit the dependency tree against.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "out",
+			Value: "badges.json",
+			Usage: "file to write the badges to, - for stdout",
+		},
+		cli.StringFlag{
+			Name:  "dir",
+			Usage: "directory to also write every badge to a file of its own in",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		g, err := loadDepGraph(pkg, filepath.Join(cwd, vendorDir))
+		if err != nil {
+			return err
+		}
+
+		badges := map[string]badge{
+			"duplicates": countBadge("duplicates", len(g.duplicates()), "none", "%d"),
+		}
+		if n, err := outdatedCount(g, frozenHashes(pkg)); err != nil {
+			Warn("not counting outdated dependencies: %s", err)
+			badges["dependencies"] = badge{1, "dependencies", "unknown", "lightgrey"}
+		} else {
+			badges["dependencies"] = countBadge("dependencies", n, "up to date", "%d outdated")
+		}
+
+		if dir := c.String("dir"); dir != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			for name, b := range badges {
+				if err := writeBadgeFile(filepath.Join(dir, name+".json"), b); err != nil {
+					return err
+				}
+			}
+		}
+
+		if c.String("out") == "-" {
+			out, err := json.MarshalIndent(badges, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+		if err := writeBadgeFile(c.String("out"), badges); err != nil {
+			return err
+		}
+		Log("wrote badges of %s to %s", pkg.Name, c.String("out"))
+		return nil
+	},
+}
+
+// badgeWarnCount is how many problems a badge shows in yellow, more are red
+const badgeWarnCount = 3
+
+// countBadge returns a badge counting n problems, green with the message
+// none if there are none, and with the count in format otherwise
+func countBadge(label string, n int, none, format string) badge {
+	switch {
+	case n == 0:
+		return badge{1, label, none, "brightgreen"}
+	case n <= badgeWarnCount:
+		return badge{1, label, fmt.Sprintf(format, n), "yellow"}
+	default:
+		return badge{1, label, fmt.Sprintf(format, n), "red"}
+	}
+}
+
+func writeBadgeFile(p string, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, append(out, '\n'), 0644)
+}
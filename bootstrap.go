@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// gxDist is the ipfs distributions path gx releases are published under
+const gxDist = "/ipns/dist.ipfs.io/gx"
+
+// errNoGx is returned when gx is needed but not installed
+var errNoGx = errors.New("gx is not installed, run 'gx-go bootstrap' to install it")
+
+// gxInstalled returns whether the gx binary is in PATH
+func gxInstalled() bool {
+	_, err := exec.LookPath("gx")
+	return err == nil
+}
+
+// newPM sets up the gx package manager, pointing at 'gx-go bootstrap' when
+// that fails for lack of gx, or the fake store GX_GO_FAKE_STORE names
+func newPM() (packageStore, error) {
+	if dir := os.Getenv(fakeStoreEnv); dir != "" {
+		return meteredStore{&fakeStore{Dir: dir}}, nil
+	}
+
+	cfg, err := gx.LoadConfig()
+	if err != nil {
+		return nil, gxSetupError("loading gx config", err)
+	}
+	pm, err := gx.NewPM(cfg)
+	if err != nil {
+		return nil, gxSetupError("setting up gx", err)
+	}
+	return meteredStore{pm}, nil
+}
+
+func gxSetupError(what string, err error) error {
+	if !gxInstalled() {
+		return fmt.Errorf("%s: %s\n%s", what, err, errNoGx)
+	}
+	return fmt.Errorf("%s: %s", what, err)
+}
+
+// requiredGxVersion returns the gx version pkg requires in its toolVersion
+func requiredGxVersion(pkg *Package) string {
+	if tv := pkg.Gx.ToolVersion; tv != nil {
+		return tv.Gx
+	}
+	return ""
+}
+
+var BootstrapCommand = cli.Command{
+	Name:  "bootstrap",
+	Usage: "install the gx version the current package needs",
+	Description: `installs gx, which most commands that fetch or publish packages rely on.
+Commands that only read the vendor directory, like 'deps ls', 'dep-map'
+and 'path', work without it.
+
+The version installed is the one given with --version, else the one the
+package.json in the current directory requires in gx.toolVersion, else the
+latest release. Releases are fetched from the ipfs distributions site
+through the configured gateway and checked against their listed checksum
+and their signature by the gx release public key embedded in gx-go, or
+given with --pubkey, or built with 'go install' with --source go.
+
+gx is installed to the global bin directory of 'gx-go hook install-path
+--global --bin' unless --dir is given. --pin records the installed version
+as the gx version the package requires, so everyone working on it gets the
+same one.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "version",
+			Usage: "gx version to install",
+		},
+		cli.StringFlag{
+			Name:  "dir",
+			Usage: "directory to install gx to",
+		},
+		cli.StringFlag{
+			Name:  "source",
+			Usage: "where to get gx from: ipfs or go",
+			Value: "ipfs",
+		},
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "install gx even if a suitable version is installed",
+		},
+		cli.StringFlag{
+			Name:   "pubkey",
+			Usage:  "base64 ed25519 public key gx releases must be signed with, instead of the embedded one",
+			EnvVar: "GX_RELEASE_PUBKEY",
+		},
+		cli.BoolFlag{
+			Name:  "pin",
+			Usage: "record the installed version as the one the package requires",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(filepath.Join(cwd, gx.PkgFileName))
+		if err != nil && (!os.IsNotExist(err) || c.Bool("pin")) {
+			return err
+		}
+
+		version := strings.TrimPrefix(c.String("version"), "v")
+		if version == "" && pkg != nil {
+			version = requiredGxVersion(pkg)
+		}
+
+		if have, err := gxToolVersion(); err == nil && !c.Bool("force") {
+			// an explicit version is installed exactly, a requirement is
+			// met by newer versions too
+			ok := version == ""
+			if c.String("version") != "" {
+				ok = have == version
+			} else if version != "" {
+				older, err := versionComp(have, version)
+				ok = err == nil && !older
+			}
+			if ok {
+				Log("gx %s is installed", have)
+				return pinGxVersion(c, pkg, have)
+			}
+		}
+
+		dir := c.String("dir")
+		if dir == "" {
+			if dir, err = binInstallPath(true); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		switch c.String("source") {
+		case "ipfs":
+			err = installGxRelease(dir, version, c.String("pubkey"))
+		case "go":
+			err = installGxFromSource(dir, version)
+		default:
+			return fmt.Errorf("unrecognized source %q, must be ipfs or go", c.String("source"))
+		}
+		if err != nil {
+			return err
+		}
+
+		exe := filepath.Join(dir, gxExeName())
+		out, err := exec.CommandContext(cancelCtx, exe, "--version").Output()
+		if err != nil {
+			return fmt.Errorf("running installed gx: %s", err)
+		}
+		fields := strings.Fields(string(out))
+		if len(fields) == 0 {
+			return fmt.Errorf("unrecognized output from gx --version")
+		}
+		installed := fields[len(fields)-1]
+		Log("installed gx %s to %s", installed, exe)
+
+		if found, err := exec.LookPath("gx"); err != nil || filepath.Clean(found) != filepath.Clean(exe) {
+			Warn("%s is not first in PATH, add it to use the installed gx", dir)
+		}
+		return pinGxVersion(c, pkg, installed)
+	},
+}
+
+func gxExeName() string {
+	if runtime.GOOS == "windows" {
+		return "gx.exe"
+	}
+	return "gx"
+}
+
+// installGxRelease installs the given gx release, or the latest one, into
+// dir from the ipfs distributions site. It must be signed with pubkey, or
+// the embedded gx release key without it.
+func installGxRelease(dir, version, pubkey string) error {
+	key, err := releaseKey(pubkey, gxReleasePubKey)
+	if err != nil {
+		return err
+	}
+
+	rel, err := distRelease(gxDist, "gx", version)
+	if err != nil {
+		return fmt.Errorf("finding gx release: %s", err)
+	}
+
+	Log("downloading gx %s from %s", rel.Version, rel.URL)
+	archive, err := download(rel.URL)
+	if err != nil {
+		return err
+	}
+	if err := rel.verify(archive, key); err != nil {
+		return fmt.Errorf("verifying release: %s", err)
+	}
+
+	bin, err := binFromArchive(archive, "gx")
+	if err != nil {
+		return err
+	}
+	return replaceBinary(filepath.Join(dir, gxExeName()), bin)
+}
+
+// installGxFromSource builds the given gx version, or the latest one, into
+// dir with 'go install'
+func installGxFromSource(dir, version string) error {
+	ref := "latest"
+	if version != "" {
+		ref = "v" + version
+	}
+
+	Log("building gx %s", ref)
+	cmd := exec.CommandContext(cancelCtx, "go", "install", "github.com/whyrusleeping/gx@"+ref)
+	cmd.Env = append(os.Environ(), "GOBIN="+dir)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go install: %s", err)
+	}
+	return nil
+}
+
+// pinGxVersion records version as the gx version pkg requires, with --pin
+func pinGxVersion(c *cli.Context, pkg *Package, version string) error {
+	if !c.Bool("pin") {
+		return nil
+	}
+
+	if pkg.Gx.ToolVersion == nil {
+		pkg.Gx.ToolVersion = &ToolVersion{}
+	}
+	pkg.Gx.ToolVersion.Gx = version
+	if err := savePackageFile(pkg, filepath.Join(cwd, gx.PkgFileName)); err != nil {
+		return err
+	}
+	Log("pinned gx %s in %s", version, gx.PkgFileName)
+	return nil
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var BrowseCommand = cli.Command{
+	Name:  "browse",
+	Usage: "interactively explore the dependency tree",
+	Description: `prints the dependency tree with numbered entries, and reads commands
+from stdin to explore it:
+
+   o <n>     expand entry n
+   c <n>     collapse entry n
+   i <n>     show the package.json of entry n
+   w <n>     show why entry n is in the tree (all paths to it)
+   d         list packages present at more than one hash
+   x         expand the tree down to every outdated package and list them
+   j <n>     jump to (expand the tree down to) the first occurrence of entry n
+   f <n> [m] compare the dependencies of entry n with entry m, by default
+             with the other hashes of the same package in the tree
+   u <n> [h] stage an update of the imports of entry n to hash h, by default
+             to the newest version in the registry, see 'update --stage'
+   q         quit
+
+Outdated packages are looked up in the configured registry.`,
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		g, err := loadDepGraph(pkg, filepath.Join(cwd, vendorDir))
+		if err != nil {
+			return err
+		}
+
+		b := &browser{g: g, open: make(map[string]bool), out: os.Stdout}
+		return b.run(os.Stdin)
+	},
+}
+
+type browser struct {
+	g   *depGraph
+	out io.Writer
+
+	// open holds the tree positions (hash paths) of expanded entries
+	open map[string]bool
+
+	// entries are the tree positions of the entries printed last
+	entries []string
+
+	// registry is the configured registry by dvcs import, fetched on first
+	// use
+	registry map[string]*registryPackage
+}
+
+func (b *browser) run(in io.Reader) error {
+	b.print()
+	scan := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(b.out, "> ")
+		if !scan.Scan() {
+			return scan.Err()
+		}
+
+		fields := strings.Fields(scan.Text())
+		if len(fields) == 0 {
+			b.print()
+			continue
+		}
+
+		if fields[0] == "q" {
+			return nil
+		}
+
+		if err := b.exec(fields); err != nil {
+			fmt.Fprintln(b.out, err)
+		}
+	}
+}
+
+func (b *browser) exec(fields []string) error {
+	switch fields[0] {
+	case "d":
+		b.printDups()
+		return nil
+	case "x":
+		return b.printOutdated()
+	}
+
+	if len(fields) < 2 {
+		return fmt.Errorf("command %q requires an entry number", fields[0])
+	}
+
+	pos, err := b.entry(fields[1])
+	if err != nil {
+		return err
+	}
+	hash := posHash(pos)
+
+	switch fields[0] {
+	case "o":
+		b.open[pos] = true
+		b.print()
+	case "c":
+		delete(b.open, pos)
+		b.print()
+	case "i":
+		out, err := json.MarshalIndent(b.g.Nodes[hash].Pkg, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(b.out, string(out))
+	case "w":
+		for _, p := range b.g.pathsTo(hash) {
+			fmt.Fprintln(b.out, b.g.chainNames(p))
+		}
+	case "j":
+		b.openTo(hash)
+		b.print()
+	case "f":
+		others := b.sameImport(hash)
+		if len(fields) > 2 {
+			pos, err := b.entry(fields[2])
+			if err != nil {
+				return err
+			}
+			others = []string{posHash(pos)}
+		}
+		if len(others) == 0 {
+			return fmt.Errorf("%s is in the tree at one hash only, give an entry to compare with", b.g.Nodes[hash].Pkg.Name)
+		}
+		for _, o := range others {
+			b.printDepDiff(hash, o)
+		}
+	case "u":
+		return b.stageUpdate(hash, fields[2:])
+	default:
+		return fmt.Errorf("unknown command %q", fields[0])
+	}
+	return nil
+}
+
+// entry returns the tree position of the printed entry numbered arg
+func (b *browser) entry(arg string) (string, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 || n >= len(b.entries) {
+		return "", fmt.Errorf("no such entry: %s", arg)
+	}
+	return b.entries[n], nil
+}
+
+// openTo expands the tree down to the first occurrence of hash
+func (b *browser) openTo(hash string) {
+	paths := b.g.pathsTo(hash)
+	if len(paths) == 0 {
+		return
+	}
+	var cur []string
+	for _, h := range paths[0][:len(paths[0])-1] {
+		cur = append(cur, h)
+		b.open[strings.Join(cur, "/")] = true
+	}
+}
+
+// sameImport returns the other hashes the package at hash is in the tree at
+func (b *browser) sameImport(hash string) []string {
+	n := b.g.Nodes[hash]
+	k := n.Pkg.Gx.DvcsImport
+	if k == "" {
+		k = n.Pkg.Name
+	}
+
+	var out []string
+	for _, h := range b.g.duplicates()[k] {
+		if h != hash {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// printDepDiff prints how the dependencies of the package at hash b differ
+// from those at hash a
+func (b *browser) printDepDiff(a, c string) {
+	from, to := b.g.Nodes[a].Pkg, b.g.Nodes[c].Pkg
+	fmt.Fprintf(b.out, "%s %s (%s) -> %s %s (%s)\n", from.Name, from.Version, a, to.Name, to.Version, c)
+
+	old := make(map[string]*gx.Dependency)
+	for _, d := range from.Dependencies {
+		old[d.Name] = d
+	}
+	var changed bool
+	for _, d := range to.Dependencies {
+		o, ok := old[d.Name]
+		delete(old, d.Name)
+		switch {
+		case !ok:
+			fmt.Fprintf(b.out, "  + %s %s (%s)\n", d.Name, d.Version, d.Hash)
+		case o.Hash != d.Hash:
+			fmt.Fprintf(b.out, "  ~ %s %s -> %s (%s)\n", d.Name, o.Version, d.Version, d.Hash)
+		default:
+			continue
+		}
+		changed = true
+	}
+	for _, d := range from.Dependencies {
+		if _, ok := old[d.Name]; ok {
+			fmt.Fprintf(b.out, "  - %s %s (%s)\n", d.Name, d.Version, d.Hash)
+			changed = true
+		}
+	}
+	if !changed {
+		fmt.Fprintln(b.out, "  same dependencies")
+	}
+}
+
+// loadRegistry fetches the configured registry once
+func (b *browser) loadRegistry() (map[string]*registryPackage, error) {
+	if b.registry == nil {
+		r, err := configuredRegistry()
+		if err != nil {
+			return nil, err
+		}
+		b.registry = r
+	}
+	return b.registry, nil
+}
+
+// latest returns the newest version the registry has of the package at
+// hash, nil if it is up to date or unknown to the registry
+func (b *browser) latest(hash string) (*registryVersion, error) {
+	reg, err := b.loadRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	n := b.g.Nodes[hash]
+	rp, ok := reg[n.Pkg.Gx.DvcsImport]
+	if !ok {
+		return nil, nil
+	}
+	f := checkFreshness(n.Pkg, n.Dep, rp, Policy{}, 0)
+	if f.Behind == 0 {
+		return nil, nil
+	}
+	for i, v := range rp.Versions {
+		if v.Version == f.Latest {
+			return &rp.Versions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// printOutdated expands the tree down to every package the registry has a
+// newer version of, prints it and lists them
+func (b *browser) printOutdated() error {
+	var hashes []string
+	for h := range b.g.Nodes {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	frozen := frozenHashes(b.g.Root)
+	var lines []string
+	for _, h := range hashes {
+		if _, ok := frozen[h]; ok {
+			continue
+		}
+		v, err := b.latest(h)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+		n := b.g.Nodes[h]
+		b.openTo(h)
+		lines = append(lines, fmt.Sprintf("%s %s (%s), latest %s (%s)", n.Pkg.Name, n.Pkg.Version, h, v.Version, v.Hash))
+	}
+	if len(lines) == 0 {
+		fmt.Fprintln(b.out, "no outdated packages")
+		return nil
+	}
+
+	b.print()
+	for _, l := range lines {
+		fmt.Fprintln(b.out, l)
+	}
+	return nil
+}
+
+// stageUpdate stages an update of the imports of the package at hash in the
+// current package, to the hash in args or else the newest version in the
+// registry
+func (b *browser) stageUpdate(hash string, args []string) error {
+	n := b.g.Nodes[hash]
+	to := ""
+	if len(args) > 0 {
+		to = args[0]
+	} else {
+		v, err := b.latest(hash)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return fmt.Errorf("no newer version of %s known, give the hash to update to", n.Pkg.Name)
+		}
+		to = v.Hash
+	}
+
+	old := gxImport(hash, n.Pkg.Name)
+	if note, ok := frozenImport(cwd, old); ok {
+		return fmt.Errorf("not updating %s, %s", n.Pkg.Name, note)
+	}
+
+	updates, err := loadStagedUpdates()
+	if err != nil {
+		return err
+	}
+	updates = stageUpdate(updates, stagedUpdate{Package: ".", Old: old, New: gxImport(to, n.Pkg.Name)})
+	if err := saveStagedUpdates(updates); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.out, "staged update of %s to %s, apply it with 'gx-go update --apply'\n", old, to)
+	return nil
+}
+
+func posHash(pos string) string {
+	return pos[strings.LastIndex(pos, "/")+1:]
+}
+
+func (b *browser) print() {
+	b.entries = nil
+	fmt.Fprintln(b.out, b.g.Root.Name)
+	var walk func(prefix string, deps []string, depth int)
+	walk = func(prefix string, deps []string, depth int) {
+		for _, h := range deps {
+			pos := h
+			if prefix != "" {
+				pos = prefix + "/" + h
+			}
+
+			n := b.g.Nodes[h]
+			mark := " "
+			if len(n.Children) > 0 {
+				mark = "+"
+				if b.open[pos] {
+					mark = "-"
+				}
+			}
+
+			fmt.Fprintf(b.out, "%4d %s%s %s %s (%s)\n", len(b.entries), strings.Repeat("  ", depth), mark, n.Pkg.Name, n.Pkg.Version, h)
+			b.entries = append(b.entries, pos)
+			if b.open[pos] {
+				walk(pos, n.Children, depth+1)
+			}
+		}
+	}
+
+	var roots []string
+	for _, d := range b.g.Root.Dependencies {
+		roots = append(roots, d.Hash)
+	}
+	walk("", roots, 0)
+}
+
+func (b *browser) printDups() {
+	dups := b.g.duplicates()
+	if len(dups) == 0 {
+		fmt.Fprintln(b.out, "no duplicate packages")
+		return
+	}
+
+	var names []string
+	for n := range dups {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintln(b.out, name)
+		for _, h := range dups[name] {
+			fmt.Fprintf(b.out, "  %s %s\n", b.g.Nodes[h].Pkg.Version, h)
+		}
+	}
+}
+
+// duplicates returns the hashes of every package that appears in the tree
+// at more than one hash, keyed by its dvcs import (or name)
+func (g *depGraph) duplicates() map[string][]string {
+	byImport := make(map[string][]string)
+	for h, n := range g.Nodes {
+		k := n.Pkg.Gx.DvcsImport
+		if k == "" {
+			k = n.Pkg.Name
+		}
+		byImport[k] = append(byImport[k], h)
+	}
+
+	for k, hs := range byImport {
+		if len(hs) < 2 {
+			delete(byImport, k)
+			continue
+		}
+		sort.Strings(hs)
+	}
+	return byImport
+}
+
+// pathsTo returns every chain of hashes from a direct dependency to hash
+func (g *depGraph) pathsTo(hash string) [][]string {
+	var out [][]string
+	var walk func(path []string, h string)
+	walk = func(path []string, h string) {
+		for _, p := range path {
+			if p == h {
+				// cycle
+				return
+			}
+		}
+
+		path = append(path[:len(path):len(path)], h)
+		if h == hash {
+			out = append(out, path)
+			return
+		}
+
+		if n, ok := g.Nodes[h]; ok {
+			for _, c := range n.Children {
+				walk(path, c)
+			}
+		}
+	}
+
+	for _, d := range g.Root.Dependencies {
+		walk(nil, d.Hash)
+	}
+	return out
+}
+
+// chainNames renders a chain of hashes as a readable chain of package names
+func (g *depGraph) chainNames(chain []string) string {
+	names := []string{g.Root.Name}
+	for _, h := range chain {
+		names = append(names, g.Nodes[h].Pkg.Name)
+	}
+	return strings.Join(names, " -> ")
+}
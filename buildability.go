@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// depBuild is the outcome of building a single dependency
+type depBuild struct {
+	Node   *depNode
+	Status string
+	Detail string
+}
+
+var depsVerifyBuildabilityCommand = cli.Command{
+	Name:  "verify-buildability",
+	Usage: "build every dependency on its own and report those that fail",
+	Description: `runs 'go build' on each package of the dependency tree separately, as it
+is vendored and rewritten, up to 8 at a time, and reports which fail to
+compile and why. A broken publish or a dependency missing from a package's
+own package.json shows up at the package it is in, rather than somewhere in
+the build of the whole project.
+
+Dependencies overlaid with a local directory are built from it.
+--no-overrides builds their vendored copies instead.`,
+	Flags: []cli.Flag{
+		noOverridesFlag,
+		formatFlag,
+		templateFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		ovs, restore, err := overlaysFor(c)
+		if err != nil {
+			return err
+		}
+		defer restore()
+
+		g, err := loadDepGraph(pkg, filepath.Join(cwd, vendorDir))
+		if err != nil {
+			return err
+		}
+
+		env, err := gxEnv("")
+		if err != nil {
+			return err
+		}
+
+		var hashes []string
+		for h := range g.Nodes {
+			hashes = append(hashes, h)
+		}
+		sort.Strings(hashes)
+
+		builds := make([]*depBuild, len(hashes))
+		err = parallel(len(hashes), func(i int) error {
+			n := g.Nodes[hashes[i]]
+			VLog("  - building %s (%s)", n.Pkg.Name, n.Dep.Hash)
+			builds[i] = buildDep(n, env)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(builds, func(i, j int) bool {
+			return builds[i].Node.Pkg.Name < builds[j].Node.Pkg.Name
+		})
+
+		var rows [][]string
+		var failed int
+		for _, b := range builds {
+			if b.Status == "FAIL" {
+				failed++
+			}
+			name := b.Node.Pkg.Name
+			if ovs[b.Node.Dep.Hash] != nil {
+				name += " (overlay)"
+			}
+			rows = append(rows, []string{name, b.Node.Pkg.Version, b.Node.Dep.Hash, b.Status, b.Detail})
+		}
+		err = writeResults(os.Stdout, c, []string{"NAME", "VERSION", "HASH", "STATUS", "DETAIL"}, rows)
+		if err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d dependencies failed to build", failed, len(builds))
+		}
+		return nil
+	},
+}
+
+// buildDep builds the packages of the dependency n in the environment env
+func buildDep(n *depNode, env []string) *depBuild {
+	b := &depBuild{Node: n, Status: "ok"}
+
+	// vendored packages are built by path, so their gx imports resolve
+	// through the vendor directory, globally installed ones by import path
+	prefix := gxImport(n.Dep.Hash, n.Pkg.Name)
+	if rel, err := filepath.Rel(cwd, n.Dir); err == nil && !strings.HasPrefix(rel, "..") {
+		prefix = filepath.ToSlash(rel)
+	}
+	target := prefix + "/..."
+	if !strings.HasPrefix(prefix, "gx/") {
+		target = "./" + target
+	}
+
+	// binaries of main packages are thrown away
+	cmd := exec.CommandContext(cancelCtx, "go", "build", "-o", os.DevNull, target)
+	cmd.Dir = cwd
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return b
+	}
+
+	if strings.Contains(string(output), "matched no packages") {
+		b.Status = "skipped"
+		b.Detail = "no go packages"
+		return b
+	}
+
+	b.Status = "FAIL"
+	b.Detail = strings.Replace(buildFailureSummary(string(output)), prefix+"/", "", -1)
+	VLog("%s", output)
+	return b
+}
+
+var missingPackageRE = regexp.MustCompile(`cannot find package "([^"]+)"|no required module provides package ([^ ;:]+)|package ([^ ]+) is not in (?:GOROOT|std)`)
+
+// buildFailureSummary condenses the output of a failed build to a line,
+// naming the missing packages if that is why it failed
+func buildFailureSummary(out string) string {
+	var missing []string
+	for _, m := range missingPackageRE.FindAllStringSubmatch(out, -1) {
+		missing = append(missing, m[1]+m[2]+m[3])
+	}
+	if missing = uniqueStrings(missing); len(missing) > 0 {
+		return "missing " + strings.Join(missing, ", ")
+	}
+
+	for _, l := range strings.Split(out, "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") {
+			return l
+		}
+	}
+	return "build failed"
+}
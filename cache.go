@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	cli "github.com/codegangsta/cli"
+)
+
+// cacheDir returns the directory gx-go keeps its caches in
+func cacheDir() (string, error) {
+	if dir := os.Getenv("GX_GO_CACHE"); dir != "" {
+		return dir, nil
+	}
+
+	ucd, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("couldnt determine user cache dir: %s", err)
+	}
+
+	return filepath.Join(ucd, "gx-go"), nil
+}
+
+// cacheGoPath returns the tool managed GOPATH used by 'import --cache-gopath'
+func cacheGoPath() (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	gp := filepath.Join(dir, "gopath")
+	if err := os.MkdirAll(gp, 0755); err != nil {
+		return "", err
+	}
+
+	return gp, nil
+}
+
+// lockDir takes an exclusive lock on dir, by creating a lock file next to it.
+// The returned function releases the lock.
+func lockDir(dir string) (func(), error) {
+	unlock, held, err := tryLockDir(dir)
+	if held {
+		lk := dir + ".lock"
+		pid, _ := ioutil.ReadFile(lk)
+		return nil, fmt.Errorf("%s is in use by another gx-go process (pid %s), remove %s if that is not the case", dir, pid, lk)
+	}
+	return unlock, err
+}
+
+// waitLockDir takes the lock of lockDir on dir, waiting up to timeout for
+// another process to release it
+func waitLockDir(dir string, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for waited := false; ; waited = true {
+		unlock, held, err := tryLockDir(dir)
+		if !held || time.Now().After(deadline) {
+			if held {
+				return lockDir(dir)
+			}
+			return unlock, err
+		}
+		if !waited {
+			VLog("  - waiting for another gx-go process to release %s", dir)
+		}
+
+		select {
+		case <-cancelCtx.Done():
+			return nil, cancelled()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// tryLockDir takes the lock of lockDir on dir, returning whether it is held
+// by another process instead. A lock left behind by a process that is gone
+// is taken over.
+func tryLockDir(dir string) (func(), bool, error) {
+	lk := dir + ".lock"
+	fi, err := os.OpenFile(lk, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) && staleLock(lk) && takeStaleLock(lk) {
+		fi, err = os.OpenFile(lk, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+
+	fmt.Fprint(fi, strconv.Itoa(os.Getpid()))
+	fi.Close()
+
+	forget := onForcedExit(func() { os.Remove(lk) })
+	return func() {
+		forget()
+		if err := os.Remove(lk); err != nil {
+			Error("failed to release lock %s: %s", lk, err)
+		}
+	}, false, nil
+}
+
+// staleLock returns whether the process that took the lock file lk is gone
+func staleLock(lk string) bool {
+	data, err := ioutil.ReadFile(lk)
+	if err != nil {
+		return false
+	}
+	// the owner may not have written its pid yet
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false
+	}
+	return !processExists(pid)
+}
+
+// takeStaleLock removes the stale lock file lk, returning whether it did.
+// Another process may take the lock between the check and the removal, so
+// the lock is first moved to a name no other process uses and checked again
+// there, and given back if it turns out to be held.
+func takeStaleLock(lk string) bool {
+	tmp := fmt.Sprintf("%s.stale.%d", lk, os.Getpid())
+	if err := os.Rename(lk, tmp); err != nil {
+		return false
+	}
+	defer os.Remove(tmp)
+
+	if !staleLock(tmp) {
+		// linking fails rather than replace a lock taken meanwhile
+		os.Link(tmp, lk)
+		return false
+	}
+	VLog("  - removed the stale lock %s", lk)
+	return true
+}
+
+// processExists returns whether a process with the given pid is running.
+// Where that cannot be told, it is assumed to be.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// finding it opened it
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// tmpGoPath is a temporary GOPATH created by 'import --tmpdir'
+type tmpGoPath struct {
+	Created time.Time `json:"created"`
+	Pid     int       `json:"pid"`
+}
+
+func tmpGoPathsFile() (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tmpgopaths.json"), nil
+}
+
+// updateTmpGoPaths applies f to the tracked temporary GOPATHs, by directory.
+// Failures are only logged, tracking is best effort.
+func updateTmpGoPaths(f func(m map[string]*tmpGoPath)) {
+	p, err := tmpGoPathsFile()
+	if err != nil {
+		Error("%s", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		Error("%s", err)
+		return
+	}
+
+	unlock, err := waitLockDir(p, time.Second)
+	if err != nil {
+		Error("tracking temporary GOPATHs: %s", err)
+		return
+	}
+	defer unlock()
+
+	m := make(map[string]*tmpGoPath)
+	if err := loadMap(&m, p); err != nil && !os.IsNotExist(err) {
+		Error("loading %s: %s", p, err)
+		return
+	}
+
+	f(m)
+
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(p, out, 0644)
+	}
+	if err != nil {
+		Error("saving %s: %s", p, err)
+	}
+}
+
+func trackTmpGoPath(dir string) {
+	updateTmpGoPaths(func(m map[string]*tmpGoPath) {
+		m[dir] = &tmpGoPath{Created: time.Now(), Pid: os.Getpid()}
+	})
+}
+
+func untrackTmpGoPath(dir string) {
+	updateTmpGoPaths(func(m map[string]*tmpGoPath) {
+		delete(m, dir)
+	})
+}
+
+var GcCommand = cli.Command{
+	Name:  "gc",
+	Usage: "clean up caches managed by gx-go",
+	Description: `removes the shared GOPATH used by 'import --cache-gopath'. The GOPATH
+is not removed while an import is using it.
+
+With --tmp, removes the temporary GOPATHs left behind by failed or kept
+'import --tmpdir' runs instead, once they are older than --older-than and
+the import that created them has exited.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "tmp",
+			Usage: "remove stale temporary GOPATHs of 'import --tmpdir'",
+		},
+		cli.DurationFlag{
+			Name:  "older-than",
+			Usage: "only remove temporary GOPATHs older than this",
+			Value: 24 * time.Hour,
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Bool("tmp") {
+			return gcTmpGoPaths(c.Duration("older-than"))
+		}
+
+		gp, err := cacheGoPath()
+		if err != nil {
+			return err
+		}
+
+		unlock, err := lockDir(gp)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		Log("removing %s", gp)
+		return os.RemoveAll(gp)
+	},
+}
+
+func gcTmpGoPaths(age time.Duration) error {
+	var ferr error
+	updateTmpGoPaths(func(m map[string]*tmpGoPath) {
+		for dir, t := range m {
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				delete(m, dir)
+				continue
+			}
+
+			if t.Pid != 0 && processExists(t.Pid) {
+				VLog("  - keeping %s, in use by pid %d", dir, t.Pid)
+				continue
+			}
+
+			if time.Since(t.Created) < age {
+				VLog("  - keeping %s, created %s", dir, t.Created.Format(time.RFC3339))
+				continue
+			}
+
+			Log("removing %s", dir)
+			if err := os.RemoveAll(dir); err != nil {
+				ferr = err
+				continue
+			}
+			delete(m, dir)
+		}
+	})
+	return ferr
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cancelCtx is cancelled when gx-go is interrupted or its --timeout expires.
+// Long running operations check it between steps, so they stop without
+// leaving half written files behind.
+var cancelCtx, cancelFunc = context.WithCancel(context.Background())
+
+// forcedExit holds the cleanups that must run even if gx-go is forced to
+// exit by a second interrupt, like releasing locks
+var forcedExit struct {
+	sync.Mutex
+	next int
+	fs   map[int]func()
+}
+
+// onForcedExit registers f to run if gx-go is forced to exit, the returned
+// function unregisters it
+func onForcedExit(f func()) func() {
+	forcedExit.Lock()
+	defer forcedExit.Unlock()
+
+	if forcedExit.fs == nil {
+		forcedExit.fs = make(map[int]func())
+	}
+	id := forcedExit.next
+	forcedExit.next++
+	forcedExit.fs[id] = f
+
+	return func() {
+		forcedExit.Lock()
+		delete(forcedExit.fs, id)
+		forcedExit.Unlock()
+	}
+}
+
+func runForcedExitCleanups() {
+	forcedExit.Lock()
+	defer forcedExit.Unlock()
+
+	for _, f := range forcedExit.fs {
+		f()
+	}
+	forcedExit.fs = nil
+}
+
+// setupCancellation cancels cancelCtx on SIGINT or SIGTERM, or once the
+// timeout expires if it is not zero. A second signal exits right away.
+func setupCancellation(timeout time.Duration) {
+	if timeout > 0 {
+		cancelCtx, cancelFunc = context.WithTimeout(cancelCtx, timeout)
+	}
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		Warn("interrupted, stopping (interrupt again to exit right away)")
+		cancelFunc()
+
+		<-sigs
+		runForcedExitCleanups()
+		os.Exit(130)
+	}()
+}
+
+// cancelled returns an error if the current command has been interrupted or
+// has timed out
+func cancelled() error {
+	switch cancelCtx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return fmt.Errorf("timed out")
+	default:
+		return fmt.Errorf("interrupted")
+	}
+}
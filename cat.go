@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var CatCommand = cli.Command{
+	Name:      "cat",
+	Usage:     "print a file or list a directory of a published package",
+	ArgsUsage: "<hash>[/path]",
+	Description: `prints the file at the given path in the package published under hash,
+or lists the directory there, the root of the package by default. The
+package itself is in a directory named after it, below the root.
+
+In a gx package, the hash may also be given as the name or alias of a
+dependency. Packages that are neither vendored nor installed globally are
+fetched into the gx-go cache.`,
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify a hash")
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(c.Args().First(), "/ipfs/"), "/", 2)
+		hash := parts[0]
+		var sub string
+		if len(parts) > 1 {
+			sub = parts[1]
+		}
+
+		if pkg, err := LoadPackageFile(gx.PkgFileName); err == nil {
+			dep, err := resolveDep(pkg, hash)
+			if err != nil {
+				return err
+			}
+			if dep != nil {
+				hash = dep.Hash
+			} else if hash, err = expandHash(pkg, filepath.Join(cwd, vendorDir), hash); err != nil {
+				return err
+			}
+		}
+
+		root, err := packageRoot(hash)
+		if err != nil {
+			return err
+		}
+
+		p := filepath.Join(root, filepath.FromSlash(sub))
+		if rel, err := filepath.Rel(root, p); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("%s is outside of the package", sub)
+		}
+
+		fi, err := os.Stat(p)
+		if err != nil {
+			return fmt.Errorf("%s not found in %s", sub, hash)
+		}
+
+		if !fi.IsDir() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(os.Stdout, f)
+			return err
+		}
+
+		ents, err := ioutil.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, e := range ents {
+			if e.IsDir() {
+				fmt.Printf("%s/\n", e.Name())
+			} else {
+				fmt.Printf("%s\t%s\n", e.Name(), humanSize(e.Size()))
+			}
+		}
+		return nil
+	},
+}
+
+// packageRoot returns the directory holding the package published under
+// hash: its vendored copy, its global install, or a copy fetched into the
+// cache
+func packageRoot(hash string) (string, error) {
+	roots := []string{filepath.Join(cwd, vendorDir), globalPath()}
+	cdir, cerr := cacheDir()
+	if cerr == nil {
+		roots = append(roots, filepath.Join(cdir, "pkgs"))
+	}
+
+	for _, root := range roots {
+		for _, h := range hashPaths(hash) {
+			d := filepath.Join(root, filepath.FromSlash(h))
+			var p Package
+			if err := gx.FindPackageInDir(&p, d); err == nil {
+				return d, nil
+			}
+		}
+	}
+
+	if cerr != nil {
+		return "", cerr
+	}
+
+	pm, err := newPM()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(cdir, "pkgs", hash)
+	VLog("  - fetching %s into %s", hash, dir)
+	err = ipfsCall(func() error {
+		_, err := pm.GetPackageTo(hash, dir)
+		return err
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("fetching %s: %s", hash, err)
+	}
+	return dir, nil
+}
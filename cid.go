@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/base32"
+	"fmt"
+	"math/big"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+)
+
+// CidFormat describes how package hashes are written in import paths
+type CidFormat struct {
+	// Version is the cid version, 0 (the default) or 1
+	Version int
+
+	// Multibase is the encoding of version 1 cids, 'base32' (the default)
+	// or 'base58btc'
+	Multibase string
+}
+
+// cidFormat is the hash format of the current package, set from its
+// package.json before running any command
+var cidFormat CidFormat
+
+const dagProtobufCodec = 0x70
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base32Encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+func base58Encode(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	var lead []byte
+	for _, c := range s {
+		if c != rune(base58Alphabet[0]) {
+			break
+		}
+		lead = append(lead, 0)
+	}
+
+	return append(lead, n.Bytes()...), nil
+}
+
+// decodeHash returns the multihash contained in a cid of either version
+func decodeHash(h string) ([]byte, error) {
+	if len(h) == 46 && strings.HasPrefix(h, "Qm") {
+		return base58Decode(h)
+	}
+
+	if len(h) < 2 {
+		return nil, fmt.Errorf("invalid cid %q", h)
+	}
+
+	var raw []byte
+	var err error
+	switch h[0] {
+	case 'b':
+		raw, err = base32Encoding.DecodeString(h[1:])
+	case 'z':
+		raw, err = base58Decode(h[1:])
+	default:
+		return nil, fmt.Errorf("unsupported multibase prefix in %q", h)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid cid %q: %s", h, err)
+	}
+
+	if len(raw) < 2 || raw[0] != 1 || raw[1] != dagProtobufCodec {
+		return nil, fmt.Errorf("%q is not a dag-pb cidv1", h)
+	}
+
+	return raw[2:], nil
+}
+
+// formatHash encodes a multihash as a cid in the given format
+func formatHash(mh []byte, f CidFormat) (string, error) {
+	if f.Version == 0 {
+		return base58Encode(mh), nil
+	}
+
+	raw := append([]byte{1, dagProtobufCodec}, mh...)
+	switch f.Multibase {
+	case "", "base32":
+		return "b" + base32Encoding.EncodeToString(raw), nil
+	case "base58btc":
+		return "z" + base58Encode(raw), nil
+	default:
+		return "", fmt.Errorf("unsupported multibase %q", f.Multibase)
+	}
+}
+
+// convertHash returns the given hash in the given format, or the hash as is
+// if it could not be parsed
+func convertHash(h string, f CidFormat) string {
+	mh, err := decodeHash(h)
+	if err != nil {
+		return h
+	}
+
+	out, err := formatHash(mh, f)
+	if err != nil {
+		return h
+	}
+	return out
+}
+
+// hashForms returns all spellings of a hash that lookups should accept
+func hashForms(h string) []string {
+	forms := []string{h}
+	for _, f := range []CidFormat{{0, ""}, {1, "base32"}, {1, "base58btc"}} {
+		if c := convertHash(h, f); c != h {
+			forms = append(forms, c)
+		}
+	}
+	return forms
+}
+
+var CidCommand = cli.Command{
+	Name:      "cid",
+	Usage:     "convert a package hash between cid versions and encodings",
+	ArgsUsage: "<hash>",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "cid-version",
+			Usage: "cid version to convert to",
+			Value: 1,
+		},
+		cli.StringFlag{
+			Name:  "multibase",
+			Usage: "multibase encoding of version 1 cids (base32 or base58btc)",
+			Value: "base32",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify a hash")
+		}
+
+		mh, err := decodeHash(c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		out, err := formatHash(mh, CidFormat{c.Int("cid-version"), c.String("multibase")})
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(out)
+		return nil
+	},
+}
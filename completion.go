@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// depArgCommands are the commands whose arguments are completed with the
+// names, hashes and aliases of the dependencies of the current package
+var depArgCommands = []string{"update", "rewrite", "fork", "freeze", "cat"}
+
+var CompletionCommand = cli.Command{
+	Name:      "completion",
+	Usage:     "print a shell completion script",
+	ArgsUsage: "<bash|zsh|fish>",
+	Description: `prints a completion script for the given shell. Arguments of the
+update, rewrite, fork, freeze and cat commands are completed with the names
+and hashes of the dependencies of the package in the current directory.
+
+   bash: source <(gx-go completion bash)
+   zsh:  source <(gx-go completion zsh)
+   fish: gx-go completion fish | source`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "deps",
+			Usage: "print the dependency names and hashes to complete with",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Bool("deps") {
+			for _, w := range depCompletions() {
+				fmt.Println(w)
+			}
+			return nil
+		}
+
+		var cmds []string
+		for _, cmd := range c.App.Commands {
+			cmds = append(cmds, cmd.Name)
+		}
+		words := strings.Join(cmds, " ")
+		depcmds := strings.Join(depArgCommands, " ")
+
+		switch c.Args().First() {
+		case "bash":
+			fmt.Printf(bashCompletion, words, depcmds)
+		case "zsh":
+			fmt.Printf("autoload -U +X bashcompinit && bashcompinit\n"+bashCompletion, words, depcmds)
+		case "fish":
+			fmt.Printf(fishCompletion, words, strings.Join(depArgCommands, " "))
+		default:
+			return fmt.Errorf("must specify one of bash, zsh or fish")
+		}
+		return nil
+	},
+}
+
+// depCompletions returns the names and hashes of all dependencies of the
+// package in the current directory, failing silently
+func depCompletions() []string {
+	pkg, err := LoadPackageFile(filepath.Join(cwd, gx.PkgFileName))
+	if err != nil {
+		return nil
+	}
+
+	var out []string
+	for _, d := range pkg.Dependencies {
+		out = append(out, d.Name, d.Hash)
+	}
+	for a := range pkg.Gx.Aliases {
+		out = append(out, a)
+	}
+	return out
+}
+
+const bashCompletion = `_gx_go() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [ $COMP_CWORD -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+	fi
+
+	case " %s " in
+	*" ${COMP_WORDS[1]} "*)
+		COMPREPLY=($(compgen -W "$(gx-go completion --deps 2>/dev/null)" -- "$cur"))
+		;;
+	esac
+}
+complete -F _gx_go gx-go
+`
+
+const fishCompletion = `complete -c gx-go -f -n '__fish_use_subcommand' -a '%s'
+complete -c gx-go -f -n '__fish_seen_subcommand_from %s' -a '(gx-go completion --deps 2>/dev/null)'
+`
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config holds the users gx-go settings, loaded from ~/.gx-go/config.json
+type Config struct {
+	// GithubToken authenticates requests to the github api, raising its
+	// rate limit. The GITHUB_TOKEN environment variable takes precedence.
+	GithubToken string `json:"githubToken,omitempty"`
+
+	// Registry is the package registry queried by 'search', either an http
+	// url or an /ipfs/ or /ipns/ path
+	Registry string `json:"registry,omitempty"`
+
+	// RegistryPublish is the endpoint 'import' posts the metadata of the
+	// packages it publishes to, keeping the registry current
+	RegistryPublish string `json:"registryPublish,omitempty"`
+
+	// BinDir is where executables of globally installed packages go
+	BinDir string `json:"binDir,omitempty"`
+
+	// Gateway is the ipfs gateway used to fetch /ipfs/ and /ipns/ paths
+	Gateway string `json:"gateway,omitempty"`
+
+	// IpfsConcurrency and IpfsRate limit how many calls to gx and the ipfs
+	// daemon are made at once, and how many are started per second. Zero
+	// means no limit.
+	IpfsConcurrency int     `json:"ipfsConcurrency,omitempty"`
+	IpfsRate        float64 `json:"ipfsRate,omitempty"`
+
+	// Dedupe turns on deduplication of installed files, see the dedupe
+	// command. It is either "link" or "copy".
+	Dedupe string `json:"dedupe,omitempty"`
+
+	// NoStamp turns off recording gx.lastTool in package.json on import and
+	// rewrite, as does setting GX_GO_NO_STAMP
+	NoStamp bool `json:"noStamp,omitempty"`
+
+	// PinningServices and ProbeGateways are checked by 'probe' for whether
+	// dependency hashes can still be fetched
+	PinningServices []PinningService `json:"pinningServices,omitempty"`
+	ProbeGateways   []string         `json:"probeGateways,omitempty"`
+
+	// Policy is checked by 'policy check', the package.json policy takes
+	// precedence
+	Policy *Policy `json:"policy,omitempty"`
+
+	// ReadOnlyVendor is what the post-install hook does with packages in a
+	// read-only vendor tree: "skip" rewriting them, or "shadow" them with
+	// rewritten copies for 'go build -overlay'. GX_GO_READONLY_VENDOR
+	// takes precedence.
+	ReadOnlyVendor string `json:"readOnlyVendor,omitempty"`
+
+	// Sources select where 'import' fetches the imports below their prefix
+	// from, instead of 'go get'
+	Sources []importSource `json:"sources,omitempty"`
+
+	// Metrics is where commands record how long fetches, publishes and
+	// rewrites took, and how often the cache was hit: a statsd://host:port
+	// server or an OpenMetrics file. --metrics and GX_GO_METRICS take
+	// precedence.
+	Metrics string `json:"metrics,omitempty"`
+}
+
+// configDir returns the directory gx-go keeps its user level state in
+func configDir() (string, error) {
+	if dir := os.Getenv("GX_GO_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("couldnt determine home dir: %s", err)
+	}
+
+	return filepath.Join(home, ".gx-go"), nil
+}
+
+// loadConfig loads the users config, a missing config file is not an error
+func loadConfig() (*Config, error) {
+	var cfg Config
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadMap(&cfg, filepath.Join(dir, "config.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading config: %s", err)
+	}
+
+	return &cfg, nil
+}
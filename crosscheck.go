@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var CrossCheckCommand = cli.Command{
+	Name:  "cross-check",
+	Usage: "check that the package builds for a matrix of platforms",
+	Description: `attempts to 'go build ./...' the current package for each of the
+given GOOS/GOARCH pairs, and reports which targets fail to build and
+which gx dependencies the failures originate in.
+
+Dependencies overlaid with a local directory are built from it and listed.
+--no-overrides builds with their vendored copies instead.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "platforms",
+			Usage: platformsUsage,
+		},
+		cli.BoolFlag{
+			Name:  "rewrite",
+			Usage: "rewrite imports to gx paths for the build and undo it afterwards",
+		},
+		noOverridesFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		platforms, err := parsePlatforms(c.String("platforms"))
+		if err != nil {
+			return err
+		}
+
+		ovs, restore, err := overlaysFor(c)
+		if err != nil {
+			return err
+		}
+		defer restore()
+		for _, h := range overlaidHashes(ovs) {
+			Log("%s is built from its overlay %s", ovs[h].Name, ovs[h].Dir)
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		mapping := make(map[string]string)
+		err = buildRewriteMapping(pkg, pkgdir, mapping, false)
+		if err != nil {
+			return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+		}
+
+		if c.Bool("rewrite") {
+			err = doRewrite(pkg, cwd, copyMap(mapping))
+			if err != nil {
+				return err
+			}
+
+			defer func() {
+				undo := make(map[string]string)
+				for k, v := range mapping {
+					undo[v] = k
+				}
+
+				if err := doRewrite(pkg, cwd, undo); err != nil {
+					Error("failed to undo rewrite: %s", err)
+				}
+			}()
+		}
+
+		// map hashes back to the dependencies they belong to for reporting
+		names := make(map[string]string)
+		for dvcs, gxpath := range mapping {
+			names[hashFromImport(gxpath)] = dvcs
+		}
+
+		env, err := gxEnv("")
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 12, 4, 1, ' ', 0)
+		var failed int
+		for _, p := range platforms {
+			if err := cancelled(); err != nil {
+				return err
+			}
+			VLog("  - building for %s", p)
+			out, err := crossBuild(cwd, env, p)
+			if err == nil {
+				fmt.Fprintf(w, "%s\tok\t\n", p)
+				continue
+			}
+
+			failed++
+			culprits := buildFailureDeps(out, names)
+			if len(culprits) == 0 {
+				fmt.Fprintf(w, "%s\tFAIL\t%s\n", p, pkg.Name)
+			} else {
+				fmt.Fprintf(w, "%s\tFAIL\t%s\n", p, strings.Join(culprits, ", "))
+			}
+			VLog("%s", out)
+		}
+		w.Flush()
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d targets failed to build", failed, len(platforms))
+		}
+
+		return nil
+	},
+}
+
+// crossBuild builds the packages in dir for the platform p in the
+// environment env
+func crossBuild(dir string, env []string, p platform) (string, error) {
+	cmd := exec.CommandContext(cancelCtx, "go", "build", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(env, "GOOS="+p.GOOS, "GOARCH="+p.GOARCH, "CGO_ENABLED=0")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+var gxPathRE = regexp.MustCompile(`gx/ipfs/(?:\w\w/)?(\w+)/`)
+
+// buildFailureDeps returns the dependencies that build output from a failed
+// build points at, using names to map hashes to readable names
+func buildFailureDeps(out string, names map[string]string) []string {
+	found := make(map[string]struct{})
+	for _, m := range gxPathRE.FindAllStringSubmatch(out, -1) {
+		name, ok := names[m[1]]
+		if !ok {
+			name = m[1]
+		}
+		found[name] = struct{}{}
+	}
+
+	var res []string
+	for n := range found {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func copyMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	cli "github.com/codegangsta/cli"
+)
+
+const (
+	dedupeLink = "link"
+	dedupeCopy = "copy"
+)
+
+// storeDir returns the content addressed file store used for deduplicating
+// vendored files
+func storeDir() (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "store"), nil
+}
+
+// dedupeDir replaces every file in dir that has identical content in the
+// store with a hardlink to it, and adds the others to the store. In copy
+// mode files are only added to the store. It returns the number of bytes
+// saved.
+func dedupeDir(dir, mode string) (int64, error) {
+	store, err := storeDir()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(store, 0755); err != nil {
+		return 0, err
+	}
+
+	var saved int64
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if p != dir && isVcsMetaDir(fi.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !fi.Mode().IsRegular() || fi.Size() == 0 {
+			return nil
+		}
+
+		sum, err := fileSum(p)
+		if err != nil {
+			return err
+		}
+
+		stored := filepath.Join(store, sum[:2], sum)
+		sfi, err := os.Stat(stored)
+		switch {
+		case os.IsNotExist(err):
+			return addToStore(p, stored, mode)
+		case err != nil:
+			return err
+		case os.SameFile(fi, sfi) || mode == dedupeCopy:
+			return nil
+		}
+
+		if err := replaceWithLink(stored, p); err != nil {
+			Warn("could not hardlink %s, keeping a copy: %s", p, err)
+			return nil
+		}
+
+		saved += fi.Size()
+		return nil
+	})
+	return saved, err
+}
+
+func fileSum(p string) (string, error) {
+	fi, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer fi.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fi); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// addToStore adds the file at p to the store, linking it in where possible
+func addToStore(p, stored, mode string) error {
+	if err := os.MkdirAll(filepath.Dir(stored), 0755); err != nil {
+		return err
+	}
+
+	if mode != dedupeCopy {
+		if err := os.Link(p, stored); err == nil {
+			return nil
+		}
+	}
+
+	tmp := stored + ".tmp"
+	if err := copyFile(p, tmp, 0444); err != nil {
+		return err
+	}
+	return os.Rename(tmp, stored)
+}
+
+// replaceWithLink atomically replaces p with a hardlink to stored
+func replaceWithLink(stored, p string) error {
+	tmp := p + ".gxlink"
+	if err := os.Link(stored, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// dedupeMode returns the dedupe mode set in the users config, empty if
+// deduplication is off
+func dedupeMode() string {
+	cfg, err := loadConfig()
+	if err != nil {
+		return ""
+	}
+
+	switch cfg.Dedupe {
+	case "", dedupeLink, dedupeCopy:
+		return cfg.Dedupe
+	default:
+		Warn("ignoring unknown dedupe mode %q, expected %q or %q", cfg.Dedupe, dedupeLink, dedupeCopy)
+		return ""
+	}
+}
+
+var DedupeCommand = cli.Command{
+	Name:  "dedupe",
+	Usage: "deduplicate identical files across vendored dependencies",
+	Description: `hardlinks every file in vendor/gx that has identical content to one
+seen before into a content addressed store in the user cache, and reports
+the space saved. Set "dedupe" to "link" or "copy" in ~/.gx-go/config.json to
+do this for every installed package.
+
+Use --copy on filesystems without hardlink support, files are then only
+recorded in the store.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "copy",
+			Usage: "do not hardlink, only populate the store",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		mode := dedupeLink
+		if c.Bool("copy") {
+			mode = dedupeCopy
+		}
+
+		saved, err := dedupeDir(filepath.Join(cwd, vendorDir), mode)
+		if err != nil {
+			return err
+		}
+
+		Log("saved %s", humanSize(saved))
+		return nil
+	},
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// generatedRE matches the standard header of generated go files
+var generatedRE = regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$`)
+
+// isGeneratedFile returns whether the go file with the given name and
+// content was generated, by its header or a well known file name
+func isGeneratedFile(name string, src []byte) bool {
+	base := filepath.Base(name)
+	if base == "wire_gen.go" || strings.HasPrefix(base, "mock_") || strings.HasSuffix(base, "_mock.go") {
+		return true
+	}
+	return generatedRE.Match(src)
+}
+
+// deepRewrite rewrites the import paths in mapping where they appear in the
+// comments and string literals of src
+func deepRewrite(src []byte, mapping map[string]string) []byte {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+
+	var out bytes.Buffer
+	last := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.COMMENT && tok != token.STRING {
+			continue
+		}
+
+		off := file.Offset(pos)
+		nlit := replacePaths(lit, mapping)
+		if nlit == lit {
+			continue
+		}
+
+		out.Write(src[last:off])
+		out.WriteString(nlit)
+		last = off + len(lit)
+	}
+
+	if last == 0 {
+		return src
+	}
+	out.Write(src[last:])
+	return out.Bytes()
+}
+
+// replacePaths replaces whole import paths from mapping found in s, the
+// longest that matches wherever several do
+func replacePaths(s string, mapping map[string]string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		if i == 0 || !isPathChar(s[i-1]) {
+			end := i
+			for end < len(s) && isPathChar(s[end]) {
+				end++
+			}
+			if ms := mappedPaths(s[i:end], mapping, true); len(ms) > 0 {
+				out.WriteString(mapping[ms[0]])
+				i += len(ms[0])
+				continue
+			}
+		}
+		out.WriteByte(s[i])
+		i++
+	}
+	return out.String()
+}
+
+func isPathChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == '/' || c == '~'
+}
+
+// deepRewriteGenerated applies deepRewrite to the generated files of the
+// package in dir, showing the changes and asking for confirmation first
+// unless yes is set
+func deepRewriteGenerated(dir string, mapping map[string]string, yes bool) error {
+	nested := nestedPackages(dir)
+	changed := make(map[string][]byte)
+	var names []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+		if fi.IsDir() {
+			if rel != "." && (skipDir(fi.Name()) || inNested(nested, rel)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(p, ".go") {
+			return nil
+		}
+
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		if !isGeneratedFile(p, src) {
+			return nil
+		}
+
+		nsrc := deepRewrite(src, mapping)
+		if !bytes.Equal(src, nsrc) {
+			fmt.Printf("--- %s\n", rel)
+			printLineDiff(src, nsrc)
+			changed[p] = nsrc
+			names = append(names, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(changed) == 0 {
+		VLog("  - no generated files to rewrite")
+		return nil
+	}
+
+	if !yes && !yesNoPrompt("rewrite.deep", "", fmt.Sprintf("apply these changes to %d generated files?", len(changed)), false) {
+		return nil
+	}
+
+	for _, p := range names {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		tmp := p + ".temp"
+		if err := ioutil.WriteFile(tmp, changed[p], fi.Mode()); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printLineDiff prints the lines that differ between a and b, which have the
+// same number of lines
+func printLineDiff(a, b []byte) {
+	al := strings.Split(string(a), "\n")
+	bl := strings.Split(string(b), "\n")
+	for i := range al {
+		if i < len(bl) && al[i] != bl[i] {
+			fmt.Printf("%d:\n-%s\n+%s\n", i+1, al[i], bl[i])
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// depPolicyFileName is the dependency policy of a project, at its root
+const depPolicyFileName = ".gx-policy.json"
+
+// depPolicy constrains which dependencies a project may pull in, so platform
+// teams can set the rules for product repositories. It is enforced by
+// 'import', the req-check hook gx runs before installing a package, and
+// 'policy check'.
+type depPolicy struct {
+	// Allow, if set, lists the only import paths dependencies may have.
+	// Entries match the path and everything below it, so an organisation
+	// like "github.com/org" allows all of its repositories, and may contain
+	// '*' wildcards.
+	Allow []string `json:"allow,omitempty"`
+
+	// Deny lists import paths dependencies may not have, in the same form
+	// as Allow. It takes precedence over Allow.
+	Deny []string `json:"deny,omitempty"`
+
+	// Licenses, if set, lists the licenses dependencies may have, as SPDX
+	// identifiers like "MIT" or "Apache-2.0"
+	Licenses []string `json:"licenses,omitempty"`
+
+	// MaxDepth is how deep the dependency tree may be, direct dependencies
+	// being at depth 1
+	MaxDepth int `json:"maxDepth,omitempty"`
+}
+
+// loadDepPolicy loads the dependency policy of the project in dir, it
+// returns nil if there is none
+func loadDepPolicy(dir string) (*depPolicy, error) {
+	var pol depPolicy
+	if err := loadMap(&pol, filepath.Join(dir, depPolicyFileName)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("loading %s: %s", depPolicyFileName, err)
+	}
+	return &pol, nil
+}
+
+// matchesImport returns whether imp is or is below one of the patterns
+func matchesImport(patterns []string, imp string) bool {
+	for _, p := range patterns {
+		p = strings.TrimSuffix(p, "/")
+		if imp == p || strings.HasPrefix(imp, p+"/") {
+			return true
+		}
+		if !strings.Contains(p, "*") {
+			continue
+		}
+
+		// match the pattern against the leading elements of imp
+		parts := strings.Split(imp, "/")
+		n := len(strings.Split(p, "/"))
+		if n <= len(parts) {
+			if ok, _ := path.Match(p, strings.Join(parts[:n], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkImport returns why a dependency with the import path imp is not
+// allowed, or "" if it is
+func (pol *depPolicy) checkImport(imp string) string {
+	if pol == nil || imp == "" {
+		return ""
+	}
+	if matchesImport(pol.Deny, imp) {
+		return "denied import path"
+	}
+	if len(pol.Allow) > 0 && !matchesImport(pol.Allow, imp) {
+		return "import path not allowed"
+	}
+	return ""
+}
+
+// checkLicense returns why a dependency with the given license is not
+// allowed, or "" if it is
+func (pol *depPolicy) checkLicense(license string) string {
+	if pol == nil || len(pol.Licenses) == 0 {
+		return ""
+	}
+	if license == "" {
+		return "unknown license"
+	}
+	for _, l := range pol.Licenses {
+		if strings.EqualFold(l, license) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("license %s not allowed", license)
+}
+
+// checkDepth returns why a dependency at the given depth of the tree is not
+// allowed, or "" if it is
+func (pol *depPolicy) checkDepth(depth int) string {
+	if pol == nil || pol.MaxDepth <= 0 || depth <= pol.MaxDepth {
+		return ""
+	}
+	return fmt.Sprintf("depth %d exceeds %d", depth, pol.MaxDepth)
+}
+
+// check returns all the ways the package dpkg in dir, at the given depth of
+// the tree, violates the policy. A depth of zero is not checked.
+func (pol *depPolicy) check(dpkg *Package, dir string, depth int) []string {
+	var out []string
+	for _, v := range []string{
+		pol.checkImport(dpkg.Gx.DvcsImport),
+		pol.checkLicense(packageLicense(dpkg, dir)),
+		pol.checkDepth(depth),
+	} {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// packageLicense returns the license of the package dpkg with its source in
+// dir, from its package.json or else its license file
+func packageLicense(dpkg *Package, dir string) string {
+	if dpkg.License != "" {
+		return dpkg.License
+	}
+	return detectLicense(dir)
+}
+
+var licenseFiles = []string{"LICENSE", "LICENSE.md", "LICENSE.txt", "LICENCE", "COPYING", "COPYING.md"}
+
+// detectLicense guesses the SPDX identifier of the license of the source in
+// dir from the text of its license file, returning "" if it does not know it
+func detectLicense(dir string) string {
+	var text string
+	for _, name := range licenseFiles {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			text = strings.Join(strings.Fields(string(b)), " ")
+			break
+		}
+	}
+	if text == "" {
+		return ""
+	}
+
+	has := func(s string) bool {
+		return strings.Contains(text, s)
+	}
+	switch {
+	case has("Apache License") && has("Version 2.0"):
+		return "Apache-2.0"
+	case has("Mozilla Public License") && has("2.0"):
+		return "MPL-2.0"
+	case has("GNU AFFERO GENERAL PUBLIC LICENSE"):
+		return "AGPL-3.0"
+	case has("GNU LESSER GENERAL PUBLIC LICENSE") && has("Version 3"):
+		return "LGPL-3.0"
+	case has("GNU LESSER GENERAL PUBLIC LICENSE"), has("GNU LIBRARY GENERAL PUBLIC LICENSE"):
+		return "LGPL-2.1"
+	case has("GNU GENERAL PUBLIC LICENSE") && has("Version 3"):
+		return "GPL-3.0"
+	case has("GNU GENERAL PUBLIC LICENSE"):
+		return "GPL-2.0"
+	case has("Permission is hereby granted, free of charge"):
+		return "MIT"
+	case has("Permission to use, copy, modify, and/or distribute"), has("Permission to use, copy, modify, and distribute this software for any purpose with or without fee"):
+		return "ISC"
+	case has("Redistribution and use in source and binary forms") && has("Neither the name"):
+		return "BSD-3-Clause"
+	case has("Redistribution and use in source and binary forms"):
+		return "BSD-2-Clause"
+	case has("This is free and unencumbered software released into the public domain"):
+		return "Unlicense"
+	}
+	return ""
+}
+
+// depPolicyResult is how a package in the dependency tree fares against the
+// dependency policy
+type depPolicyResult struct {
+	Dep     *gx.Dependency
+	Pkg     *Package
+	Depth   int
+	License string
+
+	Violations []string
+}
+
+// checkDepPolicy checks every package in the dependency tree of pkg against
+// pol, at the shallowest depth it is found at
+func checkDepPolicy(pol *depPolicy, pkg *Package, pkgdir string) ([]*depPolicyResult, error) {
+	prefetchDeps(pkg, pkgdir, 0)
+
+	var out []*depPolicyResult
+	seen := make(map[string]bool)
+	level := pkg.Dependencies
+	for depth := 1; len(level) > 0; depth++ {
+		var next []*gx.Dependency
+		for _, dep := range level {
+			if seen[dep.Hash] {
+				continue
+			}
+			seen[dep.Hash] = true
+
+			dpkg, dir, err := findDep(dep, pkgdir)
+			if err != nil {
+				return nil, fmt.Errorf("loading dep %q: %s", dep.Name, err)
+			}
+			out = append(out, &depPolicyResult{
+				Dep:        dep,
+				Pkg:        dpkg,
+				Depth:      depth,
+				License:    packageLicense(dpkg, dir),
+				Violations: pol.check(dpkg, dir, depth),
+			})
+			next = append(next, dpkg.Dependencies...)
+		}
+		level = next
+	}
+	return out, nil
+}
@@ -0,0 +1,939 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var DepsCommand = cli.Command{
+	Name:  "deps",
+	Usage: "inspect the dependency tree of the current package",
+	Subcommands: []cli.Command{
+		depsLsCommand,
+		depsTreeCommand,
+		depsStdlibUsageCommand,
+		depsStatsCommand,
+		depsOwnersCommand,
+		depsVerifyBuildabilityCommand,
+		depsAttestCommand,
+	},
+	Action: func(c *cli.Context) error { return nil },
+}
+
+// forEachDep calls f exactly once for each package in the dependency tree of
+// pkg, with the directory its source lives in
+func forEachDep(pkg *Package, pkgdir string, f func(dep *gx.Dependency, dpkg *Package, dir string) error) error {
+	prefetchDeps(pkg, pkgdir, 0)
+
+	seen := make(map[string]bool)
+	var walk func(pkg *Package) error
+	walk = func(pkg *Package) error {
+		for _, dep := range pkg.Dependencies {
+			if seen[dep.Hash] {
+				continue
+			}
+			seen[dep.Hash] = true
+
+			dpkg, dir, err := findDep(dep, pkgdir)
+			if err != nil {
+				return fmt.Errorf("loading dep %q of %q: %s", dep.Name, pkg.Name, err)
+			}
+
+			if err := f(dep, dpkg, dir); err != nil {
+				return err
+			}
+
+			if err := walk(dpkg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(pkg)
+}
+
+// depNode is a package in the dependency graph of the current package
+type depNode struct {
+	Dep *gx.Dependency
+	Pkg *Package
+	Dir string
+
+	// Children are the hashes of the nodes dependencies
+	Children []string
+}
+
+// depGraph is the dependency graph of a package, keyed by hash
+type depGraph struct {
+	Root  *Package
+	Nodes map[string]*depNode
+}
+
+// loadDepGraph loads the full dependency graph of pkg
+func loadDepGraph(pkg *Package, pkgdir string) (*depGraph, error) {
+	g := &depGraph{
+		Root:  pkg,
+		Nodes: make(map[string]*depNode),
+	}
+
+	err := forEachDep(pkg, pkgdir, func(dep *gx.Dependency, dpkg *Package, dir string) error {
+		n := &depNode{Dep: dep, Pkg: dpkg, Dir: dir}
+		for _, c := range dpkg.Dependencies {
+			n.Children = append(n.Children, c.Hash)
+		}
+		g.Nodes[dep.Hash] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// isDirect returns whether the given hash is a direct dependency of the root
+func (g *depGraph) isDirect(hash string) bool {
+	for _, d := range g.Root.Dependencies {
+		if d.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// dependents returns, for each hash, the number of packages depending on it
+func (g *depGraph) dependents() map[string]int {
+	out := make(map[string]int)
+	for _, d := range g.Root.Dependencies {
+		out[d.Hash]++
+	}
+	for _, n := range g.Nodes {
+		for _, c := range n.Children {
+			out[c]++
+		}
+	}
+	return out
+}
+
+// longestChain returns the longest chain of dependencies starting at the root
+func (g *depGraph) longestChain() []string {
+	memo := make(map[string][]string)
+	var chain func(h string) []string
+	chain = func(h string) []string {
+		if c, ok := memo[h]; ok {
+			return c
+		}
+
+		var best []string
+		if n, ok := g.Nodes[h]; ok {
+			for _, c := range n.Children {
+				if cc := chain(c); len(cc) > len(best) {
+					best = cc
+				}
+			}
+		}
+
+		memo[h] = append([]string{h}, best...)
+		return memo[h]
+	}
+
+	var best []string
+	for _, d := range g.Root.Dependencies {
+		if c := chain(d.Hash); len(c) > len(best) {
+			best = c
+		}
+	}
+	return best
+}
+
+// dirSize returns the total size of the files under dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// goImportsInDir returns all imports of the go files under dir, excluding
+// any nested vendor directories
+func goImportsInDir(dir string) (map[string]struct{}, error) {
+	imps := make(map[string]struct{})
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if p != dir && (skipDir(fi.Name()) || strings.HasPrefix(fi.Name(), "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(p, ".go") {
+			return nil
+		}
+
+		f, err := parser.ParseFile(token.NewFileSet(), p, nil, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+
+		for _, imp := range f.Imports {
+			ip, err := strconv.Unquote(imp.Path.Value)
+			if err != nil {
+				return err
+			}
+			imps[ip] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return imps, nil
+}
+
+// nonPortableStd are the standard library packages (and pseudo packages) that
+// usually keep code from building for js/wasm or tinygo
+var nonPortableStd = map[string]bool{
+	"C":           true,
+	"unsafe":      true,
+	"syscall":     true,
+	"os/exec":     true,
+	"os/signal":   true,
+	"os/user":     true,
+	"net":         true,
+	"plugin":      true,
+	"runtime/cgo": true,
+}
+
+var depsStdlibUsageCommand = cli.Command{
+	Name:  "stdlib-usage",
+	Usage: "report which standard library packages each dependency uses",
+	Description: `lists, for each package in the dependency tree, the standard library
+packages it imports that are likely to be unavailable on js/wasm or
+tinygo targets. Use --all to list every standard library import.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "list all standard library imports, not just non-portable ones",
+		},
+		formatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		var rows [][]string
+		err = forEachDep(pkg, filepath.Join(cwd, vendorDir), func(dep *gx.Dependency, dpkg *Package, dir string) error {
+			imps, err := goImportsInDir(dir)
+			if err != nil {
+				return fmt.Errorf("scanning %s: %s", dpkg.Name, err)
+			}
+
+			var std []string
+			for imp := range imps {
+				if !isStdlib(imp) {
+					continue
+				}
+				if c.Bool("all") || nonPortableStd[imp] {
+					std = append(std, imp)
+				}
+			}
+
+			if len(std) == 0 {
+				return nil
+			}
+
+			sort.Strings(std)
+			rows = append(rows, []string{dpkg.Name, dep.Hash, strings.Join(std, ", ")})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return writeTable(os.Stdout, c.String("format"), []string{"PACKAGE", "HASH", "STD IMPORTS"}, rows)
+	},
+}
+
+var depsStatsCommand = cli.Command{
+	Name:  "stats",
+	Usage: "print metrics about the dependency tree",
+	Description: `prints the number of packages in the dependency tree, direct and
+transitive, their vendored size, the longest dependency chain, the most
+depended upon packages and the packages present in more than one version.
+
+The churn of a dependency is how many of its versions the configured
+registry lists as published within --churn-window. Without a registry, or
+publication dates in it, churn is left out.
+
+--format json prints the stats as one object. The other formats and
+--template list them as metric, name and value rows.`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "top",
+			Usage: "number of most depended upon packages to list",
+			Value: 10,
+		},
+		cli.StringFlag{
+			Name:  "churn-window",
+			Usage: "period to count published versions of dependencies in, like 90d or 4w",
+			Value: "365d",
+		},
+		formatFlag,
+		templateFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		window, err := parseAge(c.String("churn-window"))
+		if err != nil {
+			return fmt.Errorf("invalid --churn-window: %s", err)
+		}
+
+		g, err := loadDepGraph(pkg, filepath.Join(cwd, vendorDir))
+		if err != nil {
+			return err
+		}
+
+		stats, err := g.stats(c.Int("top"))
+		if err != nil {
+			return err
+		}
+		if stats.Churn, err = g.churn(window); err != nil {
+			Warn("not reporting churn: %s", err)
+		}
+
+		if c.String("format") == "json" && c.String("template") == "" {
+			out, err := json.MarshalIndent(stats, "", "  ")
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(out)
+			fmt.Println()
+			return nil
+		}
+		if c.String("format") != "" || c.String("template") != "" {
+			return writeResults(os.Stdout, c, []string{"METRIC", "NAME", "VALUE"}, stats.rows())
+		}
+
+		fmt.Printf("packages:      %d\n", stats.Packages)
+		fmt.Printf("direct:        %d\n", stats.Direct)
+		fmt.Printf("transitive:    %d\n", stats.Transitive)
+		fmt.Printf("vendored size: %d bytes\n", stats.Bytes)
+		fmt.Printf("longest chain: %s\n", strings.Join(stats.LongestChain, " -> "))
+		fmt.Println("most depended upon:")
+		for _, d := range stats.MostDepended {
+			fmt.Printf("  %4d  %s\n", d.Dependents, d.Name)
+		}
+		if len(stats.Versions) > 0 {
+			fmt.Println("packages with multiple versions in the tree:")
+			for _, n := range stats.versionNames() {
+				fmt.Printf("  %s: %s\n", n, strings.Join(stats.Versions[n], ", "))
+			}
+		}
+		if len(stats.Churn) > 0 {
+			fmt.Printf("versions published in the last %s:\n", c.String("churn-window"))
+			for _, ch := range stats.Churn {
+				fmt.Printf("  %4d  %s\n", ch.Versions, ch.Name)
+			}
+		}
+		return nil
+	},
+}
+
+type depStats struct {
+	Packages     int                 `json:"packages"`
+	Direct       int                 `json:"direct"`
+	Transitive   int                 `json:"transitive"`
+	Bytes        int64               `json:"bytes"`
+	LongestChain []string            `json:"longestChain"`
+	MostDepended []dependedUpon      `json:"mostDepended"`
+	Versions     map[string][]string `json:"versions,omitempty"`
+	Churn        []versionChurn      `json:"churn,omitempty"`
+}
+
+// versionChurn is how many versions of a package were published recently
+type versionChurn struct {
+	Name     string `json:"name"`
+	Import   string `json:"import"`
+	Versions int    `json:"versions"`
+}
+
+type dependedUpon struct {
+	Name       string `json:"name"`
+	Hash       string `json:"hash"`
+	Dependents int    `json:"dependents"`
+}
+
+func (g *depGraph) stats(top int) (*depStats, error) {
+	s := &depStats{
+		Packages: len(g.Nodes),
+		Versions: make(map[string][]string),
+	}
+
+	versions := make(map[string]map[string]bool)
+	for h, n := range g.Nodes {
+		if g.isDirect(h) {
+			s.Direct++
+		} else {
+			s.Transitive++
+		}
+
+		size, err := dirSize(n.Dir)
+		if err != nil {
+			return nil, err
+		}
+		s.Bytes += size
+
+		if versions[n.Pkg.Name] == nil {
+			versions[n.Pkg.Name] = make(map[string]bool)
+		}
+		versions[n.Pkg.Name][n.Pkg.Version] = true
+	}
+
+	for name, vs := range versions {
+		if len(vs) < 2 {
+			continue
+		}
+		for v := range vs {
+			s.Versions[name] = append(s.Versions[name], v)
+		}
+		sort.Strings(s.Versions[name])
+	}
+
+	for _, h := range g.longestChain() {
+		s.LongestChain = append(s.LongestChain, g.Nodes[h].Pkg.Name)
+	}
+
+	for h, cnt := range g.dependents() {
+		if n, ok := g.Nodes[h]; ok {
+			s.MostDepended = append(s.MostDepended, dependedUpon{n.Pkg.Name, h, cnt})
+		}
+	}
+	sort.Slice(s.MostDepended, func(i, j int) bool {
+		a, b := s.MostDepended[i], s.MostDepended[j]
+		if a.Dependents != b.Dependents {
+			return a.Dependents > b.Dependents
+		}
+		return a.Name < b.Name
+	})
+	if len(s.MostDepended) > top {
+		s.MostDepended = s.MostDepended[:top]
+	}
+
+	return s, nil
+}
+
+// versionNames returns the names of the packages with multiple versions in
+// the tree, sorted
+func (s *depStats) versionNames() []string {
+	var names []string
+	for n := range s.Versions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rows returns the stats as rows of metric, name and value
+func (s *depStats) rows() [][]string {
+	rows := [][]string{
+		{"packages", "", strconv.Itoa(s.Packages)},
+		{"direct", "", strconv.Itoa(s.Direct)},
+		{"transitive", "", strconv.Itoa(s.Transitive)},
+		{"bytes", "", strconv.FormatInt(s.Bytes, 10)},
+		{"longest chain", "", strings.Join(s.LongestChain, " -> ")},
+	}
+	for _, d := range s.MostDepended {
+		rows = append(rows, []string{"dependents", d.Name, strconv.Itoa(d.Dependents)})
+	}
+	for _, n := range s.versionNames() {
+		rows = append(rows, []string{"versions", n, strings.Join(s.Versions[n], ", ")})
+	}
+	for _, ch := range s.Churn {
+		rows = append(rows, []string{"churn", ch.Name, strconv.Itoa(ch.Versions)})
+	}
+	return rows
+}
+
+// churn returns, for every package in g the configured registry has
+// publication dates for, how many of its versions were published within
+// window, most first
+func (g *depGraph) churn(window time.Duration) ([]versionChurn, error) {
+	byImport, err := configuredRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	since := time.Now().Add(-window)
+	seen := make(map[string]bool)
+	var out []versionChurn
+	for _, n := range g.Nodes {
+		imp := n.Pkg.Gx.DvcsImport
+		rp, ok := byImport[imp]
+		if !ok || seen[imp] {
+			continue
+		}
+		seen[imp] = true
+
+		var dated bool
+		ch := versionChurn{Name: n.Pkg.Name, Import: imp}
+		for _, v := range rp.Versions {
+			if v.Published.IsZero() {
+				continue
+			}
+			dated = true
+			if v.Published.After(since) {
+				ch.Versions++
+			}
+		}
+		if dated {
+			out = append(out, ch)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Versions != out[j].Versions {
+			return out[i].Versions > out[j].Versions
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+var depsOwnersCommand = cli.Command{
+	Name:  "owners",
+	Usage: "aggregate the authors and repository orgs of all dependencies",
+	Description: `lists each distinct author and repository org (host/org of the dvcs
+import) in the dependency tree along with the packages they own, so the
+set of maintainers a package trusts can be reviewed.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "org",
+			Usage: "only list packages under the given org (e.g. github.com/ipfs)",
+		},
+		formatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		g, err := loadDepGraph(pkg, filepath.Join(cwd, vendorDir))
+		if err != nil {
+			return err
+		}
+
+		filter := strings.TrimSuffix(c.String("org"), "/")
+		var rows [][]string
+		authors := make(map[string]bool)
+		orgs := make(map[string]bool)
+		for _, n := range g.Nodes {
+			org := dvcsOrg(n.Pkg.Gx.DvcsImport)
+			if filter != "" && org != filter {
+				continue
+			}
+
+			author := n.Pkg.Author
+			if author == "" {
+				author = "(unknown)"
+			}
+
+			authors[author] = true
+			orgs[org] = true
+			rows = append(rows, []string{org, author, n.Pkg.Name, n.Dep.Hash})
+		}
+
+		sort.Slice(rows, func(i, j int) bool {
+			return strings.Join(rows[i], " ") < strings.Join(rows[j], " ")
+		})
+
+		err = writeTable(os.Stdout, c.String("format"), []string{"ORG", "AUTHOR", "PACKAGE", "HASH"}, rows)
+		if err != nil {
+			return err
+		}
+
+		if c.String("format") == "" {
+			fmt.Printf("\n%d distinct authors, %d distinct orgs\n", len(authors), len(orgs))
+		}
+		return nil
+	},
+}
+
+// dvcsOrg returns the host and org of a dvcs import path
+func dvcsOrg(imp string) string {
+	if imp == "" {
+		return "(unknown)"
+	}
+
+	parts := strings.Split(imp, "/")
+	if len(parts) < 2 {
+		return imp
+	}
+	return parts[0] + "/" + parts[1]
+}
+
+// depths returns the length of the shortest chain from the root to each node
+func (g *depGraph) depths() map[string]int {
+	out := make(map[string]int)
+	var queue []string
+	for _, d := range g.Root.Dependencies {
+		if _, ok := out[d.Hash]; !ok {
+			out[d.Hash] = 1
+			queue = append(queue, d.Hash)
+		}
+	}
+
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		n, ok := g.Nodes[h]
+		if !ok {
+			continue
+		}
+		for _, c := range n.Children {
+			if _, ok := out[c]; !ok {
+				out[c] = out[h] + 1
+				queue = append(queue, c)
+			}
+		}
+	}
+	return out
+}
+
+var depsLsCommand = cli.Command{
+	Name:  "ls",
+	Usage: "list the packages in the dependency tree",
+	Description: `lists every package in the dependency tree with its version, hash, import
+path, depth (1 for direct dependencies) and vendored size. --filter takes a
+glob matched against package names and import paths.
+
+--template prints each package with a go template over the fields .Name,
+.Version, .Hash, .Import, .Depth and .Size (in bytes).`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "only-direct",
+			Usage: "only list direct dependencies",
+		},
+		cli.BoolFlag{
+			Name:  "only-transitive",
+			Usage: "only list indirect dependencies",
+		},
+		cli.StringFlag{
+			Name:  "sort",
+			Usage: "sort by size, name, version or depth",
+			Value: "name",
+		},
+		cli.StringFlag{
+			Name:  "filter",
+			Usage: "only list packages whose name or import path match the glob",
+		},
+		formatFlag,
+		templateFlag,
+	},
+	Action: func(c *cli.Context) error {
+		if c.Bool("only-direct") && c.Bool("only-transitive") {
+			return fmt.Errorf("--only-direct and --only-transitive are mutually exclusive")
+		}
+
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		g, err := loadDepGraph(pkg, filepath.Join(cwd, vendorDir))
+		if err != nil {
+			return err
+		}
+
+		type entry struct {
+			n     *depNode
+			depth int
+			size  int64
+		}
+
+		filter := c.String("filter")
+		if _, err := path.Match(filter, ""); err != nil {
+			return fmt.Errorf("invalid filter %q: %s", filter, err)
+		}
+
+		depths := g.depths()
+		var entries []entry
+		for h, n := range g.Nodes {
+			direct := g.isDirect(h)
+			if c.Bool("only-direct") && !direct || c.Bool("only-transitive") && direct {
+				continue
+			}
+
+			if filter != "" {
+				byName, _ := path.Match(filter, n.Pkg.Name)
+				byImport, _ := path.Match(filter, n.Pkg.Gx.DvcsImport)
+				if !byName && !byImport {
+					continue
+				}
+			}
+
+			size, err := dirSize(n.Dir)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{n, depths[h], size})
+		}
+
+		byName := func(a, b entry) bool {
+			if a.n.Pkg.Name != b.n.Pkg.Name {
+				return a.n.Pkg.Name < b.n.Pkg.Name
+			}
+			return a.n.Dep.Hash < b.n.Dep.Hash
+		}
+
+		var less func(a, b entry) bool
+		switch c.String("sort") {
+		case "name":
+			less = byName
+		case "size":
+			less = func(a, b entry) bool {
+				if a.size != b.size {
+					return a.size > b.size
+				}
+				return byName(a, b)
+			}
+		case "depth":
+			less = func(a, b entry) bool {
+				if a.depth != b.depth {
+					return a.depth < b.depth
+				}
+				return byName(a, b)
+			}
+		case "version":
+			less = func(a, b entry) bool {
+				if a.n.Pkg.Version != b.n.Pkg.Version {
+					if older, err := versionComp(a.n.Pkg.Version, b.n.Pkg.Version); err == nil {
+						return older
+					}
+					return a.n.Pkg.Version < b.n.Pkg.Version
+				}
+				return byName(a, b)
+			}
+		default:
+			return fmt.Errorf("unrecognized sort key %q, must be size, name, version or depth", c.String("sort"))
+		}
+		sort.Slice(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+
+		format := c.String("format")
+		var rows [][]string
+		for _, e := range entries {
+			size := strconv.FormatInt(e.size, 10)
+			if (format == "" || format == "text") && c.String("template") == "" {
+				size = humanSize(e.size)
+			}
+			rows = append(rows, []string{
+				e.n.Pkg.Name,
+				e.n.Pkg.Version,
+				e.n.Dep.Hash,
+				e.n.Pkg.Gx.DvcsImport,
+				strconv.Itoa(e.depth),
+				size,
+			})
+		}
+		return writeResults(os.Stdout, c, []string{"NAME", "VERSION", "HASH", "IMPORT", "DEPTH", "SIZE"}, rows)
+	},
+}
+
+var depsTreeCommand = cli.Command{
+	Name:  "tree",
+	Usage: "print the dependency tree",
+	Description: `prints the dependency tree of the current package. Subtrees already
+printed are not expanded again.
+
+With --why-dup, only the branches leading to packages present at more than
+one hash are printed, each duplicate is annotated with the hash the tree
+would be unified on (the newest version, preferring the one depended on
+directly), and the dependency updates that would unify them are listed.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "why-dup",
+			Usage: "annotate duplicated packages and list the updates unifying them",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		g, err := loadDepGraph(pkg, filepath.Join(cwd, vendorDir))
+		if err != nil {
+			return err
+		}
+
+		whyDup := c.Bool("why-dup")
+		dups := g.duplicates()
+		dupOf := make(map[string]string)
+		targets := make(map[string]string)
+		for imp, hs := range dups {
+			for _, h := range hs {
+				dupOf[h] = imp
+			}
+			targets[imp] = g.unifyTarget(hs)
+		}
+
+		if whyDup && len(dups) == 0 {
+			fmt.Println("no duplicate packages")
+			return nil
+		}
+
+		// hasDup memoizes whether a subtree contains a duplicate
+		hasDup := make(map[string]bool)
+		var leadsToDup func(h string, visiting map[string]bool) bool
+		leadsToDup = func(h string, visiting map[string]bool) bool {
+			if v, ok := hasDup[h]; ok {
+				return v
+			}
+			if visiting[h] {
+				return false
+			}
+			visiting[h] = true
+
+			found := dupOf[h] != ""
+			if n, ok := g.Nodes[h]; ok {
+				for _, ch := range n.Children {
+					if leadsToDup(ch, visiting) {
+						found = true
+					}
+				}
+			}
+			hasDup[h] = found
+			return found
+		}
+
+		printed := make(map[string]bool)
+		fmt.Println(g.Root.Name)
+		var walk func(deps []string, depth int)
+		walk = func(deps []string, depth int) {
+			for _, h := range deps {
+				if whyDup && !leadsToDup(h, make(map[string]bool)) {
+					continue
+				}
+
+				n := g.Nodes[h]
+				line := fmt.Sprintf("%s%s %s (%s)", strings.Repeat("  ", depth+1), n.Pkg.Name, n.Pkg.Version, h)
+				if imp := dupOf[h]; imp != "" && whyDup {
+					if targets[imp] == h {
+						line += fmt.Sprintf("  [%s at %d hashes, keep]", imp, len(dups[imp]))
+					} else {
+						t := g.Nodes[targets[imp]]
+						line += fmt.Sprintf("  [%s at %d hashes, update to %s (%s)]", imp, len(dups[imp]), t.Pkg.Version, targets[imp])
+					}
+				}
+
+				if printed[h] && len(n.Children) > 0 {
+					fmt.Println(line + " ...")
+					continue
+				}
+				fmt.Println(line)
+				printed[h] = true
+				walk(n.Children, depth+1)
+			}
+		}
+
+		var roots []string
+		for _, d := range g.Root.Dependencies {
+			roots = append(roots, d.Hash)
+		}
+		walk(roots, 0)
+
+		if whyDup {
+			fmt.Println()
+			fmt.Println("updates unifying the duplicates:")
+			for _, u := range g.unifyingUpdates(dups, targets) {
+				fmt.Printf("  %s\n", u)
+			}
+		}
+		return nil
+	},
+}
+
+// unifyTarget picks the hash duplicates of a package should be unified on:
+// the newest version, preferring direct dependencies, then the lowest hash
+func (g *depGraph) unifyTarget(hashes []string) string {
+	best := hashes[0]
+	for _, h := range hashes[1:] {
+		bv, hv := g.Nodes[best].Pkg.Version, g.Nodes[h].Pkg.Version
+		if bv != hv {
+			if older, err := versionComp(bv, hv); err == nil && older {
+				best = h
+			}
+			continue
+		}
+		if hd, bd := g.isDirect(h), g.isDirect(best); hd != bd {
+			if hd {
+				best = h
+			}
+			continue
+		}
+		if h < best {
+			best = h
+		}
+	}
+	return best
+}
+
+// unifyingUpdates lists, for every package depending on a duplicate that is
+// not the target, the dependency update bringing it onto the target
+func (g *depGraph) unifyingUpdates(dups map[string][]string, targets map[string]string) []string {
+	var out []string
+	for imp, hs := range dups {
+		target := targets[imp]
+		t := g.Nodes[target]
+		for _, h := range hs {
+			if h == target {
+				continue
+			}
+
+			for _, d := range g.Root.Dependencies {
+				if d.Hash == h {
+					out = append(out, fmt.Sprintf("%s: gx update %s %s (%s -> %s)", g.Root.Name, d.Name, target, g.Nodes[h].Pkg.Version, t.Pkg.Version))
+				}
+			}
+
+			for ph, p := range g.Nodes {
+				for _, ch := range p.Children {
+					if ch == h {
+						out = append(out, fmt.Sprintf("%s (%s): update %s to %s (%s -> %s) and republish", p.Pkg.Name, ph, t.Pkg.Name, target, g.Nodes[h].Pkg.Version, t.Pkg.Version))
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var DiffTreeCommand = cli.Command{
+	Name:      "diff-tree",
+	Usage:     "print how the dependency tree changed between two revisions",
+	ArgsUsage: "<git-ref> [<git-ref>]",
+	Description: `compares the dependency tree of the package as of the given git revision
+with the working tree, or with the second revision given, and prints the
+dependencies that were added, removed or changed, with their versions and
+hashes, for release notes and reviews of dependency updates.
+
+The tree of a revision is read from its gx-go-lock.json. Without a lockfile
+it is made up of the dependencies in its package.json, and of theirs as
+far as they are installed.`,
+	Flags: []cli.Flag{
+		formatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() || len(c.Args()) > 2 {
+			return fmt.Errorf("must specify one or two git revisions")
+		}
+
+		from, err := treeAt(cwd, c.Args()[0])
+		if err != nil {
+			return err
+		}
+		to, err := treeAt(cwd, c.Args().Get(1))
+		if err != nil {
+			return err
+		}
+
+		st := &installState{Baseline: from, Current: to}
+		changes := st.changes()
+		if len(changes) == 0 {
+			if c.String("format") == "" {
+				Log("the dependency tree did not change")
+			}
+			return nil
+		}
+
+		var rows [][]string
+		for _, ch := range changes {
+			rows = append(rows, []string{ch.Change, ch.Name, ch.Version, ch.Hash})
+		}
+		return writeTable(os.Stdout, c.String("format"), []string{"CHANGE", "NAME", "VERSION", "HASH"}, rows)
+	},
+}
+
+// treeAt lists the dependency tree of the package in dir as of the given
+// git revision, or as in the working tree if ref is empty, sorted by name
+// and hash
+func treeAt(dir, ref string) ([]installedDep, error) {
+	var pkg *Package
+	var lf lockFile
+	var lerr error
+	if ref == "" {
+		var err error
+		if pkg, err = LoadPackageFile(filepath.Join(dir, gx.PkgFileName)); err != nil {
+			return nil, err
+		}
+		lerr = loadMap(&lf, filepath.Join(dir, lockFileName))
+	} else {
+		var err error
+		if pkg, err = packageFileAt(dir, ref); err != nil {
+			return nil, err
+		}
+
+		var data []byte
+		if data, lerr = fileAt(dir, ref, lockFileName); lerr == nil {
+			lerr = json.Unmarshal(data, &lf)
+		}
+	}
+
+	switch {
+	case os.IsNotExist(lerr):
+		return installedTree(pkg, filepath.Join(dir, vendorDir)), nil
+	case lerr != nil:
+		return nil, fmt.Errorf("loading %s: %s", lockFileName, lerr)
+	}
+
+	var out []installedDep
+	for h, e := range lf.Deps {
+		out = append(out, installedDep{Name: e.Name, Version: e.Version, Hash: h})
+	}
+	// a lock that was not updated along with package.json misses its
+	// new dependencies
+	for _, dep := range pkg.Dependencies {
+		if lf.Deps[dep.Hash] == nil {
+			out = append(out, installedDep{Name: dep.Name, Version: dep.Version, Hash: dep.Hash})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Hash < out[j].Hash
+	})
+	return out, nil
+}
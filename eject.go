@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	cli "github.com/codegangsta/cli"
+	rw "github.com/whyrusleeping/gx-go/rewrite"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var EjectCommand = cli.Command{
+	Name:  "eject",
+	Usage: "permanently remove gx from the current package",
+	Description: `rewrites all gx imports back to their dvcs form, copies the source of
+each dependency into a plain vendor/<dvcsimport> tree, and deletes the gx
+metadata of the package (package.json, .gxignore, vendor/gx).
+
+With --modules, dependencies are not copied and resolution is left to go
+modules. Where the tree contains more than one version of a package, the
+one depended on directly (or else the newest) is kept.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "modules",
+			Usage: "do not vendor dependencies, leave their resolution to go modules",
+		},
+		cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		if !c.Bool("yes") && !yesNoPrompt("eject.confirm", "", "this removes all gx metadata from the package, continue?", false) {
+			return nil
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		g, err := loadDepGraph(pkg, pkgdir)
+		if err != nil {
+			return err
+		}
+
+		undo := make(map[string]string)
+		err = buildRewriteMapping(pkg, pkgdir, undo, true)
+		if err != nil {
+			return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+		}
+
+		chosen, conflicts := g.pickOnePerImport()
+		for _, imp := range conflicts {
+			Warn("multiple versions of %s in the tree, keeping %s", imp, chosen[imp].Pkg.Version)
+		}
+
+		var imps []string
+		for imp := range chosen {
+			imps = append(imps, imp)
+		}
+		sort.Strings(imps)
+
+		if !c.Bool("modules") {
+			for _, imp := range imps {
+				n := chosen[imp]
+				dst := filepath.Join(cwd, "vendor", filepath.FromSlash(imp))
+				VLog("  - vendoring %s %s to %s", n.Pkg.Name, n.Pkg.Version, dst)
+				if err := copyDir(n.Dir, dst, isGxMetadata); err != nil {
+					return fmt.Errorf("copying %s: %s", imp, err)
+				}
+
+				if err := rw.RewriteImportsContext(cancelCtx, dst, rewriteFunc(undo), isGoFile); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := doRewrite(pkg, cwd, undo); err != nil {
+			return err
+		}
+
+		for _, p := range []string{filepath.Join(cwd, "vendor", "gx"), filepath.Join(cwd, gx.PkgFileName), filepath.Join(cwd, ".gxignore")} {
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+		}
+
+		Log("ejected %s from gx:", pkg.Name)
+		Log("  - rewrote imports of %d packages back to their dvcs paths", len(imps))
+		if c.Bool("modules") {
+			Log("  - left dependency resolution to go modules, run 'go mod init' and 'go mod tidy'")
+		} else {
+			Log("  - vendored %d packages into vendor/", len(imps))
+		}
+		if len(conflicts) > 0 {
+			Log("  - collapsed %d packages present at multiple versions", len(conflicts))
+		}
+		Log("  - removed package.json, .gxignore and vendor/gx")
+		return nil
+	},
+}
+
+// pickOnePerImport chooses a single node for every dvcs import in the graph,
+// preferring direct dependencies and then newer versions. It also returns
+// the imports that had more than one node to choose from.
+func (g *depGraph) pickOnePerImport() (map[string]*depNode, []string) {
+	chosen := make(map[string]*depNode)
+	multi := make(map[string]bool)
+	for h, n := range g.Nodes {
+		imp := n.Pkg.Gx.DvcsImport
+		if imp == "" {
+			continue
+		}
+
+		cur, ok := chosen[imp]
+		if !ok {
+			chosen[imp] = n
+			continue
+		}
+
+		if cur.Dep.Hash == h {
+			continue
+		}
+
+		multi[imp] = true
+
+		switch {
+		case g.isDirect(cur.Dep.Hash):
+		case g.isDirect(h):
+			chosen[imp] = n
+		default:
+			if older, err := versionComp(cur.Pkg.Version, n.Pkg.Version); err == nil && older {
+				chosen[imp] = n
+			}
+		}
+	}
+
+	var conflicts []string
+	for imp := range multi {
+		conflicts = append(conflicts, imp)
+	}
+	sort.Strings(conflicts)
+	return chosen, conflicts
+}
+
+// isGxMetadata returns whether the file at the given relative path holds gx
+// metadata rather than package content
+func isGxMetadata(rel string) bool {
+	switch rel {
+	case gx.PkgFileName, ".gxignore", ".gx":
+		return true
+	default:
+		return false
+	}
+}
+
+func isGoFile(p string) bool {
+	return filepath.Ext(p) == ".go"
+}
+
+// rewriteFunc returns an import rewriting function for the given mapping,
+// matching whole paths and path prefixes
+func rewriteFunc(mapping map[string]string) func(string) string {
+	return func(in string) string {
+		if m, ok := gxImportFor(mapping, in); ok {
+			return m
+		}
+		return in
+	}
+}
+
+// copyDir recursively copies src to dst, skipping paths (relative to src)
+// that skip returns true for
+func copyDir(src, dst string, skip func(string) bool) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		if rel != "." && skip != nil && skip(filepath.ToSlash(rel)) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, 0755)
+		case fi.Mode()&os.ModeSymlink != 0:
+			l, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(l, target)
+		default:
+			return copyFile(p, target, fi.Mode())
+		}
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
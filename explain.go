@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var ExplainCommand = cli.Command{
+	Name:      "explain",
+	Usage:     "show how an import path is resolved in the current package",
+	ArgsUsage: "<import path>",
+	Description: `prints how the given import, in dvcs or gx form, is satisfied by the
+dependencies of the current package: the dependency providing it, whether
+it matched exactly or by prefix, the directory its source is read from and
+whether that is an overlay. Other dependencies providing the same dvcs
+import, which the rewrite passes over, are listed too.`,
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify an import path")
+		}
+		imp := c.Args().First()
+
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		g, err := loadDepGraph(pkg, pkgdir)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(imp, "gx/") {
+			return explainGxImport(g, imp)
+		}
+		return explainDvcsImport(g, pkgdir, imp)
+	},
+}
+
+func explainDvcsImport(g *depGraph, pkgdir, imp string) error {
+	fmt.Printf("import:     %s\n", imp)
+
+	if isStdlib(imp) {
+		fmt.Println("resolution: standard library, never rewritten")
+		return nil
+	}
+
+	if own := g.Root.Gx.DvcsImport; own != "" && (imp == own || strings.HasPrefix(imp, own+"/")) {
+		fmt.Println("resolution: part of the current package, never rewritten")
+		return nil
+	}
+
+	mapping := make(map[string]string)
+	if err := buildRewriteMapping(g.Root, pkgdir, mapping, false); err != nil {
+		return err
+	}
+
+	gximp, ok := gxImportFor(mapping, imp)
+	if !ok {
+		fmt.Println("resolution: not provided by any dependency, left as is")
+		fmt.Println("            (resolved by the go tool from GOPATH or vendor/)")
+		return nil
+	}
+
+	n := g.nodeFor(gximp)
+	if n == nil {
+		return fmt.Errorf("%s maps to %s, which is not in the dependency tree", imp, gximp)
+	}
+
+	if imp == n.Pkg.Gx.DvcsImport {
+		fmt.Println("match:      exact")
+	} else {
+		fmt.Printf("match:      prefix %s, subpackage %s\n", n.Pkg.Gx.DvcsImport, strings.TrimPrefix(imp, n.Pkg.Gx.DvcsImport+"/"))
+	}
+	fmt.Printf("rewrite:    %s\n", gximp)
+	explainNode(g, n)
+
+	var others []string
+	for h, o := range g.Nodes {
+		if h != n.Dep.Hash && o.Pkg.Gx.DvcsImport == n.Pkg.Gx.DvcsImport {
+			others = append(others, fmt.Sprintf("%s %s (%s)", o.Pkg.Name, o.Pkg.Version, h))
+		}
+	}
+	if len(others) > 0 {
+		sort.Strings(others)
+		fmt.Println("shadowed:   also provided by, but not used:")
+		for _, o := range others {
+			fmt.Printf("              %s\n", o)
+		}
+	}
+	return nil
+}
+
+func explainGxImport(g *depGraph, imp string) error {
+	fmt.Printf("import:     %s\n", imp)
+
+	n := g.nodeFor(imp)
+	if n == nil {
+		fmt.Printf("resolution: %s is not in the dependency tree\n", hashFromImport(imp))
+		return nil
+	}
+
+	sub := gxSubpath(imp)
+	if n.Pkg.Gx.DvcsImport != "" {
+		dvcs := n.Pkg.Gx.DvcsImport
+		if sub != "" {
+			dvcs += "/" + sub
+		}
+		fmt.Printf("dvcs form:  %s\n", dvcs)
+	}
+	explainNode(g, n)
+	return nil
+}
+
+// explainNode prints which dependency n is and where its source is read from
+func explainNode(g *depGraph, n *depNode) {
+	how := "direct dependency"
+	if !g.isDirect(n.Dep.Hash) {
+		how = "indirect dependency"
+		if paths := g.pathsTo(n.Dep.Hash); len(paths) > 0 {
+			how += ", via " + g.chainNames(paths[0])
+		}
+	}
+	fmt.Printf("dependency: %s %s (%s), %s\n", n.Pkg.Name, n.Pkg.Version, n.Dep.Hash, how)
+
+	where := "vendored"
+	if !strings.HasPrefix(n.Dir, filepath.Join(cwd, vendorDir)+string(filepath.Separator)) {
+		where = "global"
+	}
+	fmt.Printf("source:     %s (%s)\n", n.Dir, where)
+
+	if ovs, err := loadOverlays(); err == nil && ovs[n.Dep.Hash] != nil {
+		fmt.Printf("overlay:    linked to %s\n", ovs[n.Dep.Hash].Dir)
+		return
+	}
+
+	for _, p := range []string{n.Dir, filepath.Dir(n.Dir)} {
+		if fi, err := os.Lstat(p); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			target, _ := os.Readlink(p)
+			fmt.Printf("link:       %s links to %s\n", p, target)
+			return
+		}
+	}
+}
+
+// nodeFor returns the node providing the given gx import, in any hash form
+// or layout
+func (g *depGraph) nodeFor(gximp string) *depNode {
+	h := hashFromImport(gximp)
+	for _, f := range hashForms(h) {
+		if n, ok := g.Nodes[f]; ok {
+			return n
+		}
+	}
+	for hash, n := range g.Nodes {
+		for _, f := range hashForms(hash) {
+			if f == h {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// gxSubpath returns the path of the imported package within the gx package
+// in a gx import path
+func gxSubpath(imp string) string {
+	parts := strings.Split(strings.TrimPrefix(imp, "gx/ipfs/"), "/")
+	if len(parts) > 1 && len(parts[0]) == 2 {
+		parts = parts[1:]
+	}
+	if len(parts) <= 2 {
+		return ""
+	}
+	return strings.Join(parts[2:], "/")
+}
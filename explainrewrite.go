@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var ExplainRewriteCommand = cli.Command{
+	Name:      "explain-rewrite",
+	Usage:     "show how 'rewrite' would rewrite every import of a file",
+	ArgsUsage: "<file>",
+	Description: `runs the imports of the given go file through the rewrite mapping of the
+current package and prints, for each, the mapping rule that matched and the
+path it would be rewritten to. Nothing is written.
+
+A rule matches exactly if the import is mapped itself, and by prefix if it
+is below a mapped path, the longest of which wins; imports below other
+mapped paths too are listed with them. Imports no rule matches are left as
+they are.
+
+--undo, --use-map and --pkgdir select the mapping like they do for
+'rewrite'.`,
+	Flags: []cli.Flag{
+		formatFlag,
+		templateFlag,
+		cli.BoolFlag{
+			Name:  "undo",
+			Usage: "explain the rewrite back to dvcs import paths",
+		},
+		cli.StringFlag{
+			Name:  "use-map",
+			Usage: "use the mapping in the given json file instead of computing it",
+		},
+		cli.StringFlag{
+			Name:  "pkgdir",
+			Usage: "alternative location of the package directory",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify a file")
+		}
+		file, err := filepath.Abs(c.Args().First())
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(file, ".go") {
+			return fmt.Errorf("%s is not a go file, rewrite leaves it alone", c.Args().First())
+		}
+
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		if pdopt := c.String("pkgdir"); pdopt != "" {
+			pkgdir = pdopt
+		}
+
+		mapping := make(map[string]string)
+		if mp := c.String("use-map"); mp != "" {
+			mapping, err = loadRewriteMap(pkgPath(cwd, mp), pkgdir, c.Bool("undo"))
+			if err != nil {
+				return err
+			}
+		} else if err := buildRewriteMapping(pkg, pkgdir, mapping, c.Bool("undo")); err != nil {
+			return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+		}
+
+		if rel, err := filepath.Rel(cwd, file); err == nil {
+			switch {
+			case strings.HasPrefix(rel, ".."+string(filepath.Separator)):
+				Warn("%s is outside of the current package, rewrite leaves it alone", rel)
+			case inNested(nestedPackages(cwd), rel):
+				Warn("%s belongs to a nested package, rewrite leaves it alone", rel)
+			}
+		}
+
+		rows, err := explainRewrite(file, mapping)
+		if err != nil {
+			return err
+		}
+		return writeResults(os.Stdout, c, []string{"LINE", "IMPORT", "MATCH", "RULE", "RESULT", "ALSO BELOW"}, rows)
+	},
+}
+
+// explainRewrite returns, for every import of the go file at p, how the
+// rewrite with mapping treats it
+func explainRewrite(p string, mapping map[string]string) ([][]string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, p, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, imp := range f.Imports {
+		in, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		line := strconv.Itoa(fset.Position(imp.Pos()).Line)
+
+		out, matches := rewriteImport(in, mapping)
+		if _, exact := mapping[in]; exact {
+			rows = append(rows, []string{line, in, "exact", in + " -> " + out, out, ""})
+		} else if len(matches) > 0 {
+			rule := matches[0] + " -> " + mapping[matches[0]]
+			rows = append(rows, []string{line, in, "prefix", rule, out, strings.Join(matches[1:], ", ")})
+		} else {
+			rows = append(rows, []string{line, in, "none", "-", in, ""})
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// moduleOnlyFlags are GOFLAGS entries that the go tool rejects outside of
+// module mode, where imports are fetched
+var moduleOnlyFlags = []string{"-mod=", "-modfile=", "-modcacherw"}
+
+// goGetEnv returns the environment 'go get' is run with when fetching into
+// gopath. Everything is passed through from the users environment (proxies,
+// git configuration and its insteadOf rules, credentials) except what is
+// needed for a GOPATH mode fetch.
+func goGetEnv(gopath string) []string {
+	vars := map[string]string{
+		"GOPATH":      gopath,
+		"GO111MODULE": "off",
+	}
+
+	if gf := os.Getenv("GOFLAGS"); gf != "" {
+		var keep []string
+		for _, f := range strings.Fields(gf) {
+			if !isModuleOnlyFlag(f) {
+				keep = append(keep, f)
+			}
+		}
+		vars["GOFLAGS"] = strings.Join(keep, " ")
+	}
+
+	// never hang on a credential prompt we cannot answer
+	if os.Getenv("GIT_TERMINAL_PROMPT") == "" {
+		vars["GIT_TERMINAL_PROMPT"] = "0"
+	}
+
+	return setEnv(os.Environ(), vars)
+}
+
+func isModuleOnlyFlag(f string) bool {
+	for _, m := range moduleOnlyFlags {
+		if strings.HasPrefix(f, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateImport returns whether imp matches any of the GOPRIVATE style
+// patterns configured in the environment
+func isPrivateImport(imp string) bool {
+	for _, v := range []string{"GOPRIVATE", "GONOPROXY", "GONOSUMDB"} {
+		if matchPrefixPatterns(os.Getenv(v), imp) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPrefixPatterns reports whether any leading path elements of target
+// match one of the comma separated glob patterns, like the go tool does
+func matchPrefixPatterns(patterns, target string) bool {
+	for _, pat := range strings.Split(patterns, ",") {
+		pat = strings.TrimSpace(pat)
+		if pat == "" {
+			continue
+		}
+
+		n := strings.Count(pat, "/") + 1
+		parts := strings.SplitN(target, "/", n+1)
+		if len(parts) < n {
+			continue
+		}
+
+		prefix := strings.Join(parts[:n], "/")
+		if ok, _ := path.Match(pat, prefix); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// logGitRewrites prints the insteadOf rules git will apply when fetching
+func logGitRewrites() {
+	out, err := exec.Command("git", "config", "--get-regexp", `^url\..*\.insteadof$`).Output()
+	if err != nil {
+		return
+	}
+
+	for _, l := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.Fields(l)
+		if len(parts) != 2 {
+			continue
+		}
+
+		to := strings.TrimSuffix(strings.TrimPrefix(parts[0], "url."), ".insteadof")
+		VLog("  - git rewrites %s to %s", parts[1], to)
+	}
+}
+
+// fetchFailureHint returns a hint for fetch failures of private imports
+func fetchFailureHint(imp, out string) string {
+	if !isPrivateImport(imp) {
+		return ""
+	}
+
+	if strings.Contains(out, "terminal prompts disabled") || strings.Contains(out, "could not read Username") {
+		return "'" + imp + "' is private and git could not authenticate, configure git credentials or an insteadOf rule for its host"
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var ForkCommand = cli.Command{
+	Name:      "fork",
+	Usage:     "absorb a dependency into the current package",
+	ArgsUsage: "<dep>",
+	Description: `copies the source of a vendored dependency into the package (by default
+to thirdparty/<name>), rewrites all imports of it to its new path, and
+removes it from package.json. The dependencies of the forked package are
+added to package.json in its place.
+
+Use this for dependencies that are going to be hard-forked and maintained
+as part of the package.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "dir",
+			Usage: "directory to place the fork in",
+			Value: "thirdparty",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify a dependency to fork")
+		}
+
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		dep, err := resolveDep(pkg, c.Args().First())
+		if err != nil {
+			return err
+		}
+		if dep == nil {
+			return fmt.Errorf("%s not found", c.Args().First())
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		dpkg, src, err := findDep(dep, pkgdir)
+		if err != nil {
+			return err
+		}
+
+		root := pkg.Gx.DvcsImport
+		if root == "" {
+			root, err = packagesGoImport(cwd)
+			if err != nil {
+				return fmt.Errorf("could not determine the import path of the package, please set 'dvcsimport' in package.json")
+			}
+		}
+
+		rel := filepath.Join(c.String("dir"), dpkg.Name)
+		dst := filepath.Join(cwd, rel)
+		if _, err := os.Stat(dst); err == nil {
+			return fmt.Errorf("%s already exists", rel)
+		}
+
+		newimp := path.Join(root, filepath.ToSlash(rel))
+		Log("forking %s %s into %s", dpkg.Name, dpkg.Version, rel)
+		if err := copyDir(src, dst, isGxMetadata); err != nil {
+			return err
+		}
+
+		mapping := map[string]string{}
+		for _, h := range hashPaths(dep.Hash) {
+			mapping["gx/ipfs/"+h+"/"+dpkg.Name] = newimp
+		}
+		if dpkg.Gx.DvcsImport != "" {
+			mapping[dpkg.Gx.DvcsImport] = withRoot(newimp, dpkg)
+		}
+
+		if err := doRewrite(pkg, cwd, mapping); err != nil {
+			return err
+		}
+
+		var deps []*gx.Dependency
+		for _, d := range pkg.Dependencies {
+			if d.Hash != dep.Hash {
+				deps = append(deps, d)
+			}
+		}
+		for _, d := range dpkg.Dependencies {
+			have := pkg.FindDep(d.Name)
+			switch {
+			case have == nil:
+				VLog("  - adding %s, a dependency of the fork", d.Name)
+				deps = append(deps, d)
+			case have.Hash != d.Hash:
+				Warn("the fork was built against %s %s, the package depends on %s", d.Name, d.Version, have.Version)
+			}
+		}
+		pkg.Dependencies = deps
+
+		g, err := loadDepGraph(pkg, pkgdir)
+		if err == nil {
+			if n := g.dependents()[dep.Hash]; n > 0 {
+				Warn("%d other dependencies still use their own copy of %s", n, dpkg.Name)
+			}
+		}
+
+		return savePackageFile(pkg, gx.PkgFileName)
+	},
+}
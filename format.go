@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	cli "github.com/codegangsta/cli"
+)
+
+var formatFlag = cli.StringFlag{
+	Name:  "format",
+	Usage: "output format: text, json, toml, yaml or csv",
+}
+
+var templateFlag = cli.StringFlag{
+	Name:  "template",
+	Usage: "print every result with the given go template, like '{{.Name}} {{.Hash}}'",
+}
+
+// writeResults writes rows with the --template of c if one is given, and in
+// its --format otherwise
+func writeResults(w io.Writer, c *cli.Context, headers []string, rows [][]string) error {
+	if t := c.String("template"); t != "" {
+		if c.String("format") != "" {
+			return fmt.Errorf("--format and --template are mutually exclusive")
+		}
+		return writeTemplate(w, t, headers, rows)
+	}
+	return writeTable(w, c.String("format"), headers, rows)
+}
+
+// writeTemplate executes tmpl for every row. The values of a row are fields
+// named after the title cased headers without spaces, 'PINNED BY' becoming
+// .PinnedBy. Every row ends in a newline.
+func writeTemplate(w io.Writer, tmpl string, headers []string, rows [][]string) error {
+	t, err := template.New("row").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("parsing template: %s", err)
+	}
+
+	fields := make([]string, len(headers))
+	for i, h := range headers {
+		fields[i] = strings.Replace(strings.Title(strings.ToLower(h)), " ", "", -1)
+	}
+
+	for _, r := range rows {
+		obj := make(map[string]string)
+		for i, f := range fields {
+			obj[f] = r[i]
+		}
+
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, obj); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		if _, err := buf.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapRows returns the entries of m as rows of key and value, sorted by key
+func mapRows(m map[string]string) [][]string {
+	rows := [][]string{}
+	for k, v := range m {
+		rows = append(rows, []string{k, v})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return rows
+}
+
+// writeMap writes out a string map in the given format, sorted by key.
+// headers name the key and value columns where the format needs them.
+func writeMap(w io.Writer, format string, headers [2]string, m map[string]string) error {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	switch format {
+	case "", "json":
+		out, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	case "toml", "yaml":
+		sep := " = "
+		if format == "yaml" {
+			sep = ": "
+		}
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s%s%s\n", strconv.Quote(k), sep, strconv.Quote(m[k]))
+		}
+		return nil
+	case "csv", "text":
+		return writeTable(w, format, headers[:], mapRows(m))
+	default:
+		return fmt.Errorf("unrecognized output format %q", format)
+	}
+}
+
+// writeTable writes rows of values in the given format, text being the
+// default. Structured formats use the lowercased headers as field names.
+func writeTable(w io.Writer, format string, headers []string, rows [][]string) error {
+	fields := make([]string, len(headers))
+	for i, h := range headers {
+		fields[i] = strings.Replace(strings.ToLower(h), " ", "_", -1)
+	}
+
+	switch format {
+	case "", "text":
+		tw := tabwriter.NewWriter(w, 12, 4, 1, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(headers, "\t"))
+		for _, r := range rows {
+			fmt.Fprintln(tw, strings.Join(r, "\t"))
+		}
+		return tw.Flush()
+	case "json":
+		objs := []map[string]string{}
+		for _, r := range rows {
+			obj := make(map[string]string)
+			for i, f := range fields {
+				obj[f] = r[i]
+			}
+			objs = append(objs, obj)
+		}
+
+		out, err := json.MarshalIndent(objs, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	case "toml":
+		for _, r := range rows {
+			fmt.Fprintln(w, "[[row]]")
+			for i, f := range fields {
+				fmt.Fprintf(w, "%s = %s\n", f, strconv.Quote(r[i]))
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	case "yaml":
+		for _, r := range rows {
+			for i, f := range fields {
+				lead := "  "
+				if i == 0 {
+					lead = "- "
+				}
+				fmt.Fprintf(w, "%s%s: %s\n", lead, f, strconv.Quote(r[i]))
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(headers); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(rows); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unrecognized output format %q", format)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// frozenHashes returns the reasons the dependencies of pkg marked frozen
+// are pinned, by hash
+func frozenHashes(pkg *Package) map[string]string {
+	out := make(map[string]string)
+	for name, reason := range pkg.Gx.Frozen {
+		if dep := pkg.FindDep(name); dep != nil {
+			out[dep.Hash] = reason
+		}
+	}
+	return out
+}
+
+// frozenNote is how a frozen dependency is called out where it is skipped
+func frozenNote(name, reason string) string {
+	if reason == "" {
+		return fmt.Sprintf("%s is frozen", name)
+	}
+	return fmt.Sprintf("%s is frozen: %s", name, reason)
+}
+
+// frozenImport returns the freeze note if imp is the gx import of a frozen
+// dependency of the package in dir
+func frozenImport(dir, imp string) (string, bool) {
+	pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName))
+	if err != nil {
+		return "", false
+	}
+
+	for h, reason := range frozenHashes(pkg) {
+		for _, hp := range hashPaths(h) {
+			p := "gx/ipfs/" + hp
+			if imp == p || strings.HasPrefix(imp, p+"/") {
+				return frozenNote(pkg.FindDep(h).Name, reason), true
+			}
+		}
+	}
+	return "", false
+}
+
+var FreezeCommand = cli.Command{
+	Name:      "freeze",
+	Usage:     "protect a dependency pinned on purpose from updates",
+	ArgsUsage: "[dep] [reason...]",
+	Description: `marks the dependency, given by name, hash or alias, as frozen in the
+package.json gx section, along with why, like a known regression in newer
+versions. 'update' skips packages that depend on it, the post-update hook
+refuses to rewrite its imports, so 'gx update' of it fails, and 'status'
+and 'policy check' do not count it as outdated. Each of these mentions the
+freeze where it applies.
+
+--rm unfreezes the dependency, without arguments the frozen dependencies
+are listed.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "rm",
+			Usage: "unfreeze the dependency",
+		},
+		formatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		if !c.Args().Present() {
+			var rows [][]string
+			for name, reason := range pkg.Gx.Frozen {
+				var hash, version string
+				if dep := pkg.FindDep(name); dep != nil {
+					hash, version = dep.Hash, dep.Version
+				}
+				rows = append(rows, []string{name, version, hash, reason})
+			}
+			sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+			return writeTable(os.Stdout, c.String("format"), []string{"NAME", "VERSION", "HASH", "REASON"}, rows)
+		}
+
+		dep, err := resolveDep(pkg, c.Args().First())
+		if err != nil {
+			return err
+		}
+		if dep == nil {
+			return fmt.Errorf("%s not found", c.Args().First())
+		}
+
+		if c.Bool("rm") {
+			if _, ok := pkg.Gx.Frozen[dep.Name]; !ok {
+				return fmt.Errorf("%s is not frozen", dep.Name)
+			}
+			delete(pkg.Gx.Frozen, dep.Name)
+			Log("unfroze %s", dep.Name)
+		} else {
+			if pkg.Gx.Frozen == nil {
+				pkg.Gx.Frozen = make(map[string]string)
+			}
+			pkg.Gx.Frozen[dep.Name] = strings.Join(c.Args().Tail(), " ")
+			Log("froze %s at %s (%s)", dep.Name, dep.Version, dep.Hash)
+		}
+		return savePackageFile(pkg, gx.PkgFileName)
+	},
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var GenCommand = cli.Command{
+	Name:  "gen",
+	Usage: "generate files describing the dependency tree for other tools",
+	Subcommands: []cli.Command{
+		genDepLockCommand,
+		genGovendorCommand,
+		genGoworkCommand,
+	},
+	Action: func(c *cli.Context) error { return nil },
+}
+
+var genOutputFlag = cli.StringFlag{
+	Name:  "output,o",
+	Usage: "file to write to instead of stdout",
+}
+
+var genDepLockCommand = cli.Command{
+	Name:  "dep-lock",
+	Usage: "write the pinned dependency tree as a Gopkg.lock for dep",
+	Flags: []cli.Flag{genOutputFlag},
+	Action: func(c *cli.Context) error {
+		pins, err := loadPins()
+		if err != nil {
+			return err
+		}
+
+		return genWrite(c.String("output"), func(w io.Writer) error {
+			for _, p := range pins {
+				fmt.Fprintln(w, "[[projects]]")
+				fmt.Fprintf(w, "  name = %s\n", strconv.Quote(p.Import))
+				fmt.Fprintln(w, `  packages = ["."]`)
+				if p.Revision != "" {
+					fmt.Fprintf(w, "  revision = %s\n", strconv.Quote(p.Revision))
+				}
+				if p.Version != "" {
+					fmt.Fprintf(w, "  version = %s\n", strconv.Quote("v"+p.Version))
+				}
+				fmt.Fprintln(w)
+			}
+
+			fmt.Fprintln(w, "[solve-meta]")
+			fmt.Fprintln(w, `  analyzer-name = "gx-go"`)
+			fmt.Fprintln(w, "  analyzer-version = 1")
+			fmt.Fprintln(w, `  inputs-digest = ""`)
+			fmt.Fprintln(w, `  solver-name = "gx-go"`)
+			fmt.Fprintln(w, "  solver-version = 1")
+			return nil
+		})
+	},
+}
+
+type govendorPackage struct {
+	ChecksumSHA1 string `json:"checksumSHA1"`
+	Path         string `json:"path"`
+	Revision     string `json:"revision"`
+	Version      string `json:"version,omitempty"`
+}
+
+type govendorFile struct {
+	Comment  string            `json:"comment"`
+	Ignore   string            `json:"ignore"`
+	Package  []govendorPackage `json:"package"`
+	RootPath string            `json:"rootPath"`
+}
+
+var genGovendorCommand = cli.Command{
+	Name:  "govendor",
+	Usage: "write the pinned dependency tree as a vendor.json for govendor",
+	Flags: []cli.Flag{genOutputFlag},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		pins, err := loadPins()
+		if err != nil {
+			return err
+		}
+
+		vf := govendorFile{
+			Ignore:   "test",
+			Package:  []govendorPackage{},
+			RootPath: pkg.Gx.DvcsImport,
+		}
+		for _, p := range pins {
+			gp := govendorPackage{
+				Path:     p.Import,
+				Revision: p.Revision,
+			}
+			if p.Version != "" {
+				gp.Version = "v" + p.Version
+			}
+			vf.Package = append(vf.Package, gp)
+		}
+
+		return genWrite(c.String("output"), func(w io.Writer) error {
+			out, err := json.MarshalIndent(vf, "", "\t")
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(w, string(out))
+			return err
+		})
+	},
+}
+
+var genGoworkCommand = cli.Command{
+	Name:  "gowork",
+	Usage: "write a go.work using the local packages and vendored dependencies",
+	Description: `writes a go.work file with a 'use' entry for every gx package in the
+current directory and below, and a 'replace' entry pointing each dvcs
+import of the dependency tree at its gx vendored copy. This lets module
+tooling and gopls work on a gx managed multi-repo project.
+
+Module replacements must contain a go.mod, dependencies without one are
+reported.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "file to write to instead of go.work",
+			Value: "go.work",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		dirs, err := findPackages(cwd)
+		if err != nil {
+			return err
+		}
+
+		if len(dirs) == 0 {
+			return fmt.Errorf("no %s found in %s or below", gx.PkgFileName, cwd)
+		}
+
+		local := make(map[string]bool)
+		var uses []string
+		replaces := make(map[string]string)
+		for _, d := range dirs {
+			pkg, err := LoadPackageFile(filepath.Join(d, gx.PkgFileName))
+			if err != nil {
+				return err
+			}
+
+			if pkg.Gx.DvcsImport != "" {
+				local[pkg.Gx.DvcsImport] = true
+			}
+
+			uses = append(uses, workPath(d))
+			if !hasGoMod(d) {
+				Warn("%s has no go.mod, run 'go mod init' in it", workPath(d))
+			}
+
+			g, err := loadDepGraph(pkg, filepath.Join(d, vendorDir))
+			if err != nil {
+				return err
+			}
+
+			chosen, _ := g.pickOnePerImport()
+			for imp, n := range chosen {
+				if _, ok := replaces[imp]; !ok {
+					replaces[imp] = filepath.FromSlash(withRoot(n.Dir, n.Pkg))
+				}
+			}
+		}
+
+		var imps []string
+		for imp, dir := range replaces {
+			if local[imp] {
+				continue
+			}
+
+			if !hasGoMod(dir) {
+				Warn("%s (%s) has no go.mod and cannot be used as a replacement", imp, workPath(dir))
+			}
+			imps = append(imps, imp)
+		}
+		sort.Strings(imps)
+
+		return genWrite(c.String("output"), func(w io.Writer) error {
+			// the first release supporting workspaces
+			fmt.Fprint(w, "go 1.18\n\n")
+			fmt.Fprintln(w, "use (")
+			for _, u := range uses {
+				fmt.Fprintf(w, "\t%s\n", u)
+			}
+			fmt.Fprintln(w, ")")
+
+			if len(imps) > 0 {
+				fmt.Fprintln(w)
+				fmt.Fprintln(w, "replace (")
+				for _, imp := range imps {
+					fmt.Fprintf(w, "\t%s => %s\n", imp, workPath(replaces[imp]))
+				}
+				fmt.Fprintln(w, ")")
+			}
+			return nil
+		})
+	},
+}
+
+// workPath returns dir as a go.work path, relative to the current directory
+// where possible
+func workPath(dir string) string {
+	rel, err := filepath.Rel(cwd, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(dir)
+	}
+
+	if rel == "." {
+		return "."
+	}
+	return "./" + filepath.ToSlash(rel)
+}
+
+func hasGoMod(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "go.mod"))
+	return err == nil
+}
+
+// pin is a dependency of the package expressed in terms of its dvcs import
+type pin struct {
+	Import   string
+	Version  string
+	Revision string
+}
+
+// loadPins returns a pin for every package in the dependency tree of the
+// current package, sorted by import path. Where several versions of a package
+// are in the tree, only the one pickOnePerImport picks is included.
+func loadPins() ([]pin, error) {
+	pkg, err := LoadPackageFile(gx.PkgFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := loadDepGraph(pkg, vendorDir)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen, conflicts := g.pickOnePerImport()
+	for _, imp := range conflicts {
+		Warn("multiple versions of %s in the tree, using %s", imp, chosen[imp].Pkg.Version)
+	}
+
+	var out []pin
+	for imp, n := range chosen {
+		if n.Pkg.Gx.DvcsRevision == "" {
+			Warn("no revision recorded for %s, it can only be pinned by version", imp)
+		}
+		out = append(out, pin{
+			Import:   imp,
+			Version:  n.Pkg.Version,
+			Revision: n.Pkg.Gx.DvcsRevision,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Import < out[j].Import
+	})
+	return out, nil
+}
+
+// genWrite runs write with the file at path, or stdout if path is empty
+func genWrite(path string, write func(w io.Writer) error) error {
+	if path == "" {
+		return write(os.Stdout)
+	}
+
+	fi, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(fi); err != nil {
+		fi.Close()
+		return err
+	}
+	return fi.Close()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var GenerateCommand = cli.Command{
+	Name:      "generate",
+	Usage:     "run 'go generate' on the rewritten package",
+	ArgsUsage: "[packages...]",
+	Description: `runs 'go generate' on the given packages, ./... by default, with imports
+rewritten to gx paths and in the environment of 'gx-go shell', so the
+generators built by 'install-tools' are used and generated code sees the
+vendored dependencies. A package that was not rewritten before is undone
+again afterwards.
+
+In a rewritten package, generators that write dvcs imports of gx
+dependencies are caught: their imports are rewritten to the gx paths and
+the files listed.`,
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		mapping := make(map[string]string)
+		err = buildRewriteMapping(pkg, filepath.Join(cwd, vendorDir), mapping, false)
+		if err != nil {
+			return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+		}
+
+		gxImports, dvcsFiles, err := packageImports(cwd, mapping)
+		if err != nil {
+			return err
+		}
+		if len(dvcsFiles) > 0 {
+			VLog("  - rewriting imports for generate")
+			if err := doRewrite(pkg, cwd, copyMap(mapping)); err != nil {
+				return err
+			}
+		}
+		undoAfter := gxImports == 0 && len(dvcsFiles) > 0
+		if undoAfter {
+			defer func() {
+				undo := make(map[string]string)
+				for k, v := range mapping {
+					undo[v] = k
+				}
+
+				if err := doRewrite(pkg, cwd, undo); err != nil {
+					Error("failed to undo rewrite: %s", err)
+				}
+			}()
+		}
+
+		env, err := gxEnv("")
+		if err != nil {
+			return err
+		}
+
+		args := append([]string{"generate"}, c.Args()...)
+		if !c.Args().Present() {
+			args = append(args, "./...")
+		}
+		cmd := exec.CommandContext(cancelCtx, "go", args...)
+		cmd.Dir = cwd
+		cmd.Env = env
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("go generate: %s", err)
+		}
+
+		if undoAfter {
+			return nil
+		}
+
+		// generators know nothing about gx, catch what they wrote
+		_, dvcsFiles, err = packageImports(cwd, mapping)
+		if err != nil {
+			return err
+		}
+		if files := uniqueStrings(dvcsFiles); len(files) > 0 {
+			Warn("generators wrote dvcs imports of gx dependencies into:")
+			for _, f := range files {
+				Warn("  - %s", f)
+			}
+			if err := doRewrite(pkg, cwd, copyMap(mapping)); err != nil {
+				return err
+			}
+			Log("rewrote imports in %d generated files", len(files))
+		}
+		return nil
+	},
+}
+
+// uniqueStrings returns the distinct strings in in, sorted
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
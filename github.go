@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const githubAPI = "https://api.github.com"
+
+// githubMaxWait is the longest we will wait for a rate limit to reset before
+// giving up on a request
+var githubMaxWait = 2 * time.Minute
+
+var githubClient = &http.Client{Timeout: 30 * time.Second}
+
+type githubRepo struct {
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+func githubToken() string {
+	if tok := os.Getenv("GITHUB_TOKEN"); tok != "" {
+		return tok
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		Error("%s", err)
+		return ""
+	}
+	return cfg.GithubToken
+}
+
+// githubGet fetches the given api path into out, waiting out rate limits
+// when they reset soon enough
+func githubGet(p string, out interface{}) error {
+	tok := githubToken()
+	for {
+		req, err := http.NewRequest("GET", githubAPI+p, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
+		if tok != "" {
+			req.Header.Set("Authorization", "token "+tok)
+		}
+
+		resp, err := githubClient.Do(req.WithContext(cancelCtx))
+		if err != nil {
+			return err
+		}
+
+		if wait, limited := githubRateLimited(resp); limited {
+			resp.Body.Close()
+			if wait > githubMaxWait {
+				msg := fmt.Sprintf("github api rate limit exceeded, it resets in %s", wait)
+				if tok == "" {
+					msg += "\nset GITHUB_TOKEN or 'githubToken' in ~/.gx-go/config.json to raise the limit"
+				}
+				return errors.New(msg)
+			}
+
+			Log("github api rate limit reached, waiting %s", wait)
+			select {
+			case <-time.After(wait):
+			case <-cancelCtx.Done():
+				return cancelled()
+			}
+			continue
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("github api request %s failed: %s", p, resp.Status)
+		}
+
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+// githubRateLimited returns whether the response indicates we hit a rate
+// limit, and how long until we may retry
+func githubRateLimited(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != 403 && resp.StatusCode != 429 {
+		return 0, false
+	}
+
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		secs, err := strconv.Atoi(ra)
+		if err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return githubMaxWait + time.Second, true
+	}
+
+	wait := time.Until(time.Unix(reset, 0)) + time.Second
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// githubRepoPath returns the owner/repo of a github import path
+func githubRepoPath(imppath string) (string, bool) {
+	parts := strings.Split(imppath, "/")
+	if len(parts) < 3 || parts[0] != "github.com" {
+		return "", false
+	}
+	return parts[1] + "/" + parts[2], true
+}
+
+// githubMetadata fetches the repository metadata of a github import
+func githubMetadata(imppath string) (*githubRepo, error) {
+	repo, ok := githubRepoPath(imppath)
+	if !ok {
+		return nil, fmt.Errorf("%s is not hosted on github", imppath)
+	}
+
+	var r githubRepo
+	if err := githubGet("/repos/"+repo, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// latestReleaseTag returns the highest semver version among the given tags,
+// without its 'v' prefix
+func latestReleaseTag(tags []string) string {
+	var best string
+	for _, t := range tags {
+		v := strings.TrimPrefix(t, "v")
+		if !isReleaseVersion(v) {
+			continue
+		}
+
+		if best == "" {
+			best = v
+			continue
+		}
+
+		if older, _ := versionComp(best, v); older {
+			best = v
+		}
+	}
+	return best
+}
+
+// isReleaseVersion returns whether v is a plain X.Y.Z version
+func isReleaseVersion(v string) bool {
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	for _, p := range parts {
+		if _, err := strconv.Atoi(p); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// globalRootEnv names a GOPATH style directory that packages are installed
+// into globally, instead of the GOPATH, so projects with incompatible
+// dependencies do not share one global namespace
+const globalRootEnv = "GX_GLOBAL_ROOT"
+
+var globalRootOnce struct {
+	sync.Once
+	dir string
+	err error
+}
+
+// globalRoot returns the GOPATH style directory global installs go to,
+// below src/gx/ipfs: the one GX_GLOBAL_ROOT names, else the globalRoot of
+// the package in the current directory, relative to it, else the GOPATH
+func globalRoot() (string, error) {
+	globalRootOnce.Do(func() {
+		g := &globalRootOnce
+		if dir := os.Getenv(globalRootEnv); dir != "" {
+			g.dir, g.err = filepath.Abs(dir)
+			return
+		}
+
+		if pkg, err := LoadPackageFile(filepath.Join(cwd, gx.PkgFileName)); err == nil && pkg.Gx.GlobalRoot != "" {
+			g.dir = pkg.Gx.GlobalRoot
+			if !filepath.IsAbs(g.dir) {
+				g.dir = filepath.Join(cwd, g.dir)
+			}
+			return
+		}
+
+		g.dir, g.err = getGoPath()
+	})
+	return globalRootOnce.dir, globalRootOnce.err
+}
+
+// globalRootOverridden returns the global root if it is not the GOPATH, so
+// go commands have to be told about it
+func globalRootOverridden() string {
+	root, err := globalRoot()
+	if err != nil {
+		return ""
+	}
+	if gp, err := getGoPath(); err == nil && filepath.Clean(gp) == filepath.Clean(root) {
+		return ""
+	}
+	return root
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	rw "github.com/whyrusleeping/gx-go/rewrite"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var VerifyGofmtCommand = cli.Command{
+	Name:  "verify-gofmt",
+	Usage: "check that rewritten files are gofmt clean and only differ in imports",
+	Description: `checks every go file of the package for two properties the rewriter
+has to keep: the file is formatted as gofmt would, and rewriting it back to
+dvcs imports changes nothing but import paths and their order.
+
+The same checks run after every rewrite on the files it changed, and
+report problems as warnings.`,
+	Action: func(c *cli.Context) error {
+		var failed int
+		err := forEachPackage(func(dir string) error {
+			pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName))
+			if err != nil {
+				return err
+			}
+
+			toDvcs := make(map[string]string)
+			if err := buildRewriteMapping(pkg, filepath.Join(dir, vendorDir), toDvcs, true); err != nil {
+				return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+			}
+			undo := rewriteFunc(toDvcs)
+
+			return walkPackageGoFiles(dir, func(p, rel string) error {
+				src, err := ioutil.ReadFile(p)
+				if err != nil {
+					return err
+				}
+
+				var problems []string
+				if !isGofmtClean(src) {
+					problems = append(problems, "not gofmt clean")
+				}
+
+				undone, err := rw.RewriteSource(p, src, undo)
+				if err != nil {
+					problems = append(problems, err.Error())
+				} else if err := importOnlyDiff(undone, src); err != nil {
+					problems = append(problems, err.Error())
+				}
+
+				for _, pr := range problems {
+					Error("%s: %s", rel, pr)
+				}
+				if len(problems) > 0 {
+					failed++
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d files failed verification", failed)
+		}
+		return nil
+	},
+}
+
+// walkPackageGoFiles calls f for every go file of the package in dir,
+// skipping vendored and nested packages
+func walkPackageGoFiles(dir string, f func(p, rel string) error) error {
+	nested := nestedPackages(dir)
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+		if fi.IsDir() {
+			if rel != "." && (skipDir(fi.Name()) || inNested(nested, rel)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(p, ".go") {
+			return nil
+		}
+		return f(p, rel)
+	})
+}
+
+func isGofmtClean(src []byte) bool {
+	out, err := format.Source(src)
+	return err == nil && bytes.Equal(out, src)
+}
+
+// importOnlyDiff returns an error if before and after differ in anything
+// but the import declarations, or in the number of imports
+func importOnlyDiff(before, after []byte) error {
+	btoks, bimps := nonImportTokens(before)
+	atoks, aimps := nonImportTokens(after)
+
+	if bimps != aimps {
+		return fmt.Errorf("has %d imports instead of %d", aimps, bimps)
+	}
+
+	for i := 0; i < len(btoks) && i < len(atoks); i++ {
+		if !btoks[i].equal(atoks[i]) {
+			return fmt.Errorf("differs outside of the imports at line %d", atoks[i].line)
+		}
+	}
+	if len(btoks) != len(atoks) {
+		return fmt.Errorf("differs outside of the imports")
+	}
+	return nil
+}
+
+type srcToken struct {
+	tok  token.Token
+	lit  string
+	line int
+}
+
+func (t srcToken) equal(o srcToken) bool {
+	return t.tok == o.tok && t.lit == o.lit
+}
+
+// nonImportTokens returns the tokens and comments of src outside of import
+// declarations, and the number of imports
+func nonImportTokens(src []byte) ([]srcToken, int) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+
+	var out []srcToken
+	var imports int
+	var inImport, inParens bool
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		switch {
+		case tok == token.IMPORT:
+			inImport = true
+			continue
+		case inImport && tok == token.LPAREN:
+			inParens = true
+			continue
+		case inImport && tok == token.STRING:
+			imports++
+			continue
+		case inImport && inParens && tok == token.RPAREN:
+			inImport, inParens = false, false
+			continue
+		case inImport && !inParens && tok == token.SEMICOLON:
+			inImport = false
+			continue
+		case inImport:
+			// names, comments and separators of import specs
+			continue
+		}
+
+		if tok == token.SEMICOLON && lit == "\n" {
+			// automatically inserted, its position depends on comments
+			continue
+		}
+		out = append(out, srcToken{tok: tok, lit: lit, line: file.Line(pos)})
+	}
+	return out, imports
+}
+
+// verifyRewritten warns about files under dir that a rewrite changed in
+// more than their imports, or left not gofmt clean. before holds their
+// content prior to the rewrite, by path relative to dir.
+func verifyRewritten(dir string, before map[string][]byte) {
+	for rel, src := range before {
+		after, err := ioutil.ReadFile(filepath.Join(dir, rel))
+		if err != nil || bytes.Equal(src, after) {
+			continue
+		}
+
+		if isGofmtClean(src) && !isGofmtClean(after) {
+			Warn("%s is no longer gofmt clean after the rewrite", rel)
+		}
+		if err := importOnlyDiff(src, after); err != nil {
+			Warn("%s: rewrite %s", rel, err)
+		}
+	}
+}
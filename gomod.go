@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// modRequirement is a module version some imported package's go.mod
+// requires
+type modRequirement struct {
+	Version string
+
+	// By is the import whose go.mod requires the version
+	By string
+}
+
+// goModRequires returns the module versions required by the go.mod in dir,
+// by module path. It returns nil if there is no go.mod.
+func goModRequires(dir string) (map[string]string, error) {
+	fi, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fi.Close()
+
+	out := make(map[string]string)
+	var inBlock bool
+	s := bufio.NewScanner(fi)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case inBlock:
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		case fields[0] == "require":
+			fields = fields[1:]
+		default:
+			continue
+		}
+
+		if len(fields) >= 2 {
+			out[strings.Trim(fields[0], `"`)] = fields[1]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// recordModRequires remembers the requirements of the go.mod of the package
+// imppath checked out in dir, keeping the highest version of every module,
+// as the go command would select
+func (i *Importer) recordModRequires(dir, imppath string) {
+	reqs, err := goModRequires(dir)
+	if err != nil {
+		Warn("reading go.mod of %s: %s", imppath, err)
+		return
+	}
+
+	for mod, v := range reqs {
+		if have, ok := i.modRequires[mod]; ok && !modVersionOlder(have.Version, v) {
+			continue
+		}
+		i.modRequires[mod] = modRequirement{Version: v, By: imppath}
+	}
+}
+
+// modRequiredRef returns the ref of the module version required for
+// imppath by the go.mod files seen so far, if any
+func (i *Importer) modRequiredRef(imppath string) (string, modRequirement, bool) {
+	var best string
+	for mod := range i.modRequires {
+		if (imppath == mod || strings.HasPrefix(imppath, mod+"/")) && len(mod) > len(best) {
+			best = mod
+		}
+	}
+	if best == "" {
+		return "", modRequirement{}, false
+	}
+
+	req := i.modRequires[best]
+	return versionRef(req.Version), req, true
+}
+
+// modVersionOlder returns whether the module version a is older than b.
+// Pre-releases of the same version are ordered by their suffix, which orders
+// pseudo-versions by time.
+func modVersionOlder(a, b string) bool {
+	ca, sa := splitModVersion(a)
+	cb, sb := splitModVersion(b)
+	if ca != cb {
+		older, err := versionComp(ca, cb)
+		if err == nil {
+			return older
+		}
+	}
+	if sa == "" || sb == "" {
+		// a release is newer than its pre-releases
+		return sa != "" && sb == ""
+	}
+	return sa < sb
+}
+
+func splitModVersion(v string) (string, string) {
+	v = strings.TrimSuffix(strings.TrimPrefix(v, "v"), "+incompatible")
+	if i := strings.Index(v, "-"); i >= 0 {
+		return v[:i], v[i:]
+	}
+	return v, ""
+}
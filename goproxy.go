@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var proxyClient = &http.Client{Timeout: 30 * time.Second}
+
+// goProxies returns the module proxy urls of GOPROXY, in the order they are
+// to be tried
+func goProxies() []string {
+	gp := os.Getenv("GOPROXY")
+	if gp == "" {
+		if out, err := exec.Command("go", "env", "GOPROXY").Output(); err == nil {
+			gp = strings.TrimSpace(string(out))
+		}
+	}
+	if gp == "" {
+		gp = "https://proxy.golang.org"
+	}
+
+	var out []string
+	for _, p := range strings.FieldsFunc(gp, func(r rune) bool { return r == ',' || r == '|' }) {
+		if p == "direct" || p == "off" {
+			continue
+		}
+		out = append(out, strings.TrimSuffix(p, "/"))
+	}
+	return out
+}
+
+// escapeModulePath escapes upper case letters in a module path the way
+// module proxies expect, as '!' followed by the lower case letter
+func escapeModulePath(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// proxyLatest asks the module proxies for the newest release of mod. It
+// returns the version, which is a release tag from the @v/list endpoint if
+// there is one, and otherwise what @latest reports, which may be a
+// pseudo-version.
+func proxyLatest(mod string) (string, error) {
+	proxies := goProxies()
+	if len(proxies) == 0 {
+		return "", fmt.Errorf("GOPROXY lists no module proxy")
+	}
+
+	var lastErr error
+	for _, p := range proxies {
+		base := p + "/" + escapeModulePath(mod) + "/@v/"
+		versions, err := proxyList(base + "list")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		var best string
+		for _, v := range versions {
+			if !isReleaseVersion(strings.TrimPrefix(v, "v")) {
+				continue
+			}
+			if best == "" {
+				best = v
+			} else if older, _ := versionComp(strings.TrimPrefix(best, "v"), strings.TrimPrefix(v, "v")); older {
+				best = v
+			}
+		}
+		if best != "" {
+			return best, nil
+		}
+
+		var info struct {
+			Version string
+		}
+		if err := proxyGetJSON(strings.TrimSuffix(base, "@v/")+"@latest", &info); err != nil {
+			lastErr = err
+			continue
+		}
+		return info.Version, nil
+	}
+	return "", lastErr
+}
+
+func proxyList(u string) ([]string, error) {
+	resp, err := proxyClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+
+	var out []string
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		if v := strings.TrimSpace(s.Text()); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out, s.Err()
+}
+
+func proxyGetJSON(u string, v interface{}) error {
+	resp, err := proxyClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// versionRef returns the vcs ref a module version refers to: the commit of
+// a pseudo-version, the tag of any other
+func versionRef(v string) string {
+	parts := strings.Split(v, "-")
+	if len(parts) >= 3 {
+		rev := parts[len(parts)-1]
+		ts := parts[len(parts)-2]
+		if i := strings.LastIndex(ts, "."); i >= 0 {
+			ts = ts[i+1:]
+		}
+		if len(ts) == 14 && len(rev) == 12 {
+			return rev
+		}
+	}
+	return strings.TrimSuffix(v, "+incompatible")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var GrepCommand = cli.Command{
+	Name:      "grep",
+	Usage:     "search the package and its dependencies, in either import form",
+	ArgsUsage: "<pattern>",
+	Description: `searches the files of the current package and of every package in its
+dependency tree for the given regular expression. Dvcs import paths in the
+pattern also match their gx form and the other way around, so searching
+for github.com/x/y finds it whether or not the tree is rewritten.
+
+Matching lines are printed with gx imports shown in their dvcs form,
+unless --raw is given.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "i",
+			Usage: "match case insensitively",
+		},
+		cli.BoolFlag{
+			Name:  "l",
+			Usage: "only print the names of files with matches",
+		},
+		cli.BoolFlag{
+			Name:  "no-deps",
+			Usage: "only search the current package",
+		},
+		cli.BoolFlag{
+			Name:  "raw",
+			Usage: "print matching lines as they are",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify a pattern")
+		}
+
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		g, err := loadDepGraph(pkg, pkgdir)
+		if err != nil {
+			return err
+		}
+
+		toDvcs := make(map[string]string)
+		if err := buildRewriteMapping(pkg, pkgdir, toDvcs, true); err != nil {
+			return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+		}
+
+		expr := translatePattern(c.Args().First(), importForms(g))
+		if c.Bool("i") {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+		VLog("  - searching for %s", expr)
+
+		dirs := []string{cwd}
+		if !c.Bool("no-deps") {
+			for _, n := range g.Nodes {
+				dirs = append(dirs, n.Dir)
+			}
+			sort.Strings(dirs[1:])
+		}
+
+		for _, dir := range dirs {
+			err := grepDir(dir, re, func(p string, n int, line string) {
+				name := p
+				if rel, err := filepath.Rel(cwd, p); err == nil && !strings.HasPrefix(rel, "..") {
+					name = rel
+				}
+
+				if c.Bool("l") {
+					fmt.Println(name)
+					return
+				}
+				if !c.Bool("raw") {
+					line = replacePaths(line, toDvcs)
+				}
+				fmt.Printf("%s:%d:%s\n", name, n, line)
+			}, c.Bool("l"))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// importForms returns, for every dvcs import in the dependency graph and
+// every gx import of a package providing one, all the forms of that import
+func importForms(g *depGraph) map[string][]string {
+	byDvcs := make(map[string][]string)
+	for h, n := range g.Nodes {
+		dvcs := n.Pkg.Gx.DvcsImport
+		if dvcs == "" {
+			continue
+		}
+		if byDvcs[dvcs] == nil {
+			byDvcs[dvcs] = []string{dvcs}
+		}
+		for _, hp := range hashPaths(h) {
+			byDvcs[dvcs] = append(byDvcs[dvcs], withRoot("gx/ipfs/"+hp+"/"+n.Pkg.Name, n.Pkg))
+		}
+	}
+
+	out := make(map[string][]string)
+	for _, forms := range byDvcs {
+		for _, f := range forms {
+			out[f] = forms
+		}
+	}
+	return out
+}
+
+// translatePattern makes every import path in pattern match all of its
+// forms, as given by importForms
+func translatePattern(pattern string, forms map[string][]string) string {
+	var keys []string
+	for k := range forms {
+		keys = append(keys, k)
+	}
+	// longest first, so the most specific path wins
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+
+	var out strings.Builder
+	for i := 0; i < len(pattern); {
+		form, k := matchPatternAt(pattern, i, keys)
+		if form == "" {
+			out.WriteByte(pattern[i])
+			i++
+			continue
+		}
+
+		var alts []string
+		for _, f := range forms[k] {
+			alts = append(alts, regexp.QuoteMeta(f))
+		}
+		out.WriteString("(?:" + strings.Join(alts, "|") + ")")
+		i += len(form)
+	}
+	return out.String()
+}
+
+// matchPatternAt returns the import path from keys found at position i of
+// pattern, either literally or regexp quoted, and the form it was found in
+func matchPatternAt(pattern string, i int, keys []string) (string, string) {
+	for _, k := range keys {
+		for _, form := range []string{regexp.QuoteMeta(k), k} {
+			if strings.HasPrefix(pattern[i:], form) {
+				return form, k
+			}
+		}
+	}
+	return "", ""
+}
+
+// grepDir calls found for every line matching re in the text files under
+// dir, skipping vendored packages and vcs metadata. If first is set, only
+// the first match in each file is reported.
+func grepDir(dir string, re *regexp.Regexp, found func(p string, n int, line string), first bool) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if p != dir && skipDir(fi.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		// skip binary files
+		head := data
+		if len(head) > 8000 {
+			head = head[:8000]
+		}
+		if bytes.IndexByte(head, 0) >= 0 {
+			return nil
+		}
+
+		s := bufio.NewScanner(bytes.NewReader(data))
+		s.Buffer(nil, len(data)+1)
+		for n := 1; s.Scan(); n++ {
+			if re.MatchString(s.Text()) {
+				found(p, n, s.Text())
+				if first {
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,283 @@
+// Package gxgotest runs gx-go against a fake package store on disk, so the
+// gx automation of a project can be tested without gx or an ipfs daemon.
+//
+// An Env is a GOPATH, a gx-go config and cache, and the store, all in one
+// directory. Packages written with WritePackage are imported from where
+// they were written, never fetched, and everything gx-go publishes goes to
+// the store, where Install finds it:
+//
+//	env, err := gxgotest.New(dir)
+//	env.WritePackage("example.com/lib", map[string]string{"lib.go": "package lib"})
+//	hash, err := env.Import("example.com/lib")
+//	err = env.Install(project, hash)
+package gxgotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Env is a hermetic environment to run gx-go in
+type Env struct {
+	// Dir holds everything the environment uses
+	Dir string
+
+	// GoPath is the GOPATH gx-go imports into, Store the directory of the
+	// fake package store
+	GoPath string
+	Store  string
+
+	// Bin is the gx-go executable, "gx-go" from the PATH by default
+	Bin string
+
+	sources []source
+}
+
+// source is an import source of the gx-go config
+type source struct {
+	Prefix  string `json:"prefix"`
+	Backend string `json:"backend"`
+	URL     string `json:"url,omitempty"`
+}
+
+// New sets up an environment in dir, which should be empty
+func New(dir string) (*Env, error) {
+	e := &Env{
+		Dir:    dir,
+		GoPath: filepath.Join(dir, "gopath"),
+		Store:  filepath.Join(dir, "store"),
+		Bin:    "gx-go",
+	}
+	for _, d := range []string{e.GoPath, e.Store, e.path("config"), e.path("cache"), e.path("upstream"), e.path("work")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return e, e.writeConfig()
+}
+
+func (e *Env) path(elem ...string) string {
+	return filepath.Join(append([]string{e.Dir}, elem...)...)
+}
+
+// Environ returns the environment gx-go runs in: the current one, pointed
+// at the GOPATH, config, cache and store of e
+func (e *Env) Environ() []string {
+	vars := map[string]string{
+		"GOPATH":           e.GoPath,
+		"GO111MODULE":      "off",
+		"GX_GO_FAKE_STORE": e.Store,
+		"GX_GO_DIR":        e.path("config"),
+		"GX_GO_CACHE":      e.path("cache"),
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if _, ok := vars[strings.SplitN(kv, "=", 2)[0]]; !ok {
+			env = append(env, kv)
+		}
+	}
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// Run runs gx-go with args in dir, returning its combined output. A failure
+// includes the output in the error.
+func (e *Env) Run(dir string, args ...string) (string, error) {
+	cmd := exec.Command(e.Bin, args...)
+	cmd.Dir = dir
+	cmd.Env = e.Environ()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("gx-go %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out), nil
+}
+
+// output runs gx-go with args in dir, returning what it printed to stdout
+func (e *Env) output(dir string, args ...string) (string, error) {
+	cmd := exec.Command(e.Bin, args...)
+	cmd.Dir = dir
+	cmd.Env = e.Environ()
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("gx-go %s: %s", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// WritePackage writes the source of the package imp, files by their path
+// relative to it, and makes gx-go import it from there. It returns the
+// directory the package was written to.
+func (e *Env) WritePackage(imp string, files map[string]string) (string, error) {
+	dir := e.path("upstream", filepath.FromSlash(imp))
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			return "", err
+		}
+	}
+
+	e.sources = append(e.sources, source{Prefix: imp, Backend: "local", URL: dir})
+	return dir, e.writeConfig()
+}
+
+func (e *Env) writeConfig() error {
+	out, err := json.MarshalIndent(map[string]interface{}{"sources": e.sources}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(e.path("config", "config.json"), out, 0644)
+}
+
+// Import imports imp and its dependencies into the store with 'gx-go
+// import', returning the hash of imp
+func (e *Env) Import(imp string, args ...string) (string, error) {
+	report := e.path("work", "import-report.json")
+	args = append(append([]string{"import", "--yesall", "--report", report}, args...), imp)
+	if _, err := e.Run(e.path("work"), args...); err != nil {
+		return "", err
+	}
+
+	var r struct {
+		Published []struct {
+			Import string `json:"import"`
+			Hash   string `json:"hash"`
+		} `json:"published"`
+		Reused []struct {
+			Import string `json:"import"`
+			Hash   string `json:"hash"`
+		} `json:"reused"`
+	}
+	data, err := ioutil.ReadFile(report)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return "", err
+	}
+	for _, p := range append(r.Published, r.Reused...) {
+		if p.Import == imp {
+			return p.Hash, nil
+		}
+	}
+	return "", fmt.Errorf("importing %s published no package for it", imp)
+}
+
+// Install installs the packages with the given hashes, and their
+// dependencies, from the store into the project in dir, where the
+// install-path hook says, running the post-install hook on each like 'gx
+// install' does. The hook places them as the vendor layout of the project
+// wants them.
+func (e *Env) Install(dir string, hashes ...string) error {
+	root, err := e.output(dir, "hook", "install-path")
+	if err != nil {
+		return err
+	}
+	vendor := filepath.Join(root, "gx", "ipfs")
+	seen := make(map[string]bool)
+
+	var install func(hash string) error
+	install = func(hash string) error {
+		if seen[hash] {
+			return nil
+		}
+		seen[hash] = true
+
+		src := filepath.Join(e.Store, hash)
+		deps, err := packageDeps(src)
+		if err != nil {
+			return fmt.Errorf("%s is not in the store: %s", hash, err)
+		}
+		for _, d := range deps {
+			if err := install(d); err != nil {
+				return err
+			}
+		}
+
+		// installed already, in any layout
+		dst := filepath.Join(vendor, hash)
+		if _, err := os.Stat(dst); err == nil {
+			return nil
+		}
+		if m, _ := filepath.Glob(filepath.Join(vendor, "*", hash)); len(m) > 0 {
+			return nil
+		}
+		if err := copyTree(src, dst); err != nil {
+			return err
+		}
+		_, err = e.Run(dir, "hook", "post-install", dst)
+		return err
+	}
+
+	for _, h := range hashes {
+		if err := install(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packageDeps returns the hashes of the dependencies of the package stored
+// in dir
+func packageDeps(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ent := range entries {
+		data, err := ioutil.ReadFile(filepath.Join(dir, ent.Name(), "package.json"))
+		if err != nil {
+			continue
+		}
+
+		var pkg struct {
+			Dependencies []struct {
+				Hash string `json:"hash"`
+			} `json:"gxDependencies"`
+		}
+		if err := json.Unmarshal(data, &pkg); err != nil {
+			return nil, err
+		}
+
+		var out []string
+		for _, d := range pkg.Dependencies {
+			out = append(out, d.Hash)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("no package.json in %s", dir)
+}
+
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, fi.Mode())
+	})
+}
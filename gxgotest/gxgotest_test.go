@@ -0,0 +1,216 @@
+package gxgotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const (
+	testLib  = "example.com/gxgotest/lib"
+	testApp  = "example.com/gxgotest/app"
+	testProj = "example.com/gxgotest/proj"
+)
+
+// buildGxGo builds the gx-go executable into dir
+func buildGxGo(t *testing.T, dir string) string {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command")
+	}
+
+	bin := filepath.Join(dir, "gx-go")
+	out, err := exec.Command("go", "build", "-o", bin, "github.com/whyrusleeping/gx-go").CombinedOutput()
+	if err != nil {
+		t.Fatalf("building gx-go: %s\n%s", err, out)
+	}
+	return bin
+}
+
+func TestImportInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gxgotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := buildGxGo(t, dir)
+
+	for _, layout := range []string{"flat", "sharded"} {
+		t.Run(layout, func(t *testing.T) {
+			env, err := New(filepath.Join(dir, layout))
+			if err != nil {
+				t.Fatal(err)
+			}
+			env.Bin = bin
+
+			if _, err := env.WritePackage(testLib, map[string]string{
+				"lib.go": "package lib\n\nfunc Answer() int { return 42 }\n",
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := env.WritePackage(testApp, map[string]string{
+				"app.go": "package app\n\nimport \"" + testLib + "\"\n\nfunc Answer() int { return lib.Answer() }\n",
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			hash, err := env.Import(testApp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			proj := filepath.Join(env.GoPath, "src", filepath.FromSlash(testProj))
+			if err := os.MkdirAll(proj, 0755); err != nil {
+				t.Fatal(err)
+			}
+			pkg := map[string]interface{}{
+				"name":           "proj",
+				"version":        "0.0.0",
+				"language":       "go",
+				"gx":             map[string]string{"dvcsimport": testProj, "vendorlayout": layout},
+				"gxDependencies": []map[string]string{{"name": "app", "hash": hash, "version": "0.0.0"}},
+			}
+			data, err := json.MarshalIndent(pkg, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(proj, "package.json"), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := env.Install(proj, hash); err != nil {
+				t.Fatal(err)
+			}
+			// installing again finds what is there
+			if err := env.Install(proj, hash); err != nil {
+				t.Fatal(err)
+			}
+
+			want := filepath.Join(proj, "vendor", "gx", "ipfs", hash)
+			if layout == "sharded" {
+				want = filepath.Join(proj, "vendor", "gx", "ipfs", hash[len(hash)-2:], hash)
+			}
+			app, err := ioutil.ReadFile(filepath.Join(want, "app", "app.go"))
+			if err != nil {
+				t.Fatalf("app is not installed at %s: %s", want, err)
+			}
+			if strings.Contains(string(app), `"`+testLib+`"`) {
+				t.Errorf("the installed app still imports %s:\n%s", testLib, app)
+			}
+
+			ents, err := ioutil.ReadDir(filepath.Join(proj, "vendor", "gx", "ipfs"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ents) != 2 {
+				t.Errorf("%d entries in the vendor directory, want app and lib", len(ents))
+			}
+		})
+	}
+}
+
+func TestSelfUpdateOutdated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gxgotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := buildGxGo(t, dir)
+
+	env, err := New(filepath.Join(dir, "env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Bin = bin
+
+	// a gateway listing a release newer than any gx-go
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ipns/dist.ipfs.io/gx-go/versions":
+			fmt.Fprintln(w, "v999.0.0")
+		case "/ipns/dist.ipfs.io/gx-go/v999.0.0/dist.json":
+			fmt.Fprintf(w, `{"platforms": {%q: {"archs": {%q: {"link": "gx-go.tar.gz", "sha512": "00"}}}}}`, runtime.GOOS, runtime.GOARCH)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer gw.Close()
+	cfg := fmt.Sprintf(`{"gateway": %q}`, gw.URL)
+	if err := ioutil.WriteFile(filepath.Join(env.Dir, "config", "config.json"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a package requiring that release
+	proj := filepath.Join(env.GoPath, "src", filepath.FromSlash(testProj))
+	if err := os.MkdirAll(proj, 0755); err != nil {
+		t.Fatal(err)
+	}
+	pkg := `{"name": "proj", "version": "0.0.0", "language": "go", "gx": {"toolVersion": {"gx-go": "999.0.0"}}}`
+	if err := ioutil.WriteFile(filepath.Join(proj, "package.json"), []byte(pkg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := env.Run(proj, "self-update", "--check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "999.0.0 is available") {
+		t.Errorf("self-update --check did not report the release:\n%s", out)
+	}
+
+	if out, err := env.Run(proj, "deps", "ls"); err == nil || !strings.Contains(out, "requires at least gx-go version 999.0.0") {
+		t.Errorf("deps ls ran in a package requiring a newer gx-go:\n%s", out)
+	}
+}
+
+func TestCrossCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gxgotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := buildGxGo(t, dir)
+
+	env, err := New(filepath.Join(dir, "env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Bin = bin
+
+	proj := filepath.Join(env.GoPath, "src", filepath.FromSlash(testProj))
+	if err := os.MkdirAll(proj, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"package.json": `{"name": "proj", "version": "0.0.0", "language": "go", "gx": {"dvcsimport": "` + testProj + `"}}`,
+		"proj.go":      "package proj\n\nfunc Answer() int { return 42 }\n",
+		// only windows builds are broken
+		"proj_windows.go": "package proj\n\nfunc broken() int { return \"\" }\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(proj, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out, err := env.Run(proj, "cross-check", "--platforms", "linux/amd64,darwin/amd64"); err != nil {
+		t.Errorf("cross-check of a package that builds failed: %s", err)
+	} else if strings.Contains(out, "FAIL") {
+		t.Errorf("cross-check of a package that builds reported failures:\n%s", out)
+	}
+
+	out, err := env.Run(proj, "cross-check", "--platforms", "linux/amd64,windows/amd64")
+	if err == nil {
+		t.Errorf("cross-check succeeded with a target failing to build:\n%s", out)
+	}
+	if !strings.Contains(out, "windows/amd64 FAIL") {
+		t.Errorf("cross-check did not report the failing target:\n%s", out)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var HashMapCommand = cli.Command{
+	Name:  "hash-map",
+	Usage: "map the hashes of dependencies to their upstream revisions",
+	Subcommands: []cli.Command{
+		hashMapGitCommand,
+	},
+	Action: func(c *cli.Context) error { return nil },
+}
+
+var hashMapGitCommand = cli.Command{
+	Name:  "git",
+	Usage: "map the hashes of dependencies to git tags in their upstream repositories",
+	Description: `lists, for every package in the dependency tree that records the git
+revision it was imported from, the tags of its upstream repository that
+point at that revision. Where no tag does, the date of the commit is given
+instead, so compliance reports can speak in git terms.
+
+Packages without a recorded git revision are listed without one. The
+upstream repositories are queried with 'git ls-remote', commit dates of
+github repositories are read from the github api.`,
+	Flags: []cli.Flag{
+		formatFlag,
+		templateFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		var deps []*Package
+		var hashes []string
+		err = forEachDep(pkg, filepath.Join(cwd, vendorDir), func(dep *gx.Dependency, dpkg *Package, _ string) error {
+			deps = append(deps, dpkg)
+			hashes = append(hashes, dep.Hash)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		rows := make([][]string, len(deps))
+		var lsr gitRemotes
+		err = parallel(len(deps), func(i int) error {
+			rows[i] = hashMapRow(&lsr, deps[i], hashes[i])
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+		return writeResults(os.Stdout, c, []string{"NAME", "VERSION", "HASH", "IMPORT", "COMMIT", "TAG", "DATE"}, rows)
+	},
+}
+
+// hashMapRow maps the package dpkg, published as hash, to the tags of its
+// upstream git repository, or the date of its commit
+func hashMapRow(lsr *gitRemotes, dpkg *Package, hash string) []string {
+	row := []string{dpkg.Name, dpkg.Version, hash, dpkg.Gx.DvcsImport, "-", "-", "-"}
+	rev := dpkg.Gx.DvcsRevision
+	switch {
+	case dpkg.Gx.DvcsImport == "":
+		row[3] = "-"
+		return row
+	case rev == "" || (dpkg.Gx.DvcsType != "" && dpkg.Gx.DvcsType != "git"):
+		return row
+	}
+	row[4] = rev
+
+	url, err := gitRepoURL(dpkg.Gx.DvcsImport)
+	if err != nil {
+		Warn("%s: %s", dpkg.Name, err)
+		return row
+	}
+
+	tags, err := lsr.tags(url)
+	if err != nil {
+		Warn("%s: %s", dpkg.Name, err)
+		return row
+	}
+	if t := tags[rev]; len(t) > 0 {
+		row[5] = strings.Join(t, ",")
+		return row
+	}
+
+	date, err := gitCommitDate(url, dpkg.Gx.DvcsImport, rev)
+	if err != nil {
+		Warn("%s: %s", dpkg.Name, err)
+		return row
+	}
+	row[6] = date
+	return row
+}
+
+// gitRemotes caches the tags of remote repositories, several packages are
+// often published from one repository
+type gitRemotes struct {
+	lk    sync.Mutex
+	repos map[string]*gitRemote
+}
+
+type gitRemote struct {
+	once sync.Once
+	tags map[string][]string
+	err  error
+}
+
+// tags returns the tags of the git repository at url, by the commit they
+// point at
+func (g *gitRemotes) tags(url string) (map[string][]string, error) {
+	g.lk.Lock()
+	if g.repos == nil {
+		g.repos = make(map[string]*gitRemote)
+	}
+	r, ok := g.repos[url]
+	if !ok {
+		r = new(gitRemote)
+		g.repos[url] = r
+	}
+	g.lk.Unlock()
+
+	r.once.Do(func() {
+		r.tags, r.err = gitRemoteTags(url)
+	})
+	return r.tags, r.err
+}
+
+func gitRemoteTags(url string) (map[string][]string, error) {
+	VLog("  - listing tags of %s", url)
+	cmd := exec.CommandContext(cancelCtx, "git", "ls-remote", "--tags", url)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-remote %s: %s", url, err)
+	}
+
+	tags := make(map[string][]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/tags/") {
+			continue
+		}
+		// annotated tags are listed twice, the peeled ref names the commit
+		name := strings.TrimSuffix(strings.TrimPrefix(fields[1], "refs/tags/"), "^{}")
+		tags[fields[0]] = append(tags[fields[0]], name)
+	}
+	for sha, t := range tags {
+		tags[sha] = uniqueStrings(t)
+	}
+	return tags, nil
+}
+
+// gitCommitDate returns the commit date of rev in the repository at url,
+// which imppath is imported from. Github repositories are asked through the
+// api first, the commit is fetched otherwise.
+func gitCommitDate(url, imppath, rev string) (string, error) {
+	if repo, ok := githubRepoPath(imppath); ok {
+		var commit struct {
+			Commit struct {
+				Committer struct {
+					Date string `json:"date"`
+				} `json:"committer"`
+			} `json:"commit"`
+		}
+		err := githubGet("/repos/"+repo+"/commits/"+rev, &commit)
+		if err == nil {
+			return commit.Commit.Committer.Date, nil
+		}
+		VLog("  - fetching commit %s from github: %s, falling back to git", rev, err)
+	}
+
+	tmp, err := ioutil.TempDir("", "gx-go-hash-map")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"fetch", "-q", "--depth", "1", url, rev},
+	} {
+		cmd := exec.CommandContext(cancelCtx, "git", args...)
+		cmd.Dir = tmp
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(out)))
+		}
+	}
+
+	cmd := exec.CommandContext(cancelCtx, "git", "log", "-1", "--format=%cI", "FETCH_HEAD")
+	cmd.Dir = tmp
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git log: %s", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+var goImportRE = regexp.MustCompile(`<meta\s+name="go-import"\s+content="([^"]*)"`)
+
+// gitRepoURL returns the url of the git repository imppath is imported from,
+// asking the import path's server for vanity imports
+func gitRepoURL(imppath string) (string, error) {
+	switch strings.SplitN(imppath, "/", 2)[0] {
+	case "github.com", "gitlab.com", "bitbucket.org":
+		return "https://" + getBaseDVCS(imppath), nil
+	}
+
+	resp, err := githubClient.Get("https://" + imppath + "?go-get=1")
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %s", imppath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("resolving %s: %s", imppath, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %s", imppath, err)
+	}
+	for _, m := range goImportRE.FindAllStringSubmatch(string(body), -1) {
+		f := strings.Fields(m[1])
+		if len(f) != 3 || (imppath != f[0] && !strings.HasPrefix(imppath, f[0]+"/")) {
+			continue
+		}
+		if f[1] != "git" {
+			return "", fmt.Errorf("%s is hosted in %s, not git", imppath, f[1])
+		}
+		return f[2], nil
+	}
+	return "", fmt.Errorf("no go-import meta tag found for %s", imppath)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// hookCheck is the outcome of dry-running one aspect of a hook
+type hookCheck struct {
+	Hook   string
+	Status string
+	Msg    string
+}
+
+type hookChecks []hookCheck
+
+func (hc *hookChecks) ok(hook, format string, args ...interface{}) {
+	*hc = append(*hc, hookCheck{hook, "ok", fmt.Sprintf(format, args...)})
+}
+
+func (hc *hookChecks) warn(hook, format string, args ...interface{}) {
+	*hc = append(*hc, hookCheck{hook, "warn", fmt.Sprintf(format, args...)})
+}
+
+func (hc *hookChecks) fail(hook, format string, args ...interface{}) {
+	*hc = append(*hc, hookCheck{hook, "fail", fmt.Sprintf(format, args...)})
+}
+
+var hookValidateCommand = cli.Command{
+	Name:  "validate",
+	Usage: "dry-run every hook against the current package",
+	Description: `exercises every gx-go hook against the current package without changing
+anything, reporting what each would do and any configuration problems that
+would make it fail during 'gx install' or 'gx import'.`,
+	Flags: []cli.Flag{formatFlag},
+	Action: func(c *cli.Context) error {
+		var hc hookChecks
+
+		pkg, err := LoadPackageFile(filepath.Join(cwd, gx.PkgFileName))
+		if err != nil {
+			return fmt.Errorf("loading package: %s", err)
+		}
+
+		if _, err := gxToolVersion(); err != nil {
+			hc.fail("all", "%s", err)
+		}
+		if err := checkToolVersions(pkg); err != nil {
+			hc.fail("all", "%s", err)
+		}
+
+		validatePostInit(&hc, pkg)
+		validateInstallPath(&hc)
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		g, err := loadDepGraph(pkg, pkgdir)
+		if err != nil {
+			hc.fail("post-install", "loading dependencies: %s", err)
+		} else {
+			validateReqCheck(&hc, g)
+			validatePostInstall(&hc, pkg, pkgdir)
+			validatePostImport(&hc, g)
+			validatePostUpdate(&hc, pkgdir)
+		}
+
+		var rows [][]string
+		var failed int
+		for _, r := range hc {
+			rows = append(rows, []string{r.Hook, r.Status, r.Msg})
+			if r.Status == "fail" {
+				failed++
+			}
+		}
+
+		if err := writeTable(os.Stdout, c.String("format"), []string{"hook", "status", "message"}, rows); err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d hook checks failed", failed)
+		}
+		return nil
+	},
+}
+
+func validatePostInit(hc *hookChecks, pkg *Package) {
+	imp, err := packagesGoImport(cwd)
+	switch {
+	case err != nil && pkg.Gx.DvcsImport == "":
+		hc.warn("post-init", "no dvcsimport set and the package is outside of GOPATH/src, it would be left unset")
+	case err != nil:
+		hc.ok("post-init", "outside of GOPATH/src, dvcsimport would stay %s", pkg.Gx.DvcsImport)
+	case pkg.Gx.DvcsImport != "" && pkg.Gx.DvcsImport != imp:
+		hc.warn("post-init", "would change dvcsimport from %s to %s", pkg.Gx.DvcsImport, imp)
+	default:
+		hc.ok("post-init", "dvcsimport would be %s", imp)
+	}
+}
+
+func validateInstallPath(hc *hookChecks) {
+	mode := "GOPATH"
+	if moduleMode(cwd) {
+		mode = "module"
+	}
+	hc.ok("install-path", "would install to %s (%s mode)", localInstallPath(cwd), mode)
+
+	if root, err := globalRoot(); err != nil {
+		hc.fail("install-path", "GOPATH not set, global installs would fail")
+	} else {
+		hc.ok("install-path", "would install globally to %s", filepath.Join(root, "src"))
+	}
+
+	for _, global := range []bool{false, true} {
+		dir, err := binInstallPath(global)
+		if err != nil {
+			hc.fail("install-path", "determining bin dir: %s", err)
+			continue
+		}
+		hc.ok("install-path", "would install executables (global: %t) to %s", global, dir)
+	}
+}
+
+func validateReqCheck(hc *hookChecks, g *depGraph) {
+	var hashes []string
+	for h := range g.Nodes {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	var failed bool
+	for _, h := range hashes {
+		n := g.Nodes[h]
+		if err := reqCheckHook(n.Dir); err != nil {
+			hc.fail("req-check", "%s: %s", n.Pkg.Name, err)
+			failed = true
+		}
+	}
+
+	if !failed {
+		hc.ok("req-check", "requirements of all %d dependencies are met", len(hashes))
+	}
+}
+
+func validatePostInstall(hc *hookChecks, pkg *Package, pkgdir string) {
+	mapping := make(map[string]string)
+	if err := buildRewriteMapping(pkg, pkgdir, mapping, false); err != nil {
+		hc.fail("post-install", "building rewrite mapping: %s", err)
+		return
+	}
+	hc.ok("post-install", "rewrite mapping covers %d dvcs imports", len(mapping))
+
+	var imps []string
+	for imp := range mapping {
+		imps = append(imps, imp)
+	}
+	for _, group := range caseCollisions(imps) {
+		hc.warn("post-install", "imports differ only by case: %s", strings.Join(group, ", "))
+	}
+
+	hashes, err := vendoredHashes(pkgdir)
+	if err != nil {
+		hc.fail("post-install", "reading vendor dir: %s", err)
+		return
+	}
+
+	var moves int
+	for h, rel := range hashes {
+		if rel != hashPath(h) {
+			moves++
+		}
+	}
+	if moves > 0 {
+		hc.warn("post-install", "%d vendored packages are not placed for the %s layout and would be moved", moves, vendorLayout)
+	}
+}
+
+func validatePostImport(hc *hookChecks, g *depGraph) {
+	imps, err := goImportsInDir(cwd)
+	if err != nil {
+		hc.fail("post-import", "reading imports: %s", err)
+		return
+	}
+
+	var dvcs []string
+	for _, d := range g.Root.Dependencies {
+		n, ok := g.Nodes[d.Hash]
+		if !ok || n.Pkg.Gx.DvcsImport == "" {
+			continue
+		}
+
+		for imp := range imps {
+			if imp == n.Pkg.Gx.DvcsImport || strings.HasPrefix(imp, n.Pkg.Gx.DvcsImport+"/") {
+				dvcs = append(dvcs, n.Pkg.Gx.DvcsImport)
+				break
+			}
+		}
+	}
+
+	if len(dvcs) == 0 {
+		hc.ok("post-import", "no imports would be updated")
+		return
+	}
+
+	sort.Strings(dvcs)
+	hc.ok("post-import", "would offer to update imports of %s", strings.Join(dvcs, ", "))
+}
+
+func validatePostUpdate(hc *hookChecks, pkgdir string) {
+	hashes, err := vendoredHashes(pkgdir)
+	if err != nil {
+		hc.fail("post-update", "reading vendor dir: %s", err)
+		return
+	}
+
+	var bad []string
+	for h := range hashes {
+		if _, err := decodeHash(h); err != nil {
+			bad = append(bad, h)
+		}
+	}
+
+	if len(bad) > 0 {
+		sort.Strings(bad)
+		hc.fail("post-update", "vendored hashes that cannot be decoded: %s", strings.Join(bad, ", "))
+		return
+	}
+	hc.ok("post-update", "all %d vendored hashes can be converted to the configured format", len(hashes))
+}
@@ -6,37 +6,70 @@ import (
 	"go/scanner"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	rw "github.com/whyrusleeping/gx-go/rewrite"
 	gx "github.com/whyrusleeping/gx/gxutil"
-	. "github.com/whyrusleeping/stump"
 )
 
 func doUpdate(dir, oldimp, newimp string) error {
-	rwf := func(in string) string {
-		if in == oldimp {
-			return newimp
-		}
+	return doUpdateMapping(dir, map[string]string{oldimp: newimp})
+}
 
-		if strings.HasPrefix(in, oldimp+"/") {
-			return strings.Replace(in, oldimp, newimp, 1)
-		}
+// doUpdateMapping rewrites the imports of the package in dir according to
+// mapping, matching whole import paths and path prefixes
+func doUpdateMapping(dir string, mapping map[string]string) error {
+	nested := nestedPackages(dir)
+	filter := func(in string) bool {
+		return strings.HasSuffix(in, ".go") && !strings.HasPrefix(in, "vendor/") && !inNested(nested, in)
+	}
 
-		return in
+	return rw.RewriteImportsContext(cancelCtx, dir, rewriteFunc(mapping), filter)
+}
+
+// skipHash marks imports in an import map that are left unvendored on
+// purpose, such as those only used on platforms that are never built
+const skipHash = "skip"
+
+// isSkipped returns whether imp is or is below one of the skipped imports
+func isSkipped(skip []string, imp string) bool {
+	for _, s := range skip {
+		if imp == s || strings.HasPrefix(imp, s+"/") {
+			return true
+		}
 	}
+	return false
+}
 
-	filter := func(in string) bool {
-		return strings.HasSuffix(in, ".go") && !strings.HasPrefix(in, "vendor")
+// skippedImports returns the imports marked skip in the import map mapping,
+// sorted
+func skippedImports(mapping map[string]string) []string {
+	var skip []string
+	for p, hash := range mapping {
+		if hash == skipHash {
+			skip = append(skip, p)
+		}
 	}
+	sort.Strings(skip)
+	return skip
+}
 
-	return rw.RewriteImports(dir, rwf, filter)
+// skippedInMap returns whether imp is or is below an import marked skip in
+// the import map
+func (i *Importer) skippedInMap(imp string) bool {
+	return isSkipped(skippedImports(i.preMap), imp)
 }
 
+// pathIsNotStdlib returns whether path is a package outside of the standard
+// library that can be fetched, which needs a domain in its first element
 func pathIsNotStdlib(path string) bool {
+	if isStdlib(path) {
+		return false
+	}
+
 	first := strings.Split(path, "/")[0]
 
 	if len(strings.Split(first, ".")) > 1 {
@@ -48,21 +81,78 @@ func pathIsNotStdlib(path string) bool {
 type Importer struct {
 	pkgs    map[string]*gx.Dependency
 	gopath  string
-	pm      *gx.PM
+	pm      packageStore
 	rewrite bool
 	yesall  bool
 	preMap  map[string]string
 
+	// editMap makes the importer ask what to do with dependencies not in
+	// preMap, recording the answers in mapFile
+	editMap bool
+	mapFile string
+
+	// useIndex makes the importer reuse hashes from the local index for
+	// dependencies not in preMap that no ref is selected for
+	useIndex bool
+
+	// platforms are the GOOS/GOARCH combinations whose imports are vendored
+	platforms []platform
+
+	// report, if set, collects what the import published and reused
+	report *importReport
+
+	// registryPublish, if set, is the registry endpoint the metadata of
+	// every published package is posted to
+	registryPublish string
+
+	// stamp, if set, is recorded as gx.lastTool in imported packages
+	stamp *LastTool
+
+	// refs are the vcs refs to check out for given imports, instead of
+	// what 'go get' fetches
+	refs map[string]string
+
+	// latestRelease makes the importer check out the newest release the
+	// module proxies know of, for imports without a ref. released records
+	// the release versions checked out, by import.
+	latestRelease bool
+	released      map[string]string
+
+	// githubMetadata makes the importer fill in the description of new
+	// packages hosted on github from the github api
+	githubMetadata bool
+
+	// modRequires are the module versions required by the go.mod files of
+	// the packages imported so far, by module path. They select the
+	// revisions of imports without a ref.
+	modRequires map[string]modRequirement
+	ignoreGoMod bool
+
+	// policy, if set, is the dependency policy imports are checked against
+	policy *depPolicy
+
+	// sources select the fetch backends of imports, by import path prefix
+	sources []importSource
+
+	// stripVendor keeps the vendor directories of imported packages out of
+	// what is published
+	stripVendor bool
+
+	// keepGoing makes the importer carry on with the rest of the tree when
+	// importing a package fails, the failures are collected in failures
+	keepGoing bool
+	failures  []error
+
+	// chain holds the imports currently being published, from the root of
+	// the import down
+	chain  []string
+	failed map[string]error
+
 	bctx build.Context
 }
 
 func NewImporter(rw bool, gopath string, premap map[string]string) (*Importer, error) {
-	cfg, err := gx.LoadConfig()
-	if err != nil {
-		return nil, err
-	}
-
-	pm, err := gx.NewPM(cfg)
+	pm, err := newPM()
 	if err != nil {
 		return nil, err
 	}
@@ -74,13 +164,26 @@ func NewImporter(rw bool, gopath string, premap map[string]string) (*Importer, e
 	bctx := build.Default
 	bctx.GOPATH = gopath
 
+	sources, err := loadImportSources()
+	if err != nil {
+		return nil, err
+	}
+
+	logGitRewrites()
+
 	return &Importer{
-		pkgs:    make(map[string]*gx.Dependency),
-		gopath:  gopath,
-		pm:      pm,
-		rewrite: rw,
-		preMap:  premap,
-		bctx:    bctx,
+		pkgs:        make(map[string]*gx.Dependency),
+		failed:      make(map[string]error),
+		refs:        make(map[string]string),
+		released:    make(map[string]string),
+		modRequires: make(map[string]modRequirement),
+		sources:     sources,
+		gopath:      gopath,
+		pm:          pm,
+		rewrite:     rw,
+		preMap:      premap,
+		platforms:   defaultPlatforms,
+		bctx:        bctx,
 	}, nil
 }
 
@@ -101,37 +204,226 @@ func getBaseDVCS(path string) string {
 	return path
 }
 
+// importError is a failure to import a package, along with the chain of
+// imports that led to it
+type importError struct {
+	Chain []string
+	Err   error
+}
+
+func (e *importError) Error() string {
+	return fmt.Sprintf("importing %s: %s", strings.Join(e.Chain, " -> "), e.Err)
+}
+
+// depsFailedError is returned for a package whose dependencies could not be
+// imported, with --keep-going
+type depsFailedError struct {
+	Import string
+	N      int
+}
+
+func (e *depsFailedError) Error() string {
+	return fmt.Sprintf("%d dependencies of %s failed to import", e.N, e.Import)
+}
+
+// withChain attaches the current import chain to an error importing imppath
+func (i *Importer) withChain(imppath string, err error) error {
+	switch err.(type) {
+	case *importError, *depsFailedError:
+		return err
+	}
+
+	chain := append(append([]string{}, i.chain...), imppath)
+	return &importError{Chain: chain, Err: err}
+}
+
+// failureSummary reports all failures collected with keepGoing
+func (i *Importer) failureSummary() error {
+	if len(i.failures) == 0 {
+		return nil
+	}
+
+	for _, f := range i.failures {
+		Error("%s", f)
+	}
+	return fmt.Errorf("%d imports failed", len(i.failures))
+}
+
 func (i *Importer) GxPublishGoPackage(imppath string) (*gx.Dependency, error) {
+	if err := cancelled(); err != nil {
+		return nil, err
+	}
+
 	imppath = getBaseDVCS(imppath)
 	if d, ok := i.pkgs[imppath]; ok {
 		return d, nil
 	}
 
+	if err, ok := i.failed[imppath]; ok {
+		return nil, err
+	}
+
+	d, err := i.publishGoPackage(imppath)
+	if err != nil {
+		err = i.withChain(imppath, err)
+		i.failed[imppath] = err
+		if _, ok := err.(*importError); ok && i.keepGoing {
+			i.failures = append(i.failures, err)
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+func (i *Importer) publishGoPackage(imppath string) (*gx.Dependency, error) {
+
+	if other, ok := i.caseCollision(imppath); ok {
+		Warn("import paths %s and %s differ only by case, this breaks checkouts on case insensitive filesystems", other, imppath)
+		q := fmt.Sprintf("use the already imported %s in place of %s?", other, imppath)
+		if i.yesall || yesNoPrompt("import.case-collision", imppath, q, true) {
+			d := i.pkgs[other]
+			i.pkgs[imppath] = d
+			return d, nil
+		}
+	}
+
+	// the root of the import is a direct dependency
+	if v := i.policy.checkImport(imppath); v != "" {
+		return nil, fmt.Errorf("%s (%s)", v, depPolicyFileName)
+	}
+	if v := i.policy.checkDepth(len(i.chain) + 1); v != "" {
+		return nil, fmt.Errorf("dependency tree %s (%s)", v, depPolicyFileName)
+	}
+
 	if hash, ok := i.preMap[imppath]; ok {
-		pkg, err := i.pm.GetPackageTo(hash, filepath.Join(vendorDir, hash))
-		if err != nil {
-			return nil, err
+		if hash == skipHash {
+			return nil, fmt.Errorf("%s is marked skip in the map", imppath)
 		}
+		return i.useExisting(imppath, hash, "map")
+	}
 
-		dep := &gx.Dependency{
-			Hash:    hash,
-			Name:    pkg.Name,
-			Version: pkg.Version,
+	ref, explicit, ok := i.importRef(imppath)
+
+	// the index knows some published version, never the one asked for, and
+	// the package being imported is always published anew
+	if i.useIndex && !ok && len(i.chain) > 0 {
+		if idx, err := loadIndex(); err == nil {
+			if known := idx.lookup(imppath); len(known) > 0 {
+				Log("using %s (%s) from the local index for %s", known[0].Hash, known[0].Version, imppath)
+				return i.useExisting(imppath, known[0].Hash, "index")
+			}
 		}
-		i.pkgs[imppath] = dep
-		return dep, nil
 	}
 
 	// make sure its local
-	err := i.GoGet(imppath)
+	err := i.fetch(imppath)
 	if err != nil {
 		if !strings.Contains(err.Error(), "no buildable Go source files") {
-			Error("go get %s failed: %s", imppath, err)
+			Error("fetching %s failed: %s", imppath, err)
 			return nil, err
 		}
 	}
 
 	pkgpath := path.Join(i.gopath, "src", imppath)
+	if ok {
+		v, err := vcsForDir(pkgpath)
+		switch {
+		case err != nil && !explicit && sourceFor(i.sources, imppath) != defaultSource:
+			// not every backend leaves a checkout behind
+			Warn("not checking out %s of %s: %s", ref, imppath, err)
+		case err != nil:
+			return nil, err
+		default:
+			head, err := v.Head(pkgpath)
+			if err != nil {
+				return nil, err
+			}
+			Log("checking out %s of %s", ref, imppath)
+			if err := v.Checkout(pkgpath, ref); err != nil {
+				return nil, err
+			}
+			// the GOPATH may be the user's own, leave it as it was
+			defer func() {
+				if err := v.Checkout(pkgpath, head); err != nil {
+					Warn("restoring %s of %s: %s", head, imppath, err)
+				}
+			}()
+		}
+	}
+
+	if i.policy != nil {
+		var p Package
+		gx.LoadPackageFile(&p, filepath.Join(pkgpath, gx.PkgFileName))
+		if v := i.policy.checkLicense(packageLicense(&p, pkgpath)); v != "" {
+			return nil, fmt.Errorf("%s (%s)", v, depPolicyFileName)
+		}
+	}
+
+	return i.publishDir(pkgpath, imppath)
+}
+
+// importRef returns the ref imppath is imported at, if not the head of its
+// default branch: the one given for it, the one a go.mod requires or, with
+// --latest-release, its newest release. explicit is whether it was given.
+func (i *Importer) importRef(imppath string) (ref string, explicit, ok bool) {
+	if ref, ok := i.refs[imppath]; ok {
+		return ref, true, true
+	}
+	if !i.ignoreGoMod {
+		if ref, req, ok := i.modRequiredRef(imppath); ok {
+			VLog("  - go.mod of %s requires %s", req.By, req.Version)
+			return ref, false, true
+		}
+	}
+	if i.latestRelease {
+		ref, ok = i.latestReleaseRef(imppath)
+		return ref, false, ok
+	}
+	return "", false, false
+}
+
+// useExisting uses the already published package with the given hash for
+// imppath, source says where the hash came from
+func (i *Importer) useExisting(imppath, hash, source string) (*gx.Dependency, error) {
+	var pkg *gx.Package
+	err := ipfsCall(func() (err error) {
+		pkg, err = i.pm.GetPackageTo(hash, filepath.Join(vendorDir, hash))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dep := &gx.Dependency{
+		Hash:    hash,
+		Name:    pkg.Name,
+		Version: pkg.Version,
+	}
+	i.pkgs[imppath] = dep
+
+	if i.report != nil {
+		i.report.Reused = append(i.report.Reused, reusedReport{Import: imppath, Hash: hash, Source: source})
+	}
+	return dep, nil
+}
+
+// GxPublishLocalPackage publishes the go package in the given local directory
+// under the import path it will eventually be available at. Its dependencies
+// are imported from the GOPATH as usual.
+func (i *Importer) GxPublishLocalPackage(dir, imppath string) (*gx.Dependency, error) {
+	if d, ok := i.pkgs[imppath]; ok {
+		return d, nil
+	}
+
+	return i.publishDir(dir, imppath)
+}
+
+func (i *Importer) publishDir(pkgpath, imppath string) (*gx.Dependency, error) {
+	i.chain = append(i.chain, imppath)
+	defer func() {
+		i.chain = i.chain[:len(i.chain)-1]
+	}()
+
 	pkgFilePath := path.Join(pkgpath, gx.PkgFileName)
 	pkg, err := LoadPackageFile(pkgFilePath)
 	if err != nil {
@@ -144,7 +436,7 @@ func (i *Importer) GxPublishGoPackage(imppath string) (*gx.Dependency, error) {
 		pkgname := parts[len(parts)-1]
 		if !i.yesall {
 			p := fmt.Sprintf("enter name for import '%s'", imppath)
-			nname, err := prompt(p, pkgname)
+			nname, err := prompt("import.name", imppath, p, pkgname)
 			if err != nil {
 				return nil, err
 			}
@@ -161,31 +453,86 @@ func (i *Importer) GxPublishGoPackage(imppath string) (*gx.Dependency, error) {
 		if err != nil {
 			return nil, err
 		}
+
+		i.fillRepoMetadata(pkg, pkgpath, imppath)
+	}
+
+	if pkg.Gx.DvcsImport == "" {
+		pkg.Gx.DvcsImport = imppath
+	}
+
+	// record where this package came from
+	if v, err := vcsForDir(pkgpath); err == nil {
+		rev, err := v.Revision(pkgpath)
+		if err != nil {
+			Warn("failed to get revision of %s: %s", imppath, err)
+		} else {
+			pkg.Gx.DvcsType = v.Name
+			pkg.Gx.DvcsRevision = rev
+		}
+
+		if v.Name == "git" {
+			err := initGitSubmodules(pkgpath)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		VLog("  - could not determine vcs of %s: %s", imppath, err)
 	}
 
 	// wipe out existing dependencies
 	pkg.Dependencies = nil
 
+	if !i.ignoreGoMod {
+		i.recordModRequires(pkgpath, imppath)
+	}
+
 	// recurse!
-	depsToVendor, err := i.DepsToVendorForPackage(imppath)
+	depsToVendor, err := i.depsToVendorForDir(pkgpath, imppath)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching deps for %s: %s", imppath, err)
 	}
 
+	var failed int
+	pkg.Gx.Skip = nil
 	for n, child := range depsToVendor {
 		Log("- processing dep %s for %s [%d / %d]", child, imppath, n+1, len(depsToVendor))
 		if strings.HasPrefix(child, imppath) {
 			continue
 		}
+		if i.editMap {
+			if err := i.editMapEntry(child); err != nil {
+				return nil, err
+			}
+		}
+		if i.skippedInMap(child) {
+			VLog("  - %s is marked skip in the map, not vendoring it", child)
+			pkg.Gx.Skip = append(pkg.Gx.Skip, child)
+			if i.report != nil {
+				i.report.Skipped = append(i.report.Skipped, child)
+			}
+			continue
+		}
 		childdep, err := i.GxPublishGoPackage(child)
 		if err != nil {
-			return nil, err
+			if !i.keepGoing || cancelled() != nil {
+				return nil, err
+			}
+			failed++
+			continue
 		}
 
 		pkg.Dependencies = append(pkg.Dependencies, childdep)
 	}
 
-	err = gx.SavePackageFile(pkg, pkgFilePath)
+	if failed > 0 {
+		return nil, &depsFailedError{Import: imppath, N: failed}
+	}
+
+	pkg.Gx.LastTool = i.stamp
+
+	err = savePackageFile(pkg, pkgFilePath)
 	if err != nil {
 		return nil, err
 	}
@@ -195,22 +542,54 @@ func (i *Importer) GxPublishGoPackage(imppath string) (*gx.Dependency, error) {
 		return nil, err
 	}
 
-	err = i.rewriteImports(fullpkgpath)
+	err = i.rewriteImports(fullpkgpath, imppath)
 	if err != nil {
 		return nil, fmt.Errorf("rewriting imports failed: %s", err)
 	}
 
-	err = writeGxIgnore(pkgpath, []string{"Godeps/*"})
+	ignore := append([]string{"Godeps/*"}, vcsIgnores()...)
+	if vendored := nestedVendorDirs(pkgpath); len(vendored) > 0 {
+		if i.stripVendor {
+			VLog("  - not publishing the vendor directories of %s: %s", imppath, strings.Join(vendored, ", "))
+			ignore = append(ignore, vendorIgnores(vendored)...)
+		} else {
+			warnNestedVendor(imppath, pkgpath)
+		}
+	}
+
+	err = writeGxIgnore(pkgpath, ignore)
 	if err != nil {
 		return nil, err
 	}
 
-	hash, err := i.pm.PublishPackage(pkgpath, &pkg.PackageBase)
+	var hash string
+	err = ipfsCall(func() (err error) {
+		hash, err = i.pm.PublishPackage(pkgpath, &pkg.PackageBase)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	Log("published %s as %s", imppath, hash)
+	recordInIndex(indexEntry{Import: imppath, Name: pkg.Name, Hash: hash, Version: pkg.Version})
+
+	pub := publishedReport{
+		Import:  imppath,
+		Name:    pkg.Name,
+		Version: pkg.Version,
+		Hash:    hash,
+		VcsType: pkg.Gx.DvcsType,
+		Commit:  pkg.Gx.DvcsRevision,
+	}
+	if i.report != nil {
+		i.report.Published = append(i.report.Published, pub)
+	}
+	if i.registryPublish != "" {
+		if err := postToRegistry(i.registryPublish, pub); err != nil {
+			Warn("publishing %s to the registry: %s", imppath, err)
+		}
+	}
 
 	dep := &gx.Dependency{
 		Hash:    hash,
@@ -221,27 +600,90 @@ func (i *Importer) GxPublishGoPackage(imppath string) (*gx.Dependency, error) {
 	return dep, nil
 }
 
-func (i *Importer) DepsToVendorForPackage(path string) ([]string, error) {
-	rdeps := make(map[string]struct{})
+// latestReleaseRef returns the ref of the newest release of imppath known to
+// the module proxies, if there is one
+func (i *Importer) latestReleaseRef(imppath string) (string, bool) {
+	v, err := proxyLatest(imppath)
+	if err != nil {
+		Warn("could not resolve the latest release of %s: %s, using what 'go get' fetched", imppath, err)
+		return "", false
+	}
 
-	gopkg, err := i.bctx.Import(path, "", 0)
+	ref := versionRef(v)
+	if isReleaseVersion(strings.TrimPrefix(ref, "v")) {
+		i.released[imppath] = strings.TrimPrefix(ref, "v")
+	}
+	VLog("  - latest release of %s is %s", imppath, v)
+	return ref, true
+}
+
+// fillRepoMetadata fills in the description and version of a newly
+// initialized package, checked out at pkgpath, from its repository. The
+// version is only taken from a release tag if that tag is checked out.
+func (i *Importer) fillRepoMetadata(pkg *Package, pkgpath, imppath string) {
+	if v, ok := i.released[imppath]; ok {
+		pkg.Version = v
+	} else if v := latestReleaseTag(gitTagsAt(pkgpath)); v != "" {
+		VLog("  - using version %s of the release tag checked out of %s", v, imppath)
+		pkg.Version = v
+	}
+
+	if _, ok := githubRepoPath(imppath); !ok || !i.githubMetadata || pkg.Description != "" {
+		return
+	}
+
+	repo, err := githubMetadata(imppath)
 	if err != nil {
-		switch err := err.(type) {
-		case *build.NoGoError:
-			// if theres no go code here, there still might be some in lower directories
-		case scanner.ErrorList:
-			Error("failed to scan file: %s", err)
-			// continue anyway
-		case *build.MultiplePackageError:
-			Error("multiple package error: %s", err)
-		default:
-			Error("ERROR OF TYPE: %#v", err)
-			return nil, err
+		Warn("failed to fetch metadata for %s: %s", imppath, err)
+		return
+	}
+	pkg.Description = repo.Description
+}
+
+func (i *Importer) DepsToVendorForPackage(path string) ([]string, error) {
+	return i.depsToVendorForDir(filepath.Join(i.gopath, "src", path), path)
+}
+
+// depsToVendorForDir returns the dvcs dependencies of the package in dir
+// (and all of its subpackages), which is imported as path. The directories
+// are scanned in parallel.
+func (i *Importer) depsToVendorForDir(dir, path string) ([]string, error) {
+	type pkgDir struct {
+		dir, path string
+	}
+
+	var dirs []pkgDir
+	var walk func(dir, path string) error
+	walk = func(dir, path string) error {
+		dirs = append(dirs, pkgDir{dir, path})
+
+		dirents, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range dirents {
+			if !e.IsDir() || skipDir(e.Name()) {
+				continue
+			}
+			if err := walk(filepath.Join(dir, e.Name()), path+"/"+e.Name()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(dir, path); err != nil {
+		return nil, err
+	}
+
+	deps := make([][]string, len(dirs))
+	err := parallel(len(dirs), func(n int) error {
+		imps, err := i.importsForDir(dirs[n].dir)
+		if err != nil {
+			return err
 		}
 
-	} else {
-		imps := append(gopkg.Imports, gopkg.TestImports...)
 		// if the package existed and has go code in it
+		path := dirs[n].path
 		gdeps := getBaseDVCS(path) + "/Godeps/_workspace/src/"
 		for _, child := range imps {
 			if strings.HasPrefix(child, gdeps) {
@@ -250,58 +692,85 @@ func (i *Importer) DepsToVendorForPackage(path string) ([]string, error) {
 
 			child = getBaseDVCS(child)
 			if pathIsNotStdlib(child) && !strings.HasPrefix(child, path) {
-				rdeps[child] = struct{}{}
+				deps[n] = append(deps[n], child)
 			}
 		}
-	}
-
-	dirents, err := ioutil.ReadDir(filepath.Join(i.gopath, "src", path))
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, e := range dirents {
-		if !e.IsDir() || skipDir(e.Name()) {
-			continue
+	rdeps := make(map[string]struct{})
+	var depsToVendor []string
+	for _, ds := range deps {
+		for _, d := range ds {
+			if _, ok := rdeps[d]; !ok {
+				rdeps[d] = struct{}{}
+				depsToVendor = append(depsToVendor, d)
+			}
 		}
+	}
 
-		out, err := i.DepsToVendorForPackage(filepath.Join(path, e.Name()))
+	return depsToVendor, nil
+}
+
+// importsForDir returns the union of the imports of the go package in dir
+// across all of the importers platforms
+func (i *Importer) importsForDir(dir string) ([]string, error) {
+	seen := make(map[string]struct{})
+	var imps []string
+	for _, p := range i.platforms {
+		bctx := i.bctx
+		bctx.GOOS = p.GOOS
+		bctx.GOARCH = p.GOARCH
+
+		gopkg, err := bctx.ImportDir(dir, 0)
 		if err != nil {
-			return nil, err
+			switch err := err.(type) {
+			case *build.NoGoError:
+				// if theres no go code here, there still might be some in lower directories
+			case scanner.ErrorList:
+				Error("failed to scan file: %s", err)
+				// continue anyway
+			case *build.MultiplePackageError:
+				Error("multiple package error: %s", err)
+			default:
+				Error("ERROR OF TYPE: %#v", err)
+				return nil, err
+			}
+			continue
 		}
 
-		for _, o := range out {
-			rdeps[o] = struct{}{}
+		for _, imp := range append(gopkg.Imports, gopkg.TestImports...) {
+			if _, ok := seen[imp]; !ok {
+				seen[imp] = struct{}{}
+				imps = append(imps, imp)
+			}
 		}
 	}
 
-	var depsToVendor []string
-	for d, _ := range rdeps {
-		depsToVendor = append(depsToVendor, d)
-	}
-
-	return depsToVendor, nil
+	return imps, nil
 }
 
 func skipDir(name string) bool {
 	switch name {
-	case "Godeps", "vendor", ".git":
+	case "Godeps", "vendor":
 		return true
 	default:
-		return false
+		return isVcsMetaDir(name)
 	}
 }
 
-func (i *Importer) rewriteImports(pkgpath string) error {
+func (i *Importer) rewriteImports(pkgpath, base string) error {
 
 	filter := func(p string) bool {
-		return !strings.HasPrefix(p, "vendor") &&
-			!strings.HasPrefix(p, ".git") &&
+		return !strings.HasPrefix(p, "vendor/") &&
+			!isVcsMetaDir(strings.Split(p, "/")[0]) &&
 			strings.HasSuffix(p, ".go") &&
-			!strings.HasPrefix(p, "Godeps")
+			!strings.HasPrefix(p, "Godeps/")
 	}
 
-	base := pkgpath[len(i.gopath)+5:]
 	gdepath := base + "/Godeps/_workspace/src/"
 	rwf := func(in string) string {
 		if strings.HasPrefix(in, gdepath) {
@@ -332,26 +801,39 @@ func (i *Importer) rewriteImports(pkgpath string) error {
 		return in
 	}
 
-	return rw.RewriteImports(pkgpath, rwf, filter)
+	return rw.RewriteImportsContext(cancelCtx, pkgpath, rwf, filter)
 }
 
-// TODO: take an option to grab packages from local GOPATH
-func (imp *Importer) GoGet(path string) error {
-	cmd := exec.Command("go", "get", path)
-	env := os.Environ()
-	for i, e := range env {
-		if strings.HasPrefix(e, "GOPATH=") {
-			env[i] = "GOPATH=" + imp.gopath
-		}
+func writeGxIgnore(dir string, ignore []string) error {
+	return ioutil.WriteFile(filepath.Join(dir, ".gxignore"), []byte(strings.Join(ignore, "\n")), 0644)
+}
+
+// caseCollisions returns groups of import paths that differ only by case,
+// which cannot be checked out side by side on case insensitive filesystems
+func caseCollisions(imps []string) [][]string {
+	groups := make(map[string][]string)
+	for _, imp := range imps {
+		k := strings.ToLower(imp)
+		groups[k] = append(groups[k], imp)
 	}
-	cmd.Env = env
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("go get failed: %s - %s", string(out), err)
+
+	var out [][]string
+	for _, g := range groups {
+		if len(g) > 1 {
+			sort.Strings(g)
+			out = append(out, g)
+		}
 	}
-	return nil
+	return out
 }
 
-func writeGxIgnore(dir string, ignore []string) error {
-	return ioutil.WriteFile(filepath.Join(dir, ".gxignore"), []byte(strings.Join(ignore, "\n")), 0644)
+// caseCollision returns an already imported path that differs from imppath
+// only by case
+func (i *Importer) caseCollision(imppath string) (string, bool) {
+	for p := range i.pkgs {
+		if p != imppath && strings.EqualFold(p, imppath) {
+			return p, true
+		}
+	}
+	return "", false
 }
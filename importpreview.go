@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// importConflict is an import path that an import would publish anew while
+// the project already depends on it, ending up at two different hashes
+type importConflict struct {
+	Import string
+
+	// Have are the packages of the project with the import path
+	Have []*depNode
+
+	// Revision is the revision the import would publish, if known
+	Revision string
+
+	// Reuse is the package of the project that can stand in for the
+	// import, nil if none is known to be compatible
+	Reuse *depNode
+
+	// Target is the package of the project the tree would be unified on
+	Target *depNode
+}
+
+// previewTree returns the dvcs import paths in the tree of the packages
+// imported as paths, fetching them into the GOPATH as the import would.
+// Paths already in the map are not descended into.
+func (i *Importer) previewTree(roots []string, dirs map[string]string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	queue := append([]string{}, roots...)
+	for len(queue) > 0 {
+		if err := cancelled(); err != nil {
+			return nil, err
+		}
+
+		imp := queue[0]
+		queue = queue[1:]
+		if seen[imp] {
+			continue
+		}
+		seen[imp] = true
+		out = append(out, imp)
+		if _, ok := i.preMap[imp]; ok {
+			continue
+		}
+
+		var deps []string
+		var err error
+		if dir, ok := dirs[imp]; ok {
+			deps, err = i.depsToVendorForDir(dir, imp)
+		} else {
+			if gerr := i.fetch(imp); gerr != nil && !strings.Contains(gerr.Error(), "no buildable Go source files") {
+				return nil, gerr
+			}
+			if !i.ignoreGoMod {
+				i.recordModRequires(filepath.Join(i.gopath, "src", imp), imp)
+			}
+			deps, err = i.DepsToVendorForPackage(imp)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error fetching deps for %s: %s", imp, err)
+		}
+		queue = append(queue, deps...)
+	}
+	return out, nil
+}
+
+// importConflicts returns the import paths in the tree of an import that g,
+// the dependency graph of the project, has at other hashes
+func (i *Importer) importConflicts(tree []string, g *depGraph) []*importConflict {
+	byImport := make(map[string][]*depNode)
+	for _, n := range g.Nodes {
+		if imp := n.Pkg.Gx.DvcsImport; imp != "" {
+			byImport[imp] = append(byImport[imp], n)
+		}
+	}
+
+	var out []*importConflict
+	for _, imp := range tree {
+		have := byImport[imp]
+		if len(have) == 0 {
+			continue
+		}
+		if _, ok := i.preMap[imp]; ok {
+			continue
+		}
+		sort.Slice(have, func(a, b int) bool { return have[a].Dep.Hash < have[b].Dep.Hash })
+
+		var hashes []string
+		for _, n := range have {
+			hashes = append(hashes, n.Dep.Hash)
+		}
+		ic := &importConflict{Import: imp, Have: have, Target: g.Nodes[g.unifyTarget(hashes)]}
+		// the revision the import would check out, not the fetched one
+		pkgpath := filepath.Join(i.gopath, "src", imp)
+		if v, err := vcsForDir(pkgpath); err == nil {
+			if ref, _, ok := i.importRef(imp); ok {
+				ic.Revision, _ = v.ResolveRef(pkgpath, ref)
+			} else {
+				ic.Revision, _ = v.Revision(pkgpath)
+			}
+		}
+		_, req, hasReq := i.modRequiredRef(imp)
+		for _, n := range have {
+			switch {
+			case ic.Revision != "" && n.Pkg.Gx.DvcsRevision == ic.Revision:
+				// the very same code
+				ic.Reuse = n
+			case hasReq && ic.Reuse == nil && versionCompatible(n.Pkg.Version, strings.TrimPrefix(req.Version, "v")):
+				ic.Reuse = n
+			}
+		}
+		out = append(out, ic)
+	}
+	return out
+}
+
+// versionCompatible returns whether a package at version have can stand in
+// for one at version want: it is not older and has the same major version,
+// or the same minor version before 1.0
+func versionCompatible(have, want string) bool {
+	hp := strings.Split(strings.SplitN(have, "-", 2)[0], ".")
+	wp := strings.Split(strings.SplitN(want, "-", 2)[0], ".")
+	if len(hp) < 2 || len(wp) < 2 || hp[0] != wp[0] {
+		return false
+	}
+	if hp[0] == "0" && hp[1] != wp[1] {
+		return false
+	}
+	older, err := versionComp(have, want)
+	return err == nil && !older
+}
+
+// printConflicts lists the conflicts an import would create
+func printConflicts(conflicts []*importConflict) {
+	Log("%d packages of the import are already dependencies of the project:", len(conflicts))
+	var rows [][]string
+	for _, ic := range conflicts {
+		var have []string
+		for _, n := range ic.Have {
+			have = append(have, n.Pkg.Version+" "+n.Dep.Hash)
+		}
+		reuse := "-"
+		if ic.Reuse != nil {
+			reuse = ic.Reuse.Dep.Hash
+		}
+		rev := ic.Revision
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		rows = append(rows, []string{ic.Import, strings.Join(have, ", "), rev, reuse})
+	}
+	writeTable(os.Stdout, "", []string{"IMPORT", "PROJECT", "IMPORTING", "REUSE"}, rows)
+}
+
+// offerReuse offers to use the packages of the project for the conflicts of
+// an import, those accepted go into the map. Only packages known to be
+// compatible are reused by default.
+func (i *Importer) offerReuse(conflicts []*importConflict) {
+	for _, ic := range conflicts {
+		n := ic.Reuse
+		if n == nil {
+			n = ic.Target
+		}
+		q := fmt.Sprintf("use %s %s (%s) of the project for %s instead of publishing it again?", n.Pkg.Name, n.Pkg.Version, n.Dep.Hash, ic.Import)
+		def := ic.Reuse != nil
+		if i.yesall {
+			if def {
+				Log("using %s %s (%s) of the project for %s", n.Pkg.Name, n.Pkg.Version, n.Dep.Hash, ic.Import)
+				i.preMap[ic.Import] = n.Dep.Hash
+			}
+			continue
+		}
+		if yesNoPrompt("import.reuse", ic.Import, q, def) {
+			i.preMap[ic.Import] = n.Dep.Hash
+		}
+	}
+}
+
+// previewImport walks the tree of the import of roots, local packages being
+// given in dirs by import path, and compares it with the dependencies of
+// the project in the current directory. It returns the conflicts found.
+func (i *Importer) previewImport(roots []string, dirs map[string]string) ([]*importConflict, error) {
+	pkg, err := LoadPackageFile(filepath.Join(cwd, gx.PkgFileName))
+	if err != nil {
+		// not importing into a project
+		return nil, nil
+	}
+
+	g, err := loadDepGraph(pkg, filepath.Join(cwd, vendorDir))
+	if err != nil {
+		return nil, fmt.Errorf("loading the dependencies of %s: %s", pkg.Name, err)
+	}
+
+	// what the go.mod files of the tree require as fetched is only good for
+	// the preview, the import records them as it checks packages out
+	reqs := make(map[string]modRequirement)
+	for k, v := range i.modRequires {
+		reqs[k] = v
+	}
+	defer func() { i.modRequires = reqs }()
+
+	Log("previewing the import against the dependencies of %s", pkg.Name)
+	tree, err := i.previewTree(roots, dirs)
+	if err != nil {
+		return nil, err
+	}
+	conflicts := i.importConflicts(tree, g)
+	VLog("  - %d packages in the import, %d already in the project at other hashes", len(tree), len(conflicts))
+	return conflicts, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// importSource selects where the imports below Prefix are fetched from,
+// instead of 'go get'
+type importSource struct {
+	Prefix string `json:"prefix"`
+
+	// Backend is the name of one of the fetchBackends
+	Backend string `json:"backend"`
+
+	// URL is what the backend fetches from: a repository for git and hg,
+	// https://<prefix> by default, a directory for local, an archive for
+	// tarball and a module proxy for goproxy, the ones in GOPROXY by default
+	URL string `json:"url,omitempty"`
+
+	// Version is the module version goproxy fetches, the latest by default
+	Version string `json:"version,omitempty"`
+}
+
+// fetchBackend fetches the sources of imports into a GOPATH
+type fetchBackend interface {
+	// Fetch makes the package imppath, which src is selected for, available
+	// in the src directory of gopath
+	Fetch(gopath, imppath string, src *importSource) error
+}
+
+// fetchBackends are the backends sources can select, by name
+var fetchBackends = map[string]fetchBackend{
+	"go":      goGetBackend{},
+	"git":     vcsCloneBackend{Cmd: []string{"git", "clone", "-q"}},
+	"hg":      vcsCloneBackend{Cmd: []string{"hg", "clone", "-q"}},
+	"local":   rootBackend(fetchLocal),
+	"tarball": rootBackend(fetchTarball),
+	"goproxy": rootBackend(fetchProxyZip),
+}
+
+// defaultSource is used for imports no configured source is selected for
+var defaultSource = &importSource{Backend: "go"}
+
+// loadImportSources returns the import sources of the user config, checking
+// that their backends exist
+func loadImportSources() ([]importSource, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range cfg.Sources {
+		if s.Prefix == "" {
+			return nil, fmt.Errorf("import source for backend %q has no prefix", s.Backend)
+		}
+		if fetchBackends[s.Backend] == nil {
+			return nil, fmt.Errorf("import source %s: unknown backend %q", s.Prefix, s.Backend)
+		}
+		if s.URL == "" && (s.Backend == "local" || s.Backend == "tarball") {
+			return nil, fmt.Errorf("import source %s: the %s backend needs a url", s.Prefix, s.Backend)
+		}
+	}
+	return cfg.Sources, nil
+}
+
+// sourceFor returns the source with the longest prefix matching imppath
+func sourceFor(sources []importSource, imppath string) *importSource {
+	best := defaultSource
+	for i, s := range sources {
+		if (imppath == s.Prefix || strings.HasPrefix(imppath, s.Prefix+"/")) && len(s.Prefix) > len(best.Prefix) {
+			best = &sources[i]
+		}
+	}
+	return best
+}
+
+// fetch makes imppath available in the GOPATH of the importer, from the
+// source selected for it
+func (i *Importer) fetch(imppath string) error {
+	src := sourceFor(i.sources, imppath)
+	if src != defaultSource {
+		VLog("  - fetching %s with the %s backend", imppath, src.Backend)
+	}
+	stop := startTimer("fetch", "source", src.Backend)
+	err := fetchBackends[src.Backend].Fetch(i.gopath, imppath, src)
+	stop(err)
+	if err != nil {
+		if cerr := cancelled(); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	return nil
+}
+
+// goGetBackend fetches with 'go get', which also fetches the dependencies
+type goGetBackend struct{}
+
+func (goGetBackend) Fetch(gopath, imppath string, _ *importSource) error {
+	cmd := exec.CommandContext(cancelCtx, "go", "get", imppath)
+	cmd.Env = goGetEnv(gopath)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if cerr := cancelled(); cerr != nil {
+			return cerr
+		}
+		if hint := missingVcsHint(string(out)); hint != "" {
+			return fmt.Errorf("go get failed: %s", hint)
+		}
+		if hint := fetchFailureHint(imppath, string(out)); hint != "" {
+			return fmt.Errorf("go get failed: %s - %s", string(out), hint)
+		}
+		return fmt.Errorf("go get failed: %s - %s", string(out), err)
+	}
+	return nil
+}
+
+// rootBackend fetches the whole tree below the prefix of a source into the
+// directory given, once
+type rootBackend func(src *importSource, dir string) error
+
+func (f rootBackend) Fetch(gopath, imppath string, src *importSource) error {
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(src.Prefix))
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+
+	tmp := dir + ".fetch"
+	os.RemoveAll(tmp)
+	if err := f(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("fetching %s with the %s backend: %s", src.Prefix, src.Backend, err)
+	}
+	return os.Rename(tmp, dir)
+}
+
+// vcsCloneBackend clones the repository of a source, leaving a checkout
+// revisions can be selected in
+type vcsCloneBackend struct {
+	Cmd []string
+}
+
+func (b vcsCloneBackend) Fetch(gopath, imppath string, src *importSource) error {
+	return rootBackend(func(src *importSource, dir string) error {
+		url := src.URL
+		if url == "" {
+			url = "https://" + src.Prefix
+		}
+
+		args := append(append([]string{}, b.Cmd[1:]...), url, dir)
+		cmd := exec.CommandContext(cancelCtx, b.Cmd[0], args...)
+		cmd.Env = goGetEnv(gopath)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			if hint := fetchFailureHint(imppath, string(out)); hint != "" {
+				return fmt.Errorf("%s: %s - %s", b.Cmd[0], strings.TrimSpace(string(out)), hint)
+			}
+			return fmt.Errorf("%s: %s - %s", b.Cmd[0], strings.TrimSpace(string(out)), err)
+		}
+		return nil
+	}).Fetch(gopath, imppath, src)
+}
+
+// fetchLocal copies the directory of a source
+func fetchLocal(src *importSource, dir string) error {
+	fi, err := os.Stat(src.URL)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", src.URL)
+	}
+	return copyDir(src.URL, dir, nil)
+}
+
+// fetchTarball extracts the tar archive of a source, gzipped or not, whose
+// single top level directory is the root of the source
+func fetchTarball(src *importSource, dir string) error {
+	req, err := http.NewRequest("GET", src.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := downloadClient.Do(req.WithContext(cancelCtx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", src.URL, resp.Status)
+	}
+
+	var r io.Reader = bufio.NewReader(resp.Body)
+	if magic, err := r.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return untar(r, dir)
+}
+
+// fetchProxyZip extracts the module zip of a source from a module proxy,
+// the prefix of the source being the module path
+func fetchProxyZip(src *importSource, dir string) error {
+	proxies := goProxies()
+	if src.URL != "" {
+		proxies = []string{strings.TrimSuffix(src.URL, "/")}
+	}
+	if len(proxies) == 0 {
+		return fmt.Errorf("GOPROXY lists no module proxy")
+	}
+
+	version := src.Version
+	switch {
+	case version == "" && src.URL != "":
+		var info struct {
+			Version string
+		}
+		if err := proxyGetJSON(proxies[0]+"/"+escapeModulePath(src.Prefix)+"/@latest", &info); err != nil {
+			return err
+		}
+		version = info.Version
+	case version == "":
+		v, err := proxyLatest(src.Prefix)
+		if err != nil {
+			return err
+		}
+		version = v
+	}
+
+	var lastErr error
+	for _, p := range proxies {
+		u := p + "/" + escapeModulePath(src.Prefix) + "/@v/" + escapeModulePath(version) + ".zip"
+		lastErr = fetchModuleZip(u, src.Prefix+"@"+version, dir)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// fetchModuleZip downloads the module zip at u and extracts the files below
+// its root directory to dir
+func fetchModuleZip(u, root, dir string) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := proxyClient.Do(req.WithContext(cancelCtx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile("", "gx-go-module")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, resp.Body)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return fmt.Errorf("%s: %s", u, err)
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rel := strings.TrimPrefix(f.Name, root+"/")
+		if rel == f.Name || strings.Contains("/"+rel+"/", "/../") {
+			return fmt.Errorf("invalid path in module zip: %s", f.Name)
+		}
+
+		p := filepath.Join(dir, filepath.FromSlash(path.Clean(rel)))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := extractZipFile(f, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(f *zip.File, p string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	fi, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fi, r); err != nil {
+		fi.Close()
+		return err
+	}
+	return fi.Close()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	cli "github.com/codegangsta/cli"
+)
+
+// indexEntry records a package hash gx-go has seen, along with the import
+// path and version it was published as
+type indexEntry struct {
+	Import  string    `json:"import"`
+	Name    string    `json:"name,omitempty"`
+	Hash    string    `json:"hash"`
+	Version string    `json:"version,omitempty"`
+	Seen    time.Time `json:"seen"`
+}
+
+// index is the local database of every import/hash pair gx-go has seen,
+// kept in ~/.gx-go/index.json
+type index struct {
+	Entries map[string]*indexEntry `json:"entries"`
+}
+
+func indexPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "index.json"), nil
+}
+
+func loadIndex() (*index, error) {
+	idx := &index{Entries: make(map[string]*indexEntry)}
+	p, err := indexPath()
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadMap(idx, p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading index: %s", err)
+	}
+
+	if idx.Entries == nil {
+		idx.Entries = make(map[string]*indexEntry)
+	}
+	return idx, nil
+}
+
+func (idx *index) save() error {
+	p, err := indexPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := p + ".temp"
+	if err := ioutil.WriteFile(tmp, out, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (idx *index) add(e indexEntry) {
+	if e.Import == "" || e.Hash == "" {
+		return
+	}
+
+	// keep what we already know about the package
+	if old, ok := idx.Entries[e.Hash]; ok {
+		if e.Name == "" {
+			e.Name = old.Name
+		}
+		if e.Version == "" {
+			e.Version = old.Version
+		}
+	}
+
+	e.Seen = time.Now()
+	idx.Entries[e.Hash] = &e
+}
+
+// lookup returns all entries for the given import path, newest first
+func (idx *index) lookup(imp string) []*indexEntry {
+	var out []*indexEntry
+	for _, e := range idx.Entries {
+		if e.Import == imp {
+			out = append(out, e)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Seen.After(out[j].Seen)
+	})
+	return out
+}
+
+// recordInIndex adds the given entries to the local index. Failures are only
+// logged, the index is a convenience.
+func recordInIndex(entries ...indexEntry) {
+	idx, err := loadIndex()
+	if err != nil {
+		Error("%s", err)
+		return
+	}
+
+	for _, e := range entries {
+		idx.add(e)
+	}
+
+	if err := idx.save(); err != nil {
+		Error("saving index: %s", err)
+	}
+}
+
+var SearchCommand = cli.Command{
+	Name:      "search",
+	Usage:     "look up packages by import path, name or keyword",
+	ArgsUsage: "<query>",
+	Description: `searches the local index of every package gx-go has seen being
+imported, installed or mapped, and the configured registry, for packages
+whose import path or name contains the query, or that have it as a
+keyword. The registry is set with 'registry' in ~/.gx-go/config.json
+or the --registry flag.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "registry",
+			Usage: "registry to query instead of the configured one",
+		},
+		cli.BoolFlag{
+			Name:  "local",
+			Usage: "only search the local index",
+		},
+		formatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify a search query")
+		}
+		q := c.Args().First()
+
+		idx, err := loadIndex()
+		if err != nil {
+			return err
+		}
+
+		var found []*indexEntry
+		for _, e := range idx.Entries {
+			if strings.Contains(e.Import, q) || strings.Contains(e.Name, q) {
+				found = append(found, e)
+			}
+		}
+
+		sort.Slice(found, func(i, j int) bool {
+			if found[i].Import != found[j].Import {
+				return found[i].Import < found[j].Import
+			}
+			return found[i].Seen.After(found[j].Seen)
+		})
+
+		var rows [][]string
+		for _, e := range found {
+			rows = append(rows, []string{e.Import, e.Version, e.Hash, "local"})
+		}
+
+		if !c.Bool("local") {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			reg := c.String("registry")
+			if reg == "" {
+				reg = cfg.Registry
+			}
+
+			if reg != "" {
+				r, err := fetchRegistry(reg, cfg.Gateway)
+				if err != nil {
+					return err
+				}
+
+				for _, p := range r.Packages {
+					if !p.matches(q) {
+						continue
+					}
+					for _, v := range p.Versions {
+						rows = append(rows, []string{p.Import, v.Version, v.Hash, "registry"})
+					}
+				}
+			}
+		}
+
+		return writeTable(os.Stdout, c.String("format"), []string{"IMPORT", "VERSION", "HASH", "SOURCE"}, rows)
+	},
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var InstallCommand = cli.Command{
+	Name:  "install",
+	Usage: "install the dependency tree of a past revision of the package",
+	Description: `reads package.json as of the given git revision of the current repository
+and installs exactly the dependency tree it describes into the vendor
+directory, next to the current one, without checking out anything else.
+This makes it possible to bisect or reproduce historical builds.
+
+Packages already vendored are kept, packages in the gx-go cache are copied
+from it, and everything else is fetched. Installed packages are rewritten
+and placed as the post-install hook would. The installed tree is recorded,
+so 'revendor' does not remove it as not being in the dependency tree.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "at",
+			Usage: "git revision to read package.json from",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		ref := c.String("at")
+		if ref == "" {
+			return fmt.Errorf("must specify a revision with --at, use 'gx install' for the current tree")
+		}
+
+		pkg, err := packageFileAt(cwd, ref)
+		if err != nil {
+			return err
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		installed, present, err := installTree(pkg, pkgdir)
+		if err != nil {
+			return err
+		}
+
+		if err := recordInstalledAt(ref, pkg.Dependencies); err != nil {
+			return err
+		}
+
+		Log("installed %d packages of %s as of %s, %d were already present", len(installed), pkg.Name, ref, present)
+		for _, dep := range pkg.Dependencies {
+			fmt.Printf("%s\t%s\t%s\n", dep.Name, dep.Version, dep.Hash)
+		}
+		return nil
+	},
+}
+
+func installedAtPath() string {
+	return filepath.Join(cwd, localStateDir, "installed-at.json")
+}
+
+// loadInstalledAt returns the dependencies of the trees 'install --at'
+// installed, by the revision they were installed as of
+func loadInstalledAt() (map[string][]*gx.Dependency, error) {
+	out := make(map[string][]*gx.Dependency)
+	err := loadMap(&out, installedAtPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading installed revisions: %s", err)
+	}
+	return out, nil
+}
+
+// recordInstalledAt records deps as the dependencies of the tree installed
+// as of ref
+func recordInstalledAt(ref string, deps []*gx.Dependency) error {
+	at, err := loadInstalledAt()
+	if err != nil {
+		return err
+	}
+	at[ref] = deps
+
+	p := installedAtPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(at, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, append(out, '\n'), 0644)
+}
+
+// packageFileAt reads the package.json in dir as of the given git revision
+func packageFileAt(dir, ref string) (*Package, error) {
+	out, err := fileAt(dir, ref, gx.PkgFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg Package
+	if err := json.Unmarshal(out, &pkg); err != nil {
+		return nil, fmt.Errorf("parsing %s as of %s: %s", gx.PkgFileName, ref, err)
+	}
+	return &pkg, nil
+}
+
+// fileAt reads the file name in dir as of the given git revision. A file
+// the revision does not have is reported as not existing.
+func fileAt(dir, ref, name string) ([]byte, error) {
+	cmd := exec.CommandContext(cancelCtx, "git", "rev-parse", "--show-prefix")
+	cmd.Dir = dir
+	prefix, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s is not in a git repository", dir)
+	}
+
+	spec := ref + ":" + strings.TrimSpace(string(prefix)) + name
+	cmd = exec.CommandContext(cancelCtx, "git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("unknown revision %s", ref)
+	}
+	cmd = exec.CommandContext(cancelCtx, "git", "cat-file", "-e", spec)
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		return nil, &os.PathError{Op: "git show", Path: spec, Err: os.ErrNotExist}
+	}
+
+	cmd = exec.CommandContext(cancelCtx, "git", "show", spec)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git show %s: %s", spec, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// installTree installs the dependency tree of pkg into pkgdir. It returns
+// the dependencies it installed and how many were already there.
+func installTree(pkg *Package, pkgdir string) ([]*gx.Dependency, int, error) {
+	var pm packageStore
+	var installed []*gx.Dependency
+	var present int
+
+	seen := make(map[string]bool)
+	queue := append([]*gx.Dependency{}, pkg.Dependencies...)
+	for len(queue) > 0 {
+		if err := cancelled(); err != nil {
+			return nil, 0, err
+		}
+
+		dep := queue[0]
+		queue = queue[1:]
+		if seen[dep.Hash] {
+			continue
+		}
+		seen[dep.Hash] = true
+
+		if problem, dpkg := checkVendored(pkgdir, dep); problem == "" {
+			present++
+			queue = append(queue, dpkg.Dependencies...)
+			continue
+		}
+
+		dpkg, err := reinstallDep(&pm, pkgdir, dep)
+		if err != nil {
+			return nil, 0, err
+		}
+		installed = append(installed, dep)
+		queue = append(queue, dpkg.Dependencies...)
+	}
+
+	// dependencies are found after their dependents, rewrite them first
+	for i := len(installed) - 1; i >= 0; i-- {
+		d := installed[i]
+		if err := postInstallHook(filepath.Join(pkgdir, d.Hash), false); err != nil {
+			return nil, 0, fmt.Errorf("rewriting %s: %s", d.Name, err)
+		}
+	}
+	return installed, present, nil
+}
+
+// reinstallDep replaces the vendored copy of dep in pkgdir with the one in
+// the gx-go cache, or fetches it again if it is not cached. The package
+// store in pm is created on first use.
+func reinstallDep(pm *packageStore, pkgdir string, dep *gx.Dependency) (*Package, error) {
+	dpkg, err := installFromCache(pkgdir, dep)
+	if err == nil {
+		countMetric("cache_hits", 1)
+		return dpkg, nil
+	}
+	countMetric("cache_misses", 1)
+
+	if *pm == nil {
+		if *pm, err = newPM(); err != nil {
+			return nil, err
+		}
+	}
+
+	VLog("  - fetching %s (%s)", dep.Name, dep.Hash)
+	if dpkg, err = refetchDep(*pm, pkgdir, dep); err != nil {
+		return nil, fmt.Errorf("fetching %s: %s", dep.Name, err)
+	}
+	return dpkg, nil
+}
+
+// installFromCache copies the package published as dep from the gx-go cache
+// into pkgdir, if it is cached
+func installFromCache(pkgdir string, dep *gx.Dependency) (*Package, error) {
+	cdir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	src := filepath.Join(cdir, "pkgs", dep.Hash)
+	var p Package
+	if err := gx.FindPackageInDir(&p, src); err != nil {
+		return nil, err
+	}
+
+	for _, h := range hashPaths(dep.Hash) {
+		if err := os.RemoveAll(filepath.Join(pkgdir, filepath.FromSlash(h))); err != nil {
+			return nil, err
+		}
+	}
+
+	dst := filepath.Join(pkgdir, dep.Hash)
+	VLog("  - copying %s (%s) from the cache", dep.Name, dep.Hash)
+	if err := copyDir(src, dst, nil); err != nil {
+		os.RemoveAll(dst)
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// modDepsDir is where dependencies are installed, relative to the package
+// root, for packages that set 'installDeps' to "gx", as module mode builds
+// may not use vendor/
+var modDepsDir = filepath.Join(localStateDir, "deps")
+
+// installRoots are the directories a package installed into the local
+// install path can end up in, with vendorDir appended
+var installRoots = []string{
+	filepath.Join("vendor", "gx", "ipfs"),
+	filepath.Join(modDepsDir, "gx", "ipfs"),
+}
+
+// moduleMode returns whether the package in dir is built in module mode,
+// either because GO111MODULE says so, it has a go.mod, or there is no
+// GOPATH to build it in, not even the default one
+func moduleMode(dir string) bool {
+	switch os.Getenv("GO111MODULE") {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+
+	if hasGoMod(dir) {
+		return true
+	}
+	_, err := getGoPath()
+	return err != nil
+}
+
+// localInstallPath returns the directory dependencies of the package in dir
+// are installed to: its vendor directory, unless the package opted in to
+// its .gx/deps directory with 'installDeps'. The go tool only resolves
+// vendor/, so that is only for projects that point it at .gx/deps
+// themselves.
+func localInstallPath(dir string) string {
+	if pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName)); err == nil && pkg.Gx.InstallDeps == "gx" {
+		return filepath.Join(dir, modDepsDir)
+	}
+	return filepath.Join(dir, "vendor")
+}
+
+// setVendorDir points vendorDir at the local install path of the current
+// package
+func setVendorDir() {
+	rel, err := filepath.Rel(cwd, localInstallPath(cwd))
+	if err != nil {
+		return
+	}
+	vendorDir = filepath.Join(rel, "gx", "ipfs")
+}
+
+// installRootOf returns the directory holding the packages installed next
+// to npkg, or "" if it was not installed into a local install path
+func installRootOf(npkg string) string {
+	for _, r := range installRoots {
+		r = filepath.ToSlash(r)
+		p := filepath.ToSlash(npkg)
+		if i := strings.Index(p, r); i >= 0 {
+			return filepath.FromSlash(p[:i+len(r)])
+		}
+	}
+	return ""
+}
+
+// installPaths are all the places the install-path hook reports
+type installPaths struct {
+	Module    bool   `json:"module"`
+	Local     string `json:"local"`
+	Global    string `json:"global,omitempty"`
+	Bin       string `json:"bin"`
+	GlobalBin string `json:"globalBin,omitempty"`
+}
+
+var installLocHookCommand = cli.Command{
+	Name:  "install-path",
+	Usage: "prints out install path",
+	Description: `prints the directory gx installs dependencies of the current package to.
+
+Packages install into their vendor directory, in module mode too. Packages
+that set 'installDeps' to "gx" in the gx section of package.json install
+into .gx/deps instead, which the go tool does not resolve by itself. --json
+reports whether the package is built in module mode, because it has a
+go.mod or GO111MODULE=on is set.
+
+Global installs go to the GOPATH, or to the GOPATH style directory named by
+GX_GLOBAL_ROOT or the 'globalRoot' of package.json, which keeps the global
+packages of a project apart from those of others. Go commands only find
+them with that directory in the GOPATH, as 'gx-go shell' sets it up.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "global",
+			Usage: "print global install directory",
+		},
+		cli.BoolFlag{
+			Name:  "bin",
+			Usage: "print the directory executables should be installed to",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print all install paths as json",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Bool("json") {
+			ip := installPaths{
+				Module: moduleMode(cwd),
+				Local:  localInstallPath(cwd),
+			}
+			if root, err := globalRoot(); err == nil {
+				ip.Global = filepath.Join(root, "src")
+			}
+
+			var err error
+			if ip.Bin, err = binInstallPath(false); err != nil {
+				return err
+			}
+			if gbin, err := binInstallPath(true); err == nil {
+				ip.GlobalBin = gbin
+			}
+
+			out, err := json.MarshalIndent(ip, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
+		if c.Bool("bin") {
+			dir, err := binInstallPath(c.Bool("global"))
+			if err != nil {
+				return err
+			}
+			fmt.Println(dir)
+			return nil
+		}
+
+		if c.Bool("global") {
+			root, err := globalRoot()
+			if err != nil {
+				return fmt.Errorf("GOPATH not set and no %s given", globalRootEnv)
+			}
+			fmt.Println(filepath.Join(root, "src"))
+			return nil
+		}
+
+		fmt.Println(localInstallPath(cwd))
+		return nil
+	},
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// limiter bounds the number of concurrent calls and the rate they are
+// started at
+type limiter struct {
+	sem  chan struct{}
+	tick <-chan time.Time
+}
+
+// newLimiter returns a limiter allowing concurrency calls at once, started
+// at most perSecond times a second. Zero values mean no limit.
+func newLimiter(concurrency int, perSecond float64) *limiter {
+	l := new(limiter)
+	if concurrency > 0 {
+		l.sem = make(chan struct{}, concurrency)
+	}
+	if perSecond > 0 {
+		l.tick = time.NewTicker(time.Duration(float64(time.Second) / perSecond)).C
+	}
+	return l
+}
+
+func (l *limiter) do(f func() error) error {
+	if l.sem != nil {
+		l.sem <- struct{}{}
+		defer func() { <-l.sem }()
+	}
+	if l.tick != nil {
+		<-l.tick
+	}
+	return f()
+}
+
+var (
+	ipfsLimitOnce sync.Once
+	ipfsLimit     *limiter
+)
+
+// ipfsCall runs f, a call to gx or the ipfs daemon, within the limits set by
+// the ipfsConcurrency and ipfsRate config options, or the GX_GO_IPFS_CONCURRENCY
+// and GX_GO_IPFS_RATE environment variables
+func ipfsCall(f func() error) error {
+	ipfsLimitOnce.Do(func() {
+		var conc int
+		var rate float64
+		if cfg, err := loadConfig(); err == nil {
+			conc, rate = cfg.IpfsConcurrency, cfg.IpfsRate
+		}
+
+		if v, err := strconv.Atoi(os.Getenv("GX_GO_IPFS_CONCURRENCY")); err == nil {
+			conc = v
+		}
+		if v, err := strconv.ParseFloat(os.Getenv("GX_GO_IPFS_RATE"), 64); err == nil {
+			rate = v
+		}
+
+		VLog("  - limiting ipfs calls to %d at once, %g per second", conc, rate)
+		ipfsLimit = newLimiter(conc, rate)
+	})
+
+	if err := cancelled(); err != nil {
+		return err
+	}
+	return ipfsLimit.do(f)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// installedDep is a package in an installed dependency tree
+type installedDep struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Hash    string `json:"hash"`
+}
+
+// installState is what 'last-install --record' records about the
+// dependency tree: the tree installed for the current package.json, and
+// the one installed before it
+type installState struct {
+	// PackageFile is the sha256 of the package.json Current was installed for
+	PackageFile string         `json:"packageFile"`
+	Baseline    []installedDep `json:"baseline"`
+	Current     []installedDep `json:"current"`
+}
+
+// installChange is a difference between two installed trees
+type installChange struct {
+	Change  string
+	Name    string
+	Version string
+	Hash    string
+}
+
+func installStatePath(dir string) string {
+	return filepath.Join(dir, localStateDir, "install-state.json")
+}
+
+func loadInstallState(dir string) (*installState, error) {
+	var st installState
+	err := loadMap(&st, installStatePath(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading install state: %s", err)
+	}
+	return &st, nil
+}
+
+func (st *installState) save(dir string) error {
+	p := installStatePath(dir)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, append(out, '\n'), 0644)
+}
+
+// installedTree lists the dependency tree of pkg, as far as it is installed
+// in pkgdir, sorted by name and hash
+func installedTree(pkg *Package, pkgdir string) []installedDep {
+	var out []installedDep
+	seen := make(map[string]bool)
+	queue := append([]*gx.Dependency{}, pkg.Dependencies...)
+	for len(queue) > 0 {
+		dep := queue[0]
+		queue = queue[1:]
+		if seen[dep.Hash] {
+			continue
+		}
+		seen[dep.Hash] = true
+
+		out = append(out, installedDep{Name: dep.Name, Version: dep.Version, Hash: dep.Hash})
+		if dpkg, _, err := findDepUncached(dep, pkgdir); err == nil {
+			queue = append(queue, dpkg.Dependencies...)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Hash < out[j].Hash
+	})
+	return out
+}
+
+// recordInstall updates the install state of the package in dir with its
+// tree as currently installed. The tree recorded for a previous package.json
+// becomes the baseline changes are reported against.
+func recordInstall(dir string) (*installState, error) {
+	pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, gx.PkgFileName))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+
+	st, err := loadInstallState(dir)
+	if err != nil {
+		return nil, err
+	}
+	if pf := hex.EncodeToString(sum[:]); pf != st.PackageFile {
+		st.Baseline = st.Current
+		st.PackageFile = pf
+	}
+	st.Current = installedTree(pkg, filepath.Join(dir, vendorDir))
+	return st, st.save(dir)
+}
+
+// changes lists what was added, removed or changed between the baseline
+// and the current tree, by package name
+func (st *installState) changes() []installChange {
+	before := make(map[string][]installedDep)
+	after := make(map[string][]installedDep)
+	names := make(map[string]bool)
+	for _, d := range st.Baseline {
+		before[d.Name] = append(before[d.Name], d)
+		names[d.Name] = true
+	}
+	for _, d := range st.Current {
+		after[d.Name] = append(after[d.Name], d)
+		names[d.Name] = true
+	}
+
+	var sorted []string
+	for n := range names {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+
+	var out []installChange
+	for _, n := range sorted {
+		b, a := before[n], after[n]
+		switch {
+		case len(b) == 0:
+			for _, d := range a {
+				out = append(out, installChange{"added", n, d.Version, d.Hash})
+			}
+		case len(a) == 0:
+			for _, d := range b {
+				out = append(out, installChange{"removed", n, d.Version, d.Hash})
+			}
+		case depHashList(b) != depHashList(a):
+			out = append(out, installChange{
+				Change:  "changed",
+				Name:    n,
+				Version: depVersionList(b) + " -> " + depVersionList(a),
+				Hash:    depHashList(b) + " -> " + depHashList(a),
+			})
+		}
+	}
+	return out
+}
+
+func depHashList(deps []installedDep) string {
+	var hs []string
+	for _, d := range deps {
+		hs = append(hs, d.Hash)
+	}
+	return strings.Join(hs, ",")
+}
+
+func depVersionList(deps []installedDep) string {
+	var vs []string
+	for _, d := range deps {
+		vs = append(vs, d.Version)
+	}
+	return strings.Join(vs, ",")
+}
+
+var LastInstallCommand = cli.Command{
+	Name:  "last-install",
+	Usage: "print which dependencies the last install added, removed or changed",
+	Description: `prints the dependency delta of the last install, with versions and hashes,
+as recorded by 'gx-go last-install --record'. The tree installed for the
+current package.json is compared with the one installed for the
+package.json before it, the first install recorded lists every dependency
+as added.
+
+gx runs the hooks of gx-go once for every package it installs, never once
+the whole tree is in place, so installs are recorded by running
+'gx-go last-install --record' after them.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "record",
+			Usage: "record the currently installed tree first",
+		},
+		formatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		var st *installState
+		var err error
+		if c.Bool("record") {
+			st, err = recordInstall(cwd)
+		} else {
+			st, err = loadInstallState(cwd)
+		}
+		if err != nil {
+			return err
+		}
+		if st.PackageFile == "" {
+			return fmt.Errorf("no install recorded, see 'gx-go last-install --help'")
+		}
+
+		changes := st.changes()
+		if len(changes) == 0 {
+			if c.String("format") == "" {
+				Log("the last install changed no dependencies")
+			}
+			return nil
+		}
+
+		var rows [][]string
+		for _, ch := range changes {
+			rows = append(rows, []string{ch.Change, ch.Name, ch.Version, ch.Hash})
+		}
+		return writeTable(os.Stdout, c.String("format"), []string{"CHANGE", "NAME", "VERSION", "HASH"}, rows)
+	},
+}
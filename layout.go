@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	rw "github.com/whyrusleeping/gx-go/rewrite"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+const (
+	layoutFlat    = "flat"
+	layoutSharded = "sharded"
+)
+
+// vendorLayout is the vendor directory layout of the current package, set
+// from its package.json before running any command
+var vendorLayout = layoutFlat
+
+func checkLayout(l string) error {
+	switch l {
+	case layoutFlat, layoutSharded:
+		return nil
+	default:
+		return fmt.Errorf("unknown vendor layout %q, expected %q or %q", l, layoutFlat, layoutSharded)
+	}
+}
+
+// shardKey returns the shard directory of a hash in the sharded layout: its
+// last two characters, as the first ones are the same multibase and
+// multicodec prefix for all hashes of a format
+func shardKey(h string) string {
+	if len(h) < 2 {
+		return h
+	}
+	return h[len(h)-2:]
+}
+
+func hashPathIn(h, layout string) string {
+	if layout == layoutSharded {
+		return shardKey(h) + "/" + h
+	}
+	return h
+}
+
+// hashPath returns the path of the package with the given hash, relative to
+// the gx/ipfs root, in the current hash format and vendor layout
+func hashPath(hash string) string {
+	return hashPathIn(convertHash(hash, cidFormat), vendorLayout)
+}
+
+// gxImport returns the import path of the named package with the given hash
+func gxImport(hash, name string) string {
+	return "gx/ipfs/" + hashPath(hash) + "/" + name
+}
+
+// pkgImport returns the gx import path the dvcs import of pkg, published
+// with the given hash, maps to
+func pkgImport(hash string, pkg *Package) string {
+	return withRoot(gxImport(hash, pkg.Name), pkg)
+}
+
+// withRoot appends the root subdirectory of pkg, if it has one, to imp
+func withRoot(imp string, pkg *Package) string {
+	if r := strings.Trim(path.Clean("/"+pkg.Gx.Root), "/"); r != "" {
+		return imp + "/" + r
+	}
+	return imp
+}
+
+// hashPaths returns every path, relative to the gx/ipfs root, that the
+// package with the given hash may be found at
+func hashPaths(hash string) []string {
+	var out []string
+	for _, h := range hashForms(hash) {
+		out = append(out, h, hashPathIn(h, layoutSharded))
+	}
+	return out
+}
+
+// hashFromImport returns the hash in a gx import path
+func hashFromImport(imp string) string {
+	parts := strings.Split(strings.TrimPrefix(imp, "gx/ipfs/"), "/")
+	if len(parts) > 1 && len(parts[0]) == 2 {
+		return parts[1]
+	}
+	return parts[0]
+}
+
+// placeVendored moves or links the package gx installed into pkgdir/hash to
+// where the current hash format and layout expect it, returning its new
+// location
+func placeVendored(pkgdir, hash string) (string, error) {
+	have := filepath.Join(pkgdir, hash)
+	want := filepath.Join(pkgdir, filepath.FromSlash(hashPath(hash)))
+	if want == have {
+		return have, nil
+	}
+
+	if _, err := os.Lstat(want); err == nil {
+		return want, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(want), 0755); err != nil {
+		return "", err
+	}
+
+	if vendorLayout == layoutSharded {
+		return want, os.Rename(have, want)
+	}
+
+	// only the spelling of the hash differs, link it
+	return want, os.Symlink(hash, want)
+}
+
+var VendorLayoutCommand = cli.Command{
+	Name:      "vendor-layout",
+	Usage:     "migrate the vendor directory between the flat and sharded layouts",
+	ArgsUsage: "<flat|sharded>",
+	Description: `moves the packages in vendor/gx/ipfs to the given layout, rewrites all
+gx imports of the package and its vendored dependencies accordingly, and
+records the layout in package.json.
+
+In the flat layout packages live at vendor/gx/ipfs/<hash>, in the sharded
+layout at vendor/gx/ipfs/<shard>/<hash>, where the shard is made up of the
+last two characters of the hash.
+
+Only gx-go knows the sharded layout: gx itself still looks for packages at
+vendor/gx/ipfs/<hash>, so gx commands that read the vendored packages, like
+'gx deps', do not find them there. The post-install hook moves the packages
+gx installs into their shards.`,
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify a layout")
+		}
+
+		layout := c.Args().First()
+		if err := checkLayout(layout); err != nil {
+			return err
+		}
+
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		if layout == vendorLayout {
+			Log("vendor directory already uses the %s layout", layout)
+			return nil
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		hashes, err := vendoredHashes(pkgdir)
+		if err != nil {
+			return err
+		}
+
+		mapping := make(map[string]string)
+		for h, rel := range hashes {
+			nrel := hashPathIn(h, layout)
+			if nrel == rel {
+				continue
+			}
+
+			from := filepath.Join(pkgdir, filepath.FromSlash(rel))
+			to := filepath.Join(pkgdir, filepath.FromSlash(nrel))
+			VLog("  - moving %s to %s", rel, nrel)
+			if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(from, to); err != nil {
+				return err
+			}
+
+			mapping["gx/ipfs/"+rel] = "gx/ipfs/" + nrel
+		}
+
+		removeEmptyShards(pkgdir)
+
+		rwf := func(in string) string {
+			for from, to := range mapping {
+				if in == from || strings.HasPrefix(in, from+"/") {
+					return to + in[len(from):]
+				}
+			}
+			return in
+		}
+		filter := func(p string) bool {
+			return strings.HasSuffix(p, ".go")
+		}
+
+		if err := rw.RewriteImportsContext(cancelCtx, cwd, rwf, filter); err != nil {
+			return err
+		}
+		if err := rw.RewriteImportsContext(cancelCtx, pkgdir, rwf, filter); err != nil {
+			return err
+		}
+		for h := range hashes {
+			npkg := filepath.Join(pkgdir, filepath.FromSlash(hashPathIn(h, layout)))
+			forgetRewrite(npkg)
+			if _, err := os.Stat(filepath.Join(npkg, installSumFile)); err == nil {
+				if err := recordInstalledDigest(npkg); err != nil {
+					return err
+				}
+			}
+		}
+
+		pkg.Gx.VendorLayout = layout
+		return savePackageFile(pkg, gx.PkgFileName)
+	},
+}
+
+// vendoredHashes returns the hashes of all packages in pkgdir, along with
+// their path relative to it
+func vendoredHashes(pkgdir string) (map[string]string, error) {
+	out := make(map[string]string)
+	ents, err := ioutil.ReadDir(pkgdir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return out, nil
+		}
+		return nil, err
+	}
+
+	for _, e := range ents {
+		if len(e.Name()) > 2 {
+			out[e.Name()] = e.Name()
+			continue
+		}
+
+		sub, err := ioutil.ReadDir(filepath.Join(pkgdir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sub {
+			out[s.Name()] = e.Name() + "/" + s.Name()
+		}
+	}
+
+	return out, nil
+}
+
+func removeEmptyShards(pkgdir string) {
+	ents, err := ioutil.ReadDir(pkgdir)
+	if err != nil {
+		return
+	}
+
+	for _, e := range ents {
+		if len(e.Name()) == 2 {
+			// fails on non-empty directories, which is what we want
+			os.Remove(filepath.Join(pkgdir, e.Name()))
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// lockFileName is the lockfile of a package, next to its package.json
+const lockFileName = "gx-go-lock.json"
+
+// lockFile pins the whole dependency tree of a package
+type lockFile struct {
+	LockVersion int `json:"lockVersion"`
+
+	// Root are the hashes of the direct dependencies
+	Root []string `json:"root"`
+
+	// Deps are all packages in the tree, by hash
+	Deps map[string]*lockEntry `json:"deps"`
+}
+
+type lockEntry struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version,omitempty"`
+	Deps    []string `json:"deps,omitempty"`
+}
+
+// lockProblem is a discrepancy between package.json, the lockfile and the
+// vendor directory
+type lockProblem struct {
+	Kind   string
+	Name   string
+	Hash   string
+	Detail string
+}
+
+func loadLockFile(dir string) (*lockFile, error) {
+	var lf lockFile
+	if err := loadMap(&lf, filepath.Join(dir, lockFileName)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no %s, create one with 'gx-go verify-lock --update'", lockFileName)
+		}
+		return nil, fmt.Errorf("loading %s: %s", lockFileName, err)
+	}
+	return &lf, nil
+}
+
+func (lf *lockFile) save(dir string) error {
+	out, err := json.MarshalIndent(lf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, lockFileName), append(out, '\n'), 0644)
+}
+
+// buildLockFile locks the dependency tree of pkg as vendored in pkgdir
+func buildLockFile(pkg *Package, pkgdir string) (*lockFile, error) {
+	lf := &lockFile{
+		LockVersion: 1,
+		Deps:        make(map[string]*lockEntry),
+	}
+	for _, dep := range pkg.Dependencies {
+		lf.Root = append(lf.Root, dep.Hash)
+	}
+
+	err := forEachDep(pkg, pkgdir, func(dep *gx.Dependency, dpkg *Package, _ string) error {
+		e := &lockEntry{Name: dpkg.Name, Version: dpkg.Version}
+		for _, d := range dpkg.Dependencies {
+			e.Deps = append(e.Deps, d.Hash)
+		}
+		lf.Deps[dep.Hash] = e
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+// verifyLock checks pkg and the packages vendored in pkgdir against lf.
+// Overlaid packages are not held to their lock entry, only their locked
+// dependencies are followed.
+func verifyLock(pkg *Package, lf *lockFile, pkgdir string, ovs map[string]*overlay) ([]lockProblem, error) {
+	var problems []lockProblem
+
+	// direct dependencies
+	locked := make(map[string]bool)
+	for _, h := range lf.Root {
+		locked[h] = true
+	}
+	declared := make(map[string]*gx.Dependency)
+	for _, dep := range pkg.Dependencies {
+		declared[dep.Hash] = dep
+	}
+	for _, dep := range pkg.Dependencies {
+		if locked[dep.Hash] {
+			continue
+		}
+		p := lockProblem{Kind: "unlocked", Name: dep.Name, Hash: dep.Hash, Detail: "dependency is not in the lockfile"}
+		for _, h := range lf.Root {
+			if e := lf.Deps[h]; e != nil && e.Name == dep.Name && declared[h] == nil {
+				p.Kind = "drift"
+				p.Detail = fmt.Sprintf("locked at %s", h)
+			}
+		}
+		problems = append(problems, p)
+	}
+	for _, h := range lf.Root {
+		if declared[h] != nil {
+			continue
+		}
+		name := ""
+		if e := lf.Deps[h]; e != nil {
+			name = e.Name
+			if pkg.FindDep(name) != nil {
+				// reported as drift above
+				continue
+			}
+		}
+		problems = append(problems, lockProblem{Kind: "stale", Name: name, Hash: h, Detail: "locked but not a dependency"})
+	}
+
+	// the locked tree against the vendored packages
+	reached := make(map[string]bool)
+	queue := append([]string{}, lf.Root...)
+	for _, dep := range pkg.Dependencies {
+		queue = append(queue, dep.Hash)
+	}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if reached[h] {
+			continue
+		}
+		reached[h] = true
+
+		e := lf.Deps[h]
+		if e == nil {
+			if declared[h] == nil {
+				problems = append(problems, lockProblem{Kind: "unlocked", Hash: h, Detail: "dependency is not in the lockfile"})
+			}
+			continue
+		}
+		if ovs[h] != nil {
+			queue = append(queue, e.Deps...)
+			continue
+		}
+
+		dpkg, _, err := findDepUncached(&gx.Dependency{Name: e.Name, Hash: h}, pkgdir)
+		if err != nil {
+			problems = append(problems, lockProblem{Kind: "missing", Name: e.Name, Hash: h, Detail: "not vendored"})
+			queue = append(queue, e.Deps...)
+			continue
+		}
+
+		if dpkg.Name != e.Name || dpkg.Version != e.Version {
+			problems = append(problems, lockProblem{
+				Kind:   "drift",
+				Name:   e.Name,
+				Hash:   h,
+				Detail: fmt.Sprintf("vendored %s %s, locked %s %s", dpkg.Name, dpkg.Version, e.Name, e.Version),
+			})
+		}
+
+		want := make(map[string]bool)
+		for _, d := range e.Deps {
+			want[d] = true
+		}
+		for _, d := range dpkg.Dependencies {
+			if !want[d.Hash] {
+				problems = append(problems, lockProblem{Kind: "drift", Name: e.Name, Hash: h, Detail: fmt.Sprintf("depends on %s %s, which is not locked for it", d.Name, d.Hash)})
+			}
+			delete(want, d.Hash)
+		}
+		for d := range want {
+			problems = append(problems, lockProblem{Kind: "drift", Name: e.Name, Hash: h, Detail: fmt.Sprintf("locked dependency %s is not depended on", d)})
+		}
+		queue = append(queue, e.Deps...)
+	}
+
+	var unreached []string
+	for h := range lf.Deps {
+		if !reached[h] {
+			unreached = append(unreached, h)
+		}
+	}
+	sort.Strings(unreached)
+	for _, h := range unreached {
+		problems = append(problems, lockProblem{Kind: "stale", Name: lf.Deps[h].Name, Hash: h, Detail: "locked but not in the dependency tree"})
+	}
+
+	// vendored packages nobody asked for
+	forms := make(map[string]bool)
+	for h := range lf.Deps {
+		for _, f := range hashForms(h) {
+			forms[f] = true
+		}
+	}
+	vendored, err := vendoredHashes(pkgdir)
+	if err != nil {
+		return nil, err
+	}
+	var extra []string
+	for h := range vendored {
+		if !forms[h] {
+			extra = append(extra, h)
+		}
+	}
+	sort.Strings(extra)
+	for _, h := range extra {
+		problems = append(problems, lockProblem{Kind: "extra", Hash: h, Detail: "vendored but not locked"})
+	}
+
+	return problems, nil
+}
+
+var VerifyLockCommand = cli.Command{
+	Name:  "verify-lock",
+	Usage: "check that package.json, the lockfile and the vendor directory agree",
+	Description: `compares the dependencies in package.json, the dependency tree pinned in
+gx-go-lock.json and the packages in the vendor directory, without reading any
+go sources, and lists every discrepancy:
+
+  unlocked  a dependency that is not in the lockfile
+  drift     a package whose hash, version or dependencies differ from the lock
+  stale     a locked package that is no longer in the dependency tree
+  missing   a locked package that is not vendored or installed globally
+  extra     a vendored package that is not locked
+
+The command fails if there are any. With --update, the lockfile is
+written from the vendored dependency tree instead.
+
+Dependencies overlaid with a local directory are listed, but not checked
+against the lock. --no-overrides checks their vendored copies instead.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "update",
+			Usage: "write the lockfile from the vendored dependency tree",
+		},
+		noOverridesFlag,
+		formatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		ovs, restore, err := overlaysFor(c)
+		if err != nil {
+			return err
+		}
+		defer restore()
+
+		if c.Bool("update") {
+			if len(ovs) > 0 {
+				return fmt.Errorf("cannot lock overlaid dependencies (%s), remove the overlays or pass --no-overrides", strings.Join(overriddenNames(ovs), ", "))
+			}
+			lf, err := buildLockFile(pkg, pkgdir)
+			if err != nil {
+				return err
+			}
+			if err := lf.save(cwd); err != nil {
+				return err
+			}
+			Log("locked %d packages", len(lf.Deps))
+			return nil
+		}
+
+		lf, err := loadLockFile(cwd)
+		if err != nil {
+			return err
+		}
+
+		problems, err := verifyLock(pkg, lf, pkgdir, ovs)
+		if err != nil {
+			return err
+		}
+		for _, h := range overlaidHashes(ovs) {
+			Log("%s (%s) is overlaid with %s, not verified", ovs[h].Name, h, ovs[h].Dir)
+		}
+		if len(problems) == 0 {
+			if c.String("format") == "" {
+				Log("package.json, %s and the vendor directory agree", lockFileName)
+			}
+			return nil
+		}
+
+		var rows [][]string
+		for _, p := range problems {
+			rows = append(rows, []string{p.Kind, p.Name, p.Hash, p.Detail})
+		}
+		if err := writeTable(os.Stdout, c.String("format"), []string{"KIND", "NAME", "HASH", "DETAIL"}, rows); err != nil {
+			return err
+		}
+		return fmt.Errorf("%d discrepancies", len(problems))
+	},
+}
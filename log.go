@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+var levelNames = map[string]logLevel{
+	"error": levelError,
+	"warn":  levelWarn,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+// level is the most verbose level of messages that get printed
+var level = levelInfo
+
+func parseLogLevel(s string) (logLevel, error) {
+	l, ok := levelNames[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q, expected error, warn, info or debug", s)
+	}
+	return l, nil
+}
+
+const (
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// useColor is set when stderr is a terminal and NO_COLOR is not set
+var useColor = isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == ""
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func logAt(l logLevel, w io.Writer, color, format string, args ...interface{}) {
+	if l > level {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if useColor && color != "" {
+		msg = color + msg + colorReset
+	}
+	fmt.Fprintln(w, msg)
+}
+
+// Log prints progress information. Like all log messages it goes to
+// stderr, stdout is left to the results of commands.
+func Log(format string, args ...interface{}) {
+	logAt(levelInfo, os.Stderr, "", format, args...)
+}
+
+// VLog prints debugging information
+func VLog(format string, args ...interface{}) {
+	logAt(levelDebug, os.Stderr, "", format, args...)
+}
+
+// Warn prints a problem that does not stop the current operation
+func Warn(format string, args ...interface{}) {
+	logAt(levelWarn, os.Stderr, colorYellow, format, args...)
+}
+
+// repeated counts the diagnostics reported with WarnOnce, by message
+var repeated = struct {
+	sync.Mutex
+	counts map[string]int
+	order  []string
+}{counts: make(map[string]int)}
+
+// WarnOnce is Warn for diagnostics that may come up many times in a run,
+// like those about the same packages met again walking a large tree. Only
+// the first occurrence of a message is printed, warnSummary lists how
+// often it came up.
+func WarnOnce(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	repeated.Lock()
+	repeated.counts[msg]++
+	first := repeated.counts[msg] == 1
+	if first {
+		repeated.order = append(repeated.order, msg)
+	}
+	repeated.Unlock()
+
+	if first {
+		Warn("%s", msg)
+	}
+}
+
+// warnSummary prints the warnings WarnOnce suppressed repetitions of, with
+// how many times each came up
+func warnSummary() {
+	repeated.Lock()
+	defer repeated.Unlock()
+
+	var lines []string
+	for _, msg := range repeated.order {
+		if n := repeated.counts[msg]; n > 1 {
+			first := strings.SplitN(msg, "\n", 2)[0]
+			lines = append(lines, fmt.Sprintf("  %dx %s", n, first))
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	Warn("repeated warnings, each printed once above:")
+	for _, l := range lines {
+		Warn("%s", l)
+	}
+}
+
+// Error prints an error
+func Error(format string, args ...interface{}) {
+	logAt(levelError, os.Stderr, colorRed, format, args...)
+}
+
+// Fatal prints its arguments as an error, flushes the metrics of the run as
+// failed and exits
+func Fatal(args ...interface{}) {
+	msg := fmt.Sprintln(args...)
+	Error("%s", msg[:len(msg)-1])
+	closeMetrics(fmt.Errorf("%s", msg))
+	os.Exit(1)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+)
+
+// ignoreRule is a single gitignore style pattern, along with where it came
+// from
+type ignoreRule struct {
+	Pattern string
+	Source  string
+	Negate  bool
+	DirOnly bool
+}
+
+func (r ignoreRule) String() string {
+	return r.Source + ": " + r.Pattern
+}
+
+// match returns whether the rule matches the slash separated relative path
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.DirOnly && !isDir {
+		return false
+	}
+
+	pat := r.Pattern
+	if strings.HasPrefix(pat, "/") || strings.Contains(pat, "/") {
+		ok, _ := path.Match(strings.TrimPrefix(pat, "/"), rel)
+		return ok
+	}
+
+	ok, _ := path.Match(pat, path.Base(rel))
+	return ok
+}
+
+// loadIgnoreRules reads the ignore rules in the given file, a missing file
+// has no rules
+func loadIgnoreRules(file, source string) ([]ignoreRule, error) {
+	fi, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fi.Close()
+
+	var out []ignoreRule
+	s := bufio.NewScanner(fi)
+	for s.Scan() {
+		out = append(out, parseIgnoreRules(source, s.Text())...)
+	}
+	return out, s.Err()
+}
+
+func parseIgnoreRules(source string, lines ...string) []ignoreRule {
+	var out []ignoreRule
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		r := ignoreRule{Source: source}
+		if strings.HasPrefix(l, "!") {
+			r.Negate = true
+			l = l[1:]
+		}
+		if strings.HasSuffix(l, "/") {
+			r.DirOnly = true
+			l = strings.TrimSuffix(l, "/")
+		}
+		r.Pattern = l
+		out = append(out, r)
+	}
+	return out
+}
+
+// publishIgnoreRules returns the rules deciding which files of the package
+// in dir are published, in order of precedence (last match wins)
+func publishIgnoreRules(dir string) ([]ignoreRule, error) {
+	rules := parseIgnoreRules("gx", "vendor/gx")
+	rules = append(rules, parseIgnoreRules("import", append([]string{"Godeps/*"}, vcsIgnores()...)...)...)
+
+	for _, f := range []string{".gitignore", ".gxignore"} {
+		rs, err := loadIgnoreRules(filepath.Join(dir, f), f)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rs...)
+	}
+	return rules, nil
+}
+
+// excludedBy returns the rule excluding rel, a file or directory, or nil if
+// it is included
+func excludedBy(rules []ignoreRule, rel string, isDir bool) *ignoreRule {
+	var hit *ignoreRule
+	for i := range rules {
+		if rules[i].match(rel, isDir) {
+			hit = &rules[i]
+		}
+	}
+
+	if hit == nil || hit.Negate {
+		return nil
+	}
+	return hit
+}
+
+var LsFilesCommand = cli.Command{
+	Name:  "ls-files",
+	Usage: "list the files publishing the package would include",
+	Description: `lists every file that 'gx publish' or 'gx-go import' would ship for the
+package in the current directory (or the given one), with its size. With
+--excluded, the files left out are listed instead, along with the rule
+excluding them.
+
+Rules come from .gitignore and .gxignore, the vcs metadata and Godeps
+patterns the importer adds, and gx leaving out vendor/gx.`,
+	ArgsUsage: "[dir]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "excluded",
+			Usage: "list the excluded files and the rules excluding them",
+		},
+		formatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		dir := cwd
+		if c.Args().Present() {
+			dir = c.Args().First()
+		}
+
+		rules, err := publishIgnoreRules(dir)
+		if err != nil {
+			return err
+		}
+
+		var rows [][]string
+		var total int64
+		var count int
+		err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(dir, p)
+			if err != nil || rel == "." {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			if r := excludedBy(rules, rel, fi.IsDir()); r != nil {
+				if c.Bool("excluded") {
+					rows = append(rows, []string{rel, r.String()})
+				}
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if fi.IsDir() || c.Bool("excluded") {
+				return nil
+			}
+
+			total += fi.Size()
+			count++
+			rows = append(rows, []string{rel, strconv.FormatInt(fi.Size(), 10)})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if c.Bool("excluded") {
+			return writeTable(os.Stdout, c.String("format"), []string{"path", "rule"}, rows)
+		}
+
+		if err := writeTable(os.Stdout, c.String("format"), []string{"path", "size"}, rows); err != nil {
+			return err
+		}
+		if c.String("format") == "" {
+			fmt.Printf("%d files, %s\n", count, humanSize(total))
+		}
+		return nil
+	},
+}
@@ -13,16 +13,18 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/tabwriter"
 
 	cli "github.com/codegangsta/cli"
 	rw "github.com/whyrusleeping/gx-go/rewrite"
 	gx "github.com/whyrusleeping/gx/gxutil"
-	. "github.com/whyrusleeping/stump"
 )
 
 var vendorDir = filepath.Join("vendor", "gx", "ipfs")
 
+const GxGoVersion = "1.1.0"
+
 var cwd string
 
 // for go packages, extra info
@@ -32,6 +34,71 @@ type GoInfo struct {
 	// GoVersion sets a compiler version requirement, users will be warned if installing
 	// a package using an unsupported compiler
 	GoVersion string `json:"goversion,omitempty"`
+
+	// DvcsType and DvcsRevision record the version control system and the
+	// revision the package was imported from
+	DvcsType     string `json:"dvcstype,omitempty"`
+	DvcsRevision string `json:"dvcsrevision,omitempty"`
+
+	// Bins lists command packages provided by dependencies that are built
+	// into the project local bin directory by 'install-tools'
+	Bins []string `json:"bins,omitempty"`
+
+	// BinDir overrides where executables are installed to for this package,
+	// relative to its root
+	BinDir string `json:"bindir,omitempty"`
+
+	// CidVersion and Multibase set the format hashes are written in, in
+	// import paths of this package. Both cid versions are always accepted
+	// when looking packages up.
+	CidVersion int    `json:"cidversion,omitempty"`
+	Multibase  string `json:"multibase,omitempty"`
+
+	// VendorLayout is either 'flat' (the default) or 'sharded', see the
+	// vendor-layout command
+	VendorLayout string `json:"vendorlayout,omitempty"`
+
+	// Aliases maps short names to dependencies (by name or hash), for use
+	// in place of the dependency in commands taking one
+	Aliases map[string]string `json:"aliases,omitempty"`
+
+	// Root is the subdirectory of the package that its dvcs import path
+	// refers to, for repositories whose importable package does not live at
+	// their root
+	Root string `json:"root,omitempty"`
+
+	// InstallDeps is "gx" to install dependencies into .gx/deps rather than
+	// vendor/
+	InstallDeps string `json:"installDeps,omitempty"`
+
+	// GlobalRoot is a GOPATH style directory, relative to the package, that
+	// its dependencies are installed into globally instead of the GOPATH.
+	// GX_GLOBAL_ROOT takes precedence.
+	GlobalRoot string `json:"globalRoot,omitempty"`
+
+	// ToolVersion sets minimum versions of the gx tooling required to work
+	// on this package, so everyone on a project produces the same vendor tree
+	ToolVersion *ToolVersion `json:"toolVersion,omitempty"`
+
+	// LastTool records the gx-go and go versions and the options of the last
+	// import or rewrite of the package
+	LastTool *LastTool `json:"lastTool,omitempty"`
+
+	// Policy sets how fresh dependencies have to be, see 'policy check'
+	Policy *Policy `json:"policy,omitempty"`
+
+	// Frozen are dependencies pinned on purpose, by name, with the reason.
+	// Updates skip them, see 'freeze'.
+	Frozen map[string]string `json:"frozen,omitempty"`
+
+	// Skip lists dvcs imports that are left unvendored on purpose, marked
+	// "skip" in the map given to 'import'
+	Skip []string `json:"skip,omitempty"`
+}
+
+type ToolVersion struct {
+	GxGo string `json:"gx-go,omitempty"`
+	Gx   string `json:"gx,omitempty"`
 }
 
 type Package struct {
@@ -60,16 +127,59 @@ func main() {
 	app.Name = "gx-go"
 	app.Author = "whyrusleeping"
 	app.Usage = "gx extensions for golang"
-	app.Version = "1.1.0"
+	app.Version = GxGoVersion
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
 			Name:  "verbose",
-			Usage: "turn on verbose output",
+			Usage: "turn on verbose output (same as --log-level=debug)",
+		},
+		cli.StringFlag{
+			Name:  "log-level",
+			Usage: "most verbose messages to print: error, warn, info or debug",
+			Value: "info",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "stop the command cleanly after the given duration, e.g. 10m",
+		},
+		cli.StringFlag{
+			Name:   "answers",
+			Usage:  "json file answering interactive prompts, by prompt id",
+			EnvVar: "GX_GO_ANSWERS",
+		},
+		cli.StringFlag{
+			Name:   "metrics",
+			Usage:  "record timings and counts to a statsd://host:port server or an OpenMetrics file",
+			EnvVar: "GX_GO_METRICS",
 		},
 	}
 	app.Before = func(c *cli.Context) error {
-		Verbose = c.Bool("verbose")
-		return nil
+		setupCancellation(c.Duration("timeout"))
+
+		runCommand = c.Args().First()
+		if cmd := app.Command(runCommand); cmd != nil && len(cmd.Subcommands) > 0 && c.Args().Get(1) != "" {
+			runCommand += " " + c.Args().Get(1)
+		}
+		if err := setupMetrics(c.String("metrics")); err != nil {
+			return err
+		}
+
+		l, err := parseLogLevel(c.String("log-level"))
+		if err != nil {
+			return err
+		}
+		level = l
+		if c.Bool("verbose") {
+			level = levelDebug
+		}
+		rw.Warn = WarnOnce
+
+		if err := loadAnswers(c.String("answers")); err != nil {
+			return err
+		}
+
+		setVendorDir()
+		return localPreamble(!versionCheckExempt[runCommand] && !helpRequested(c.Args()))
 	}
 
 	mcwd, err := os.Getwd()
@@ -90,9 +200,54 @@ func main() {
 		RewriteCommand,
 		UpdateCommand,
 		DvcsDepsCommand,
+		CrossCheckCommand,
+		DepsCommand,
+		InstallToolsCommand,
+		ShellCommand,
+		GcCommand,
+		CidCommand,
+		VendorLayoutCommand,
+		BrowseCommand,
+		SearchCommand,
+		CompletionCommand,
+		EjectCommand,
+		ForkCommand,
+		GenCommand,
+		LsPackagesCommand,
+		DedupeCommand,
+		OverlayCommand,
+		ShimCommand,
+		LsFilesCommand,
+		SelfUpdateCommand,
+		ExplainCommand,
+		GrepCommand,
+		ProbeCommand,
+		RepairCommand,
+		VerifyLockCommand,
+		PolicyCommand,
+		AnnotateCommand,
+		RenameCommand,
+		VerifyGofmtCommand,
+		StatusCommand,
+		CatCommand,
+		InstallCommand,
+		GenerateCommand,
+		LastInstallCommand,
+		BootstrapCommand,
+		TranslateCommand,
+		FreezeCommand,
+		HashMapCommand,
+		DiffTreeCommand,
+		SelftestCommand,
+		RevendorCommand,
+		ExplainRewriteCommand,
+		BadgeCommand,
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	err = app.Run(os.Args)
+	closeMetrics(err)
+	warnSummary()
+	if err != nil {
 		Fatal(err)
 	}
 }
@@ -100,25 +255,63 @@ func main() {
 var DepMapCommand = cli.Command{
 	Name:  "dep-map",
 	Usage: "prints out a json dep map for usage by 'import --map'",
+	Description: `prints the dvcs import and hash of every package in the dependency tree.
+
+--scope limits the map to the given dependency (by name, hash or alias)
+and its subtree, --depth to that many levels of the tree, 1 being the
+direct dependencies or the scoped dependency itself.
+
+--template prints each entry with a go template over .Import and .Hash.`,
+	Flags: []cli.Flag{
+		formatFlag,
+		templateFlag,
+		cli.IntFlag{
+			Name:  "depth",
+			Usage: "only map this many levels of the dependency tree",
+		},
+		cli.StringFlag{
+			Name:  "scope",
+			Usage: "only map the given dependency and its subtree",
+		},
+	},
 	Action: func(c *cli.Context) error {
 		pkg, err := LoadPackageFile(gx.PkgFileName)
 		if err != nil {
 			return err
 		}
 
+		if s := c.String("scope"); s != "" {
+			dep, err := resolveDep(pkg, s)
+			if err != nil {
+				return err
+			}
+			if dep == nil {
+				return fmt.Errorf("%s not found", s)
+			}
+			pkg = &Package{}
+			pkg.Dependencies = []*gx.Dependency{dep}
+		}
+
+		if c.Int("depth") < 0 {
+			return fmt.Errorf("--depth must be positive")
+		}
+
 		m := make(map[string]string)
-		err = buildMap(pkg, m)
+		err = buildMapDepth(pkg, m, c.Int("depth"))
 		if err != nil {
 			return err
 		}
 
-		out, err := json.MarshalIndent(m, "", "  ")
-		if err != nil {
-			return err
+		var seen []indexEntry
+		for imp, hash := range m {
+			seen = append(seen, indexEntry{Import: imp, Hash: hash})
 		}
+		recordInIndex(seen...)
 
-		os.Stdout.Write(out)
-		return nil
+		if c.String("template") != "" {
+			return writeResults(os.Stdout, c, []string{"import", "hash"}, mapRows(m))
+		}
+		return writeMap(os.Stdout, c.String("format"), [2]string{"import", "hash"}, m)
 	},
 }
 
@@ -132,6 +325,7 @@ var HookCommand = cli.Command{
 		postInitHookCommand,
 		postUpdateHookCommand,
 		postInstallHookCommand,
+		hookValidateCommand,
 	},
 	Action: func(c *cli.Context) error { return nil },
 }
@@ -141,7 +335,44 @@ var ImportCommand = cli.Command{
 	Usage: "import a go package and all its depencies into gx",
 	Description: `imports a given go package and all of its dependencies into gx
 producing a package.json for each, and outputting a package hash
-for each.`,
+for each.
+
+With --manifest, every package listed in the given file is imported, one
+import path per line, optionally followed by a vcs ref to check out. The
+packages share the GOPATH, the map and everything published, and --report
+covers all of them.
+
+Dependencies of a package with a go.mod are imported at the versions its
+go.mod requires, the highest one if several go.mod files require the same
+module, rather than whatever is checked out in the GOPATH. --ignore-go-mod
+turns this off.
+
+With --edit-map, every dependency the map has no entry for is asked about:
+it can be published as usual, mapped to an already published hash, replaced
+by another import, which is published in its place, or skipped. Answers
+other than publishing are added to the map file for the next import.
+
+Packages that ship their own vendor directories are listed, as the copies
+in them shadow the gx dependencies. With --strip-vendor, those directories
+are not published.
+
+Imports that violate the dependency policy in .gx-policy.json, see 'policy
+check', fail.
+
+With --preview, imported into a gx package, the import is previewed first:
+packages of its tree that the project already depends on would be
+published again at new hashes. The project's packages are offered for
+reuse, by default those at the revision the import would check out or
+compatible with the version a go.mod requires, and reused ones are treated
+as if given in the map. --preview-only lists them without importing.
+
+With --latest-release, packages without a ref or go.mod requirement are
+imported at the newest release the module proxies in GOPROXY list, rather
+than the head of their default branch.
+
+Packages are fetched with 'go get', unless a source in the "sources" of the
+user config selects another backend for their import path: git, hg, local,
+tarball or goproxy. See the README for the source fields.`,
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "rewrite",
@@ -155,35 +386,163 @@ for each.`,
 			Name:  "tmpdir",
 			Usage: "create and use a temporary directory for the GOPATH",
 		},
+		cli.BoolFlag{
+			Name:  "keep-tmpdir",
+			Usage: "keep the temporary GOPATH of --tmpdir after a successful import",
+		},
+		cli.BoolFlag{
+			Name:  "cache-gopath",
+			Usage: "use a persistent GOPATH managed by gx-go, shared between imports",
+		},
 		cli.StringFlag{
 			Name:  "map",
-			Usage: "json document mapping imports to prexisting hashes",
+			Usage: "json document mapping imports to prexisting hashes, or \"skip\" to leave them unvendored",
+		},
+		cli.BoolFlag{
+			Name:  "edit-map",
+			Usage: "ask what to do with dependencies not in the map, recording the answers in it",
+		},
+		cli.BoolFlag{
+			Name:  "local",
+			Usage: "import the package from a local directory instead of the GOPATH",
+		},
+		cli.StringFlag{
+			Name:  "platforms",
+			Usage: platformsUsage,
+		},
+		cli.BoolFlag{
+			Name:  "use-index",
+			Usage: "reuse hashes from the local index for dependencies missing from the map",
+		},
+		cli.StringFlag{
+			Name:  "report",
+			Usage: "write a json report of the published and reused packages to the given file",
+		},
+		cli.BoolFlag{
+			Name:  "keep-going",
+			Usage: "import as much of the tree as possible and report all failures at the end",
+		},
+		cli.StringFlag{
+			Name:  "registry-publish",
+			Usage: "post the metadata of published packages to this registry endpoint, overriding 'registryPublish' in the config",
+		},
+		cli.BoolFlag{
+			Name:  "no-registry-publish",
+			Usage: "do not post published packages to the configured registry",
+		},
+		cli.BoolFlag{
+			Name:  "latest-release",
+			Usage: "import the newest release GOPROXY knows of instead of the default branch",
+		},
+		cli.BoolFlag{
+			Name:  "github-metadata",
+			Usage: "fill in the description of new packages hosted on github from the github api",
+		},
+		cli.BoolFlag{
+			Name:  "strip-vendor",
+			Usage: "do not publish the vendor directories imported packages ship",
+		},
+		cli.BoolFlag{
+			Name:  "ignore-go-mod",
+			Usage: "do not check out the versions go.mod files require",
+		},
+		cli.StringFlag{
+			Name:  "manifest",
+			Usage: "import every package listed in the given file, one import path and optional ref per line",
+		},
+		cli.BoolFlag{
+			Name:  "preview",
+			Usage: "preview the import and offer to reuse the dependencies the project in the current directory already has",
+		},
+		cli.BoolFlag{
+			Name:  "preview-only",
+			Usage: "only list the packages of the import the project already depends on at other hashes",
 		},
 	},
-	Action: func(c *cli.Context) error {
+	Action: func(c *cli.Context) (err error) {
+		var entries []manifestEntry
+		name := c.Args().First()
+		switch mf := c.String("manifest"); {
+		case mf != "" && c.Args().Present():
+			return fmt.Errorf("--manifest and a package argument are mutually exclusive")
+		case mf != "":
+			if entries, err = loadManifest(mf); err != nil {
+				return err
+			}
+			name = mf
+		case !c.Args().Present():
+			return fmt.Errorf("must specify a package name")
+		default:
+			entries = []manifestEntry{{Import: name}}
+		}
+
 		var mapping map[string]string
 		preset := c.String("map")
+		if c.Bool("edit-map") && preset == "" {
+			return fmt.Errorf("--edit-map needs a --map file to record the answers in")
+		}
 		if preset != "" {
 			err := loadMap(&mapping, preset)
-			if err != nil {
+			if err != nil && !(c.Bool("edit-map") && os.IsNotExist(err)) {
 				return err
 			}
 		}
 
 		var gopath string
-		if c.Bool("tmpdir") {
-			dir, err := ioutil.TempDir("", "gx-go-import")
+		switch {
+		case c.Bool("tmpdir") && c.Bool("cache-gopath"):
+			return fmt.Errorf("--tmpdir and --cache-gopath are mutually exclusive")
+		case c.Bool("cache-gopath"):
+			dir, err := cacheGoPath()
 			if err != nil {
-				return fmt.Errorf("creating temp dir: %s", err)
+				return err
 			}
+
+			unlock, err := lockDir(dir)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
 			err = os.Setenv("GOPATH", dir)
 			if err != nil {
 				return fmt.Errorf("setting GOPATH: %s", err)
 			}
-			Log("setting GOPATH to", dir)
+			Log("setting GOPATH to %s", dir)
 
 			gopath = dir
-		} else {
+		case c.Bool("tmpdir"):
+			dir, terr := ioutil.TempDir("", "gx-go-import")
+			if terr != nil {
+				return fmt.Errorf("creating temp dir: %s", terr)
+			}
+			trackTmpGoPath(dir)
+
+			if serr := os.Setenv("GOPATH", dir); serr != nil {
+				return fmt.Errorf("setting GOPATH: %s", serr)
+			}
+			Log("setting GOPATH to %s", dir)
+
+			removeTmp := func() {
+				os.RemoveAll(dir)
+				untrackTmpGoPath(dir)
+			}
+			defer onForcedExit(removeTmp)()
+			defer func() {
+				switch {
+				case cancelled() != nil:
+					// an interrupted import leaves nothing worth coming back to
+					Log("removing %s", dir)
+					removeTmp()
+				case err != nil || c.Bool("keep-tmpdir"):
+					Log("use 'gx-go shell --gopath %s' to work in it later, 'gx-go gc --tmp' removes it", dir)
+				default:
+					removeTmp()
+				}
+			}()
+
+			gopath = dir
+		default:
 			gp, err := getGoPath()
 			if err != nil {
 				return fmt.Errorf("couldnt determine gopath: %s", err)
@@ -198,28 +557,242 @@ for each.`,
 		}
 
 		importer.yesall = c.Bool("yesall")
+		importer.editMap = c.Bool("edit-map")
+		importer.mapFile = preset
+		importer.useIndex = c.Bool("use-index")
+		importer.keepGoing = c.Bool("keep-going")
+		importer.stripVendor = c.Bool("strip-vendor")
+		if importer.policy, err = loadDepPolicy(cwd); err != nil {
+			return err
+		}
+		importer.latestRelease = c.Bool("latest-release")
+		importer.githubMetadata = c.Bool("github-metadata")
+		importer.ignoreGoMod = c.Bool("ignore-go-mod")
+		importer.stamp = toolStamp(map[string]string{
+			"rewrite":         strconv.FormatBool(c.Bool("rewrite")),
+			"platforms":       c.String("platforms"),
+			"strip-vendor":    strconv.FormatBool(c.Bool("strip-vendor")),
+			"latest-release":  strconv.FormatBool(c.Bool("latest-release")),
+			"ignore-go-mod":   strconv.FormatBool(c.Bool("ignore-go-mod")),
+			"github-metadata": strconv.FormatBool(c.Bool("github-metadata")),
+			"skip":            strings.Join(skippedImports(mapping), ","),
+		})
+		importer.registryPublish = c.String("registry-publish")
+		if importer.registryPublish == "" && !c.Bool("no-registry-publish") {
+			if cfg, err := loadConfig(); err == nil {
+				importer.registryPublish = cfg.RegistryPublish
+			}
+		}
+		importer.platforms, err = parsePlatforms(c.String("platforms"))
+		if err != nil {
+			return err
+		}
 
-		if !c.Args().Present() {
-			return fmt.Errorf("must specify a package name")
+		if rp := c.String("report"); rp != "" {
+			importer.report = newImportReport(name)
+			if c.String("manifest") != "" {
+				for _, e := range entries {
+					importer.report.Packages = append(importer.report.Packages, e.Import)
+				}
+			}
+			defer func() {
+				if werr := importer.report.write(rp, err); werr != nil {
+					Error("writing import report: %s", werr)
+				}
+			}()
 		}
 
-		pkg := c.Args().First()
-		Log("vendoring package %s", pkg)
+		for _, e := range entries {
+			if e.Ref != "" {
+				importer.refs[getBaseDVCS(e.Import)] = e.Ref
+			}
+		}
 
-		_, err = importer.GxPublishGoPackage(pkg)
-		if err != nil {
-			return err
+		if c.Bool("preview") || c.Bool("preview-only") {
+			var roots []string
+			dirs := make(map[string]string)
+			for _, e := range entries {
+				if !c.Bool("local") && !isLocalPath(e.Import) {
+					roots = append(roots, getBaseDVCS(e.Import))
+					continue
+				}
+				dir, err := filepath.Abs(e.Import)
+				if err != nil {
+					return err
+				}
+				// without asking, that comes with the import itself
+				imp, err := localImportPath(dir, true)
+				if err != nil {
+					VLog("  - not previewing %s: %s", dir, err)
+					continue
+				}
+				roots = append(roots, imp)
+				dirs[imp] = dir
+			}
+
+			conflicts, err := importer.previewImport(roots, dirs)
+			if err != nil {
+				return err
+			}
+			if len(conflicts) > 0 {
+				printConflicts(conflicts)
+			}
+			if c.Bool("preview-only") {
+				if len(conflicts) == 0 {
+					Log("the import does not duplicate any dependencies of the project")
+				}
+				return nil
+			}
+			importer.offerReuse(conflicts)
 		}
 
-		return nil
+		// packages of a manifest share the importer, so dependencies they
+		// have in common are only published once
+		for _, e := range entries {
+			if ierr := importEntry(importer, e, c.Bool("local")); ierr != nil {
+				err = ierr
+				if !importer.keepGoing {
+					break
+				}
+			}
+		}
+
+		if ferr := importer.failureSummary(); ferr != nil {
+			return ferr
+		}
+		return err
 	},
 }
 
+// importEntry imports a single package given to 'import', from the GOPATH
+// or a local directory
+func importEntry(importer *Importer, e manifestEntry, local bool) error {
+	if !local && !isLocalPath(e.Import) {
+		Log("vendoring package %s", e.Import)
+		_, err := importer.GxPublishGoPackage(e.Import)
+		return err
+	}
+
+	if e.Ref != "" {
+		return fmt.Errorf("cannot check out %s of local package %s", e.Ref, e.Import)
+	}
+
+	dir, err := filepath.Abs(e.Import)
+	if err != nil {
+		return err
+	}
+
+	imp, err := localImportPath(dir, importer.yesall)
+	if err != nil {
+		return err
+	}
+
+	Log("vendoring local package %s as %s", dir, imp)
+	_, err = importer.GxPublishLocalPackage(dir, imp)
+	return err
+}
+
+func isLocalPath(p string) bool {
+	return filepath.IsAbs(p) || p == "." || p == ".." ||
+		strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../")
+}
+
+// localImportPath determines the import path a package in a local directory
+// will eventually live at, from its package.json or its location within the
+// GOPATH, prompting for it if neither is available.
+func localImportPath(dir string, yesall bool) (string, error) {
+	var def string
+	pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName))
+	switch {
+	case err == nil && pkg.Gx.DvcsImport != "":
+		def = pkg.Gx.DvcsImport
+	case err != nil && !os.IsNotExist(err):
+		return "", err
+	default:
+		def, _ = packagesGoImport(dir)
+	}
+
+	if yesall {
+		if def == "" {
+			return "", fmt.Errorf("could not infer the import path of %s, please set 'dvcsimport' in its package.json", dir)
+		}
+		return def, nil
+	}
+
+	imp, err := prompt("import.path", dir, "enter the import path this package will live at", def)
+	if err != nil {
+		return "", err
+	}
+	if imp == "" {
+		return "", fmt.Errorf("no import path specified for %s", dir)
+	}
+
+	return imp, nil
+}
+
 var UpdateCommand = cli.Command{
 	Name:      "update",
 	Usage:     "update a packages imports to a new path",
 	ArgsUsage: "[old import] [new import]",
+	Description: `rewrites imports of the old import path to the new one. The old import
+may also be given as the name, hash or alias of a dependency, which stands
+for its gx import path.
+
+With --stage, the update is only recorded in .gx/staged-updates.json, so a
+plan of updates can be reviewed before any file changes. --stage without
+arguments lists the staged updates, and --apply performs all of them, one
+pass over each package.
+
+Packages in which the old import is a frozen dependency, see 'freeze', are
+skipped.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "stage",
+			Usage: "record the update in .gx/staged-updates.json instead of performing it",
+		},
+		cli.BoolFlag{
+			Name:  "apply",
+			Usage: "perform all staged updates",
+		},
+	},
 	Action: func(c *cli.Context) error {
+		if c.Bool("stage") && c.Bool("apply") {
+			return fmt.Errorf("--stage and --apply are mutually exclusive")
+		}
+
+		if c.Bool("apply") {
+			if c.Args().Present() {
+				return fmt.Errorf("--apply takes no arguments")
+			}
+
+			updates, err := loadStagedUpdates()
+			if err != nil {
+				return err
+			}
+			if len(updates) == 0 {
+				return fmt.Errorf("no updates staged")
+			}
+			if err := applyStagedUpdates(updates); err != nil {
+				return err
+			}
+			return saveStagedUpdates(nil)
+		}
+
+		var updates []stagedUpdate
+		if c.Bool("stage") {
+			var err error
+			if updates, err = loadStagedUpdates(); err != nil {
+				return err
+			}
+
+			if !c.Args().Present() {
+				for _, u := range updates {
+					fmt.Printf("%s\t%s\t%s\n", u.Package, u.Old, u.New)
+				}
+				return nil
+			}
+		}
+
 		if len(c.Args()) < 2 {
 			return fmt.Errorf("must specify current and new import names")
 		}
@@ -227,12 +800,38 @@ var UpdateCommand = cli.Command{
 		oldimp := c.Args()[0]
 		newimp := c.Args()[1]
 
-		err := doUpdate(cwd, oldimp, newimp)
-		if err != nil {
+		err := forEachPackage(func(dir string) error {
+			old := oldimp
+			if pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName)); err == nil {
+				dep, err := resolveDep(pkg, old)
+				if err != nil {
+					return err
+				}
+				if dep != nil {
+					old = gxImport(dep.Hash, dep.Name)
+				}
+			}
+			if note, ok := frozenImport(dir, old); ok {
+				Log("skipping %s, %s", dir, note)
+				return nil
+			}
+
+			if c.Bool("stage") {
+				rel, err := filepath.Rel(cwd, dir)
+				if err != nil {
+					return err
+				}
+				updates = stageUpdate(updates, stagedUpdate{Package: filepath.ToSlash(rel), Old: old, New: newimp})
+				Log("staged update of %s to %s", old, newimp)
+				return nil
+			}
+
+			return doUpdate(dir, old, newimp)
+		})
+		if err != nil || !c.Bool("stage") {
 			return err
 		}
-
-		return nil
+		return saveStagedUpdates(updates)
 	},
 }
 
@@ -252,83 +851,222 @@ var RewriteCommand = cli.Command{
 			Name:  "pkgdir",
 			Usage: "alternative location of the package directory",
 		},
+		cli.BoolFlag{
+			Name:  "deep",
+			Usage: "also rewrite import paths in comments and strings of generated files, like mocks",
+		},
+		cli.BoolFlag{
+			Name:  "yes",
+			Usage: "apply deep rewrites without asking",
+		},
+		cli.StringFlag{
+			Name:  "emit-map",
+			Usage: "write the computed mapping of dvcs to gx imports to the given json file",
+		},
+		cli.StringFlag{
+			Name:  "use-map",
+			Usage: "rewrite with the mapping in the given json file instead of computing it",
+		},
+		cli.BoolFlag{
+			Name:  "annotate",
+			Usage: "add comments naming the package next to gx imports, see 'annotate'",
+		},
 	},
 	Action: func(c *cli.Context) error {
-		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if c.IsSet("use-map") && c.Args().Present() {
+			return fmt.Errorf("--use-map cannot be combined with dependency arguments")
+		}
+
+		return forEachPackage(func(dir string) error {
+			return rewritePackage(c, dir)
+		})
+	},
+}
+
+func rewritePackage(c *cli.Context, dir string) error {
+	pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName))
+	if err != nil {
+		return err
+	}
+
+	pkgdir := filepath.Join(dir, vendorDir)
+	if pdopt := c.String("pkgdir"); pdopt != "" {
+		pkgdir = pdopt
+	}
+
+	VLog("  - building rewrite mapping")
+	mapping := make(map[string]string)
+	if mp := c.String("use-map"); mp != "" {
+		mapping, err = loadRewriteMap(pkgPath(dir, mp), pkgdir, c.Bool("undo"))
 		if err != nil {
 			return err
 		}
-
-		pkgdir := filepath.Join(cwd, vendorDir)
-		if pdopt := c.String("pkgdir"); pdopt != "" {
-			pkgdir = pdopt
+	} else if !c.Args().Present() {
+		err = buildRewriteMapping(pkg, pkgdir, mapping, c.Bool("undo"))
+		if err != nil {
+			return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
 		}
+	} else {
+		for _, arg := range c.Args() {
+			dep, err := resolveDep(pkg, arg)
+			if err != nil {
+				return err
+			}
+			if dep == nil {
+				return fmt.Errorf("%s not found", arg)
+			}
 
-		VLog("  - building rewrite mapping")
-		mapping := make(map[string]string)
-		if !c.Args().Present() {
-			err = buildRewriteMapping(pkg, pkgdir, mapping, c.Bool("undo"))
+			pkg, err := loadDep(dep, pkgdir)
 			if err != nil {
-				return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+				return err
 			}
-		} else {
-			for _, arg := range c.Args() {
-				dep := pkg.FindDep(arg)
-				if dep == nil {
-					return fmt.Errorf("%s not found", arg)
-				}
 
-				pkg, err := loadDep(dep, pkgdir)
-				if err != nil {
-					return err
-				}
+			addRewriteForDep(dep, pkg, mapping, c.Bool("undo"))
+		}
+	}
+	VLog("  - rewrite mapping complete")
+	if !c.Bool("undo") {
+		warnCaseCollisions(mapping)
+	}
+
+	if mp := c.String("emit-map"); mp != "" {
+		if err := writeRewriteMap(pkgPath(dir, mp), mapping); err != nil {
+			return fmt.Errorf("writing mapping: %s", err)
+		}
+	}
 
-				addRewriteForDep(dep, pkg, mapping, c.Bool("undo"))
+	if c.Bool("dry-run") {
+		tabPrintSortedMap(nil, mapping)
+		return nil
+	}
+
+	if !c.Bool("undo") && !dirWritable(pkgdir) {
+		VLog("  - %s is read-only, leaving vendored packages where they are", pkgdir)
+	} else if !c.Bool("undo") {
+		err = forEachDep(pkg, pkgdir, func(dep *gx.Dependency, _ *Package, _ string) error {
+			if _, err := os.Stat(filepath.Join(pkgdir, dep.Hash)); err != nil {
+				return nil
 			}
+			_, err := placeVendored(pkgdir, dep.Hash)
+			return err
+		})
+		if err != nil {
+			return err
 		}
-		VLog("  - rewrite mapping complete")
+	}
 
-		if c.Bool("dry-run") {
-			tabPrintSortedMap(nil, mapping)
-			return nil
+	if c.Bool("undo") {
+		if err := annotatePackage(dir, nil, annotateStrip); err != nil {
+			return err
 		}
+	}
 
-		err = doRewrite(pkg, cwd, mapping)
-		if err != nil {
+	// an installed package no longer is as the post-install hook left it
+	if strings.Contains(filepath.ToSlash(dir), "gx/ipfs/") {
+		forgetRewrite(filepath.Dir(dir))
+	}
+
+	err = doRewrite(pkg, dir, mapping)
+	if err != nil {
+		return err
+	}
+
+	if !c.Bool("undo") {
+		mode := annotateRefresh
+		if c.Bool("annotate") {
+			mode = annotateAll
+		}
+		labels, err := importLabels(pkg, pkgdir)
+		switch {
+		case err != nil && mode == annotateAll:
 			return err
+		case err != nil:
+			VLog("  - not refreshing annotations: %s", err)
+		default:
+			if err := annotatePackage(dir, labels, mode); err != nil {
+				return err
+			}
 		}
+	}
 
-		return nil
-	},
+	if c.Bool("deep") {
+		if err := deepRewriteGenerated(dir, mapping, c.Bool("yes")); err != nil {
+			return err
+		}
+	}
+
+	return stampPackage(dir, toolStamp(map[string]string{
+		"undo":     strconv.FormatBool(c.Bool("undo")),
+		"deep":     strconv.FormatBool(c.Bool("deep")),
+		"annotate": strconv.FormatBool(c.Bool("annotate")),
+	}))
 }
 
 var DvcsDepsCommand = cli.Command{
 	Name:  "dvcs-deps",
 	Usage: "display dvcs deps that arent tracked in gx",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "platforms",
+			Usage: platformsUsage,
+		},
+		formatFlag,
+		templateFlag,
+	},
 	Action: func(c *cli.Context) error {
 		i, err := NewImporter(false, os.Getenv("GOPATH"), nil)
 		if err != nil {
 			return err
 		}
 
-		relp, err := getImportPath(cwd)
+		i.platforms, err = parsePlatforms(c.String("platforms"))
 		if err != nil {
 			return err
 		}
 
-		deps, err := i.DepsToVendorForPackage(relp)
-		if err != nil {
-			return err
-		}
+		return forEachPackage(func(dir string) error {
+			relp, err := getImportPath(dir)
+			if err != nil {
+				return err
+			}
 
-		for _, d := range deps {
-			fmt.Println(d)
-		}
+			deps, err := i.DepsToVendorForPackage(relp)
+			if err != nil {
+				return err
+			}
 
-		return nil
+			if pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName)); err == nil {
+				deps = withoutSkipped(deps, pkg.Gx.Skip)
+			}
+
+			if c.String("format") == "" && c.String("template") == "" {
+				for _, d := range deps {
+					fmt.Println(d)
+				}
+				return nil
+			}
+
+			sort.Strings(deps)
+			var rows [][]string
+			for _, d := range deps {
+				rows = append(rows, []string{d})
+			}
+			return writeResults(os.Stdout, c, []string{"import"}, rows)
+		})
 	},
 }
 
+// withoutSkipped returns the imports in deps that are not skipped
+func withoutSkipped(deps, skip []string) []string {
+	var out []string
+	for _, d := range deps {
+		if !isSkipped(skip, d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
 func getImportPath(pkgpath string) (string, error) {
 	gopath, err := getGoPath()
 	if err != nil {
@@ -338,11 +1076,11 @@ func getImportPath(pkgpath string) (string, error) {
 	srcdir := path.Join(gopath, "src")
 	srcdir += "/"
 
-	if !strings.HasPrefix(cwd, srcdir) {
+	if !strings.HasPrefix(pkgpath, srcdir) {
 		return "", fmt.Errorf("package not within GOPATH/src")
 	}
 
-	rel := cwd[len(srcdir):]
+	rel := pkgpath[len(srcdir):]
 	return rel, nil
 }
 
@@ -360,9 +1098,19 @@ var PathCommand = cli.Command{
 	},
 }
 
-func prompt(text, def string) (string, error) {
-	scan := bufio.NewScanner(os.Stdin)
+// prompt asks for a line of text, def being the default. id and subject
+// identify the prompt in an answers file.
+func prompt(id, subject, text, def string) (string, error) {
 	fmt.Printf("%s (default: '%s') ", text, def)
+	if a, ok := answerFor(id, subject); ok {
+		fmt.Println(a)
+		if a == "" {
+			return def, nil
+		}
+		return a, nil
+	}
+
+	scan := bufio.NewScanner(os.Stdin)
 	for scan.Scan() {
 		if scan.Text() != "" {
 			return scan.Text(), nil
@@ -373,46 +1121,72 @@ func prompt(text, def string) (string, error) {
 	return "", scan.Err()
 }
 
-func yesNoPrompt(prompt string, def bool) bool {
+// yesNoPrompt asks a yes or no question, def being the default. id and
+// subject identify the prompt in an answers file.
+func yesNoPrompt(id, subject, prompt string, def bool) bool {
 	opts := "[y/N]"
 	if def {
 		opts = "[Y/n]"
 	}
 
 	fmt.Printf("%s %s ", prompt, opts)
+	if a, ok := answerFor(id, subject); ok {
+		fmt.Println(a)
+		if yes, ok := parseYesNo(a, def); ok {
+			return yes
+		}
+		Fatal(fmt.Sprintf("answer %q to prompt %q in %s is not 'y' or 'n'", a, id, answersFile))
+	}
+
 	scan := bufio.NewScanner(os.Stdin)
 	for scan.Scan() {
-		val := strings.ToLower(scan.Text())
-		switch val {
-		case "":
-			return def
-		case "y":
-			return true
-		case "n":
-			return false
-		default:
-			fmt.Println("please type 'y' or 'n'")
+		if yes, ok := parseYesNo(scan.Text(), def); ok {
+			return yes
 		}
+		fmt.Println("please type 'y' or 'n'")
 	}
 
 	panic("unexpected termination of stdin")
 }
 
 var postImportCommand = cli.Command{
-	Name:  "post-import",
-	Usage: "hook called after importing a new go package",
+	Name:      "post-import",
+	Usage:     "hook called after importing a new go package",
+	ArgsUsage: "<hash>...",
+	Description: `offers to update the imports of the package to the newly imported
+packages. Several hashes may be given, in which case a single combined
+update is done.
+
+--update-imports, or the GX_GO_UPDATE_IMPORTS environment variable, set
+whether to 'always' update imports, 'never' update them, or 'ask' (the
+default).`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "update-imports",
+			Usage:  "whether to update imports: always, never or ask",
+			EnvVar: "GX_GO_UPDATE_IMPORTS",
+		},
+	},
 	Action: func(c *cli.Context) error {
 		if !c.Args().Present() {
 			Fatal("no package specified")
 		}
-		dephash := c.Args().First()
+
+		mode := c.String("update-imports")
+		switch mode {
+		case "":
+			mode = "ask"
+		case "always", "never", "ask":
+		default:
+			return fmt.Errorf("unknown --update-imports value %q, expected always, never or ask", mode)
+		}
 
 		pkg, err := LoadPackageFile(gx.PkgFileName)
 		if err != nil {
 			return err
 		}
 
-		err = postImportHook(pkg, dephash)
+		err = postImportHook(pkg, c.Args(), mode)
 		if err != nil {
 			return err
 		}
@@ -450,25 +1224,25 @@ var postInitHookCommand = cli.Command{
 			dir = cwd
 		}
 
-		pkgpath := filepath.Join(dir, gx.PkgFileName)
-		pkg, err := LoadPackageFile(pkgpath)
-		if err != nil {
-			return err
-		}
+		return postInitHook(dir)
+	},
+}
 
-		imp, _ := packagesGoImport(dir)
+// postInitHook records the go import path of the package initialized in dir
+func postInitHook(dir string) error {
+	pkgpath := filepath.Join(dir, gx.PkgFileName)
+	pkg, err := LoadPackageFile(pkgpath)
+	if err != nil {
+		return err
+	}
 
-		if imp != "" {
-			pkg.Gx.DvcsImport = imp
-		}
+	imp, _ := packagesGoImport(dir)
 
-		err = gx.SavePackageFile(pkg, pkgpath)
-		if err != nil {
-			return err
-		}
+	if imp != "" {
+		pkg.Gx.DvcsImport = imp
+	}
 
-		return nil
-	},
+	return savePackageFile(pkg, pkgpath)
 }
 
 var postInstallHookCommand = cli.Command{
@@ -484,109 +1258,142 @@ var postInstallHookCommand = cli.Command{
 		if !c.Args().Present() {
 			return fmt.Errorf("must specify path to newly installed package")
 		}
-		npkg := c.Args().First()
-		// update sub-package refs here
-		// ex:
-		// if this package is 'github.com/X/Y' replace all imports
-		// matching 'github.com/X/Y*' with 'gx/<hash>/name*'
-
-		var pkg Package
-		err := gx.FindPackageInDir(&pkg, npkg)
-		if err != nil {
-			return fmt.Errorf("find package failed: %s", err)
-		}
-
-		dir := filepath.Join(npkg, pkg.Name)
-
-		// build rewrite mapping from parent package if
-		// this call is made on one in the vendor directory
-		var reldir string
-		if strings.Contains(npkg, "vendor/gx/ipfs") {
-			reldir = strings.Split(npkg, "vendor/gx/ipfs")[0]
-			reldir = filepath.Join(reldir, "vendor", "gx", "ipfs")
-		} else {
-			reldir = dir
-		}
-
-		mapping := make(map[string]string)
-		err = buildRewriteMapping(&pkg, reldir, mapping, false)
-		if err != nil {
-			return fmt.Errorf("building rewrite mapping failed: %s", err)
-		}
-
-		hash := filepath.Base(npkg)
-		newimp := "gx/ipfs/" + hash + "/" + pkg.Name
-		mapping[pkg.Gx.DvcsImport] = newimp
-
-		err = doRewrite(&pkg, dir, mapping)
-		if err != nil {
-			return fmt.Errorf("rewrite failed: %s", err)
-		}
-
-		return nil
+		return postInstallHook(c.Args().First(), c.Bool("global"))
 	},
 }
 
-func doRewrite(pkg *Package, cwd string, mapping map[string]string) error {
+func doRewrite(pkg *Package, cwd string, mapping map[string]string) (err error) {
+	stop := startTimer("rewrite")
+	defer func() { stop(err) }()
+
+	var file string
+	ambiguous := make(map[string][]string)
+	cache := make(map[string]string)
 	rwm := func(in string) string {
-		m, ok := mapping[in]
-		if ok {
+		if m, ok := cache[in]; ok {
+			if _, amb := ambiguous[in]; amb {
+				ambiguous[in] = append(ambiguous[in], file)
+			}
 			return m
 		}
 
-		for k, v := range mapping {
-			if strings.HasPrefix(in, k+"/") {
-				nmapping := strings.Replace(in, k, v, 1)
-				mapping[in] = nmapping
-				return nmapping
-			}
+		out, matches := rewriteImport(in, mapping)
+		if len(matches) > 1 {
+			ambiguous[in] = append(ambiguous[in], file)
 		}
-
-		mapping[in] = in
-		return in
+		cache[in] = out
+		return out
 	}
 
+	nested := nestedPackages(cwd)
+	before := make(map[string][]byte)
 	filter := func(s string) bool {
-		return strings.HasSuffix(s, ".go")
+		if strings.HasSuffix(s, ".go") && !inNested(nested, s) {
+			file = s
+			if src, err := ioutil.ReadFile(filepath.Join(cwd, s)); err == nil {
+				before[s] = src
+			}
+			return true
+		}
+		return false
 	}
 
 	VLog("  - rewriting imports")
-	err := rw.RewriteImports(cwd, rwm, filter)
+	err = rw.RewriteImportsContext(cancelCtx, cwd, rwm, filter)
 	if err != nil {
 		return err
 	}
 	VLog("  - finished!")
 
+	verifyRewritten(cwd, before)
+	warnAmbiguousImports(ambiguous, mapping)
 	return nil
 }
 
-var installLocHookCommand = cli.Command{
-	Name:  "install-path",
-	Usage: "prints out install path",
-	Flags: []cli.Flag{
-		cli.BoolFlag{
-			Name:  "global",
-			Usage: "print global install directory",
-		},
-	},
-	Action: func(c *cli.Context) error {
-		if c.Bool("global") {
-			gpath, err := getGoPath()
-			if err != nil {
-				return fmt.Errorf("GOPATH not set")
-			}
-			fmt.Println(filepath.Join(gpath, "src"))
-			return nil
-		} else {
-			cwd, err := os.Getwd()
-			if err != nil {
-				return fmt.Errorf("install-path cwd: %s", err)
-			}
+// mappedPaths returns the paths in mapping that the path s is or is below,
+// longest first, so github.com/x/y/z comes before github.com/x/y. Paths end
+// at a slash, and with dots also at a dot, as before a qualified identifier.
+func mappedPaths(s string, mapping map[string]string, dots bool) []string {
+	var out []string
+	for end := len(s); end > 0; end-- {
+		if end < len(s) && s[end] != '/' && !(dots && s[end] == '.') {
+			continue
+		}
+		if _, ok := mapping[s[:end]]; ok {
+			out = append(out, s[:end])
+		}
+	}
+	return out
+}
 
-			fmt.Println(filepath.Join(cwd, "vendor"))
-			return nil
+// rewriteImport returns the rewritten form of the import in, using the
+// longest mapped path that is in or a parent of it, along with every mapped
+// path that is a parent of in, longest first
+func rewriteImport(in string, mapping map[string]string) (string, []string) {
+	if m, ok := mapping[in]; ok {
+		return m, nil
+	}
+
+	matches := mappedPaths(in, mapping, false)
+	if len(matches) == 0 {
+		return in, nil
+	}
+	return mapping[matches[0]] + in[len(matches[0]):], matches
+}
+
+// warnAmbiguousImports lists the files with imports below more than one
+// mapped package, along with the package they were rewritten to belong to
+func warnAmbiguousImports(ambiguous map[string][]string, mapping map[string]string) {
+	if len(ambiguous) == 0 {
+		return
+	}
+
+	var imps []string
+	for imp := range ambiguous {
+		imps = append(imps, imp)
+	}
+	sort.Strings(imps)
+
+	Warn("imports below more than one dependency, using the longest match:")
+	for _, imp := range imps {
+		out, matches := rewriteImport(imp, mapping)
+		Warn("  %s -> %s (also below %s)", imp, out, strings.Join(matches[1:], ", "))
+		for _, f := range ambiguous[imp] {
+			Warn("    %s", f)
 		}
-	},
+	}
+}
+
+// binInstallPath returns the directory executables of installed packages
+// go to. Local installs go to the 'bindir' of the current package (its bin
+// directory by default), global ones to the configured 'binDir', GOBIN or
+// GOPATH/bin, in that order.
+func binInstallPath(global bool) (string, error) {
+	if !global {
+		pkg, err := LoadPackageFile(filepath.Join(cwd, gx.PkgFileName))
+		if err == nil && pkg.Gx.BinDir != "" {
+			return filepath.Join(cwd, pkg.Gx.BinDir), nil
+		}
+		return filepath.Join(cwd, toolsDir), nil
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	if cfg.BinDir != "" {
+		return cfg.BinDir, nil
+	}
+
+	if gobin := os.Getenv("GOBIN"); gobin != "" {
+		return gobin, nil
+	}
+
+	gpath, err := getGoPath()
+	if err != nil {
+		return "", fmt.Errorf("GOPATH not set")
+	}
+	return filepath.Join(gpath, "bin"), nil
 }
 
 var postUpdateHookCommand = cli.Command{
@@ -596,12 +1403,33 @@ var postUpdateHookCommand = cli.Command{
 		if len(c.Args()) < 2 {
 			Fatal("must specify two arguments")
 		}
-		before := "gx/ipfs/" + c.Args()[0]
-		after := "gx/ipfs/" + c.Args()[1]
-		err := doUpdate(cwd, before, after)
+		// the hashes may be abbreviated
+		pkg, _ := LoadPackageFile(gx.PkgFileName)
+		pkgdir := filepath.Join(cwd, vendorDir)
+		before, err := expandHash(pkg, pkgdir, c.Args()[0])
+		if err != nil {
+			return err
+		}
+		newHash, err := expandHash(pkg, pkgdir, c.Args()[1])
 		if err != nil {
 			return err
 		}
+		if pkg != nil {
+			for h, reason := range frozenHashes(pkg) {
+				if h == before || h == newHash {
+					name := pkg.FindDep(h).Name
+					return fmt.Errorf("%s, unfreeze it with 'gx-go freeze --rm %s' to update it", frozenNote(name, reason), name)
+				}
+			}
+		}
+
+		after := "gx/ipfs/" + hashPath(newHash)
+		for _, h := range hashPaths(before) {
+			err := doUpdate(cwd, "gx/ipfs/"+h, after)
+			if err != nil {
+				return err
+			}
+		}
 
 		return nil
 	},
@@ -623,21 +1451,137 @@ func packagesGoImport(p string) (string, error) {
 	return p[len(srcdir):], nil
 }
 
-func postImportHook(pkg *Package, npkgHash string) error {
-	var npkg Package
-	err := gx.LoadPackage(&npkg, "go", npkgHash)
+func postImportHook(pkg *Package, hashes []string, mode string) error {
+	if mode == "never" {
+		return nil
+	}
+
+	mapping := make(map[string]string)
+	var imps []string
+	for _, h := range hashes {
+		var npkg Package
+		err := gx.LoadPackage(&npkg, "go", h)
+		if err != nil {
+			return err
+		}
+
+		if npkg.Gx.DvcsImport != "" {
+			mapping[npkg.Gx.DvcsImport] = pkgImport(h, &npkg)
+			imps = append(imps, npkg.Gx.DvcsImport)
+		}
+	}
+
+	if len(imps) == 0 {
+		return nil
+	}
+
+	if mode == "ask" {
+		sort.Strings(imps)
+		q := fmt.Sprintf("update imports of %s to the newly imported packages?", strings.Join(imps, ", "))
+		if !yesNoPrompt("post-import.update", "", q, false) {
+			return nil
+		}
+	}
+
+	return doUpdateMapping(cwd, mapping)
+}
+
+// postInstallHook rewrites the imports of the package newly installed to
+// npkg, a vendor/gx/ipfs/<hash> style directory, and places it for the
+// configured vendor layout
+func postInstallHook(npkg string, global bool) error {
+	// update sub-package refs here
+	// ex:
+	// if this package is 'github.com/X/Y' replace all imports
+	// matching 'github.com/X/Y*' with 'gx/<hash>/name*'
+
+	var pkg Package
+	err := gx.FindPackageInDir(&pkg, npkg)
+	if err != nil {
+		return fmt.Errorf("find package failed: %s", err)
+	}
+
+	dir := filepath.Join(npkg, pkg.Name)
+
+	// build rewrite mapping from parent package if
+	// this call is made on one in the vendor directory
+	reldir := installRootOf(npkg)
+	if reldir == "" {
+		reldir = dir
+	}
+
+	mapping := make(map[string]string)
+	err = buildRewriteMapping(&pkg, reldir, mapping, false)
+	if err != nil {
+		return fmt.Errorf("building rewrite mapping failed: %s", err)
+	}
+	warnCaseCollisions(mapping)
+
+	hash := filepath.Base(npkg)
+	mapping[pkg.Gx.DvcsImport] = pkgImport(hash, &pkg)
+
+	if !dirWritable(dir) {
+		recordInIndex(indexEntry{Import: pkg.Gx.DvcsImport, Name: pkg.Name, Hash: hash, Version: pkg.Version})
+		if readOnlyVendorMode() != "shadow" {
+			Warn("%s is read-only, not rewriting %s, its dvcs imports stay as they are", dir, pkg.Name)
+			Warn("set 'readOnlyVendor' to \"shadow\" in ~/.gx-go/config.json to build from rewritten copies instead")
+			return nil
+		}
+
+		n, err := shadowRewrite(dir, mapping)
+		if err != nil {
+			return fmt.Errorf("shadowing read-only %s: %s", pkg.Name, err)
+		}
+		Log("%s is read-only, wrote %d rewritten files to the shadow tree, build with 'go build -overlay=%s'", pkg.Name, n, buildOverlayFile)
+		return nil
+	}
+
+	// other projects sharing a global install path may be installing the
+	// same package, only one rewrites it
+	unlock, err := lockInstalled(npkg)
 	if err != nil {
 		return err
 	}
+	digest := mappingDigest(mapping)
+	if rewrittenWith(npkg, digest) {
+		VLog("  - %s is already rewritten", pkg.Name)
+	} else {
+		err = doRewrite(&pkg, dir, mapping)
+		if err != nil {
+			unlock()
+			return fmt.Errorf("rewrite failed: %s", err)
+		}
+		warnNestedVendor(pkg.Name, dir)
+		if err := markRewritten(npkg, digest); err != nil {
+			Warn("recording the rewrite of %s: %s", pkg.Name, err)
+		}
+		if err := recordInstalledDigest(npkg); err != nil {
+			Warn("recording the content of %s: %s", pkg.Name, err)
+		}
+	}
+	unlock()
 
-	if npkg.Gx.DvcsImport != "" {
-		q := fmt.Sprintf("update imports of %s to the newly imported package?", npkg.Gx.DvcsImport)
-		if yesNoPrompt(q, false) {
-			nimp := fmt.Sprintf("gx/ipfs/%s/%s", npkgHash, npkg.Name)
-			err := doUpdate(cwd, npkg.Gx.DvcsImport, nimp)
-			if err != nil {
-				return err
-			}
+	recordInIndex(indexEntry{Import: pkg.Gx.DvcsImport, Name: pkg.Name, Hash: hash, Version: pkg.Version})
+
+	placed, err := placeVendored(filepath.Dir(npkg), hash)
+	if err != nil {
+		return fmt.Errorf("placing package in vendor dir: %s", err)
+	}
+
+	if mode := dedupeMode(); mode != "" {
+		saved, err := dedupeDir(placed, mode)
+		if err != nil {
+			Warn("deduplicating %s failed: %s", pkg.Name, err)
+		} else if saved > 0 {
+			Log("saved %s by deduplicating %s", humanSize(saved), pkg.Name)
+		}
+	}
+
+	if !global && pkg.Gx.DvcsImport != "" {
+		// the package is installed, a tool that does not build is no
+		// reason to fail the install
+		if err := installToolsForDep(cwd, pkg.Gx.DvcsImport); err != nil {
+			Warn("installing tools of %s failed: %s", pkg.Name, err)
 		}
 	}
 
@@ -652,8 +1596,18 @@ func reqCheckHook(pkgpath string) error {
 		return err
 	}
 
+	// gx checks packages before adding or installing them, the position in
+	// the tree is not known here
+	pol, err := loadDepPolicy(cwd)
+	if err != nil {
+		return err
+	}
+	if v := pol.check(&npkg, pkgpath, 0); len(v) > 0 {
+		return fmt.Errorf("package '%s' violates the dependency policy in %s: %s", npkg.Name, depPolicyFileName, strings.Join(v, ", "))
+	}
+
 	if npkg.Gx.GoVersion != "" {
-		out, err := exec.Command("go", "version").CombinedOutput()
+		out, err := exec.CommandContext(cancelCtx, "go", "version").CombinedOutput()
 		if err != nil {
 			return fmt.Errorf("no go compiler installed")
 		}
@@ -685,13 +1639,147 @@ func reqCheckHook(pkgpath string) error {
 				return fmt.Errorf("package '%s' requires at least go version %s.\nhowever, your gx-go binary was compiled with %s.\nPlease update gx-go (or recompile with your current go compiler)", npkg.Name, reqvers, gxgocompvers)
 			}
 		} else {
-			Log("gx-go was compiled with an unrecognized version of go. (%s)", gxgocompvers)
-			Log("If you encounter any strange issues during its usage, try rebuilding gx-go with go %s or higher", reqvers)
+			Warn("gx-go was compiled with an unrecognized version of go. (%s)", gxgocompvers)
+			Warn("If you encounter any strange issues during its usage, try rebuilding gx-go with go %s or higher", reqvers)
+		}
+	}
+
+	return checkToolVersions(&npkg)
+}
+
+// versionCheckExempt are the commands that run without checking the tool
+// versions the package requires: the ones that update the tools, and the ones
+// scripts and shells run that must keep working with outdated tools
+var versionCheckExempt = map[string]bool{
+	"":            true,
+	"help":        true,
+	"h":           true,
+	"self-update": true,
+	"bootstrap":   true,
+	"completion":  true,
+	"shim fetch":  true,
+}
+
+// helpRequested returns whether args ask for the help of a command
+func helpRequested(args cli.Args) bool {
+	for _, a := range args {
+		switch a {
+		case "-h", "--help", "--generate-bash-completion":
+			return true
+		}
+	}
+	return false
+}
+
+// localPreamble applies the settings of the package in the current
+// directory, if there is one, and, if check is set, runs the tool version
+// check against it. It is run before every command.
+func localPreamble(check bool) error {
+	pkg, err := LoadPackageFile(filepath.Join(cwd, gx.PkgFileName))
+	if err != nil {
+		// not in a package directory, nothing to check
+		return nil
+	}
+
+	cidFormat = CidFormat{pkg.Gx.CidVersion, pkg.Gx.Multibase}
+	if _, err := formatHash(nil, cidFormat); err != nil {
+		return fmt.Errorf("invalid hash format in package.json: %s", err)
+	}
+
+	if pkg.Gx.VendorLayout != "" {
+		if err := checkLayout(pkg.Gx.VendorLayout); err != nil {
+			return err
+		}
+		vendorLayout = pkg.Gx.VendorLayout
+	}
+
+	warnOverlays()
+
+	if !check {
+		return nil
+	}
+	return checkToolVersions(pkg)
+}
+
+func checkToolVersions(pkg *Package) error {
+	tv := pkg.Gx.ToolVersion
+	if tv == nil {
+		return nil
+	}
+
+	if tv.GxGo != "" {
+		badreq, err := versionComp(GxGoVersion, tv.GxGo)
+		if err != nil {
+			return fmt.Errorf("parsing gx-go version requirement: %s", err)
 		}
+		if badreq {
+			return fmt.Errorf("package '%s' requires at least gx-go version %s, you have %s.\nPlease update gx-go with:\n  gx-go self-update", pkg.Name, tv.GxGo, GxGoVersion)
+		}
+	}
+
+	return checkGxVersion(pkg, tv.Gx)
+}
+
+// checkGxVersion checks the installed gx against the version requirement
+// reqvers of pkg, if any
+func checkGxVersion(pkg *Package, reqvers string) error {
+	if reqvers == "" {
+		return nil
+	}
+	if !gxInstalled() {
+		// commands that need gx fail on their own, the rest work without it
+		WarnOnce("package '%s' requires gx version %s, but gx is not installed, run 'gx-go bootstrap' to install it", pkg.Name, reqvers)
+		return nil
+	}
+
+	havevers, err := gxToolVersion()
+	if err != nil {
+		return err
+	}
+
+	badreq, err := versionComp(havevers, reqvers)
+	if err != nil {
+		return fmt.Errorf("parsing gx version requirement: %s", err)
 	}
+	if badreq {
+		return fmt.Errorf("package '%s' requires at least gx version %s, you have %s installed.\nPlease update gx with:\n  gx-go bootstrap", pkg.Name, reqvers, havevers)
+	}
+
 	return nil
 }
 
+var gxVersion struct {
+	once sync.Once
+	v    string
+	err  error
+}
+
+// gxToolVersion returns the version of the installed gx binary
+func gxToolVersion() (string, error) {
+	gxVersion.once.Do(func() {
+		if !gxInstalled() {
+			gxVersion.err = errNoGx
+			return
+		}
+
+		out, err := exec.Command("gx", "--version").CombinedOutput()
+		if err != nil {
+			gxVersion.err = fmt.Errorf("could not determine gx version (is gx installed?): %s", err)
+			return
+		}
+
+		parts := strings.Fields(string(out))
+		if len(parts) == 0 {
+			gxVersion.err = fmt.Errorf("unrecognized output from gx --version")
+			return
+		}
+
+		gxVersion.v = parts[len(parts)-1]
+	})
+
+	return gxVersion.v, gxVersion.err
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -727,40 +1815,112 @@ func versionComp(have, req string) (bool, error) {
 }
 
 func globalPath() string {
-	gp, _ := getGoPath()
-	return filepath.Join(gp, "src", "gx", "ipfs")
+	root, _ := globalRoot()
+	return filepath.Join(root, "src", "gx", "ipfs")
+}
+
+// resolveDep finds the dependency of pkg referred to by ref, which may be
+// one of its aliases, or the name, hash or an abbreviation of the hash of
+// the dependency. It returns nil if there is no such dependency, and an
+// error if ref abbreviates several.
+func resolveDep(pkg *Package, ref string) (*gx.Dependency, error) {
+	if target, ok := pkg.Gx.Aliases[ref]; ok {
+		ref = target
+	}
+	if dep := pkg.FindDep(ref); dep != nil {
+		return dep, nil
+	}
+
+	h, err := matchHash(depHashes(pkg.Dependencies), ref)
+	if err != nil || h == "" {
+		return nil, err
+	}
+	return pkg.FindDep(h), nil
 }
 
 func loadDep(dep *gx.Dependency, pkgdir string) (*Package, error) {
+	pkg, _, err := findDep(dep, pkgdir)
+	return pkg, err
+}
+
+type foundDep struct {
+	pkg *Package
+	dir string
+}
+
+// foundDeps caches the results of findDep, hooks walk the same packages
+// many times over
+var foundDeps = struct {
+	sync.Mutex
+	m map[string]foundDep
+}{m: make(map[string]foundDep)}
+
+// findDep loads the package of the given dependency, looking in pkgdir and the
+// global namespace, and returns it along with the directory its source is in
+func findDep(dep *gx.Dependency, pkgdir string) (*Package, string, error) {
+	key := pkgdir + "\x00" + dep.Hash
+	foundDeps.Lock()
+	f, ok := foundDeps.m[key]
+	foundDeps.Unlock()
+	if ok {
+		return f.pkg, f.dir, nil
+	}
+
+	pkg, dir, err := findDepUncached(dep, pkgdir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	foundDeps.Lock()
+	foundDeps.m[key] = foundDep{pkg: pkg, dir: dir}
+	foundDeps.Unlock()
+	return pkg, dir, nil
+}
+
+func findDepUncached(dep *gx.Dependency, pkgdir string) (*Package, string, error) {
 	var cpkg Package
-	pdir := filepath.Join(pkgdir, dep.Hash)
 	VLog("  - fetching dep: %s (%s)", dep.Name, dep.Hash)
-	err := gx.FindPackageInDir(&cpkg, pdir)
-	if err != nil {
-		// try global
-		p := filepath.Join(globalPath(), dep.Hash)
+	forms := hashPaths(dep.Hash)
+	for _, h := range forms {
+		pdir := filepath.Join(pkgdir, filepath.FromSlash(h))
+		if err := gx.FindPackageInDir(&cpkg, pdir); err == nil {
+			return &cpkg, filepath.Join(pdir, cpkg.Name), nil
+		}
+	}
+
+	// try global
+	var gerr error
+	for _, h := range forms {
+		p := filepath.Join(globalPath(), filepath.FromSlash(h))
 		VLog("  - checking in global namespace (%s)", p)
-		gerr := gx.FindPackageInDir(&cpkg, p)
-		if gerr != nil {
-			return nil, fmt.Errorf("failed to find package: %s", gerr)
+		gerr = gx.FindPackageInDir(&cpkg, p)
+		if gerr == nil {
+			return &cpkg, filepath.Join(p, cpkg.Name), nil
 		}
 	}
 
-	return &cpkg, nil
+	return nil, "", fmt.Errorf("failed to find package: %s", gerr)
 }
 
 func addRewriteForDep(dep *gx.Dependency, pkg *Package, m map[string]string, undo bool) {
 	if pkg.Gx.DvcsImport != "" {
-		from := pkg.Gx.DvcsImport
-		to := "gx/ipfs/" + dep.Hash + "/" + pkg.Name
 		if undo {
-			from, to = to, from
+			// undo rewrites done with any hash format
+			for _, h := range hashPaths(dep.Hash) {
+				m[withRoot("gx/ipfs/"+h+"/"+pkg.Name, pkg)] = pkg.Gx.DvcsImport
+			}
+			return
 		}
-		m[from] = to
+
+		m[pkg.Gx.DvcsImport] = pkgImport(dep.Hash, pkg)
 	}
 }
 
 func buildRewriteMapping(pkg *Package, pkgdir string, m map[string]string, undo bool) error {
+	return buildRewriteMappingSeen(pkg, pkgdir, m, undo, make(map[string]bool))
+}
+
+func buildRewriteMappingSeen(pkg *Package, pkgdir string, m map[string]string, undo bool, seen map[string]bool) error {
 	for _, dep := range pkg.Dependencies {
 		cpkg, err := loadDep(dep, pkgdir)
 		if err != nil {
@@ -769,8 +1929,14 @@ func buildRewriteMapping(pkg *Package, pkgdir string, m map[string]string, undo
 
 		addRewriteForDep(dep, cpkg, m, undo)
 
+		// the subtree of a package only has to be walked once
+		if seen[dep.Hash] {
+			continue
+		}
+		seen[dep.Hash] = true
+
 		// recurse!
-		err = buildRewriteMapping(cpkg, pkgdir, m, undo)
+		err = buildRewriteMappingSeen(cpkg, pkgdir, m, undo, seen)
 		if err != nil {
 			return err
 		}
@@ -780,9 +1946,20 @@ func buildRewriteMapping(pkg *Package, pkgdir string, m map[string]string, undo
 }
 
 func buildMap(pkg *Package, m map[string]string) error {
+	return buildMapDepth(pkg, m, 0)
+}
+
+// buildMapDepth is buildMap, only descending depth levels into the tree,
+// or all of it if depth is 0
+func buildMapDepth(pkg *Package, m map[string]string, depth int) error {
+	prefetchDeps(pkg, vendorDir, depth)
+	return buildMapLevel(pkg, m, depth)
+}
+
+// buildMapLevel is the walk of buildMapDepth, over the prefetched tree
+func buildMapLevel(pkg *Package, m map[string]string, depth int) error {
 	for _, dep := range pkg.Dependencies {
-		var ch Package
-		err := gx.FindPackageInDir(&ch, filepath.Join(vendorDir, dep.Hash))
+		ch, _, err := findDep(dep, vendorDir)
 		if err != nil {
 			return err
 		}
@@ -791,16 +1968,22 @@ func buildMap(pkg *Package, m map[string]string) error {
 			e, ok := m[ch.Gx.DvcsImport]
 			if ok {
 				if e != dep.Hash {
-					Log("have two dep packages with same import path: ", ch.Gx.DvcsImport)
-					Log("  - ", e)
-					Log("  - ", dep.Hash)
+					WarnOnce("have two dep packages with same import path: %s\n  - %s\n  - %s", ch.Gx.DvcsImport, e, dep.Hash)
 				}
 				continue
 			}
 			m[ch.Gx.DvcsImport] = dep.Hash
 		}
 
-		err = buildMap(&ch, m)
+		if depth == 1 {
+			continue
+		}
+		next := depth
+		if depth > 0 {
+			next--
+		}
+
+		err = buildMapLevel(ch, m, next)
 		if err != nil {
 			return err
 		}
@@ -808,6 +1991,23 @@ func buildMap(pkg *Package, m map[string]string) error {
 	return nil
 }
 
+// warnCaseCollisions warns about dvcs imports in the mapping that differ
+// only by case
+func warnCaseCollisions(mapping map[string]string) {
+	var imps []string
+	for k := range mapping {
+		imps = append(imps, k)
+	}
+
+	for _, g := range caseCollisions(imps) {
+		msg := "dependencies with import paths differing only by case will break on case insensitive filesystems:"
+		for _, imp := range g {
+			msg += fmt.Sprintf("\n  - %s (%s)", imp, mapping[imp])
+		}
+		WarnOnce("%s\nconsider updating dependents to use a single spelling with 'gx-go update'", msg)
+	}
+}
+
 func loadMap(i interface{}, file string) error {
 	fi, err := os.Open(file)
 	if err != nil {
@@ -837,8 +2037,25 @@ func tabPrintSortedMap(headers []string, m map[string]string) {
 	w.Flush()
 }
 
+// goEnvGoPath caches the GOPATH the go tool uses when it is not set in the
+// environment
+var goEnvGoPath struct {
+	sync.Once
+	gp string
+}
+
+// getGoPath returns the first entry of the GOPATH, the default the go tool
+// uses if GOPATH is not set
 func getGoPath() (string, error) {
 	gp := os.Getenv("GOPATH")
+	if gp == "" {
+		goEnvGoPath.Do(func() {
+			if out, err := exec.Command("go", "env", "GOPATH").Output(); err == nil {
+				goEnvGoPath.gp = strings.TrimSpace(string(out))
+			}
+		})
+		gp = goEnvGoPath.gp
+	}
 	if gp == "" {
 		return "", fmt.Errorf("GOPATH not set")
 	}
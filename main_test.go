@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testMapping = map[string]string{
+	"github.com/x/y":         "gx/ipfs/QmY/y",
+	"github.com/x/y/z":       "gx/ipfs/QmZ/z",
+	"github.com/x/yy":        "gx/ipfs/QmYY/yy",
+	"golang.org/x/net/trace": "gx/ipfs/QmT/trace",
+}
+
+func TestRewriteImport(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		out     string
+		matches []string
+	}{
+		{"exact", "github.com/x/y", "gx/ipfs/QmY/y", nil},
+		{"exact below another", "github.com/x/y/z", "gx/ipfs/QmZ/z", nil},
+		{"prefix", "github.com/x/yy/sub", "gx/ipfs/QmYY/yy/sub", []string{"github.com/x/yy"}},
+		{"ambiguous, longest wins", "github.com/x/y/z/sub", "gx/ipfs/QmZ/z/sub", []string{"github.com/x/y/z", "github.com/x/y"}},
+		{"below the shorter only", "github.com/x/y/other", "gx/ipfs/QmY/y/other", []string{"github.com/x/y"}},
+		{"not a path prefix", "github.com/x/yz", "github.com/x/yz", nil},
+		{"parent of a mapped path", "golang.org/x/net", "golang.org/x/net", nil},
+		{"unmapped", "fmt", "fmt", nil},
+	}
+
+	for _, c := range cases {
+		out, matches := rewriteImport(c.in, testMapping)
+		if out != c.out {
+			t.Errorf("%s: rewriteImport(%q) = %q, want %q", c.name, c.in, out, c.out)
+		}
+		if !reflect.DeepEqual(matches, c.matches) {
+			t.Errorf("%s: rewriteImport(%q) matched %q, want %q", c.name, c.in, matches, c.matches)
+		}
+	}
+}
+
+func TestGxImportFor(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{"github.com/x/y", "gx/ipfs/QmY/y", true},
+		{"github.com/x/y/z/sub", "gx/ipfs/QmZ/z/sub", true},
+		{"github.com/x/yz", "", false},
+	}
+
+	for _, c := range cases {
+		out, ok := gxImportFor(testMapping, c.in)
+		if out != c.out || ok != c.ok {
+			t.Errorf("gxImportFor(%q) = %q, %t, want %q, %t", c.in, out, ok, c.out, c.ok)
+		}
+	}
+}
+
+func TestReplacePaths(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{`"github.com/x/y"`, `"gx/ipfs/QmY/y"`},
+		{`"github.com/x/y/z/sub"`, `"gx/ipfs/QmZ/z/sub"`},
+		{`*github.com/x/y.Type`, `*gx/ipfs/QmY/y.Type`},
+		{`see github.com/x/y/z.`, `see gx/ipfs/QmZ/z.`},
+		{`github.com/x/yz and github.com/x/yy`, `github.com/x/yz and gx/ipfs/QmYY/yy`},
+		{`mygithub.com/x/y`, `mygithub.com/x/y`},
+	}
+
+	for _, c := range cases {
+		if out := replacePaths(c.in, testMapping); out != c.out {
+			t.Errorf("replacePaths(%q) = %q, want %q", c.in, out, c.out)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// manifestEntry is a package to import, listed in an 'import --manifest'
+// file, optionally at a given vcs ref
+type manifestEntry struct {
+	Import string
+	Ref    string
+}
+
+// loadManifest reads an import manifest: one import path per line,
+// optionally followed by a ref to check out. Blank lines and lines starting
+// with '#' are ignored.
+func loadManifest(file string) ([]manifestEntry, error) {
+	fi, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	var out []manifestEntry
+	s := bufio.NewScanner(fi)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected an import path and an optional ref", file, n)
+		}
+
+		e := manifestEntry{Import: fields[0]}
+		if len(fields) == 2 {
+			e.Ref = fields[1]
+		}
+		out = append(out, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s lists no packages", file)
+	}
+	return out, nil
+}
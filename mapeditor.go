@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// editMapEntry asks what to do with the dependency child, which the map has
+// no entry for: publish it, use an already published hash, use another
+// import in its place or skip it. The answer is recorded in the map file.
+func (i *Importer) editMapEntry(child string) error {
+	base := getBaseDVCS(child)
+	if i.yesall || i.pkgs[base] != nil {
+		return nil
+	}
+	if _, ok := i.preMap[child]; ok {
+		return nil
+	}
+	if _, ok := i.preMap[base]; ok {
+		return nil
+	}
+
+	q := fmt.Sprintf("%s is not in the map: publish it, use an existing (h)ash, (r)eplace it with another import or (s)kip it?", base)
+	for {
+		a, err := prompt("import.map", base, q, "publish")
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(a)) {
+		case "p", "publish":
+			return nil
+		case "s", "skip":
+			return i.recordMapEntry(base, skipHash)
+		case "h", "hash":
+			hash, err := prompt("import.map-hash", base, fmt.Sprintf("hash of the package to use for %s:", base), "")
+			if err != nil {
+				return err
+			}
+			if hash = strings.TrimSpace(hash); hash == "" || strings.Contains(hash, "/") {
+				return fmt.Errorf("%q is not a package hash", hash)
+			}
+			return i.recordMapEntry(base, hash)
+		case "r", "replace":
+			repl, err := prompt("import.map-replace", base, fmt.Sprintf("import to use in place of %s:", base), "")
+			if err != nil {
+				return err
+			}
+			if repl = strings.TrimSpace(repl); repl == "" || getBaseDVCS(repl) == base {
+				return fmt.Errorf("%q cannot replace %s", repl, base)
+			}
+
+			dep, err := i.GxPublishGoPackage(repl)
+			if err != nil {
+				return err
+			}
+			i.pkgs[base] = dep
+			return i.recordMapEntry(base, dep.Hash)
+		}
+
+		if _, ok := answerFor("import.map", base); ok {
+			return fmt.Errorf("answer %q to prompt %q in %s is not publish, hash, replace or skip", a, "import.map", answersFile)
+		}
+		fmt.Println("please type 'p', 'h', 'r' or 's'")
+	}
+}
+
+// recordMapEntry maps imp to hash for the rest of the import, and adds the
+// entry to the map file
+func (i *Importer) recordMapEntry(imp, hash string) error {
+	i.preMap[imp] = hash
+
+	m := make(map[string]string)
+	if err := loadMap(&m, i.mapFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("loading %s: %s", i.mapFile, err)
+	}
+	m[imp] = hash
+
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(i.mapFile, append(out, '\n'), 0644); err != nil {
+		return err
+	}
+	VLog("  - recorded %s -> %s in %s", imp, hash, i.mapFile)
+	return nil
+}
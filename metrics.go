@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// metricsLockTimeout is how long a run waits for others to finish adding
+// their measurements to the metrics file
+const metricsLockTimeout = 10 * time.Second
+
+// metricsSink receives how long operations took and how often things
+// happened in a run. Names are like "fetch" or "cache_hits", every sink
+// qualifies them its own way.
+type metricsSink interface {
+	timing(name string, d time.Duration, labels []string)
+	count(name string, n int, labels []string)
+	close() error
+}
+
+// metrics is where the measurements of this run go, nil if nowhere
+var metrics metricsSink
+
+// runCommand is the command this run is for, like "deps stats", and
+// runStart when it started, for the timing closeMetrics records
+var (
+	runCommand string
+	runStart   = time.Now()
+)
+
+// setupMetrics sends the measurements of the run to dest, a statsd address
+// as statsd://host:port, or a file the run adds its measurements to in the
+// OpenMetrics text format. Without dest, the metrics set in the config are
+// used, if any.
+func setupMetrics(dest string) error {
+	if dest == "" {
+		if cfg, err := loadConfig(); err == nil {
+			dest = cfg.Metrics
+		}
+	}
+
+	switch {
+	case dest == "":
+		return nil
+	case strings.HasPrefix(dest, "statsd://"):
+		conn, err := net.Dial("udp", strings.TrimPrefix(dest, "statsd://"))
+		if err != nil {
+			return fmt.Errorf("metrics: %s", err)
+		}
+		metrics = &statsdSink{conn: conn}
+	default:
+		metrics = &fileSink{path: dest, values: make(map[string]float64)}
+	}
+	return nil
+}
+
+// closeMetrics records the duration of the command the run was for and
+// flushes the measurements. Later calls do nothing.
+func closeMetrics(err error) {
+	if metrics == nil {
+		return
+	}
+	command := runCommand
+	if command == "" {
+		command = "help"
+	}
+	metrics.timing("command", time.Since(runStart), []string{"command", command, "result", metricResult(err)})
+
+	m := metrics
+	metrics = nil
+	if err := m.close(); err != nil {
+		Warn("writing metrics: %s", err)
+	}
+}
+
+// startTimer starts timing the operation name, labeled with the given
+// key, value pairs. The returned function records its duration and whether
+// it failed.
+func startTimer(name string, labels ...string) func(error) {
+	if metrics == nil {
+		return func(error) {}
+	}
+	start := time.Now()
+	return func(err error) {
+		metrics.timing(name, time.Since(start), append(labels, "result", metricResult(err)))
+	}
+}
+
+// countMetric adds n to the counter name, labeled with the given key, value
+// pairs
+func countMetric(name string, n int, labels ...string) {
+	if metrics != nil {
+		metrics.count(name, n, labels)
+	}
+}
+
+func metricResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// meteredStore times the fetches and publishes of a packageStore
+type meteredStore struct {
+	packageStore
+}
+
+func (s meteredStore) GetPackageTo(hash, out string) (*gx.Package, error) {
+	stop := startTimer("fetch", "source", "gx")
+	pkg, err := s.packageStore.GetPackageTo(hash, out)
+	stop(err)
+	return pkg, err
+}
+
+func (s meteredStore) PublishPackage(dir string, pkg *gx.PackageBase) (string, error) {
+	stop := startTimer("publish")
+	hash, err := s.packageStore.PublishPackage(dir, pkg)
+	stop(err)
+	return hash, err
+}
+
+// statsdSink sends every measurement to a statsd server as it is made, with
+// labels as tags in the format of the datadog and telegraf servers
+type statsdSink struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (s *statsdSink) timing(name string, d time.Duration, labels []string) {
+	s.send(fmt.Sprintf("gx_go.%s:%d|ms%s", name, d/time.Millisecond, statsdTags(labels)))
+}
+
+func (s *statsdSink) count(name string, n int, labels []string) {
+	s.send(fmt.Sprintf("gx_go.%s:%d|c%s", name, n, statsdTags(labels)))
+}
+
+func (s *statsdSink) send(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// metrics are best effort, a missing server must not fail the command
+	s.conn.Write([]byte(line))
+}
+
+func (s *statsdSink) close() error {
+	return s.conn.Close()
+}
+
+func statsdTags(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var tags []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		tags = append(tags, labels[i]+":"+labels[i+1])
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+// fileSink adds up the measurements of a run, and adds them to those in its
+// file when the run ends. Timings are summaries in seconds, counts
+// counters, so a collector scraping the file sees totals growing over the
+// runs.
+type fileSink struct {
+	mu     sync.Mutex
+	path   string
+	values map[string]float64
+}
+
+func (s *fileSink) timing(name string, d time.Duration, labels []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fam := "gx_go_" + name + "_seconds"
+	s.values[fam+"_sum"+openMetricsLabels(labels)] += d.Seconds()
+	s.values[fam+"_count"+openMetricsLabels(labels)]++
+}
+
+func (s *fileSink) count(name string, n int, labels []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values["gx_go_"+name+"_total"+openMetricsLabels(labels)] += float64(n)
+}
+
+func (s *fileSink) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) == 0 {
+		return nil
+	}
+
+	unlock, err := waitLockDir(s.path, metricsLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	prev, err := readOpenMetrics(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading %s: %s", s.path, err)
+	}
+	for k, v := range s.values {
+		prev[k] += v
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".metrics")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	writeOpenMetrics(w, prev)
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// openMetricsLabels formats key, value pairs as the label set of a sample,
+// sorted by key
+func openMetricsLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"="+strconv.Quote(labels[i+1]))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// readOpenMetrics reads the samples of a file written by writeOpenMetrics,
+// by name and labels
+func readOpenMetrics(path string) (map[string]float64, error) {
+	values := make(map[string]float64)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return values, err
+	}
+
+	for _, l := range strings.Split(string(data), "\n") {
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		i := strings.LastIndex(l, " ")
+		if i < 0 {
+			return values, fmt.Errorf("malformed sample %q", l)
+		}
+		v, err := strconv.ParseFloat(l[i+1:], 64)
+		if err != nil {
+			return values, fmt.Errorf("malformed sample %q", l)
+		}
+		values[l[:i]] = v
+	}
+	return values, nil
+}
+
+// writeOpenMetrics writes samples in the OpenMetrics text format, grouped
+// into their metric families
+func writeOpenMetrics(w *bufio.Writer, values map[string]float64) {
+	families := make(map[string][]string)
+	for k := range values {
+		fam := strings.SplitN(k, "{", 2)[0]
+		for _, suffix := range []string{"_total", "_sum", "_count"} {
+			if strings.HasSuffix(fam, suffix) {
+				fam = strings.TrimSuffix(fam, suffix)
+				break
+			}
+		}
+		families[fam] = append(families[fam], k)
+	}
+
+	var names []string
+	for fam := range families {
+		names = append(names, fam)
+	}
+	sort.Strings(names)
+
+	for _, fam := range names {
+		if strings.HasSuffix(fam, "_seconds") {
+			fmt.Fprintf(w, "# TYPE %s summary\n", fam)
+			fmt.Fprintf(w, "# UNIT %s seconds\n", fam)
+		} else {
+			fmt.Fprintf(w, "# TYPE %s counter\n", fam)
+		}
+
+		samples := families[fam]
+		sort.Strings(samples)
+		for _, k := range samples {
+			fmt.Fprintf(w, "%s %s\n", k, strconv.FormatFloat(values[k], 'g', -1, 64))
+		}
+	}
+	fmt.Fprintln(w, "# EOF")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// nestedVendorDirs returns the vendor directories a package in dir ships,
+// relative to dir. Their copies of dependencies shadow the gx managed ones
+// for the code next to them. Vendor directories holding nothing but gx
+// packages are not included.
+func nestedVendorDirs(dir string) []string {
+	var out []string
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		if isVcsMetaDir(fi.Name()) {
+			return filepath.SkipDir
+		}
+		if fi.Name() != "vendor" || p == dir {
+			return nil
+		}
+
+		ents, err := ioutil.ReadDir(p)
+		if err != nil {
+			return filepath.SkipDir
+		}
+		for _, e := range ents {
+			if e.Name() != "gx" {
+				rel, _ := filepath.Rel(dir, p)
+				out = append(out, filepath.ToSlash(rel))
+				break
+			}
+		}
+		return filepath.SkipDir
+	})
+	return out
+}
+
+// vendorIgnores returns gxignore patterns excluding the given vendor
+// directories from publishing
+func vendorIgnores(dirs []string) []string {
+	var out []string
+	for _, d := range dirs {
+		out = append(out, d+"/*")
+	}
+	return out
+}
+
+// warnNestedVendor warns about the vendor directories shipped by the
+// package name in dir
+func warnNestedVendor(name, dir string) {
+	dirs := nestedVendorDirs(dir)
+	if len(dirs) == 0 {
+		return
+	}
+	Warn("%s ships vendor directories that shadow gx dependencies and may break builds:\n  - %s", name, strings.Join(dirs, "\n  - "))
+}
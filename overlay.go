@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// localStateDir holds per package state of gx-go that is not meant to be
+// committed
+const localStateDir = ".gx"
+
+// overlay replaces the vendored copy of a dependency with a local directory
+type overlay struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+
+	// Vendored is where the vendored copy is, relative to the vendor dir
+	Vendored string `json:"vendored"`
+}
+
+func overlaysPath() string {
+	return filepath.Join(cwd, localStateDir, "overlays.json")
+}
+
+// loadOverlays returns the active overlays of the current package, by hash
+func loadOverlays() (map[string]*overlay, error) {
+	out := make(map[string]*overlay)
+	err := loadMap(&out, overlaysPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading overlays: %s", err)
+	}
+	return out, nil
+}
+
+func saveOverlays(ovs map[string]*overlay) error {
+	p := overlaysPath()
+	if len(ovs) == 0 {
+		err := os.Remove(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(ovs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, out, 0644)
+}
+
+// overlaidDir is where the vendored copy of an overlaid package is kept
+func overlaidDir(hash string) string {
+	return filepath.Join(cwd, localStateDir, "overlaid", hash)
+}
+
+var OverlayCommand = cli.Command{
+	Name:  "overlay",
+	Usage: "temporarily use a local directory in place of a dependency",
+	Description: `replaces the vendored copy of a dependency with a link to a local
+directory, without touching package.json, so changes to the dependency can
+be tried out before publishing them. Rewrite, build and test commands see
+the local directory in place of the dependency until the overlay is
+removed.
+
+The local directory must be a gx package whose imports are rewritten, as
+with any other gx development checkout.
+
+Overlays stay in place until removed with 'overlay rm'. 'overlay exec'
+overlays a dependency for a single command only.`,
+	Subcommands: []cli.Command{
+		overlayAddCommand,
+		overlayRmCommand,
+		overlayExecCommand,
+		overlayLsCommand,
+	},
+	Action: func(c *cli.Context) error { return nil },
+}
+
+var overlayAddCommand = cli.Command{
+	Name:      "add",
+	Usage:     "overlay a dependency with a local directory",
+	ArgsUsage: "<dep> <dir>",
+	Action: func(c *cli.Context) error {
+		if len(c.Args()) < 2 {
+			return fmt.Errorf("must specify a dependency and a directory")
+		}
+
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		_, err = addOverlay(pkg, c.Args()[0], c.Args()[1])
+		return err
+	},
+}
+
+var overlayRmCommand = cli.Command{
+	Name:      "rm",
+	Usage:     "restore the vendored copy of an overlaid dependency",
+	ArgsUsage: "<dep>",
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify a dependency")
+		}
+
+		hash := c.Args().First()
+		if pkg, err := LoadPackageFile(gx.PkgFileName); err == nil {
+			dep, err := resolveDep(pkg, hash)
+			if err != nil {
+				return err
+			}
+			if dep != nil {
+				hash = dep.Hash
+			}
+		}
+
+		return removeOverlay(hash)
+	},
+}
+
+var overlayExecCommand = cli.Command{
+	Name:      "exec",
+	Usage:     "run a command with a dependency overlaid for its duration",
+	ArgsUsage: "<dep> <dir> -- <command> [args...]",
+	Description: `overlays the dependency with the local directory like 'overlay add',
+runs the command and removes the overlay again, also if the command fails
+or gx-go is interrupted.`,
+	Action: func(c *cli.Context) error {
+		args := []string(c.Args())
+		if len(args) > 2 && args[2] == "--" {
+			args = append(args[:2:2], args[3:]...)
+		}
+		if len(args) < 3 {
+			return fmt.Errorf("must specify a dependency, a directory and a command")
+		}
+
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		hash, err := addOverlay(pkg, args[0], args[1])
+		if err != nil {
+			return err
+		}
+		restore := func() {
+			if err := removeOverlay(hash); err != nil {
+				Error("failed to remove overlay of %s: %s", args[0], err)
+			}
+		}
+		done := onForcedExit(restore)
+		defer func() {
+			done()
+			restore()
+		}()
+
+		cmd := exec.Command(args[2], args[3:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	},
+}
+
+// addOverlay overlays the dependency of pkg given by ref with the package in
+// dir, returning its hash
+func addOverlay(pkg *Package, ref, dir string) (string, error) {
+	dep, err := resolveDep(pkg, ref)
+	if err != nil {
+		return "", err
+	}
+	if dep == nil {
+		return "", fmt.Errorf("%s not found", ref)
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var lpkg Package
+	if err := gx.LoadPackageFile(&lpkg, filepath.Join(dir, gx.PkgFileName)); err != nil {
+		return "", fmt.Errorf("%s is not a gx package: %s", dir, err)
+	}
+	if lpkg.Name != dep.Name {
+		Warn("%s holds %s, not %s", dir, lpkg.Name, dep.Name)
+	}
+
+	ovs, err := loadOverlays()
+	if err != nil {
+		return "", err
+	}
+	if o, ok := ovs[dep.Hash]; ok {
+		return "", fmt.Errorf("%s is already overlaid with %s", dep.Name, o.Dir)
+	}
+
+	pkgdir := filepath.Join(cwd, vendorDir)
+	var vendored string
+	for _, h := range hashPaths(dep.Hash) {
+		rel := filepath.Join(filepath.FromSlash(h), dep.Name)
+		if _, err := os.Stat(filepath.Join(pkgdir, rel)); err == nil {
+			vendored = rel
+			break
+		}
+	}
+	if vendored == "" {
+		return "", fmt.Errorf("%s is not vendored, run 'gx install' first", dep.Name)
+	}
+
+	keep := overlaidDir(dep.Hash)
+	if err := os.MkdirAll(keep, 0755); err != nil {
+		return "", err
+	}
+	link := filepath.Join(pkgdir, vendored)
+	kept := filepath.Join(keep, dep.Name)
+	if err := os.Rename(link, kept); err != nil {
+		return "", err
+	}
+	if err := os.Symlink(dir, link); err != nil {
+		// put the vendored copy back rather than strand it in the keep dir
+		if rerr := os.Rename(kept, link); rerr != nil {
+			return "", fmt.Errorf("%s, and moving %s back failed: %s", err, kept, rerr)
+		}
+		os.Remove(keep)
+		return "", err
+	}
+
+	ovs[dep.Hash] = &overlay{Name: dep.Name, Dir: dir, Vendored: filepath.ToSlash(vendored)}
+	Log("overlaid %s with %s", dep.Name, dir)
+	return dep.Hash, saveOverlays(ovs)
+}
+
+// removeOverlay restores the vendored copy of the overlaid dependency with
+// the given hash
+func removeOverlay(hash string) error {
+	ovs, err := loadOverlays()
+	if err != nil {
+		return err
+	}
+
+	o, ok := ovs[hash]
+	if !ok {
+		return fmt.Errorf("%s is not overlaid", hash)
+	}
+
+	link := filepath.Join(cwd, vendorDir, filepath.FromSlash(o.Vendored))
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(filepath.Join(overlaidDir(hash), o.Name), link); err != nil {
+		return err
+	}
+	delete(ovs, hash)
+	if err := saveOverlays(ovs); err != nil {
+		return err
+	}
+	Log("restored %s", o.Name)
+
+	// these fail on non-empty directories, which is what we want
+	os.Remove(overlaidDir(hash))
+	os.Remove(filepath.Dir(overlaidDir(hash)))
+	os.Remove(filepath.Join(cwd, localStateDir))
+	return nil
+}
+
+var overlayLsCommand = cli.Command{
+	Name:  "ls",
+	Usage: "list active overlays",
+	Flags: []cli.Flag{formatFlag},
+	Action: func(c *cli.Context) error {
+		ovs, err := loadOverlays()
+		if err != nil {
+			return err
+		}
+
+		var rows [][]string
+		for _, h := range overlaidHashes(ovs) {
+			rows = append(rows, []string{ovs[h].Name, h, ovs[h].Dir})
+		}
+		return writeTable(os.Stdout, c.String("format"), []string{"name", "hash", "dir"}, rows)
+	},
+}
+
+// warnOverlays reminds the user of the overlays active in the current package
+func warnOverlays() {
+	ovs, err := loadOverlays()
+	if err != nil {
+		return
+	}
+
+	for _, o := range ovs {
+		Warn("%s is overlaid with %s", o.Name, o.Dir)
+	}
+}
+
+// noOverridesFlag makes a checking command look at the vendored copies of
+// overlaid dependencies, see overlaysFor
+var noOverridesFlag = cli.BoolFlag{
+	Name:  "no-overrides",
+	Usage: "restore the vendored copy of every overlaid dependency while checking",
+}
+
+// overlaysFor returns the overlays active for the command, which are none
+// if it was run with --no-overrides. In that case the overlays are lifted
+// until restore is called.
+func overlaysFor(c *cli.Context) (map[string]*overlay, func(), error) {
+	ovs, err := loadOverlays()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !c.Bool("no-overrides") || len(ovs) == 0 {
+		return ovs, func() {}, nil
+	}
+
+	pkgdir := filepath.Join(cwd, vendorDir)
+	var lifted []string
+	restore := func() {
+		for _, h := range lifted {
+			o := ovs[h]
+			link := filepath.Join(pkgdir, filepath.FromSlash(o.Vendored))
+			if err := os.Rename(link, filepath.Join(overlaidDir(h), o.Name)); err != nil {
+				Error("failed to restore overlay of %s: %s", o.Name, err)
+				continue
+			}
+			if err := os.Symlink(o.Dir, link); err != nil {
+				Error("failed to restore overlay of %s: %s", o.Name, err)
+			}
+		}
+	}
+
+	for h, o := range ovs {
+		link := filepath.Join(pkgdir, filepath.FromSlash(o.Vendored))
+		if err := os.Remove(link); err != nil {
+			restore()
+			return nil, nil, fmt.Errorf("lifting overlay of %s: %s", o.Name, err)
+		}
+		if err := os.Rename(filepath.Join(overlaidDir(h), o.Name), link); err != nil {
+			os.Symlink(o.Dir, link)
+			restore()
+			return nil, nil, fmt.Errorf("lifting overlay of %s: %s", o.Name, err)
+		}
+		lifted = append(lifted, h)
+		VLog("  - using the vendored copy of %s", o.Name)
+	}
+
+	done := onForcedExit(restore)
+	return make(map[string]*overlay), func() {
+		done()
+		restore()
+	}, nil
+}
+
+// overlaidHashes returns the hashes of the overlaid dependencies, sorted
+func overlaidHashes(ovs map[string]*overlay) []string {
+	var hashes []string
+	for h := range ovs {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// overriddenNames returns the names of the overlaid dependencies, sorted
+func overriddenNames(ovs map[string]*overlay) []string {
+	var names []string
+	for _, o := range ovs {
+		names = append(names, o.Name)
+	}
+	sort.Strings(names)
+	return names
+}
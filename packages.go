@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// findPackages returns the directories at or below root that hold a gx
+// package, skipping vendor and hidden directories
+func findPackages(root string) ([]string, error) {
+	var out []string
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			return nil
+		}
+
+		name := fi.Name()
+		if p != root && (name == "vendor" || name == "node_modules" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+
+		if _, err := os.Stat(filepath.Join(p, gx.PkgFileName)); err == nil {
+			out = append(out, p)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// nestedPackages returns the paths, relative to dir, of the packages below
+// dir that are independent of the package in dir
+func nestedPackages(dir string) []string {
+	dirs, err := findPackages(dir)
+	if err != nil {
+		return nil
+	}
+
+	var out []string
+	for _, d := range dirs {
+		if d == dir {
+			continue
+		}
+		rel, err := filepath.Rel(dir, d)
+		if err != nil {
+			continue
+		}
+		out = append(out, filepath.ToSlash(rel))
+	}
+	return out
+}
+
+// inNested returns whether the relative path rel lies in one of nested
+func inNested(nested []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, n := range nested {
+		if rel == n || strings.HasPrefix(rel, n+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// forEachPackage runs f on the package in the current directory, or, if
+// there is none, on every package found below it
+func forEachPackage(f func(dir string) error) error {
+	if _, err := os.Stat(filepath.Join(cwd, gx.PkgFileName)); err == nil {
+		return f(cwd)
+	}
+
+	dirs, err := findPackages(cwd)
+	if err != nil {
+		return err
+	}
+
+	if len(dirs) == 0 {
+		return fmt.Errorf("no %s found in %s or below", gx.PkgFileName, cwd)
+	}
+
+	for _, d := range dirs {
+		if err := cancelled(); err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(cwd, d)
+		Log("%s:", rel)
+		if err := f(d); err != nil {
+			return fmt.Errorf("%s: %s", rel, err)
+		}
+	}
+	return nil
+}
+
+var LsPackagesCommand = cli.Command{
+	Name:  "ls-packages",
+	Usage: "list the gx packages in the current repository",
+	Description: `lists every directory at or below the current one holding a
+package.json, along with the name and dvcs import of its package.`,
+	Flags: []cli.Flag{formatFlag},
+	Action: func(c *cli.Context) error {
+		dirs, err := findPackages(cwd)
+		if err != nil {
+			return err
+		}
+
+		var rows [][]string
+		for _, d := range dirs {
+			pkg, err := LoadPackageFile(filepath.Join(d, gx.PkgFileName))
+			if err != nil {
+				return err
+			}
+
+			rel, _ := filepath.Rel(cwd, d)
+			rows = append(rows, []string{rel, pkg.Name, pkg.Version, pkg.Gx.DvcsImport})
+		}
+
+		return writeTable(os.Stdout, c.String("format"), []string{"dir", "name", "version", "import"}, rows)
+	},
+}
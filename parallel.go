@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// parallelJobs bounds how many packages or directories are read at once
+// when walking dependency trees and source trees
+var parallelJobs = 8
+
+// parallel calls f for every i in [0, n) on up to parallelJobs goroutines,
+// and returns the error of the lowest i that failed
+func parallel(n int, f func(i int) error) error {
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelJobs && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = f(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		if cancelled() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if err := cancelled(); err != nil {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefetchDeps loads the packages of the dependency tree of pkg into the
+// findDep cache, a level of the tree at a time, so the serial walks that
+// follow do not wait on the filesystem. Only depth levels are loaded, or
+// the whole tree if depth is 0. Failures are left for the walks to report.
+func prefetchDeps(pkg *Package, pkgdir string, depth int) {
+	seen := make(map[string]bool)
+	var level []*gx.Dependency
+	for _, d := range pkg.Dependencies {
+		if !seen[d.Hash] {
+			seen[d.Hash] = true
+			level = append(level, d)
+		}
+	}
+
+	for n := 1; len(level) > 0; n++ {
+		pkgs := make([]*Package, len(level))
+		parallel(len(level), func(i int) error {
+			pkgs[i], _, _ = findDep(level[i], pkgdir)
+			return nil
+		})
+
+		if n == depth {
+			return
+		}
+
+		var next []*gx.Dependency
+		for _, p := range pkgs {
+			if p == nil {
+				continue
+			}
+			for _, d := range p.Dependencies {
+				if !seen[d.Hash] {
+					seen[d.Hash] = true
+					next = append(next, d)
+				}
+			}
+		}
+		level = next
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// savePackageFile writes pkg to the package file at p, like
+// gx.SavePackageFile, but edits the file in place if there is one: fields
+// keep their order and formatting, and only the values that changed are
+// written anew, so updating a dependency changes the lines it is on.
+func savePackageFile(pkg interface{}, p string) error {
+	data, err := json.MarshalIndent(pkg, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	old, err := ioutil.ReadFile(p)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		return ioutil.WriteFile(p, data, 0644)
+	}
+
+	out, err := editJSON(old, data)
+	if err != nil {
+		VLog("  - not preserving the formatting of %s: %s", p, err)
+		out = data
+	}
+	if bytes.Equal(out, old) {
+		return nil
+	}
+	return ioutil.WriteFile(p, out, 0644)
+}
+
+// jsonNode is a value in a json document, by its position in the document
+type jsonNode struct {
+	start, end int
+
+	// kind is '{' or '[' for objects and arrays, 0 for other values
+	kind byte
+
+	// members are the members of an object, elems the elements of an array
+	members []jsonMember
+	elems   []*jsonNode
+
+	// pre is where the whitespace before each member or element starts,
+	// after the opening bracket or the comma before it
+	pre []int
+}
+
+type jsonMember struct {
+	name       string
+	start, end int
+	val        *jsonNode
+}
+
+// editJSON returns the document old changed to have the content of the
+// document new, keeping the order of members and the formatting of old
+// wherever it does not change
+func editJSON(old, new []byte) ([]byte, error) {
+	if !json.Valid(old) {
+		return nil, fmt.Errorf("invalid json")
+	}
+	op := &jsonParser{data: old}
+	oroot, err := op.value()
+	if err != nil {
+		return nil, err
+	}
+	np := &jsonParser{data: new}
+	nroot, err := np.value()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &jsonEditor{old: old, new: new, unit: "  "}
+	if oroot.kind == '{' && len(oroot.members) > 0 {
+		ws := old[oroot.pre[0]:oroot.members[0].start]
+		if i := bytes.LastIndexByte(ws, '\n'); i >= 0 && i+1 < len(ws) {
+			e.unit = string(ws[i+1:])
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(old[:oroot.start])
+	out.WriteString(e.edit(oroot, nroot, ""))
+	out.Write(old[oroot.end:])
+	return out.Bytes(), nil
+}
+
+type jsonEditor struct {
+	old, new []byte
+
+	// unit is the indentation of each level in old
+	unit string
+}
+
+// edit returns the text of n, formatted like o where it has its content.
+// ind is the indentation of the line o starts on.
+func (e *jsonEditor) edit(o, n *jsonNode, ind string) string {
+	oraw, nraw := e.old[o.start:o.end], e.new[n.start:n.end]
+	if jsonEqual(oraw, nraw) {
+		return string(oraw)
+	}
+	if o.kind != n.kind || o.kind == 0 || len(o.pre) == 0 {
+		return e.render(n, ind, !bytes.ContainsRune(oraw, '\n') && o.kind != 0 && len(o.pre) > 0)
+	}
+
+	// the whitespace around the members or elements of o
+	open := string(e.old[o.pre[0]:o.itemStart(0)])
+	between := open
+	if len(o.pre) > 1 {
+		between = string(e.old[o.pre[1]:o.itemStart(1)])
+	}
+	close := string(e.old[o.itemEnd(len(o.pre)-1) : o.end-1])
+
+	inner := ind
+	if i := strings.LastIndexByte(open, '\n'); i >= 0 {
+		inner = open[i+1:]
+	}
+	// new members and elements of a value on one line stay on it
+	compact := !bytes.ContainsRune(oraw, '\n')
+
+	var parts []string
+	if o.kind == '{' {
+		sep := string(e.old[o.members[0].end:o.members[0].val.start])
+		have := make(map[string]int)
+		for i, m := range o.members {
+			have[m.name] = i
+		}
+
+		// members keep their place in o, new ones come last
+		type part struct {
+			rank int
+			text string
+		}
+		var ps []part
+		for i, m := range n.members {
+			if r, ok := have[m.name]; ok {
+				om := o.members[r]
+				ps = append(ps, part{r, string(e.old[om.start:om.val.start]) + e.edit(om.val, m.val, inner)})
+				continue
+			}
+			ps = append(ps, part{len(o.members) + i, string(e.new[m.start:m.end]) + sep + e.render(m.val, inner, compact)})
+		}
+		sort.SliceStable(ps, func(i, j int) bool { return ps[i].rank < ps[j].rank })
+		for _, p := range ps {
+			parts = append(parts, p.text)
+		}
+	} else {
+		// elements with a hash, like dependencies, keep their formatting
+		// wherever they move, others keep that of their index
+		byHash := make(map[string]*jsonNode)
+		for _, el := range o.elems {
+			if h := elemHash(e.old, el); h != "" {
+				byHash[h] = el
+			}
+		}
+		for i, el := range n.elems {
+			h := elemHash(e.new, el)
+			switch {
+			case h != "" && byHash[h] != nil:
+				parts = append(parts, e.edit(byHash[h], el, inner))
+			case h == "" && i < len(o.elems) && elemHash(e.old, o.elems[i]) == "":
+				parts = append(parts, e.edit(o.elems[i], el, inner))
+			default:
+				parts = append(parts, e.render(el, inner, compact))
+			}
+		}
+	}
+
+	if len(parts) == 0 {
+		return string(o.kind) + string(closing(o.kind))
+	}
+	return string(o.kind) + open + strings.Join(parts, ","+between) + close + string(closing(o.kind))
+}
+
+// elemHash returns the "hash" member of n, an element of an array in data, if
+// it is an object with one
+func elemHash(data []byte, n *jsonNode) string {
+	for _, m := range n.members {
+		if m.name != "hash" {
+			continue
+		}
+		var h string
+		if json.Unmarshal(data[m.val.start:m.val.end], &h) == nil {
+			return h
+		}
+	}
+	return ""
+}
+
+// render formats n anew, indented like old, or on one line if compact
+func (e *jsonEditor) render(n *jsonNode, ind string, compact bool) string {
+	raw := e.new[n.start:n.end]
+	var buf bytes.Buffer
+	var err error
+	if compact {
+		err = json.Compact(&buf, raw)
+	} else {
+		err = json.Indent(&buf, raw, ind, e.unit)
+	}
+	if err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// itemStart and itemEnd return where the i-th member or element of n starts
+// and ends
+func (n *jsonNode) itemStart(i int) int {
+	if n.kind == '{' {
+		return n.members[i].start
+	}
+	return n.elems[i].start
+}
+
+func (n *jsonNode) itemEnd(i int) int {
+	if n.kind == '{' {
+		return n.members[i].val.end
+	}
+	return n.elems[i].end
+}
+
+func closing(kind byte) byte {
+	if kind == '{' {
+		return '}'
+	}
+	return ']'
+}
+
+// jsonEqual returns whether the json values a and b are the same
+func jsonEqual(a, b []byte) bool {
+	var av, bv interface{}
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+// jsonParser finds the positions of the values in a valid json document
+type jsonParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *jsonParser) value() (*jsonNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, fmt.Errorf("unexpected end of json")
+	}
+
+	n := &jsonNode{start: p.pos}
+	switch c := p.data[p.pos]; c {
+	case '{', '[':
+		n.kind = c
+		p.pos++
+		for {
+			pre := p.pos
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == closing(c) {
+				p.pos++
+				break
+			}
+			n.pre = append(n.pre, pre)
+
+			if c == '{' {
+				p.skipSpace()
+				ks := p.pos
+				if err := p.str(); err != nil {
+					return nil, err
+				}
+				var name string
+				if err := json.Unmarshal(p.data[ks:p.pos], &name); err != nil {
+					return nil, err
+				}
+				m := jsonMember{name: name, start: ks, end: p.pos}
+				p.skipSpace()
+				p.pos++ // the colon
+				v, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				m.val = v
+				n.members = append(n.members, m)
+			} else {
+				v, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				n.elems = append(n.elems, v)
+			}
+
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == ',' {
+				p.pos++
+			}
+		}
+	case '"':
+		if err := p.str(); err != nil {
+			return nil, err
+		}
+	default:
+		for p.pos < len(p.data) && strings.IndexByte(",]} \t\r\n", p.data[p.pos]) < 0 {
+			p.pos++
+		}
+	}
+	n.end = p.pos
+	return n, nil
+}
+
+func (p *jsonParser) str() error {
+	p.pos++
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case '"':
+			p.pos++
+			return nil
+		}
+		p.pos++
+	}
+	return fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,224 @@
+package main
+
+import "testing"
+
+const testPkgFile = `{
+  "name": "proj",
+  "gxDependencies": [
+    {
+      "hash": "QmA",
+      "name": "a",
+      "version": "1.0.0"
+    },
+    {
+      "hash": "QmB",
+      "name": "b",
+      "version": "1.0.0"
+    }
+  ],
+  "version": "0.1.0"
+}
+`
+
+func TestEditJSON(t *testing.T) {
+	cases := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "unchanged",
+			old:  testPkgFile,
+			new: `{
+  "name": "proj",
+  "version": "0.1.0",
+  "gxDependencies": [
+    {"hash": "QmA", "name": "a", "version": "1.0.0"},
+    {"hash": "QmB", "name": "b", "version": "1.0.0"}
+  ]
+}`,
+			want: testPkgFile,
+		},
+		{
+			name: "reorder members",
+			old:  testPkgFile,
+			new: `{
+  "version": "0.2.0",
+  "gxDependencies": [
+    {"version": "1.0.0", "name": "a", "hash": "QmA"},
+    {"version": "1.0.0", "name": "b", "hash": "QmB"}
+  ],
+  "name": "proj"
+}`,
+			want: `{
+  "name": "proj",
+  "gxDependencies": [
+    {
+      "hash": "QmA",
+      "name": "a",
+      "version": "1.0.0"
+    },
+    {
+      "hash": "QmB",
+      "name": "b",
+      "version": "1.0.0"
+    }
+  ],
+  "version": "0.2.0"
+}
+`,
+		},
+		{
+			name: "reorder elements",
+			old:  testPkgFile,
+			new: `{
+  "name": "proj",
+  "version": "0.1.0",
+  "gxDependencies": [
+    {"hash": "QmB", "name": "b", "version": "1.0.0"},
+    {"hash": "QmA", "name": "a", "version": "1.0.0"}
+  ]
+}`,
+			want: `{
+  "name": "proj",
+  "gxDependencies": [
+    {
+      "hash": "QmB",
+      "name": "b",
+      "version": "1.0.0"
+    },
+    {
+      "hash": "QmA",
+      "name": "a",
+      "version": "1.0.0"
+    }
+  ],
+  "version": "0.1.0"
+}
+`,
+		},
+		{
+			name: "insert",
+			old:  testPkgFile,
+			new: `{
+  "name": "proj",
+  "version": "0.1.0",
+  "language": "go",
+  "gxDependencies": [
+    {"hash": "QmA", "name": "a", "version": "1.0.0"},
+    {"hash": "QmB", "name": "b", "version": "1.0.0"},
+    {"hash": "QmC", "name": "c", "version": "2.0.0"}
+  ]
+}`,
+			want: `{
+  "name": "proj",
+  "gxDependencies": [
+    {
+      "hash": "QmA",
+      "name": "a",
+      "version": "1.0.0"
+    },
+    {
+      "hash": "QmB",
+      "name": "b",
+      "version": "1.0.0"
+    },
+    {
+      "hash": "QmC",
+      "name": "c",
+      "version": "2.0.0"
+    }
+  ],
+  "version": "0.1.0",
+  "language": "go"
+}
+`,
+		},
+		{
+			name: "delete the first element",
+			old: `{
+  "gxDependencies": [
+    {"hash": "QmA", "name": "a", "version": "1.0.0"},
+    {"hash": "QmB", "name": "b",   "version": "1.0.0"}
+  ]
+}
+`,
+			new: `{
+  "gxDependencies": [
+    {"hash": "QmB", "name": "b", "version": "1.0.0"}
+  ]
+}`,
+			want: `{
+  "gxDependencies": [
+    {"hash": "QmB", "name": "b",   "version": "1.0.0"}
+  ]
+}
+`,
+		},
+		{
+			name: "update an element",
+			old:  testPkgFile,
+			new: `{
+  "name": "proj",
+  "version": "0.1.0",
+  "gxDependencies": [
+    {"hash": "QmA", "name": "a", "version": "1.0.0"},
+    {"hash": "QmB2", "name": "b", "version": "1.1.0"}
+  ]
+}`,
+			want: `{
+  "name": "proj",
+  "gxDependencies": [
+    {
+      "hash": "QmA",
+      "name": "a",
+      "version": "1.0.0"
+    },
+    {
+      "hash": "QmB2",
+      "name": "b",
+      "version": "1.1.0"
+    }
+  ],
+  "version": "0.1.0"
+}
+`,
+		},
+		{
+			name: "compact",
+			old:  `{"name":"proj","gxDependencies":[{"hash":"QmA","name":"a","version":"1.0.0"}]}`,
+			new: `{
+  "name": "proj",
+  "language": "go",
+  "gxDependencies": [
+    {"hash": "QmA", "name": "a", "version": "1.0.0"},
+    {"hash": "QmC", "name": "c", "version": "2.0.0"}
+  ]
+}`,
+			want: `{"name":"proj","gxDependencies":[{"hash":"QmA","name":"a","version":"1.0.0"},{"hash":"QmC","name":"c","version":"2.0.0"}],"language":"go"}`,
+		},
+		{
+			name: "tabs",
+			old:  "{\n\t\"name\": \"proj\"\n}\n",
+			new:  `{"name": "proj", "gx": {"dvcsimport": "example.com/proj"}}`,
+			want: "{\n\t\"name\": \"proj\",\n\t\"gx\": {\n\t\t\"dvcsimport\": \"example.com/proj\"\n\t}\n}\n",
+		},
+	}
+
+	for _, c := range cases {
+		out, err := editJSON([]byte(c.old), []byte(c.new))
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if string(out) != c.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.name, out, c.want)
+		}
+	}
+}
+
+func TestEditJSONInvalid(t *testing.T) {
+	if _, err := editJSON([]byte(`{"name": `), []byte(`{"name": "proj"}`)); err == nil {
+		t.Error("editing invalid json succeeded")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// platform is a GOOS/GOARCH combination that dependencies are discovered for
+type platform struct {
+	GOOS   string
+	GOARCH string
+}
+
+func (p platform) String() string {
+	return p.GOOS + "/" + p.GOARCH
+}
+
+// defaultPlatforms is the matrix used for dependency discovery unless
+// otherwise specified, so that platform specific dependencies get vendored
+// even when importing from a different platform
+var defaultPlatforms = []platform{
+	{"linux", "amd64"},
+	{"linux", "386"},
+	{"linux", "arm"},
+	{"linux", "arm64"},
+	{"darwin", "amd64"},
+	{"windows", "amd64"},
+	{"windows", "386"},
+	{"freebsd", "amd64"},
+	{"openbsd", "amd64"},
+}
+
+const platformsUsage = `comma separated list of GOOS/GOARCH pairs to discover dependencies for
+	('host' for the current platform only, 'default' for the builtin matrix)`
+
+// parsePlatforms parses a comma separated list of GOOS/GOARCH pairs
+func parsePlatforms(s string) ([]platform, error) {
+	if s == "" || s == "default" {
+		return defaultPlatforms, nil
+	}
+
+	var out []platform
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "host" {
+			out = append(out, platform{runtime.GOOS, runtime.GOARCH})
+			continue
+		}
+
+		parts := strings.Split(p, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid platform %q, expected GOOS/GOARCH", p)
+		}
+
+		out = append(out, platform{parts[0], parts[1]})
+	}
+
+	return out, nil
+}
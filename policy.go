@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// Policy sets how far behind the newest published versions dependencies
+// may fall, enforced by 'policy check'. It is set in the user config or the
+// package.json, which takes precedence field by field.
+type Policy struct {
+	// MaxDependencyAge is how long ago the used version of a dependency may
+	// have been published once a newer version exists, like "180d"
+	MaxDependencyAge string `json:"maxDependencyAge,omitempty"`
+
+	// MaxVersionsBehind is how many newer versions of a dependency may exist
+	MaxVersionsBehind int `json:"maxVersionsBehind,omitempty"`
+}
+
+// merge returns p with the fields set in over replaced
+func (p Policy) merge(over *Policy) Policy {
+	if over == nil {
+		return p
+	}
+	if over.MaxDependencyAge != "" {
+		p.MaxDependencyAge = over.MaxDependencyAge
+	}
+	if over.MaxVersionsBehind != 0 {
+		p.MaxVersionsBehind = over.MaxVersionsBehind
+	}
+	return p
+}
+
+// parseAge parses a duration, additionally accepting days and weeks as in
+// "180d" or "4w"
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// freshness is how a dependency compares to what the registry has
+type freshness struct {
+	Name    string
+	Version string
+	Hash    string
+	Latest  string
+	Behind  int
+
+	// Published is when the used version was published, zero if unknown
+	Published time.Time
+
+	Violations []string
+}
+
+// checkFreshness evaluates dep against the versions the registry knows of
+// its import path
+func checkFreshness(dpkg *Package, dep *gx.Dependency, rp *registryPackage, pol Policy, maxAge time.Duration) *freshness {
+	f := &freshness{Name: dpkg.Name, Version: dpkg.Version, Hash: dep.Hash, Latest: dpkg.Version}
+
+	newer := make(map[string]bool)
+	for _, v := range rp.Versions {
+		if v.Hash == dep.Hash || v.Version == dpkg.Version {
+			if f.Published.IsZero() || (!v.Published.IsZero() && v.Published.Before(f.Published)) {
+				f.Published = v.Published
+			}
+			continue
+		}
+		if older, err := versionComp(dpkg.Version, v.Version); err == nil && older {
+			newer[v.Version] = true
+			if o, err := versionComp(f.Latest, v.Version); err == nil && o {
+				f.Latest = v.Version
+			}
+		}
+	}
+	f.Behind = len(newer)
+
+	if pol.MaxVersionsBehind > 0 && f.Behind > pol.MaxVersionsBehind {
+		f.Violations = append(f.Violations, fmt.Sprintf("%d versions behind", f.Behind))
+	}
+	if maxAge > 0 && f.Behind > 0 && !f.Published.IsZero() {
+		if age := time.Since(f.Published); age > maxAge {
+			f.Violations = append(f.Violations, fmt.Sprintf("%d days old", int(age.Hours()/24)))
+		}
+	}
+	return f
+}
+
+var PolicyCommand = cli.Command{
+	Name:  "policy",
+	Usage: "enforce policies on the dependency tree",
+	Subcommands: []cli.Command{
+		policyCheckCommand,
+	},
+	Action: func(c *cli.Context) error { return nil },
+}
+
+var policyCheckCommand = cli.Command{
+	Name:  "check",
+	Usage: "check that dependencies are allowed and fresh enough",
+	Description: `checks every package in the dependency tree against the dependency policy
+of the project in .gx-policy.json, which platform teams use to constrain
+what dependencies may be pulled in:
+
+  allow     the only import paths dependencies may have, entries match
+            everything below them, like an organisation "github.com/org",
+            and may contain '*' wildcards
+  deny      import paths dependencies may not have, taking precedence
+  licenses  SPDX identifiers of the licenses dependencies may have, taken
+            from their package.json or recognised from their license file
+  maxDepth  how deep the dependency tree may be, direct dependencies are 1
+
+'import' and the req-check hook gx runs before adding or installing a
+package enforce the same policy.
+
+Packages are also compared with the versions the registry lists for their
+import path, against the 'policy' set in ~/.gx-go/config.json or the
+package.json gx section:
+
+  maxVersionsBehind  how many newer versions of a dependency may exist
+  maxDependencyAge   how long ago the used version may have been published
+                     once there is a newer one, like "180d" or "26w"
+
+The command fails if any dependency violates a policy. Packages the
+registry does not list and frozen dependencies, see 'freeze', are reported
+but not held against the freshness policy.
+
+--template prints each package with a go template over the fields .Name,
+.Version, .Latest, .Behind, .Published, .Depth, .License and .Status, which
+makes it easy to list just the violations.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "registry",
+			Usage: "registry to check against instead of the configured one",
+		},
+		formatFlag,
+		templateFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		deppol, err := loadDepPolicy(cwd)
+		if err != nil {
+			return err
+		}
+
+		pol := Policy{}.merge(cfg.Policy).merge(pkg.Gx.Policy)
+		fresh := pol.MaxDependencyAge != "" || pol.MaxVersionsBehind != 0
+		if !fresh && deppol == nil {
+			return fmt.Errorf("no policy set, add a %s or set 'policy' in ~/.gx-go/config.json or package.json", depPolicyFileName)
+		}
+
+		var maxAge time.Duration
+		if pol.MaxDependencyAge != "" {
+			if maxAge, err = parseAge(pol.MaxDependencyAge); err != nil {
+				return fmt.Errorf("invalid maxDependencyAge: %s", err)
+			}
+		}
+
+		byImport := make(map[string]*registryPackage)
+		if fresh {
+			reg := c.String("registry")
+			if reg == "" {
+				reg = cfg.Registry
+			}
+			if reg == "" {
+				return fmt.Errorf("no registry configured, set 'registry' in ~/.gx-go/config.json or pass --registry")
+			}
+
+			r, err := fetchRegistry(reg, cfg.Gateway)
+			if err != nil {
+				return err
+			}
+			for _, p := range r.Packages {
+				byImport[p.Import] = p
+			}
+		}
+
+		results, err := checkDepPolicy(deppol, pkg, filepath.Join(cwd, vendorDir))
+		if err != nil {
+			return err
+		}
+		sort.Slice(results, func(i, j int) bool {
+			if results[i].Pkg.Name != results[j].Pkg.Name {
+				return results[i].Pkg.Name < results[j].Pkg.Name
+			}
+			return results[i].Dep.Hash < results[j].Dep.Hash
+		})
+
+		frozen := frozenHashes(pkg)
+
+		var rows [][]string
+		var violations int
+		var names []string
+		unlisted := make(map[string]bool)
+		for _, r := range results {
+			latest, behind, published := "-", "-", "-"
+			reason, isFrozen := frozen[r.Dep.Hash]
+			if fresh {
+				if rp, ok := byImport[r.Pkg.Gx.DvcsImport]; ok {
+					f := checkFreshness(r.Pkg, r.Dep, rp, pol, maxAge)
+					latest, behind, published = f.Latest, strconv.Itoa(f.Behind), "unknown"
+					if !f.Published.IsZero() {
+						published = f.Published.Format("2006-01-02")
+					}
+					if !isFrozen {
+						r.Violations = append(r.Violations, f.Violations...)
+					}
+				} else if !unlisted[r.Pkg.Name] {
+					unlisted[r.Pkg.Name] = true
+					names = append(names, r.Pkg.Name)
+				}
+			}
+
+			var notes []string
+			if len(r.Violations) > 0 {
+				notes = r.Violations
+				violations++
+			}
+			if isFrozen && reason != "" {
+				notes = append(notes, "frozen: "+reason)
+			} else if isFrozen {
+				notes = append(notes, "frozen")
+			}
+			status := "ok"
+			if len(notes) > 0 {
+				status = strings.Join(notes, ", ")
+			}
+			license := r.License
+			if license == "" {
+				license = "unknown"
+			}
+			rows = append(rows, []string{r.Pkg.Name, r.Pkg.Version, latest, behind, published, strconv.Itoa(r.Depth), license, status})
+		}
+
+		err = writeResults(os.Stdout, c, []string{"NAME", "VERSION", "LATEST", "BEHIND", "PUBLISHED", "DEPTH", "LICENSE", "STATUS"}, rows)
+		if err != nil {
+			return err
+		}
+
+		if len(names) > 0 {
+			sort.Strings(names)
+			Warn("not listed in the registry: %s", strings.Join(names, ", "))
+		}
+
+		if violations > 0 {
+			return fmt.Errorf("%d dependencies violate the policy", violations)
+		}
+		return nil
+	},
+}
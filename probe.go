@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// defaultProbeGateways are the public gateways probed when none are
+// configured
+var defaultProbeGateways = []string{"https://ipfs.io", "https://dweb.link"}
+
+// probeResult is how retrievable a dependency hash is
+type probeResult struct {
+	Name string
+	Hash string
+
+	// Local is "pinned", "present", "absent" or "unknown" when no ipfs
+	// command is installed
+	Local string
+
+	Pinned   []string
+	Gateways []string
+}
+
+// risk classifies the result: a hash is ok if a pinning service keeps it,
+// at risk if it can be fetched now but nothing keeps it around, and
+// unfetchable if it cannot be fetched at all
+func (r *probeResult) risk() string {
+	switch {
+	case len(r.Pinned) > 0:
+		return "ok"
+	case len(r.Gateways) > 0 || r.Local == "pinned" || r.Local == "present":
+		return "at risk"
+	default:
+		return "unfetchable"
+	}
+}
+
+var ProbeCommand = cli.Command{
+	Name:  "probe",
+	Usage: "check that every dependency can still be fetched",
+	Description: `checks each hash in the dependency tree against the local ipfs node, the
+pinning services in 'pinningServices' in ~/.gx-go/config.json and the
+gateways in 'probeGateways' (ipfs.io and dweb.link by default), and reports
+how each can be retrieved.
+
+A hash is ok if a pinning service keeps it, at risk if it can be fetched
+now but is only cached, and unfetchable if none of the sources has it. The
+command fails if any hash is unfetchable, or at risk with --strict.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "strict",
+			Usage: "also fail if any hash is at risk",
+		},
+		cli.DurationFlag{
+			Name:  "gateway-timeout",
+			Usage: "how long to wait for each gateway",
+			Value: 20 * time.Second,
+		},
+		formatFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		gateways := cfg.ProbeGateways
+		if len(gateways) == 0 {
+			gateways = defaultProbeGateways
+		}
+
+		var results []*probeResult
+		err = forEachDep(pkg, filepath.Join(cwd, vendorDir), func(dep *gx.Dependency, dpkg *Package, _ string) error {
+			results = append(results, &probeResult{Name: dpkg.Name, Hash: dep.Hash})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		client := &http.Client{Timeout: c.Duration("gateway-timeout")}
+		_, noIpfs := exec.LookPath("ipfs")
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, 8)
+		for _, r := range results {
+			wg.Add(1)
+			go func(r *probeResult) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				r.Local = "unknown"
+				if noIpfs == nil {
+					r.Local = probeLocal(r.Hash)
+				}
+				for _, s := range cfg.PinningServices {
+					if ok, err := s.pinned(client, r.Hash); err != nil {
+						Warn("checking %s with %s: %s", r.Hash, s.Name, err)
+					} else if ok {
+						r.Pinned = append(r.Pinned, s.Name)
+					}
+				}
+				for _, gw := range gateways {
+					if probeGateway(client, gw, r.Hash) {
+						r.Gateways = append(r.Gateways, gw)
+					}
+				}
+			}(r)
+		}
+		wg.Wait()
+
+		if err := cancelled(); err != nil {
+			return err
+		}
+
+		sort.Slice(results, func(i, j int) bool {
+			if results[i].Name != results[j].Name {
+				return results[i].Name < results[j].Name
+			}
+			return results[i].Hash < results[j].Hash
+		})
+
+		var rows [][]string
+		var risky, lost int
+		for _, r := range results {
+			switch r.risk() {
+			case "at risk":
+				risky++
+			case "unfetchable":
+				lost++
+			}
+			rows = append(rows, []string{
+				r.Name,
+				r.Hash,
+				r.risk(),
+				r.Local,
+				listOrNone(r.Pinned),
+				fmt.Sprintf("%d/%d", len(r.Gateways), len(gateways)),
+			})
+		}
+
+		err = writeTable(os.Stdout, c.String("format"), []string{"NAME", "HASH", "STATUS", "LOCAL", "PINNED BY", "GATEWAYS"}, rows)
+		if err != nil {
+			return err
+		}
+
+		if len(cfg.PinningServices) == 0 {
+			Warn("no pinning services configured, every hash is at best at risk")
+		}
+
+		switch {
+		case lost > 0:
+			return fmt.Errorf("%d hashes cannot be fetched", lost)
+		case risky > 0 && c.Bool("strict"):
+			return fmt.Errorf("%d hashes are not pinned by any pinning service", risky)
+		}
+		return nil
+	},
+}
+
+func listOrNone(l []string) string {
+	if len(l) == 0 {
+		return "-"
+	}
+	return strings.Join(l, ",")
+}
+
+// probeLocal returns whether the local ipfs node has pinned hash, only has
+// it in its store, or does not have it
+func probeLocal(hash string) string {
+	var state string
+	ipfsCall(func() error {
+		if exec.CommandContext(cancelCtx, "ipfs", "pin", "ls", "--type=recursive", hash).Run() == nil {
+			state = "pinned"
+		} else if exec.CommandContext(cancelCtx, "ipfs", "--offline", "block", "stat", hash).Run() == nil {
+			state = "present"
+		} else {
+			state = "absent"
+		}
+		return nil
+	})
+	return state
+}
+
+// probeGateway returns whether the gateway serves hash
+func probeGateway(client *http.Client, gateway, hash string) bool {
+	req, err := http.NewRequest("HEAD", strings.TrimSuffix(gateway, "/")+"/ipfs/"+hash, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := client.Do(req.WithContext(cancelCtx))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == 200
+}
+
+// PinningService is a remote pinning service implementing the ipfs pinning
+// service api
+type PinningService struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	Token    string `json:"token,omitempty"`
+}
+
+// pinned returns whether the service has pinned hash
+func (s *PinningService) pinned(client *http.Client, hash string) (bool, error) {
+	u := strings.TrimSuffix(s.Endpoint, "/") + "/pins?status=pinned&cid=" + hash
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return false, err
+	}
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := client.Do(req.WithContext(cancelCtx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("%s", resp.Status)
+	}
+
+	var out struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, err
+	}
+	return out.Count > 0, nil
+}
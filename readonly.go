@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	rw "github.com/whyrusleeping/gx-go/rewrite"
+)
+
+// buildOverlayFile is the 'go build -overlay' file listing the shadow copies
+// of files in read-only vendor trees, relative to the package root
+var buildOverlayFile = filepath.Join(localStateDir, "build-overlay.json")
+
+// buildOverlay is the format of 'go build -overlay' files
+type buildOverlay struct {
+	Replace map[string]string
+}
+
+// dirWritable returns whether files can be created in dir, which is what
+// rewriting needs to replace files in it
+func dirWritable(dir string) bool {
+	f, err := ioutil.TempFile(dir, ".gx-go-write-test")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
+
+// readOnlyVendorMode returns what to do with packages that are installed
+// into a read-only vendor tree: "skip" rewriting them, or "shadow" them
+// with rewritten copies used through 'go build -overlay'
+func readOnlyVendorMode() string {
+	if m := os.Getenv("GX_GO_READONLY_VENDOR"); m != "" {
+		return m
+	}
+	if cfg, err := loadConfig(); err == nil && cfg.ReadOnlyVendor != "" {
+		return cfg.ReadOnlyVendor
+	}
+	return "skip"
+}
+
+// shadowRewrite writes rewritten copies of the go files in dir whose imports
+// change with mapping to the shadow tree of the current package, and lists
+// them in its build overlay. It returns the number of files shadowed.
+func shadowRewrite(dir string, mapping map[string]string) (int, error) {
+	rwf := func(in string) string {
+		out, _ := rewriteImport(in, mapping)
+		return out
+	}
+
+	ovpath := filepath.Join(cwd, buildOverlayFile)
+	var ov buildOverlay
+	if err := loadMap(&ov, ovpath); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if ov.Replace == nil {
+		ov.Replace = make(map[string]string)
+	}
+
+	shadowRoot := filepath.Join(cwd, localStateDir, "shadow")
+	var n int
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if p != dir && (skipDir(fi.Name()) || strings.HasPrefix(fi.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, ".go") {
+			return nil
+		}
+
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		out, err := rw.RewriteSource(p, src, rwf)
+		if err != nil {
+			Warn("not shadowing %s: %s", p, err)
+			return nil
+		}
+		if bytes.Equal(out, src) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(cwd, p)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Join("abs", strings.TrimPrefix(p, filepath.VolumeName(p)))
+		}
+		shadow := filepath.Join(shadowRoot, rel)
+		if err := os.MkdirAll(filepath.Dir(shadow), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(shadow, out, 0644); err != nil {
+			return err
+		}
+
+		ov.Replace[p] = shadow
+		n++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := json.MarshalIndent(ov, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(ovpath), 0755); err != nil {
+		return 0, err
+	}
+	return n, ioutil.WriteFile(ovpath, append(data, '\n'), 0644)
+}
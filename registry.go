@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultGateway = "https://ipfs.io"
+
+// registryPackage is a package listed in a registry
+type registryPackage struct {
+	Import   string            `json:"import"`
+	Name     string            `json:"name"`
+	Keywords []string          `json:"keywords,omitempty"`
+	Versions []registryVersion `json:"versions"`
+}
+
+type registryVersion struct {
+	Version   string    `json:"version"`
+	Hash      string    `json:"hash"`
+	Published time.Time `json:"published,omitempty"`
+}
+
+// registry is the document served by a registry, listing all the packages
+// it knows about
+type registry struct {
+	Packages []*registryPackage `json:"packages"`
+}
+
+var registryClient = &http.Client{Timeout: 30 * time.Second}
+
+// registryURL returns the http url of a registry, which is either an http
+// url or an /ipfs/ or /ipns/ path that is fetched through the gateway
+func registryURL(reg, gateway string) string {
+	if strings.HasPrefix(reg, "/ipfs/") || strings.HasPrefix(reg, "/ipns/") {
+		if gateway == "" {
+			gateway = defaultGateway
+		}
+		return strings.TrimSuffix(gateway, "/") + reg
+	}
+	return reg
+}
+
+func fetchRegistry(reg, gateway string) (*registry, error) {
+	u := registryURL(reg, gateway)
+	resp, err := registryClient.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("fetching registry: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("fetching registry %s: %s", u, resp.Status)
+	}
+
+	var r registry
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("decoding registry %s: %s", u, err)
+	}
+	return &r, nil
+}
+
+// configuredRegistry fetches the registry of the gx-go config and returns
+// its packages by dvcs import
+func configuredRegistry() (map[string]*registryPackage, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Registry == "" {
+		return nil, fmt.Errorf("no registry configured")
+	}
+
+	r, err := fetchRegistry(cfg.Registry, cfg.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	byImport := make(map[string]*registryPackage)
+	for _, p := range r.Packages {
+		byImport[p.Import] = p
+	}
+	return byImport, nil
+}
+
+// matches returns whether the package matches the query by import path, name
+// or keyword
+func (p *registryPackage) matches(q string) bool {
+	if strings.Contains(p.Import, q) || strings.Contains(p.Name, q) {
+		return true
+	}
+
+	for _, k := range p.Keywords {
+		if k == q {
+			return true
+		}
+	}
+	return false
+}
+
+// postToRegistry posts the metadata of a newly published package to a
+// registry endpoint
+func postToRegistry(endpoint string, p publishedReport) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	resp, err := registryClient.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("posting to %s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
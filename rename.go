@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var RenameCommand = cli.Command{
+	Name:      "rename",
+	Usage:     "move a package to a new dvcs import path across the workspace",
+	ArgsUsage: "<old dvcs import> <new dvcs import>",
+	Description: `renames a package, as when its repository moves to another github
+organization. In every package at or below the current directory, it
+updates the 'dvcsimport' and 'bins' entries of package.json, the module
+directive of go.mod and all imports of the old path or paths below it.
+
+Imports in gx form refer to the package by hash and are left as they are,
+they pick up the new path once the package is published again.`,
+	Action: func(c *cli.Context) error {
+		if len(c.Args()) != 2 {
+			return fmt.Errorf("must specify the old and new dvcs import paths")
+		}
+		oldimp := strings.TrimSuffix(c.Args()[0], "/")
+		newimp := strings.TrimSuffix(c.Args()[1], "/")
+		if oldimp == newimp {
+			return fmt.Errorf("old and new import paths are the same")
+		}
+
+		dirs, err := findPackages(cwd)
+		if err != nil {
+			return err
+		}
+		if len(dirs) == 0 {
+			return fmt.Errorf("no %s found in %s or below", gx.PkgFileName, cwd)
+		}
+
+		var found bool
+		for _, d := range dirs {
+			if err := cancelled(); err != nil {
+				return err
+			}
+
+			rel, _ := filepath.Rel(cwd, d)
+			pkg, err := LoadPackageFile(filepath.Join(d, gx.PkgFileName))
+			if err != nil {
+				return fmt.Errorf("%s: %s", rel, err)
+			}
+			if pkg.Gx.DvcsImport == oldimp {
+				found = true
+			}
+
+			Log("%s:", rel)
+			if err := renameInPackage(d, pkg, oldimp, newimp); err != nil {
+				return fmt.Errorf("%s: %s", rel, err)
+			}
+		}
+
+		if !found {
+			Warn("no package in the workspace has the dvcs import %s, only its dependents were updated", oldimp)
+		}
+		return nil
+	},
+}
+
+// renameInPackage moves everything in the package in dir that refers to
+// oldimp over to newimp
+func renameInPackage(dir string, pkg *Package, oldimp, newimp string) error {
+	if renamePkgMeta(pkg, oldimp, newimp) {
+		VLog("  - updating package.json")
+		if err := savePackageFile(pkg, filepath.Join(dir, gx.PkgFileName)); err != nil {
+			return err
+		}
+	}
+
+	if err := renameGoMod(dir, oldimp, newimp); err != nil {
+		return err
+	}
+
+	return doUpdate(dir, oldimp, newimp)
+}
+
+// renamePkgMeta updates the import paths in the gx section of pkg, and
+// returns whether any changed
+func renamePkgMeta(pkg *Package, oldimp, newimp string) bool {
+	var changed bool
+	if imp, ok := renamedImport(pkg.Gx.DvcsImport, oldimp, newimp); ok {
+		pkg.Gx.DvcsImport = imp
+		changed = true
+	}
+	for i, b := range pkg.Gx.Bins {
+		if imp, ok := renamedImport(b, oldimp, newimp); ok {
+			pkg.Gx.Bins[i] = imp
+			changed = true
+		}
+	}
+	return changed
+}
+
+// renamedImport returns imp moved from below oldimp to below newimp, if it
+// is oldimp or below it
+func renamedImport(imp, oldimp, newimp string) (string, bool) {
+	if imp == oldimp || strings.HasPrefix(imp, oldimp+"/") {
+		return newimp + imp[len(oldimp):], true
+	}
+	return imp, false
+}
+
+var moduleRE = regexp.MustCompile(`(?m)^module\s+("?)([^\s"]+)("?)`)
+
+// renameGoMod updates the module directive of the go.mod in dir, if any
+func renameGoMod(dir, oldimp, newimp string) error {
+	p := filepath.Join(dir, "go.mod")
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	m := moduleRE.FindSubmatchIndex(data)
+	if m == nil {
+		return nil
+	}
+	mod, ok := renamedImport(string(data[m[4]:m[5]]), oldimp, newimp)
+	if !ok {
+		return nil
+	}
+
+	VLog("  - updating go.mod")
+	out := append(append(append([]byte{}, data[:m[4]]...), mod...), data[m[5]:]...)
+	return ioutil.WriteFile(p, out, 0644)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// brokenDep is a vendored dependency that has to be fetched again
+type brokenDep struct {
+	Dep     *gx.Dependency
+	Problem string
+}
+
+var RepairCommand = cli.Command{
+	Name:  "repair",
+	Usage: "re-fetch missing or corrupt vendored dependencies",
+	Description: `checks every vendored package in the dependency tree and fetches those
+that are missing, empty, lack a package.json, or hold a different package
+than the hash they are vendored under was published as, or whose content
+changed since the post-install hook rewrote them. Only the repaired
+packages are rewritten and placed, as the post-install hook would.
+
+Dependencies installed globally and active overlays are left alone.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only report what would be repaired",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		ovs, err := loadOverlays()
+		if err != nil {
+			return err
+		}
+
+		var pm packageStore
+		if !c.Bool("dry-run") {
+			pm, err = newPM()
+			if err != nil {
+				return err
+			}
+		}
+
+		var broken []brokenDep
+		seen := make(map[string]bool)
+		queue := pkg.Dependencies
+		for len(queue) > 0 {
+			if err := cancelled(); err != nil {
+				return err
+			}
+
+			dep := queue[0]
+			queue = queue[1:]
+			if seen[dep.Hash] || ovs[dep.Hash] != nil {
+				continue
+			}
+			seen[dep.Hash] = true
+
+			problem, dpkg := checkVendored(pkgdir, dep)
+			if problem != "" {
+				Log("%s (%s): %s", dep.Name, dep.Hash, problem)
+				broken = append(broken, brokenDep{dep, problem})
+				if c.Bool("dry-run") {
+					continue
+				}
+
+				if dpkg, err = refetchDep(pm, pkgdir, dep); err != nil {
+					return fmt.Errorf("fetching %s: %s", dep.Name, err)
+				}
+			}
+			queue = append(queue, dpkg.Dependencies...)
+		}
+
+		if len(broken) == 0 {
+			Log("all vendored dependencies are intact")
+			return nil
+		}
+		if c.Bool("dry-run") {
+			return nil
+		}
+
+		// dependencies are found after their dependents, rewrite them first
+		for i := len(broken) - 1; i >= 0; i-- {
+			d := broken[i].Dep
+			if err := postInstallHook(filepath.Join(pkgdir, d.Hash), false); err != nil {
+				return fmt.Errorf("rewriting %s: %s", d.Name, err)
+			}
+		}
+
+		Log("repaired %d dependencies", len(broken))
+		return nil
+	},
+}
+
+// checkVendored returns what is wrong with the vendored copy of dep, if
+// anything, along with its package when intact
+func checkVendored(pkgdir string, dep *gx.Dependency) (string, *Package) {
+	for _, h := range hashPaths(dep.Hash) {
+		d := filepath.Join(pkgdir, filepath.FromSlash(h))
+		if _, err := os.Stat(d); err != nil {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(d)
+		if err != nil {
+			return err.Error(), nil
+		}
+		if len(entries) == 0 {
+			return "empty directory", nil
+		}
+
+		var p Package
+		if err := gx.FindPackageInDir(&p, d); err != nil {
+			return "missing package.json", nil
+		}
+
+		if p.Name != dep.Name {
+			return fmt.Sprintf("holds %s, not %s", p.Name, dep.Name), nil
+		}
+		if dep.Version != "" && p.Version != dep.Version {
+			return fmt.Sprintf("holds version %s, not %s", p.Version, dep.Version), nil
+		}
+
+		if _, err := os.Stat(filepath.Join(d, p.Name)); err != nil {
+			return "missing package source", nil
+		}
+		if sum, err := ioutil.ReadFile(filepath.Join(d, installSumFile)); err == nil {
+			if have, err := installedDigest(d); err != nil || have != strings.TrimSpace(string(sum)) {
+				return "content changed since it was installed", nil
+			}
+		}
+		return "", &p
+	}
+
+	// installed globally
+	if p, _, err := findDepUncached(dep, pkgdir); err == nil {
+		return "", p
+	}
+	return "missing", nil
+}
+
+// installSumFile records, next to the source of an installed package, the
+// digest of its content as the post-install hook left it
+const installSumFile = ".gx-go-sum"
+
+// installedDigest returns a digest of the files of the package installed at
+// npkg, leaving out what gx-go keeps next to them
+func installedDigest(npkg string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(npkg, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(npkg, p)
+		switch rel {
+		case installSumFile, rewriteMarkerFile, ".gx-go.lock":
+			return nil
+		}
+
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "link %s %s\x00", filepath.ToSlash(rel), target)
+		case fi.Mode().IsRegular():
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			fmt.Fprintf(h, "file %s %x\x00", filepath.ToSlash(rel), sum)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// recordInstalledDigest records the digest of the package installed at
+// npkg, for checkVendored to find changes to it by
+func recordInstalledDigest(npkg string) error {
+	digest, err := installedDigest(npkg)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(npkg, installSumFile), []byte(digest+"\n"), 0644)
+}
+
+// refetchDep removes every copy of dep from pkgdir and fetches it again
+func refetchDep(pm packageStore, pkgdir string, dep *gx.Dependency) (*Package, error) {
+	for _, h := range hashPaths(dep.Hash) {
+		if err := os.RemoveAll(filepath.Join(pkgdir, filepath.FromSlash(h))); err != nil {
+			return nil, err
+		}
+	}
+
+	err := ipfsCall(func() error {
+		_, err := pm.GetPackageTo(dep.Hash, filepath.Join(pkgdir, dep.Hash))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var p Package
+	if err := gx.FindPackageInDir(&p, filepath.Join(pkgdir, dep.Hash)); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// importReport describes the outcome of an import, for consumption by other
+// tools, see 'import --report'
+type importReport struct {
+	Package   string            `json:"package"`
+	Packages  []string          `json:"packages,omitempty"` // of a manifest
+	Started   time.Time         `json:"started"`
+	Finished  time.Time         `json:"finished"`
+	Published []publishedReport `json:"published"`
+	Reused    []reusedReport    `json:"reused"`
+	Skipped   []string          `json:"skipped,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+type publishedReport struct {
+	Import  string `json:"import"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Hash    string `json:"hash"`
+	VcsType string `json:"vcstype,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+}
+
+// reusedReport is a mapping to an already published package, Source says
+// where it came from: "map" or "index"
+type reusedReport struct {
+	Import string `json:"import"`
+	Hash   string `json:"hash"`
+	Source string `json:"source"`
+}
+
+func newImportReport(pkg string) *importReport {
+	return &importReport{
+		Package:   pkg,
+		Started:   time.Now(),
+		Published: []publishedReport{},
+		Reused:    []reusedReport{},
+	}
+}
+
+// write finishes the report with the outcome of the import and writes it to
+// the given file
+func (r *importReport) write(path string, err error) error {
+	r.Finished = time.Now()
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	out, merr := json.MarshalIndent(r, "", "  ")
+	if merr != nil {
+		return merr
+	}
+	return ioutil.WriteFile(path, append(out, '\n'), 0644)
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// revendorRounds bounds how often revendor checks the tree again, as the
+// dependencies of re-fetched packages are only known once they are fetched
+const revendorRounds = 5
+
+var RevendorCommand = cli.Command{
+	Name:  "revendor",
+	Usage: "restore the vendor directory to what package.json specifies after a merge",
+	Description: `finds vendored packages that a merge left conflicted or inconsistent,
+removes them and installs exactly the dependency tree package.json
+specifies in their place, from the gx-go cache or by fetching it. Only the
+re-installed packages are rewritten, as the post-install hook would.
+
+A vendored package is replaced if it is missing or broken as 'repair'
+checks, has unmerged paths in git, contains conflict markers, or imports
+gx packages outside of its dependency tree, as happens when the halves of
+two versions are merged. Vendored packages that are neither in the
+dependency tree nor in one 'install --at' installed are removed.
+
+Dependencies installed globally and active overlays are left alone, the
+dependencies of overlays are checked like any other.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only report what would be replaced or removed",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		ovs, err := loadOverlays()
+		if err != nil {
+			return err
+		}
+
+		unmerged, err := unmergedVendored(pkgdir)
+		if err != nil {
+			return err
+		}
+
+		at, err := loadInstalledAt()
+		if err != nil {
+			return err
+		}
+
+		var pm packageStore
+		var replaced, removed int
+		for round := 0; round < revendorRounds; round++ {
+			broken, extra, err := revendorCheck(pkg, pkgdir, ovs, at, unmerged)
+			if err != nil {
+				return err
+			}
+			if len(broken) == 0 && len(extra) == 0 {
+				break
+			}
+
+			for _, b := range broken {
+				Log("%s (%s): %s", b.Dep.Name, b.Dep.Hash, b.Problem)
+			}
+			for _, h := range extra {
+				Log("%s: not in the dependency tree", h)
+			}
+			if c.Bool("dry-run") {
+				return nil
+			}
+
+			for _, b := range broken {
+				if _, err := reinstallDep(&pm, pkgdir, b.Dep); err != nil {
+					return err
+				}
+				// what git says about the old copy no longer holds
+				for _, f := range hashForms(b.Dep.Hash) {
+					delete(unmerged, f)
+				}
+			}
+			for _, h := range extra {
+				if err := os.RemoveAll(filepath.Join(pkgdir, filepath.FromSlash(h))); err != nil {
+					return err
+				}
+			}
+			removeEmptyShards(pkgdir)
+
+			// dependencies are found after their dependents, rewrite them first
+			for i := len(broken) - 1; i >= 0; i-- {
+				d := broken[i].Dep
+				if err := postInstallHook(filepath.Join(pkgdir, d.Hash), false); err != nil {
+					return fmt.Errorf("rewriting %s: %s", d.Name, err)
+				}
+			}
+			replaced += len(broken)
+			removed += len(extra)
+		}
+
+		if replaced == 0 && removed == 0 {
+			Log("the vendor directory matches package.json")
+			return nil
+		}
+		Log("re-installed %d packages, removed %d", replaced, removed)
+		if len(unmerged) > 0 || replaced > 0 {
+			Log("if the merge is still in progress, mark the vendor directory resolved with 'git add %s'", filepath.Dir(filepath.Dir(vendorDir)))
+		}
+		return nil
+	},
+}
+
+// revendorCheck returns the packages in the dependency tree of pkg whose
+// vendored copies in pkgdir have to be replaced, and the paths of vendored
+// packages outside of the tree and of the trees 'install --at' installed
+func revendorCheck(pkg *Package, pkgdir string, ovs map[string]*overlay, at map[string][]*gx.Dependency, unmerged map[string]bool) ([]brokenDep, []string, error) {
+	var broken []brokenDep
+	var intact []*gx.Dependency
+	pkgs := make(map[string]*Package)
+	reached := make(map[string]bool)
+	queue := append([]*gx.Dependency{}, pkg.Dependencies...)
+	for len(queue) > 0 {
+		if err := cancelled(); err != nil {
+			return nil, nil, err
+		}
+
+		dep := queue[0]
+		queue = queue[1:]
+		if reached[dep.Hash] {
+			continue
+		}
+		reached[dep.Hash] = true
+
+		// overlays are left alone, their dependencies are not
+		if ovs[dep.Hash] != nil {
+			if dpkg, _, err := findDepUncached(dep, pkgdir); err == nil {
+				pkgs[dep.Hash] = dpkg
+				queue = append(queue, dpkg.Dependencies...)
+			}
+			continue
+		}
+
+		problem, dpkg := checkVendored(pkgdir, dep)
+		if problem != "" {
+			broken = append(broken, brokenDep{dep, problem})
+			continue
+		}
+		pkgs[dep.Hash] = dpkg
+		intact = append(intact, dep)
+		queue = append(queue, dpkg.Dependencies...)
+	}
+
+	// what a package may import is only known once its tree is
+	for _, dep := range intact {
+		if problem := vendoredConflict(pkgdir, dep.Hash, depClosure(dep.Hash, pkgs), unmerged); problem != "" {
+			broken = append(broken, brokenDep{dep, problem})
+		}
+	}
+
+	// packages of broken ones are not known yet, keep what may be theirs
+	if len(broken) > 0 {
+		return broken, nil, nil
+	}
+
+	for _, deps := range at {
+		queue = append(queue, deps...)
+	}
+	for len(queue) > 0 {
+		dep := queue[0]
+		queue = queue[1:]
+		if reached[dep.Hash] {
+			continue
+		}
+		reached[dep.Hash] = true
+		if _, dpkg := checkVendored(pkgdir, dep); dpkg != nil {
+			queue = append(queue, dpkg.Dependencies...)
+		}
+	}
+
+	forms := make(map[string]bool)
+	for h := range reached {
+		for _, f := range hashForms(h) {
+			forms[f] = true
+		}
+	}
+	vendored, err := vendoredHashes(pkgdir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var extra []string
+	for h, rel := range vendored {
+		if !forms[h] && isVendoredHash(h) {
+			extra = append(extra, rel)
+		}
+	}
+	sort.Strings(extra)
+	return broken, extra, nil
+}
+
+// depClosure returns every form of the hashes in the dependency tree of the
+// package published as hash, itself included, as far as pkgs has their
+// packages
+func depClosure(hash string, pkgs map[string]*Package) map[string]bool {
+	out := make(map[string]bool)
+	queue := []string{hash}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if out[h] {
+			continue
+		}
+		for _, f := range hashForms(h) {
+			out[f] = true
+		}
+		if p := pkgs[h]; p != nil {
+			for _, d := range p.Dependencies {
+				queue = append(queue, d.Hash)
+			}
+		}
+	}
+	return out
+}
+
+// isVendoredHash returns whether name, an entry of the vendor directory,
+// is a vendored package rather than a file gx-go keeps next to them
+func isVendoredHash(name string) bool {
+	return !strings.HasPrefix(name, ".") && !strings.Contains(name, ".")
+}
+
+// vendoredConflict returns what a merge did to the intact looking vendored
+// copy of the package published as hash, if anything. deps are the hashes,
+// in every form, the package may import.
+func vendoredConflict(pkgdir, hash string, deps map[string]bool, unmerged map[string]bool) string {
+	for _, f := range hashForms(hash) {
+		if unmerged[f] {
+			return "unmerged in git"
+		}
+	}
+
+	dir := ""
+	for _, h := range hashPaths(hash) {
+		d := filepath.Join(pkgdir, filepath.FromSlash(h))
+		if _, err := os.Stat(d); err == nil {
+			dir = d
+			break
+		}
+	}
+
+	var problem string
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || problem != "" {
+			return err
+		}
+		if fi.IsDir() {
+			if fi.Name() == "vendor" && p != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, ".go") && !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+		if hasConflictMarkers(p) {
+			problem = "conflict markers in " + filepath.ToSlash(rel)
+			return nil
+		}
+		if !strings.HasSuffix(p, ".go") {
+			return nil
+		}
+
+		f, err := parser.ParseFile(token.NewFileSet(), p, nil, parser.ImportsOnly)
+		if err != nil {
+			problem = fmt.Sprintf("%s does not parse", filepath.ToSlash(rel))
+			return nil
+		}
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			if strings.HasPrefix(path, "gx/ipfs/") && !deps[hashFromImport(path)] {
+				problem = fmt.Sprintf("%s imports %s, which is not a dependency", filepath.ToSlash(rel), hashFromImport(path))
+				return nil
+			}
+		}
+		return nil
+	})
+	return problem
+}
+
+// hasConflictMarkers returns whether the file at p contains the markers git
+// leaves around conflicting hunks
+func hasConflictMarkers(p string) bool {
+	fi, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer fi.Close()
+
+	s := bufio.NewScanner(fi)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		l := s.Bytes()
+		if bytes.HasPrefix(l, []byte("<<<<<<< ")) || bytes.HasPrefix(l, []byte(">>>>>>> ")) {
+			return true
+		}
+	}
+	return false
+}
+
+// unmergedVendored returns the hashes of the packages in pkgdir that have
+// unmerged paths in git. Outside of a git repository there are none.
+func unmergedVendored(pkgdir string) (map[string]bool, error) {
+	out := make(map[string]bool)
+	cmd := exec.CommandContext(cancelCtx, "git", "ls-files", "-u", "-z", "--full-name", "--", ".")
+	cmd.Dir = pkgdir
+	data, err := cmd.Output()
+	if err != nil {
+		if _, serr := os.Stat(pkgdir); os.IsNotExist(serr) {
+			return out, nil
+		}
+		VLog("  - not checking for unmerged paths: %s", err)
+		return out, nil
+	}
+
+	cmd = exec.CommandContext(cancelCtx, "git", "rev-parse", "--show-prefix")
+	cmd.Dir = pkgdir
+	prefix, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range strings.Split(string(data), "\x00") {
+		parts := strings.SplitN(entry, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		rel := strings.TrimPrefix(parts[1], strings.TrimSpace(string(prefix)))
+		elems := strings.Split(rel, "/")
+		if len(elems[0]) == 2 && len(elems) > 1 {
+			elems = elems[1:]
+		}
+		out[elems[0]] = true
+	}
+	return out, nil
+}
@@ -3,11 +3,13 @@ package rewrite
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/printer"
 	"go/token"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"strconv"
@@ -27,16 +29,34 @@ func init() {
 	}
 }
 
+// Warn reports the files RewriteImports could not rewrite and left as they
+// are. It prints to stderr unless replaced.
+var Warn = func(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
 func RewriteImports(path string, rw func(string) string, filter func(string) bool) error {
+	return RewriteImportsContext(context.Background(), path, rw, filter)
+}
+
+// RewriteImportsContext is RewriteImports, stopping between files once ctx
+// is done. Files are replaced atomically, so none is left half rewritten.
+func RewriteImportsContext(ctx context.Context, path string, rw func(string) string, filter func(string) bool) error {
 	w := fs.Walk(path)
 	for w.Step() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		rel := w.Path()[len(path):]
 		if len(rel) == 0 {
 			continue
 		}
 		rel = rel[1:]
 
-		if strings.HasPrefix(rel, ".git") || strings.HasPrefix(rel, "vendor") {
+		// vendored code, also of vendor directories further down, is not
+		// ours to rewrite
+		if isVcsDir(rel) || strings.Contains("/"+rel+"/", "/vendor/") {
 			w.SkipDir()
 			continue
 		}
@@ -49,28 +69,66 @@ func RewriteImports(path string, rw func(string) string, filter func(string) boo
 			continue
 		}
 
-		err := rewriteImportsInFile(w.Path(), rw)
-		if err != nil {
-			fmt.Println("rewrite error: ", err)
+		if err := rewriteImportsInFile(w.Path(), rw); err != nil {
+			Warn("rewrite error: %s", err)
 		}
 	}
 	return nil
 }
 
+// isVcsDir returns whether the given relative path is inside of the metadata
+// directory of a version control system. Only the directory itself counts,
+// not others starting with its name, like .github.
+func isVcsDir(rel string) bool {
+	first := strings.SplitN(rel, "/", 2)[0]
+	for _, d := range []string{".git", ".hg", ".bzr", ".svn"} {
+		if first == d {
+			return true
+		}
+	}
+	return false
+}
+
 // inspired by godeps rewrite, rewrites import paths with gx vendored names
 func rewriteImportsInFile(fi string, rw func(string) string) error {
+	src, err := ioutil.ReadFile(fi)
+	if err != nil {
+		return err
+	}
+
+	out, err := RewriteSource(fi, src, rw)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(out, src) {
+		return nil
+	}
+
+	wpath := fi + ".temp"
+	if err := ioutil.WriteFile(wpath, out, 0666); err != nil {
+		os.Remove(wpath)
+		return err
+	}
+
+	return os.Rename(wpath, fi)
+}
+
+// RewriteSource rewrites the import paths of the go source src with rw, as
+// RewriteImports does for files. It returns src itself if no import
+// changes.
+func RewriteSource(name string, src []byte, rw func(string) string) ([]byte, error) {
 	cfg := &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
 	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, fi, nil, parser.ParseComments)
+	file, err := parser.ParseFile(fset, name, src, parser.ParseComments)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var changed bool
 	for _, imp := range file.Imports {
 		p, err := strconv.Unquote(imp.Path.Value)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		np := rw(p)
@@ -82,18 +140,18 @@ func rewriteImportsInFile(fi string, rw func(string) string) error {
 	}
 
 	if !changed {
-		return nil
+		return src, nil
 	}
 
 	buf := bufpool.Get().(*bytes.Buffer)
 	if err = cfg.Fprint(buf, fset, file); err != nil {
-		return err
+		return nil, err
 	}
 
 	fset = token.NewFileSet()
-	file, err = parser.ParseFile(fset, fi, buf, parser.ParseComments)
+	file, err = parser.ParseFile(fset, name, buf, parser.ParseComments)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	buf.Reset()
@@ -101,21 +159,11 @@ func rewriteImportsInFile(fi string, rw func(string) string) error {
 
 	ast.SortImports(fset, file)
 
-	wpath := fi + ".temp"
-	w, err := os.Create(wpath)
-	if err != nil {
-		return err
-	}
-
-	if err = cfg.Fprint(w, fset, file); err != nil {
-		return err
+	var out bytes.Buffer
+	if err = cfg.Fprint(&out, fset, file); err != nil {
+		return nil, err
 	}
-
-	if err = w.Close(); err != nil {
-		return err
-	}
-
-	return os.Rename(wpath, fi)
+	return out.Bytes(), nil
 }
 
 func fixCanonicalImports(buf []byte) (bool, error) {
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// installLockTimeout is how long the post-install hook waits for another
+// gx-go process rewriting the same installed package
+var installLockTimeout = 5 * time.Minute
+
+// rewriteMarkerFile records, next to the source of an installed package, the
+// digest of the mapping it was rewritten with
+const rewriteMarkerFile = ".gx-go-rewritten"
+
+// lockInstalled takes the lock of the package installed at npkg, which
+// projects sharing a global install path may be rewriting at the same time
+func lockInstalled(npkg string) (func(), error) {
+	return waitLockDir(filepath.Join(npkg, ".gx-go"), installLockTimeout)
+}
+
+// mappingDigest returns a digest of mapping that does not depend on the
+// order of its entries
+func mappingDigest(mapping map[string]string) string {
+	lines := make([]string, 0, len(mapping))
+	for k, v := range mapping {
+		lines = append(lines, k+" "+v)
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// rewrittenWith returns whether the package installed at npkg was already
+// rewritten with the mapping of the given digest
+func rewrittenWith(npkg, digest string) bool {
+	b, err := ioutil.ReadFile(filepath.Join(npkg, rewriteMarkerFile))
+	return err == nil && strings.TrimSpace(string(b)) == digest
+}
+
+// forgetRewrite removes the record of how the package installed at npkg was
+// rewritten, for rewrites other than the post-install hook's
+func forgetRewrite(npkg string) {
+	os.Remove(filepath.Join(npkg, rewriteMarkerFile))
+}
+
+// markRewritten records that the package installed at npkg was rewritten
+// with the mapping of the given digest
+func markRewritten(npkg, digest string) error {
+	return ioutil.WriteFile(filepath.Join(npkg, rewriteMarkerFile), []byte(digest+"\n"), 0644)
+}
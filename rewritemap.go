@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pkgPath resolves p relative to the package directory dir
+func pkgPath(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// writeRewriteMap writes a rewrite mapping as written by 'rewrite --emit-map'
+func writeRewriteMap(file string, mapping map[string]string) error {
+	out, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(out, '\n'), 0644)
+}
+
+// loadRewriteMap loads a mapping written by 'rewrite --emit-map', inverting
+// it if it maps in the other direction than asked for by undo. It warns
+// about gx imports in the mapping whose packages are not installed in
+// pkgdir, which means the mapping is stale.
+func loadRewriteMap(file, pkgdir string, undo bool) (map[string]string, error) {
+	mapping := make(map[string]string)
+	if err := loadMap(&mapping, file); err != nil {
+		return nil, fmt.Errorf("loading mapping: %s", err)
+	}
+
+	var missing []string
+	toGx := true
+	for k, v := range mapping {
+		gximp := v
+		if strings.HasPrefix(k, "gx/") {
+			toGx = false
+			gximp = k
+		}
+
+		if !hashInstalled(pkgdir, hashFromImport(gximp)) {
+			missing = append(missing, gximp)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		Warn("%s is stale, these packages are not installed:", file)
+		for _, m := range missing {
+			Warn("  - %s", m)
+		}
+	}
+
+	if toGx == !undo {
+		return mapping, nil
+	}
+
+	inverted := make(map[string]string)
+	for k, v := range mapping {
+		inverted[v] = k
+	}
+	return inverted, nil
+}
+
+// hashInstalled returns whether the package with the given hash is
+// installed in pkgdir or globally
+func hashInstalled(pkgdir, hash string) bool {
+	for _, h := range hashPaths(hash) {
+		for _, root := range []string{pkgdir, globalPath()} {
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(h))); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
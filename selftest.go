@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gxgotest "github.com/whyrusleeping/gx-go/gxgotest"
+)
+
+var SelftestCommand = cli.Command{
+	Name:  "selftest",
+	Usage: "run the import, install and rewrite pipeline against a fake package store",
+	Description: `imports a small package with a dependency, installs it into a project,
+rewrites and builds the project and undoes the rewrite, all in a temporary
+directory, with packages published to a fake store on disk instead of ipfs.
+Neither gx nor an ipfs daemon are needed, making it a quick check that
+gx-go works on this machine.
+
+The same environment is available to the tests of other projects through
+the gxgotest package, and any gx-go command uses a fake store when
+GX_GO_FAKE_STORE names a directory.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "keep",
+			Usage: "keep the temporary directory for inspection",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		dir, err := ioutil.TempDir("", "gx-go-selftest")
+		if err != nil {
+			return err
+		}
+		if c.Bool("keep") {
+			Log("running in %s", dir)
+		} else {
+			defer os.RemoveAll(dir)
+		}
+
+		env, err := gxgotest.New(dir)
+		if err != nil {
+			return err
+		}
+		if env.Bin, err = os.Executable(); err != nil {
+			return err
+		}
+
+		var failed int
+		for _, s := range selftestSteps(env) {
+			if err := cancelled(); err != nil {
+				return err
+			}
+			if err := s.run(); err != nil {
+				Log("FAIL %s: %s", s.name, err)
+				failed++
+				break
+			}
+			Log("ok   %s", s.name)
+		}
+		if failed > 0 {
+			return fmt.Errorf("selftest failed")
+		}
+		return nil
+	},
+}
+
+type selftestStep struct {
+	name string
+	run  func() error
+}
+
+const (
+	selftestLib  = "example.com/gx-go-selftest/lib"
+	selftestApp  = "example.com/gx-go-selftest/app"
+	selftestProj = "example.com/gx-go-selftest/proj"
+)
+
+func selftestSteps(env *gxgotest.Env) []selftestStep {
+	proj := filepath.Join(env.GoPath, "src", filepath.FromSlash(selftestProj))
+	main := filepath.Join(proj, "main.go")
+	var hash string
+
+	return []selftestStep{
+		{"write packages", func() error {
+			if _, err := env.WritePackage(selftestLib, map[string]string{
+				"lib.go": "package lib\n\nfunc Answer() int { return 42 }\n",
+			}); err != nil {
+				return err
+			}
+			_, err := env.WritePackage(selftestApp, map[string]string{
+				"app.go": "package app\n\nimport \"" + selftestLib + "\"\n\nfunc Answer() int { return lib.Answer() }\n",
+			})
+			return err
+		}},
+		{"import", func() error {
+			var err error
+			hash, err = env.Import(selftestApp)
+			return err
+		}},
+		{"install", func() error {
+			if err := os.MkdirAll(proj, 0755); err != nil {
+				return err
+			}
+			pkg := map[string]interface{}{
+				"name":           "proj",
+				"version":        "0.0.0",
+				"language":       "go",
+				"gx":             map[string]string{"dvcsimport": selftestProj},
+				"gxDependencies": []map[string]string{{"name": "app", "hash": hash, "version": "0.0.0"}},
+			}
+			data, err := json.MarshalIndent(pkg, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filepath.Join(proj, "package.json"), data, 0644); err != nil {
+				return err
+			}
+			src := "package main\n\nimport \"" + selftestApp + "\"\n\nfunc main() { println(app.Answer()) }\n"
+			if err := ioutil.WriteFile(main, []byte(src), 0644); err != nil {
+				return err
+			}
+			return env.Install(proj, hash)
+		}},
+		{"rewrite", func() error {
+			if _, err := env.Run(proj, "rewrite"); err != nil {
+				return err
+			}
+			return selftestImports(main, "gx/ipfs/"+hash+"/app")
+		}},
+		{"build", func() error {
+			if _, err := exec.LookPath("go"); err != nil {
+				Log("     no go command, not building")
+				return nil
+			}
+			cmd := exec.CommandContext(cancelCtx, "go", "build", "-o", os.DevNull, ".")
+			cmd.Dir = proj
+			cmd.Env = env.Environ()
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("go build: %s", strings.TrimSpace(string(out)))
+			}
+			return nil
+		}},
+		{"undo rewrite", func() error {
+			if _, err := env.Run(proj, "rewrite", "--undo"); err != nil {
+				return err
+			}
+			return selftestImports(main, selftestApp)
+		}},
+	}
+}
+
+// selftestImports checks that the go file at p imports imp
+func selftestImports(p, imp string) error {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(data), `"`+imp+`"`) {
+		return fmt.Errorf("%s does not import %s:\n%s", filepath.Base(p), imp, data)
+	}
+	return nil
+}
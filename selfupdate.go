@@ -0,0 +1,402 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	cli "github.com/codegangsta/cli"
+)
+
+const (
+	// selfUpdateDist is the ipfs distributions path gx-go releases are
+	// published under
+	selfUpdateDist = "/ipns/dist.ipfs.io/gx-go"
+
+	// selfUpdateRepo is the github repository gx-go releases are published to
+	selfUpdateRepo = "whyrusleeping/gx-go"
+)
+
+var downloadClient = &http.Client{Timeout: 5 * time.Minute}
+
+// releasePubKey and gxReleasePubKey are the base64 ed25519 public keys gx-go
+// and gx releases are signed with. Release builds embed them with
+// -ldflags "-X main.releasePubKey=<key> -X main.gxReleasePubKey=<key>".
+var (
+	releasePubKey   string
+	gxReleasePubKey string
+)
+
+// release is a downloadable gx-go build for this platform
+type release struct {
+	Version string
+	URL     string
+
+	// Sum is the expected checksum of the archive, computed with NewHash
+	Sum     string
+	NewHash func() hash.Hash
+
+	// SigURL, if set, is where a detached signature of the archive is
+	SigURL string
+}
+
+var SelfUpdateCommand = cli.Command{
+	Name:  "self-update",
+	Usage: "update gx-go to the latest release",
+	Description: `downloads the latest gx-go release for this platform, verifies its
+checksum, and replaces the running binary with it. Releases are fetched
+from the ipfs distributions site through the configured gateway, or from
+github releases with --source github.
+
+The release must also carry a valid signature by the release public key
+embedded in gx-go, read from the archive url with '.sig' appended unless
+the release lists one. --pubkey (a base64 ed25519 public key) replaces the
+embedded key, builds without one need it to update.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "source",
+			Usage: "where to fetch releases from: ipfs or github",
+			Value: "ipfs",
+		},
+		cli.BoolFlag{
+			Name:  "check",
+			Usage: "only report whether an update is available",
+		},
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "install the latest release even if it is not newer",
+		},
+		cli.StringFlag{
+			Name:   "pubkey",
+			Usage:  "base64 ed25519 public key releases must be signed with, instead of the embedded one",
+			EnvVar: "GX_GO_RELEASE_PUBKEY",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		var rel *release
+		var err error
+		switch c.String("source") {
+		case "ipfs":
+			rel, err = distRelease(selfUpdateDist, "gx-go", "")
+		case "github":
+			rel, err = latestGithubRelease()
+		default:
+			return fmt.Errorf("unrecognized release source %q, must be ipfs or github", c.String("source"))
+		}
+		if err != nil {
+			return fmt.Errorf("finding latest release: %s", err)
+		}
+
+		newer, err := versionComp(GxGoVersion, rel.Version)
+		if err != nil {
+			return fmt.Errorf("comparing versions: %s", err)
+		}
+
+		if c.Bool("check") {
+			if newer {
+				Log("gx-go %s is available, you have %s", rel.Version, GxGoVersion)
+			} else {
+				Log("gx-go %s is up to date", GxGoVersion)
+			}
+			return nil
+		}
+
+		if !newer && !c.Bool("force") {
+			Log("gx-go %s is up to date", GxGoVersion)
+			return nil
+		}
+
+		pubkey, err := releaseKey(c.String("pubkey"), releasePubKey)
+		if err != nil {
+			return err
+		}
+
+		Log("downloading gx-go %s from %s", rel.Version, rel.URL)
+		archive, err := download(rel.URL)
+		if err != nil {
+			return err
+		}
+
+		if err := rel.verify(archive, pubkey); err != nil {
+			return fmt.Errorf("verifying release: %s", err)
+		}
+
+		bin, err := binFromArchive(archive, "gx-go")
+		if err != nil {
+			return err
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		exe, err = filepath.EvalSymlinks(exe)
+		if err != nil {
+			return err
+		}
+
+		if err := replaceBinary(exe, bin); err != nil {
+			return fmt.Errorf("replacing %s: %s", exe, err)
+		}
+
+		Log("updated %s from %s to %s", exe, GxGoVersion, rel.Version)
+		return nil
+	},
+}
+
+// distPlatform is the platform name used by the ipfs distributions
+func distPlatform() string {
+	return runtime.GOOS + "-" + runtime.GOARCH
+}
+
+// distRelease finds the given version of the program name published under
+// the ipfs distributions path distPath, or its latest release if version
+// is ""
+func distRelease(distPath, name, version string) (*release, error) {
+	gateway := defaultGateway
+	if cfg, err := loadConfig(); err == nil && cfg.Gateway != "" {
+		gateway = cfg.Gateway
+	}
+	base := registryURL(distPath, gateway)
+
+	versions, err := download(base + "/versions")
+	if err != nil {
+		return nil, err
+	}
+
+	var latest string
+	s := bufio.NewScanner(bytes.NewReader(versions))
+	for s.Scan() {
+		v := strings.TrimSpace(s.Text())
+		if v == "" {
+			continue
+		}
+		if version == "" || strings.TrimPrefix(v, "v") == strings.TrimPrefix(version, "v") {
+			latest = v
+		}
+	}
+	if latest == "" && version != "" {
+		return nil, fmt.Errorf("%s %s is not listed at %s", name, version, base)
+	}
+	if latest == "" {
+		return nil, fmt.Errorf("no versions listed at %s", base)
+	}
+
+	data, err := download(base + "/" + latest + "/dist.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var dist struct {
+		Platforms map[string]struct {
+			Archs map[string]struct {
+				Link   string `json:"link"`
+				Sha512 string `json:"sha512"`
+			} `json:"archs"`
+		} `json:"platforms"`
+	}
+	if err := json.Unmarshal(data, &dist); err != nil {
+		return nil, fmt.Errorf("decoding dist.json: %s", err)
+	}
+
+	a, ok := dist.Platforms[runtime.GOOS].Archs[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("%s %s has no build for %s", name, latest, distPlatform())
+	}
+	if a.Sha512 == "" {
+		return nil, fmt.Errorf("%s %s lists no checksum for %s", name, latest, distPlatform())
+	}
+
+	return &release{
+		Version: strings.TrimPrefix(latest, "v"),
+		URL:     base + "/" + latest + "/" + strings.TrimPrefix(a.Link, "/"),
+		Sum:     a.Sha512,
+		NewHash: sha512.New,
+	}, nil
+}
+
+// latestGithubRelease finds the latest release on github, along with the
+// checksum listed for it in the releases checksums file
+func latestGithubRelease() (*release, error) {
+	var r struct {
+		TagName string `json:"tag_name"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	if err := githubGet("/repos/"+selfUpdateRepo+"/releases/latest", &r); err != nil {
+		return nil, err
+	}
+
+	rel := &release{
+		Version: strings.TrimPrefix(r.TagName, "v"),
+		NewHash: sha256.New,
+	}
+
+	var name, sums string
+	for _, a := range r.Assets {
+		switch {
+		case strings.Contains(a.Name, distPlatform()) && strings.HasSuffix(a.Name, ".tar.gz"):
+			name, rel.URL = a.Name, a.URL
+		case strings.HasSuffix(a.Name, ".tar.gz.sig") && strings.Contains(a.Name, distPlatform()):
+			rel.SigURL = a.URL
+		case a.Name == "SHA256SUMS" || strings.HasSuffix(a.Name, "checksums.txt"):
+			sums = a.URL
+		}
+	}
+
+	if rel.URL == "" {
+		return nil, fmt.Errorf("gx-go %s has no build for %s", r.TagName, distPlatform())
+	}
+	if sums == "" {
+		return nil, fmt.Errorf("gx-go %s has no checksums file", r.TagName)
+	}
+
+	data, err := download(sums)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		f := strings.Fields(l)
+		if len(f) == 2 && strings.TrimPrefix(f[1], "*") == name {
+			rel.Sum = f[0]
+		}
+	}
+	if rel.Sum == "" {
+		return nil, fmt.Errorf("no checksum listed for %s", name)
+	}
+
+	return rel, nil
+}
+
+// releaseKey decodes the base64 ed25519 public key releases are verified
+// with: the given one, or the embedded one without it
+func releaseKey(given, embedded string) (ed25519.PublicKey, error) {
+	k := given
+	if k == "" {
+		k = embedded
+	}
+	if k == "" {
+		return nil, fmt.Errorf("no release public key is embedded in this build, give one with --pubkey")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(k)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid release public key")
+	}
+	return raw, nil
+}
+
+// verify checks the archive against the releases checksum and its signature
+// by pubkey
+func (r *release) verify(archive []byte, pubkey ed25519.PublicKey) error {
+	h := r.NewHash()
+	h.Write(archive)
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, r.Sum) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", r.Sum, sum)
+	}
+
+	sigURL := r.SigURL
+	if sigURL == "" {
+		sigURL = r.URL + ".sig"
+	}
+	sig, err := download(sigURL)
+	if err != nil {
+		return fmt.Errorf("fetching signature: %s", err)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = raw
+	}
+	if !ed25519.Verify(pubkey, archive, sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func download(u string) ([]byte, error) {
+	resp, err := downloadClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// binFromArchive returns the binary of the program name in a release archive
+func binFromArchive(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+
+	want := name
+	if runtime.GOOS == "windows" {
+		want += ".exe"
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s binary in release archive", want)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag == tar.TypeReg && path.Base(hdr.Name) == want {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+// replaceBinary atomically replaces the executable at exe with bin
+func replaceBinary(exe string, bin []byte) error {
+	tmp := exe + ".new"
+	if err := ioutil.WriteFile(tmp, bin, 0755); err != nil {
+		return err
+	}
+
+	// a running executable cannot be replaced on windows, but it can be
+	// moved out of the way
+	old := exe + ".old"
+	if runtime.GOOS == "windows" {
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, exe); err != nil {
+		os.Remove(tmp)
+		if runtime.GOOS == "windows" {
+			os.Rename(old, exe)
+		}
+		return err
+	}
+	return nil
+}
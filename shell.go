@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+)
+
+var ShellCommand = cli.Command{
+	Name:      "shell",
+	Usage:     "spawn a shell (or run a command) in an environment set up for gx",
+	ArgsUsage: "[-- command [args...]]",
+	Description: `spawns $SHELL with GOPATH and PATH set up so that the go toolchain
+resolves the gx dependencies of the current package, and tools installed
+with 'install-tools' are available. If a command is given after '--', it
+is run in that environment instead.
+
+Use --gopath to enter a GOPATH created by 'import --tmpdir'.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "gopath",
+			Usage: "GOPATH to use instead of the current one",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		env, err := gxEnv(c.String("gopath"))
+		if err != nil {
+			return err
+		}
+
+		args := []string(c.Args())
+		if len(args) == 0 {
+			args = []string{userShell()}
+			Log("entering gx shell, exit to return")
+		}
+
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = env
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	},
+}
+
+func userShell() string {
+	if sh := os.Getenv("SHELL"); sh != "" {
+		return sh
+	}
+	if runtime.GOOS == "windows" {
+		if sh := os.Getenv("COMSPEC"); sh != "" {
+			return sh
+		}
+		return "cmd.exe"
+	}
+	return "/bin/sh"
+}
+
+// gxEnv returns the current environment modified so that the go toolchain
+// resolves gx dependencies from the given (or current) GOPATH
+func gxEnv(gopath string) ([]string, error) {
+	if gopath == "" {
+		gp, err := getGoPath()
+		if err != nil {
+			return nil, fmt.Errorf("couldnt determine gopath: %s", err)
+		}
+		gopath = gp
+	}
+
+	gopath, err := filepath.Abs(gopath)
+	if err != nil {
+		return nil, err
+	}
+
+	bindir, err := binInstallPath(false)
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Join([]string{
+		bindir,
+		filepath.Join(gopath, "bin"),
+		os.Getenv("PATH"),
+	}, string(os.PathListSeparator))
+
+	// packages installed globally outside of the GOPATH have to be found too
+	gopaths := gopath
+	if root := globalRootOverridden(); root != "" {
+		gopaths += string(os.PathListSeparator) + root
+	}
+
+	return setEnv(os.Environ(), map[string]string{
+		"GOPATH": gopaths,
+		"PATH":   path,
+		// gx paths only resolve in GOPATH mode
+		"GO111MODULE": "off",
+		"GX_GO_SHELL": "1",
+	}), nil
+}
+
+// setEnv returns env with the given variables set, replacing existing values
+func setEnv(env []string, vars map[string]string) []string {
+	var out []string
+	for _, e := range env {
+		k := strings.SplitN(e, "=", 2)[0]
+		if _, ok := vars[k]; !ok {
+			out = append(out, e)
+		}
+	}
+
+	for k, v := range vars {
+		out = append(out, k+"="+v)
+	}
+	return out
+}
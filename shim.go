@@ -0,0 +1,306 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+)
+
+// shimMarker identifies go wrappers written by 'shim install'
+const shimMarker = "# installed by 'gx-go shim install'"
+
+const shimScript = `#!/bin/sh
+%s
+gx-go shim fetch -- "$@" >&2 || exit 1
+exec %q "$@"
+`
+
+var ShimCommand = cli.Command{
+	Name:  "shim",
+	Usage: "let plain go tooling build packages with gx imports",
+	Description: `installs a wrapper around the go command that, before running it,
+fetches every gx/ipfs/<hash> package imported by the packages it is asked to
+build from ipfs into GOPATH/src/gx/ipfs. This lets anyone with gx-go
+installed 'go get' and 'go build' gx rewritten packages.
+
+Packages are fetched with the ipfs command when it is installed, and from
+the configured gateway otherwise.`,
+	Subcommands: []cli.Command{
+		shimInstallCommand,
+		shimUninstallCommand,
+		shimFetchCommand,
+	},
+	Action: func(c *cli.Context) error { return nil },
+}
+
+var shimDirFlag = cli.StringFlag{
+	Name:  "dir",
+	Usage: "directory to install the wrapper to, must come before the go binary in PATH",
+}
+
+func shimPath(c *cli.Context) (string, error) {
+	dir := c.String("dir")
+	if dir == "" {
+		d, err := binInstallPath(true)
+		if err != nil {
+			return "", err
+		}
+		dir = d
+	}
+	return filepath.Join(dir, "go"), nil
+}
+
+func isShim(p string) bool {
+	data, err := ioutil.ReadFile(p)
+	return err == nil && bytes.Contains(data, []byte(shimMarker))
+}
+
+var shimInstallCommand = cli.Command{
+	Name:  "install",
+	Usage: "install the go wrapper",
+	Flags: []cli.Flag{shimDirFlag},
+	Action: func(c *cli.Context) error {
+		p, err := shimPath(c)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(p); err == nil && !isShim(p) {
+			return fmt.Errorf("%s exists and is not a gx-go wrapper", p)
+		}
+
+		realgo, err := exec.LookPath("go")
+		if err != nil {
+			return fmt.Errorf("could not find the go command: %s", err)
+		}
+		if isShim(realgo) {
+			return fmt.Errorf("%s is already a gx-go wrapper, run 'gx-go shim uninstall' first", realgo)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+
+		script := fmt.Sprintf(shimScript, shimMarker, realgo)
+		if err := ioutil.WriteFile(p, []byte(script), 0755); err != nil {
+			return err
+		}
+
+		Log("installed go wrapper to %s, wrapping %s", p, realgo)
+		if found, err := exec.LookPath("go"); err != nil || found != p {
+			Warn("%s must come before %s in PATH for the wrapper to be used", filepath.Dir(p), filepath.Dir(realgo))
+		}
+		return nil
+	},
+}
+
+var shimUninstallCommand = cli.Command{
+	Name:  "uninstall",
+	Usage: "remove the go wrapper",
+	Flags: []cli.Flag{shimDirFlag},
+	Action: func(c *cli.Context) error {
+		p, err := shimPath(c)
+		if err != nil {
+			return err
+		}
+
+		if !isShim(p) {
+			return fmt.Errorf("no gx-go wrapper installed at %s", p)
+		}
+		return os.Remove(p)
+	},
+}
+
+var shimFetchCommand = cli.Command{
+	Name:      "fetch",
+	Usage:     "fetch the gx packages imported by packages given to a go command",
+	ArgsUsage: "[go command line]",
+	Action: func(c *cli.Context) error {
+		gopath, err := getGoPath()
+		if err != nil {
+			return err
+		}
+
+		args := []string(c.Args())
+		if len(args) == 0 || !shimBuildCommands[args[0]] {
+			return nil
+		}
+
+		dirs := shimPackageDirs(gopath, args)
+		seen := make(map[string]bool)
+		for len(dirs) > 0 {
+			dir := dirs[0]
+			dirs = dirs[1:]
+
+			imps, err := goImportsInDir(dir)
+			if err != nil {
+				VLog("  - skipping %s: %s", dir, err)
+				continue
+			}
+
+			for imp := range imps {
+				if !strings.HasPrefix(imp, "gx/ipfs/") {
+					continue
+				}
+
+				hash := hashFromImport(imp)
+				if seen[hash] {
+					continue
+				}
+				seen[hash] = true
+
+				// the import names where the package goes, flat or sharded
+				prefix := imp[:strings.Index(imp, hash)+len(hash)]
+				dst := filepath.Join(gopath, "src", filepath.FromSlash(prefix))
+				if _, err := os.Stat(dst); err != nil {
+					Log("fetching %s", hash)
+					if err := fetchHash(hash, dst); err != nil {
+						return fmt.Errorf("fetching %s: %s", imp, err)
+					}
+				}
+				dirs = append(dirs, dst)
+			}
+		}
+		return nil
+	},
+}
+
+// shimBuildCommands are the go subcommands that need the gx packages imported
+// by their arguments
+var shimBuildCommands = map[string]bool{
+	"build":   true,
+	"get":     true,
+	"install": true,
+	"run":     true,
+	"test":    true,
+	"vet":     true,
+	"list":    true,
+}
+
+// shimPackageDirs returns the directories of the packages named on a go
+// command line, after the subcommand, defaulting to the current directory
+func shimPackageDirs(gopath string, args []string) []string {
+	var dirs []string
+	for _, a := range args[1:] {
+		if strings.HasPrefix(a, "-") {
+			continue
+		}
+
+		a = strings.TrimSuffix(strings.TrimSuffix(a, "..."), "/")
+		if a == "" {
+			a = "."
+		}
+
+		if isLocalPath(a) {
+			dirs = append(dirs, a)
+			continue
+		}
+
+		dir := filepath.Join(gopath, "src", filepath.FromSlash(a))
+		if _, err := os.Stat(dir); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	if len(dirs) == 0 {
+		dirs = append(dirs, ".")
+	}
+	return dirs
+}
+
+// fetchHash fetches the ipfs object with the given hash to dst, using the
+// ipfs command if available, or the configured gateway
+func fetchHash(hash, dst string) error {
+	if _, err := exec.LookPath("ipfs"); err == nil {
+		out, err := exec.CommandContext(cancelCtx, "ipfs", "get", "-o", dst, "/ipfs/"+hash).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ipfs get: %s: %s", err, out)
+		}
+		return nil
+	}
+
+	gateway := defaultGateway
+	if cfg, err := loadConfig(); err == nil && cfg.Gateway != "" {
+		gateway = cfg.Gateway
+	}
+
+	u := strings.TrimSuffix(gateway, "/") + "/ipfs/" + hash + "?format=tar"
+	resp, err := downloadClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp := dst + ".fetch"
+	if err := untar(resp.Body, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// untar extracts a tar archive of a single directory to dst, stripping the
+// leading directory from all paths
+func untar(r io.Reader, dst string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(hdr.Name)
+		if strings.Contains("/"+name+"/", "/../") {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+
+		parts := strings.SplitN(path.Clean(name), "/", 2)
+		rel := ""
+		if len(parts) == 2 {
+			rel = parts[1]
+		}
+
+		p := filepath.Join(dst, filepath.FromSlash(rel))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+			fi, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode)&0755|0644)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(fi, tr); err != nil {
+				fi.Close()
+				return err
+			}
+			if err := fi.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
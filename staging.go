@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// stagedUpdate is an import update recorded by 'update --stage', to be
+// performed by 'update --apply'
+type stagedUpdate struct {
+	// Package is the directory of the package to update, relative to the
+	// directory the update was staged in
+	Package string `json:"package"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+func stagedUpdatesPath() string {
+	return filepath.Join(cwd, localStateDir, "staged-updates.json")
+}
+
+// loadStagedUpdates returns the updates staged in the current directory
+func loadStagedUpdates() ([]stagedUpdate, error) {
+	var out []stagedUpdate
+	err := loadMap(&out, stagedUpdatesPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading staged updates: %s", err)
+	}
+	return out, nil
+}
+
+func saveStagedUpdates(updates []stagedUpdate) error {
+	p := stagedUpdatesPath()
+	if len(updates) == 0 {
+		err := os.Remove(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		// fails if anything else is kept there, which is what we want
+		os.Remove(filepath.Dir(p))
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(updates, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, append(out, '\n'), 0644)
+}
+
+// stageUpdate adds u to updates, replacing an update of the same import in
+// the same package
+func stageUpdate(updates []stagedUpdate, u stagedUpdate) []stagedUpdate {
+	for i, s := range updates {
+		if s.Package == u.Package && s.Old == u.Old {
+			updates[i] = u
+			return updates
+		}
+	}
+	return append(updates, u)
+}
+
+// applyStagedUpdates performs the staged updates, rewriting each package
+// once with all of its updates
+func applyStagedUpdates(updates []stagedUpdate) error {
+	var order []string
+	mappings := make(map[string]map[string]string)
+	for _, u := range updates {
+		m, ok := mappings[u.Package]
+		if !ok {
+			m = make(map[string]string)
+			mappings[u.Package] = m
+			order = append(order, u.Package)
+		}
+		if note, ok := frozenImport(filepath.Join(cwd, filepath.FromSlash(u.Package)), u.Old); ok {
+			Log("%s: skipping update of %s, %s", u.Package, u.Old, note)
+			continue
+		}
+		m[u.Old] = u.New
+	}
+
+	for _, p := range order {
+		if err := cancelled(); err != nil {
+			return err
+		}
+
+		Log("%s: applying %d updates", p, len(mappings[p]))
+		if err := doUpdateMapping(filepath.Join(cwd, filepath.FromSlash(p)), mappings[p]); err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+	}
+	return nil
+}
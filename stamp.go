@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// LastTool records the tooling and the options that last imported or
+// rewrote a package, so the behavior can be reproduced later. Options that
+// name local paths are left out, they differ between machines.
+type LastTool struct {
+	GxGo string `json:"gx-go"`
+	Go   string `json:"go,omitempty"`
+
+	// Options are the settings the output depends on, by flag name
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// stampDisabled returns whether the user opted out of recording
+// gx.lastTool, with GX_GO_NO_STAMP or 'noStamp' in the config
+func stampDisabled() bool {
+	if v := os.Getenv("GX_GO_NO_STAMP"); v != "" && v != "0" {
+		return true
+	}
+
+	cfg, err := loadConfig()
+	return err == nil && cfg.NoStamp
+}
+
+// toolStamp returns the stamp for the running command with the given
+// options, along with the vendor layout and hash format of the current
+// package. Unset options are left out. It returns nil if stamping is
+// disabled.
+func toolStamp(opts map[string]string) *LastTool {
+	if stampDisabled() {
+		return nil
+	}
+
+	lt := &LastTool{
+		GxGo:    GxGoVersion,
+		Options: make(map[string]string),
+	}
+
+	if out, err := exec.Command("go", "env", "GOVERSION").Output(); err == nil {
+		lt.Go = strings.TrimPrefix(strings.TrimSpace(string(out)), "go")
+	}
+
+	lt.Options["layout"] = vendorLayout
+	lt.Options["cid-version"] = strconv.Itoa(cidFormat.Version)
+	if cidFormat.Multibase != "" {
+		lt.Options["multibase"] = cidFormat.Multibase
+	}
+	for k, v := range opts {
+		if v != "" && v != "false" {
+			lt.Options[k] = v
+		}
+	}
+	return lt
+}
+
+// stampPackage records lt in the package.json in dir
+func stampPackage(dir string, lt *LastTool) error {
+	if lt == nil {
+		return nil
+	}
+
+	p := filepath.Join(dir, gx.PkgFileName)
+	pkg, err := LoadPackageFile(p)
+	if err != nil {
+		return err
+	}
+
+	pkg.Gx.LastTool = lt
+	return savePackageFile(pkg, p)
+}
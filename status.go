@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// repoStatus summarizes the gx state of a package, see 'status'. Counts
+// that could not be determined are left out, with the reason in Errors.
+type repoStatus struct {
+	Package string `json:"package"`
+
+	// Rewrite is "rewritten", "undone", "mixed" or "none" if the package
+	// does not import any of its dependencies
+	Rewrite    string `json:"rewrite"`
+	GxImports  int    `json:"gxImports"`
+	DvcsImport int    `json:"dvcsImports"`
+
+	// Lockfile is "missing", "current" or "stale"
+	Lockfile     string `json:"lockfile"`
+	LockProblems int    `json:"lockProblems"`
+
+	Dependencies int  `json:"dependencies"`
+	Outdated     *int `json:"outdated,omitempty"`
+	Duplicates   *int `json:"duplicates,omitempty"`
+	Untracked    *int `json:"untracked,omitempty"`
+	Broken       *int `json:"broken,omitempty"`
+
+	// Frozen are the dependencies pinned on purpose, which are not counted
+	// as outdated
+	Frozen []string `json:"frozen,omitempty"`
+
+	// Overridden are the dependencies overlaid with a local directory,
+	// which are not checked against their hashes
+	Overridden []string `json:"overridden,omitempty"`
+
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func (s *repoStatus) fail(item string, err error) {
+	if s.Errors == nil {
+		s.Errors = make(map[string]string)
+	}
+	s.Errors[item] = err.Error()
+}
+
+var StatusCommand = cli.Command{
+	Name:  "status",
+	Usage: "summarize the gx state of the package",
+	Description: `prints whether the package is rewritten, whether gx-go-lock.json matches
+the tree, and how many dependencies are outdated according to the
+configured registry, present at more than one hash, imported without being
+tracked in gx, or broken in the vendor directory.
+
+Dependencies overlaid with a local directory are listed and not counted as
+broken or stale. --no-overrides checks their vendored copies instead.
+
+--format json prints the status as one object, with counts as numbers, for
+dashboards. The other formats and --template list it as items and values.
+
+gx-go keeps no journal of interrupted operations, so there are no pending
+entries to report.`,
+	Flags: []cli.Flag{
+		formatFlag,
+		templateFlag,
+		noOverridesFlag,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		ovs, restore, err := overlaysFor(c)
+		if err != nil {
+			return err
+		}
+		st := packageStatus(pkg, cwd, ovs)
+		restore()
+
+		switch {
+		case c.String("format") == "json" && c.String("template") == "":
+			out, err := json.MarshalIndent(st, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		case c.String("format") == "" && c.String("template") == "":
+			tw := tabwriter.NewWriter(os.Stdout, 12, 4, 1, ' ', 0)
+			for _, r := range statusRows(st) {
+				fmt.Fprintf(tw, "%s:\t%s\n", r[0], r[1])
+			}
+			return tw.Flush()
+		default:
+			return writeResults(os.Stdout, c, []string{"ITEM", "VALUE"}, statusRows(st))
+		}
+	},
+}
+
+// statusRows returns the items of st with their values as shown to users
+func statusRows(st *repoStatus) [][]string {
+	count := func(item string, n *int) string {
+		if n != nil {
+			return strconv.Itoa(*n)
+		}
+		if e, ok := st.Errors[item]; ok {
+			return "unknown (" + e + ")"
+		}
+		return "unknown"
+	}
+
+	rewrite := st.Rewrite
+	if st.Rewrite == "mixed" {
+		rewrite = fmt.Sprintf("mixed, %d gx and %d dvcs imports", st.GxImports, st.DvcsImport)
+	}
+	lock := st.Lockfile
+	if st.Lockfile == "stale" {
+		lock = fmt.Sprintf("stale, %d discrepancies", st.LockProblems)
+	}
+
+	rows := [][]string{
+		{"package", st.Package},
+		{"imports", rewrite},
+		{"lockfile", lock},
+		{"dependencies", strconv.Itoa(st.Dependencies)},
+		{"outdated", count("outdated", st.Outdated)},
+		{"duplicates", count("duplicates", st.Duplicates)},
+		{"untracked", count("untracked", st.Untracked)},
+		{"broken", count("broken", st.Broken)},
+	}
+	if len(st.Frozen) > 0 {
+		rows = append(rows, []string{"frozen", strings.Join(st.Frozen, ", ")})
+	}
+	if len(st.Overridden) > 0 {
+		rows = append(rows, []string{"overridden", strings.Join(st.Overridden, ", ")})
+	}
+	return rows
+}
+
+// packageStatus gathers the status of pkg in dir, with the given overlays
+// active
+func packageStatus(pkg *Package, dir string, ovs map[string]*overlay) *repoStatus {
+	st := &repoStatus{Package: pkg.Name, Overridden: overriddenNames(ovs)}
+	for name := range pkg.Gx.Frozen {
+		st.Frozen = append(st.Frozen, name)
+	}
+	sort.Strings(st.Frozen)
+	pkgdir := filepath.Join(dir, vendorDir)
+
+	// rewrite state
+	toGx := make(map[string]string)
+	if err := buildRewriteMapping(pkg, pkgdir, toGx, false); err != nil {
+		st.fail("rewrite", err)
+	}
+	var dvcsFiles []string
+	var err error
+	st.GxImports, dvcsFiles, err = packageImports(dir, toGx)
+	if err != nil {
+		st.fail("rewrite", err)
+	}
+	st.DvcsImport = len(dvcsFiles)
+	switch {
+	case st.GxImports > 0 && st.DvcsImport > 0:
+		st.Rewrite = "mixed"
+	case st.GxImports > 0:
+		st.Rewrite = "rewritten"
+	case st.DvcsImport > 0:
+		st.Rewrite = "undone"
+	default:
+		st.Rewrite = "none"
+	}
+
+	// lockfile
+	if lf, err := loadLockFile(dir); err != nil {
+		st.Lockfile = "missing"
+	} else if problems, err := verifyLock(pkg, lf, pkgdir, ovs); err != nil {
+		st.fail("lockfile", err)
+	} else {
+		st.LockProblems = len(problems)
+		st.Lockfile = "current"
+		if len(problems) > 0 {
+			st.Lockfile = "stale"
+		}
+	}
+
+	// broken vendor entries
+	var broken int
+	seen := make(map[string]bool)
+	queue := append([]*gx.Dependency{}, pkg.Dependencies...)
+	for len(queue) > 0 {
+		dep := queue[0]
+		queue = queue[1:]
+		if seen[dep.Hash] {
+			continue
+		}
+		seen[dep.Hash] = true
+
+		if ovs[dep.Hash] != nil {
+			if dpkg, _, err := findDepUncached(dep, pkgdir); err == nil {
+				queue = append(queue, dpkg.Dependencies...)
+			}
+			continue
+		}
+		if problem, dpkg := checkVendored(pkgdir, dep); problem != "" {
+			broken++
+		} else {
+			queue = append(queue, dpkg.Dependencies...)
+		}
+	}
+	st.Broken = &broken
+	st.Dependencies = len(seen)
+
+	// duplicates and outdated dependencies need the whole tree
+	if g, err := loadDepGraph(pkg, pkgdir); err != nil {
+		st.fail("duplicates", err)
+		st.fail("outdated", err)
+	} else {
+		dups := len(g.duplicates())
+		st.Duplicates = &dups
+
+		if n, err := outdatedCount(g, frozenHashes(pkg)); err != nil {
+			st.fail("outdated", err)
+		} else {
+			st.Outdated = &n
+		}
+	}
+
+	// dvcs imports not tracked in gx
+	if imp, err := getImportPath(dir); err != nil {
+		st.fail("untracked", err)
+	} else if i, err := NewImporter(false, os.Getenv("GOPATH"), nil); err != nil {
+		st.fail("untracked", err)
+	} else if deps, err := i.DepsToVendorForPackage(imp); err != nil {
+		st.fail("untracked", err)
+	} else {
+		var n int
+		for _, d := range withoutSkipped(deps, pkg.Gx.Skip) {
+			if _, ok := gxImportFor(toGx, d); !ok {
+				n++
+			}
+		}
+		st.Untracked = &n
+	}
+
+	return st
+}
+
+// outdatedCount returns how many packages in g, other than the frozen ones,
+// have newer versions in the configured registry
+func outdatedCount(g *depGraph, frozen map[string]string) (int, error) {
+	byImport, err := configuredRegistry()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for h, node := range g.Nodes {
+		rp, ok := byImport[node.Pkg.Gx.DvcsImport]
+		if _, isFrozen := frozen[h]; !ok || isFrozen {
+			continue
+		}
+		if checkFreshness(node.Pkg, node.Dep, rp, Policy{}, 0).Behind > 0 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// packageImports returns how many imports of gx paths the package in dir
+// has, along with a file for every import that the toGx mapping would
+// rewrite
+func packageImports(dir string, toGx map[string]string) (int, []string, error) {
+	var gxImports int
+	var dvcsFiles []string
+	err := walkPackageGoFiles(dir, func(p, rel string) error {
+		f, err := parser.ParseFile(token.NewFileSet(), p, nil, parser.ImportsOnly)
+		if err != nil {
+			return nil
+		}
+		for _, imp := range f.Imports {
+			ipath, _ := strconv.Unquote(imp.Path.Value)
+			if strings.HasPrefix(ipath, "gx/ipfs/") {
+				gxImports++
+			} else if _, ok := gxImportFor(toGx, ipath); ok {
+				dvcsFiles = append(dvcsFiles, rel)
+			}
+		}
+		return nil
+	})
+	return gxImports, dvcsFiles, err
+}
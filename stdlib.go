@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// stdCache is the cached list of standard library packages of a go release
+type stdCache struct {
+	GoVersion string   `json:"goVersion"`
+	Packages  []string `json:"packages"`
+}
+
+var std struct {
+	once sync.Once
+	pkgs map[string]bool
+}
+
+func stdCachePath() (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "std.json"), nil
+}
+
+// stdPackages returns the standard library packages of the installed go
+// toolchain, as listed by 'go list std'. The list is cached per go version,
+// and the last cached list is used when the go command is unavailable. It
+// returns nil if neither works.
+func stdPackages() map[string]bool {
+	std.once.Do(func() {
+		var cached stdCache
+		cp, err := stdCachePath()
+		if err == nil {
+			loadMap(&cached, cp)
+		}
+
+		out, err := exec.Command("go", "env", "GOVERSION").Output()
+		if err != nil {
+			if len(cached.Packages) > 0 {
+				VLog("  - go unavailable, using standard library of %s", cached.GoVersion)
+				std.pkgs = stdSet(cached.Packages)
+			}
+			return
+		}
+
+		version := strings.TrimSpace(string(out))
+		if version == cached.GoVersion && len(cached.Packages) > 0 {
+			std.pkgs = stdSet(cached.Packages)
+			return
+		}
+
+		out, err = exec.Command("go", "list", "std").Output()
+		if err != nil {
+			Warn("listing the standard library failed: %s", err)
+			if len(cached.Packages) > 0 {
+				std.pkgs = stdSet(cached.Packages)
+			}
+			return
+		}
+
+		pkgs := strings.Fields(string(out))
+		std.pkgs = stdSet(pkgs)
+
+		if cp != "" {
+			data, err := json.Marshal(stdCache{GoVersion: version, Packages: pkgs})
+			if err == nil && os.MkdirAll(filepath.Dir(cp), 0755) == nil {
+				ioutil.WriteFile(cp, data, 0644)
+			}
+		}
+	})
+	return std.pkgs
+}
+
+func stdSet(pkgs []string) map[string]bool {
+	out := map[string]bool{"C": true}
+	for _, p := range pkgs {
+		out[p] = true
+	}
+	return out
+}
+
+// isStdlib returns whether imp is a standard library package, falling back
+// to checking for a domain in its first element if the standard library
+// cannot be listed
+func isStdlib(imp string) bool {
+	if pkgs := stdPackages(); pkgs != nil {
+		return pkgs[imp]
+	}
+	return !strings.Contains(strings.Split(imp, "/")[0], ".")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// packageStore publishes and fetches gx packages: the gx package manager,
+// backed by ipfs, or a fakeStore
+type packageStore interface {
+	GetPackageTo(hash, out string) (*gx.Package, error)
+	InitPkg(dir, name, lang string, setup func(*gx.Package)) error
+	PublishPackage(dir string, pkg *gx.PackageBase) (string, error)
+}
+
+// fakeStoreEnv names a directory gx-go keeps packages in, instead of
+// publishing them to ipfs with gx, so its commands can be tested without
+// either
+const fakeStoreEnv = "GX_GO_FAKE_STORE"
+
+// fakeStore is a content store on disk standing in for gx and ipfs. Every
+// package is kept in a directory named after its hash, which is derived
+// from its content like an ipfs hash, holding a directory named after the
+// package, as gx publishes them.
+type fakeStore struct {
+	Dir string
+}
+
+func (s *fakeStore) GetPackageTo(hash, out string) (*gx.Package, error) {
+	src := filepath.Join(s.Dir, hash)
+	if _, err := os.Stat(src); err != nil {
+		return nil, fmt.Errorf("%s is not in the fake store %s", hash, s.Dir)
+	}
+
+	if err := copyDir(src, out, nil); err != nil {
+		return nil, err
+	}
+
+	var pkg gx.Package
+	if err := gx.FindPackageInDir(&pkg, out); err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// InitPkg writes a package.json like 'gx init' does, and runs the
+// post-init hook gx would run for go packages
+func (s *fakeStore) InitPkg(dir, name, lang string, setup func(*gx.Package)) error {
+	var pkg gx.Package
+	pkg.Name = name
+	pkg.Language = lang
+	pkg.Version = "0.0.0"
+	if setup != nil {
+		setup(&pkg)
+	}
+
+	if err := savePackageFile(&pkg, filepath.Join(dir, gx.PkgFileName)); err != nil {
+		return err
+	}
+	if lang != "go" {
+		return nil
+	}
+	return postInitHook(dir)
+}
+
+// PublishPackage stores the files of dir that gx would publish
+func (s *fakeStore) PublishPackage(dir string, pkg *gx.PackageBase) (string, error) {
+	rules, err := publishIgnoreRules(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir(s.Dir, ".publish")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = copyDir(dir, filepath.Join(tmp, pkg.Name), func(rel string) bool {
+		fi, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(rel)))
+		return err == nil && excludedBy(rules, rel, fi.IsDir()) != nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := contentHash(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(s.Dir, hash)
+	if _, err := os.Stat(dst); err == nil {
+		return hash, nil
+	}
+	return hash, os.Rename(tmp, dst)
+}
+
+// contentHash returns a hash of the files below dir, in the form of an ipfs
+// hash
+func contentHash(dir string) (string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, p := range files {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return "", err
+		}
+		fi, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		st, err := fi.Stat()
+		if err != nil {
+			fi.Close()
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), st.Size())
+		_, err = io.Copy(h, fi)
+		fi.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// a sha2-256 multihash, like the hashes of ipfs objects
+	return base58Encode(append([]byte{0x12, 0x20}, h.Sum(nil)...)), nil
+}
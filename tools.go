@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// toolsDir is where install-tools places built binaries, relative to the
+// package root
+const toolsDir = "bin"
+
+var InstallToolsCommand = cli.Command{
+	Name:      "install-tools",
+	Usage:     "build the command packages listed in 'gx.bins' into ./bin",
+	ArgsUsage: "[bin...]",
+	Description: `builds each command package listed in the 'bins' field of the 'gx'
+section of package.json from the vendored (and rewritten) sources of the
+dependency providing it, and places the result in the 'bin' directory of
+the current package. This pins the versions of code generators and other
+tools per project. If arguments are given, only those bins are built.`,
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(gx.PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		bins := pkg.Gx.Bins
+		if c.Args().Present() {
+			bins = c.Args()
+		}
+
+		if len(bins) == 0 {
+			Log("no bins listed in package.json")
+			return nil
+		}
+
+		return installTools(pkg, cwd, bins)
+	},
+}
+
+// gxImportFor returns the gx import path a dvcs import path resolves to via
+// the given rewrite mapping, like rewriteImport, and whether it is mapped
+func gxImportFor(mapping map[string]string, imp string) (string, bool) {
+	out, matches := rewriteImport(imp, mapping)
+	if _, ok := mapping[imp]; !ok && len(matches) == 0 {
+		return "", false
+	}
+	return out, true
+}
+
+// installTools builds each of the given bins from the dependencies of pkg
+// into the bin directory of dir
+func installTools(pkg *Package, dir string, bins []string) error {
+	mapping := make(map[string]string)
+	err := buildRewriteMapping(pkg, filepath.Join(dir, vendorDir), mapping, false)
+	if err != nil {
+		return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+	}
+
+	bindir := filepath.Join(dir, toolsDir)
+	if pkg.Gx.BinDir != "" {
+		bindir = filepath.Join(dir, pkg.Gx.BinDir)
+	}
+	if err := os.MkdirAll(bindir, 0755); err != nil {
+		return err
+	}
+
+	env, err := gxEnv("")
+	if err != nil {
+		return err
+	}
+
+	for _, bin := range bins {
+		gxpath, ok := gxImportFor(mapping, bin)
+		if !ok {
+			return fmt.Errorf("%s is not provided by any dependency", bin)
+		}
+
+		// vendored dependencies are built by path, so their gx imports
+		// resolve through the vendor directory, globally installed ones by
+		// import path
+		target := gxpath
+		rel := filepath.Join(vendorDir, filepath.FromSlash(strings.TrimPrefix(gxpath, "gx/ipfs/")))
+		if _, err := os.Stat(filepath.Join(dir, rel)); err == nil {
+			target = "./" + filepath.ToSlash(rel)
+		}
+
+		out := filepath.Join(bindir, path.Base(bin))
+		Log("installing %s to %s", bin, out)
+		cmd := exec.CommandContext(cancelCtx, "go", "build", "-o", out, target)
+		cmd.Dir = dir
+		cmd.Env = env
+		if o, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("building %s failed: %s - %s", bin, string(o), err)
+		}
+	}
+
+	return nil
+}
+
+// installToolsForDep builds the bins of the package in dir that are provided
+// by the dependency with the given dvcs import path, it is called after that
+// dependency is installed. Outside of a package there is nothing to build.
+func installToolsForDep(dir, dvcsimport string) error {
+	pkg, err := LoadPackageFile(filepath.Join(dir, gx.PkgFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var bins []string
+	for _, bin := range pkg.Gx.Bins {
+		if bin == dvcsimport || strings.HasPrefix(bin, dvcsimport+"/") {
+			bins = append(bins, bin)
+		}
+	}
+
+	if len(bins) == 0 {
+		return nil
+	}
+
+	return installTools(pkg, dir, bins)
+}
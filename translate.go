@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cli "github.com/codegangsta/cli"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+var TranslateCommand = cli.Command{
+	Name:  "translate",
+	Usage: "map import paths read on stdin between their dvcs and gx forms",
+	Description: `reads import paths on stdin, one per line, and writes each in its mapped
+form on stdout: dvcs imports of dependencies become their gx imports and
+gx imports become dvcs imports, as 'rewrite' and 'rewrite --undo' would
+map them in the current dependency tree. This lets editors, linters and
+shell pipelines translate imports without reimplementing the mapping.
+
+Every input line produces exactly one output line, written as soon as it is
+read, so the command can be kept running as a coprocess. Quotes around a
+path are kept. Paths that map to nothing, like the standard library, are
+written unchanged, --strict makes the command fail if there were any.
+
+--to gx or --to dvcs only translates in that direction.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "to",
+			Usage: "only translate to gx or to dvcs imports",
+		},
+		cli.BoolFlag{
+			Name:  "strict",
+			Usage: "fail if any path could not be translated",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		toGx, toDvcs := true, true
+		switch c.String("to") {
+		case "":
+		case "gx":
+			toDvcs = false
+		case "dvcs":
+			toGx = false
+		default:
+			return fmt.Errorf("unrecognized --to %q, must be gx or dvcs", c.String("to"))
+		}
+
+		pkg, err := LoadPackageFile(filepath.Join(cwd, gx.PkgFileName))
+		if err != nil {
+			return err
+		}
+
+		pkgdir := filepath.Join(cwd, vendorDir)
+		mapping := make(map[string]string)
+		undo := make(map[string]string)
+		if toGx {
+			if err := buildRewriteMapping(pkg, pkgdir, mapping, false); err != nil {
+				return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+			}
+		}
+		if toDvcs {
+			if err := buildRewriteMapping(pkg, pkgdir, undo, true); err != nil {
+				return fmt.Errorf("build of rewrite mapping failed:\n%s", err)
+			}
+		}
+
+		var unmapped int
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			imp := strings.Trim(line, "\"`")
+			if imp == "" {
+				fmt.Println(line)
+				continue
+			}
+
+			m, ok := gxImportFor(undo, imp)
+			if !ok {
+				m, ok = gxImportFor(mapping, imp)
+			}
+			if !ok {
+				unmapped++
+				VLog("  - %s does not map to anything", imp)
+				fmt.Println(line)
+				continue
+			}
+			fmt.Println(strings.Replace(line, imp, m, 1))
+		}
+		if err := s.Err(); err != nil {
+			return err
+		}
+
+		if c.Bool("strict") && unmapped > 0 {
+			return fmt.Errorf("%d paths could not be translated", unmapped)
+		}
+		return nil
+	},
+}
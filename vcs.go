@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// vcs describes a version control system supported by 'go get'
+type vcs struct {
+	Name string
+
+	// MetaDir is the metadata directory the vcs keeps at the root of a checkout
+	MetaDir string
+
+	// RevCmd prints the revision currently checked out
+	RevCmd []string
+
+	// CheckoutCmd checks out the ref appended to it
+	CheckoutCmd []string
+
+	// ResolveCmd prints the revision of the ref appended to it
+	ResolveCmd []string
+}
+
+var vcsList = []*vcs{
+	{
+		Name:        "git",
+		MetaDir:     ".git",
+		RevCmd:      []string{"git", "rev-parse", "HEAD"},
+		CheckoutCmd: []string{"git", "checkout"},
+		ResolveCmd:  []string{"git", "rev-list", "-n", "1"},
+	},
+	{
+		Name:        "hg",
+		MetaDir:     ".hg",
+		RevCmd:      []string{"hg", "log", "-r", ".", "--template", "{node}"},
+		CheckoutCmd: []string{"hg", "update", "-r"},
+		ResolveCmd:  []string{"hg", "log", "--template", "{node}", "-r"},
+	},
+	{
+		Name:        "bzr",
+		MetaDir:     ".bzr",
+		RevCmd:      []string{"bzr", "revno"},
+		CheckoutCmd: []string{"bzr", "update", "-r"},
+		ResolveCmd:  []string{"bzr", "revno", "-r"},
+	},
+	{
+		Name:        "svn",
+		MetaDir:     ".svn",
+		RevCmd:      []string{"svnversion"},
+		CheckoutCmd: []string{"svn", "update", "-r"},
+		ResolveCmd:  []string{"svn", "info", "--show-item", "revision", "-r"},
+	},
+}
+
+// vcsForDir returns the vcs used by the checkout rooted at dir
+func vcsForDir(dir string) (*vcs, error) {
+	for _, v := range vcsList {
+		fi, err := os.Stat(filepath.Join(dir, v.MetaDir))
+		if err == nil && fi.IsDir() {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no known vcs checkout in %s", dir)
+}
+
+// Revision returns the revision currently checked out in dir
+func (v *vcs) Revision(dir string) (string, error) {
+	cmd := exec.CommandContext(cancelCtx, v.RevCmd[0], v.RevCmd[1:]...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s - %s", strings.Join(v.RevCmd, " "), string(out), err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Head returns what is checked out in dir, in a form Checkout takes: the
+// branch if git is on one, the revision otherwise
+func (v *vcs) Head(dir string) (string, error) {
+	if v.Name == "git" {
+		cmd := exec.CommandContext(cancelCtx, "git", "symbolic-ref", "-q", "--short", "HEAD")
+		cmd.Dir = dir
+		if out, err := cmd.Output(); err == nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+	}
+	return v.Revision(dir)
+}
+
+// ResolveRef returns the revision ref names in the checkout at dir, without
+// checking it out
+func (v *vcs) ResolveRef(dir, ref string) (string, error) {
+	args := append(append([]string{}, v.ResolveCmd[1:]...), ref)
+	cmd := exec.CommandContext(cancelCtx, v.ResolveCmd[0], args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %s - %s", strings.Join(v.ResolveCmd, " "), ref, string(out), err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Checkout checks out ref in the checkout at dir
+func (v *vcs) Checkout(dir, ref string) error {
+	args := append(append([]string{}, v.CheckoutCmd[1:]...), ref)
+	cmd := exec.CommandContext(cancelCtx, v.CheckoutCmd[0], args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %s - %s", strings.Join(v.CheckoutCmd, " "), ref, string(out), err)
+	}
+	return nil
+}
+
+// isVcsMetaDir returns whether the given name is the metadata directory of a
+// known vcs
+func isVcsMetaDir(name string) bool {
+	for _, v := range vcsList {
+		if name == v.MetaDir {
+			return true
+		}
+	}
+	return false
+}
+
+// vcsIgnores returns gxignore patterns excluding vcs metadata from publishing
+func vcsIgnores() []string {
+	var out []string
+	for _, v := range vcsList {
+		out = append(out, v.MetaDir+"/*")
+	}
+	return out
+}
+
+// missingVcsHint inspects failed 'go get' output for a missing vcs binary and
+// returns a hint for the user, or an empty string
+func missingVcsHint(out string) string {
+	for _, v := range vcsList {
+		if strings.Contains(out, fmt.Sprintf("exec: %q", v.Name)) {
+			return fmt.Sprintf("the '%s' command is required to fetch this package, please install it", v.Name)
+		}
+	}
+	return ""
+}
+
+// gitTagsAt returns the tags of the revision checked out in the git checkout
+// at dir, none if it is not a git checkout
+func gitTagsAt(dir string) []string {
+	cmd := exec.CommandContext(cancelCtx, "git", "tag", "--points-at", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(out))
+}
+
+// gitSubmodules returns the paths of the submodules declared in the
+// .gitmodules file of the git checkout at dir
+func gitSubmodules(dir string) ([]string, error) {
+	cmd := exec.CommandContext(cancelCtx, "git", "config", "--file", ".gitmodules", "--get-regexp", `^submodule\..*\.path$`)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if _, serr := os.Stat(filepath.Join(dir, ".gitmodules")); os.IsNotExist(serr) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading .gitmodules: %s", err)
+	}
+
+	var paths []string
+	for _, l := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.Fields(l)
+		if len(parts) == 2 {
+			paths = append(paths, parts[1])
+		}
+	}
+	return paths, nil
+}
+
+// initGitSubmodules makes sure all submodules of the git checkout at dir are
+// checked out, so their content gets published along with the package
+func initGitSubmodules(dir string) error {
+	subs, err := gitSubmodules(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(subs) == 0 {
+		return nil
+	}
+
+	VLog("  - initializing %d git submodules in %s", len(subs), dir)
+	cmd := exec.CommandContext(cancelCtx, "git", "submodule", "update", "--init", "--recursive")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		Warn("failed to initialize git submodules in %s: %s", dir, strings.TrimSpace(string(out)))
+		Warn("the published package will be missing the following submodules:")
+		for _, s := range subs {
+			Warn("  - %s", s)
+		}
+		return nil
+	}
+
+	return nil
+}